@@ -0,0 +1,166 @@
+package spectroperf
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// cleanupConcurrency is how many documents cleanup removes at once.
+const cleanupConcurrency = 256
+
+// CleanupResult is the outcome of a cleanup.
+type CleanupResult struct {
+	// Namespace is the key namespace the documents were removed from.
+	Namespace string
+	// Removed is the number of documents removed, or that would be with a dry run.
+	Removed int
+}
+
+// Cleanup removes the documents written under the key namespace of the config, which defaults to
+// its run ID, from its collection, leaving every other document of the bucket in place.  By
+// default the keys are found with a range scan of the namespace prefix, which finds documents of
+// every workload and generator; with useQuery they are deleted by a query on the Namespace field
+// the built in workloads stamp their documents with, which needs an index on it.  A dry run only
+// counts the documents.
+func Cleanup(ctx context.Context, cfg Config, useQuery bool, dryRun bool) (CleanupResult, error) {
+	if cfg.KeyNamespace == "" {
+		return CleanupResult{}, fmt.Errorf("documents written without a key namespace cannot be told apart from others")
+	}
+	if cfg.Connstr == "" {
+		return CleanupResult{}, fmt.Errorf("no connection string provided")
+	}
+
+	auth, err := newClusterAuth(cfg)
+	if err != nil {
+		return CleanupResult{}, err
+	}
+	cluster, bucket, err := connectBucket(cfg, auth.opts)
+	if err != nil {
+		return CleanupResult{}, err
+	}
+	defer cluster.Close(nil)
+	scope := bucket.Scope(cfg.Scope)
+
+	result := CleanupResult{Namespace: cfg.KeyNamespace}
+	if useQuery {
+		result.Removed, err = cleanupQuery(ctx, scope, cfg.Collection, cfg.KeyNamespace, dryRun)
+	} else {
+		result.Removed, err = cleanupScan(ctx, scope.Collection(cfg.Collection), cfg.KeyNamespace, dryRun)
+	}
+	return result, err
+}
+
+// cleanupScan removes the documents whose keys start with the namespace, found by a range scan.
+func cleanupScan(ctx context.Context, coll *gocb.Collection, namespace string, dryRun bool) (int, error) {
+	scan, err := coll.Scan(gocb.NewRangeScanForPrefix(namespace+"::"), &gocb.ScanOptions{Context: ctx, IDsOnly: true})
+	if err != nil {
+		return 0, errors.Wrap(err, "range scan failed")
+	}
+	defer scan.Close()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	workChan := make(chan string, cleanupConcurrency)
+	var removed atomic.Int64
+	var wg sync.WaitGroup
+
+	wg.Add(cleanupConcurrency)
+	for i := 0; i < cleanupConcurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for key := range workChan {
+				if !dryRun {
+					_, err := coll.Remove(key, &gocb.RemoveOptions{Context: ctx})
+					if errors.Is(err, gocb.ErrDocumentNotFound) {
+						continue
+					}
+					if err != nil {
+						cancel(errors.Wrapf(err, "failed to remove %s", key))
+						return
+					}
+				}
+				if removed.Add(1)%10000 == 0 {
+					zap.L().Info("Cleanup progress", zap.Int64("removed", removed.Load()))
+				}
+			}
+		}()
+	}
+
+	for ctx.Err() == nil {
+		item := scan.Next()
+		if item == nil {
+			break
+		}
+		select {
+		case workChan <- item.ID():
+		case <-ctx.Done():
+		}
+	}
+	close(workChan)
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return int(removed.Load()), err
+	}
+	err = scan.Err()
+	if err != nil {
+		return int(removed.Load()), errors.Wrap(err, "error iterating the range scan")
+	}
+	return int(removed.Load()), nil
+}
+
+// cleanupQuery deletes the documents stamped with the namespace whose keys start with it.
+func cleanupQuery(ctx context.Context, scope *gocb.Scope, collection string, namespace string, dryRun bool) (int, error) {
+	where := "WHERE Namespace = $namespace AND META().id LIKE $prefix"
+	params := map[string]interface{}{
+		"namespace": namespace,
+		"prefix":    escapeLike(namespace+"::") + "%",
+	}
+	opts := &gocb.QueryOptions{
+		Context:         ctx,
+		NamedParameters: params,
+		ScanConsistency: gocb.QueryScanConsistencyRequestPlus,
+		Metrics:         true,
+	}
+
+	if dryRun {
+		rows, err := scope.Query(fmt.Sprintf("SELECT RAW COUNT(*) FROM `%s` %s", collection, where), opts)
+		if err != nil {
+			return 0, errors.Wrap(err, "count query failed")
+		}
+		var count int
+		err = rows.One(&count)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to read count")
+		}
+		return count, nil
+	}
+
+	rows, err := scope.Query(fmt.Sprintf("DELETE FROM `%s` %s", collection, where), opts)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete query failed")
+	}
+	for rows.Next() {
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, errors.Wrap(err, "delete query failed")
+	}
+	meta, err := rows.MetaData()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read query metadata")
+	}
+	return int(meta.Metrics.MutationCount), nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,84 @@
+package spectroperf
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/couchbaselabs/spectroperf/workload/workloads"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// managementURL returns the address of the management REST API of the cluster under test.
+func managementURL(cfg Config) (string, error) {
+	if cfg.MgmtURL != "" {
+		return cfg.MgmtURL, nil
+	}
+	return workloads.MgmtURL(cfg.Connstr)
+}
+
+// captureTopology returns the topology of the cluster under test, or nil if it could not be read,
+// as a run goes ahead without it.
+func captureTopology(ctx context.Context, cfg Config, username string, password string, tlsConfig *tls.Config) *workload.ClusterTopology {
+	mgmtURL, err := managementURL(cfg)
+	if err != nil {
+		zap.L().Warn("Not capturing cluster topology", zap.Error(err))
+		return nil
+	}
+	topology, err := workload.CaptureTopology(ctx, mgmtURL, cfg.Bucket, username, password, tlsConfig)
+	if err != nil {
+		zap.L().Warn("Failed to capture cluster topology", zap.Error(err))
+		return nil
+	}
+	return &topology
+}
+
+// reportClusterStats logs each sample of the cluster stats, and writes them to a CSV file if a
+// path is given.
+func reportClusterStats(path string, samples []workload.ClusterSample) error {
+	for _, sample := range samples {
+		zap.L().Info("Cluster stats",
+			zap.Time("time", sample.Time),
+			zap.Float64("serverOps", sample.ServerOps),
+			zap.Float64("cacheMissRatio", sample.CacheMissRatio),
+			zap.Float64("diskWriteQueue", sample.DiskWriteQueue),
+			zap.Float64("cpu", sample.CPU),
+			zap.Float64("clientOps", sample.ClientOps),
+			zap.Float64("clientFailures", sample.ClientFailures),
+			zap.Float64("clientP99Ms", sample.ClientP99))
+	}
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create cluster stats file")
+	}
+	defer file.Close()
+
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"time", "server_ops", "cache_miss_ratio", "disk_write_queue", "cpu", "client_ops", "client_failures", "client_p99_ms"})
+	for _, sample := range samples {
+		w.Write([]string{
+			sample.Time.Format(time.RFC3339),
+			format(sample.ServerOps),
+			format(sample.CacheMissRatio),
+			format(sample.DiskWriteQueue),
+			format(sample.CPU),
+			format(sample.ClientOps),
+			format(sample.ClientFailures),
+			format(sample.ClientP99),
+		})
+	}
+	w.Flush()
+	return errors.Wrap(w.Error(), "failed to write cluster stats file")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/couchbaselabs/spectroperf"
+	"go.uber.org/zap"
+)
+
+func runCleanup(args []string) {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	useQuery := fs.Bool("query", false, "delete the documents with a query on their Namespace field rather than a range scan of their keys")
+	dryRun := fs.Bool("dry-run", false, "count the documents that would be removed without removing them")
+	fs.Parse(args)
+
+	// The remaining flags are those of a run, naming its cluster, collection and run ID
+	cfg, err := spectroperf.ParseArgs(fs.Args())
+	if err != nil {
+		zap.L().Fatal("Invalid configuration", zap.Error(err))
+	}
+
+	result, err := spectroperf.Cleanup(context.Background(), cfg, *useQuery, *dryRun)
+	if err != nil {
+		zap.L().Fatal("Failed to clean up", zap.String("namespace", result.Namespace), zap.Int("removed", result.Removed), zap.Error(err))
+	}
+	if *dryRun {
+		zap.L().Info("Documents to clean up", zap.String("namespace", result.Namespace), zap.Int("documents", result.Removed))
+		return
+	}
+	zap.L().Info("Cleaned up", zap.String("namespace", result.Namespace), zap.Int("removed", result.Removed))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Grafana dashboard JSON, with only the fields spectroperf sets.
+type grafanaDashboard struct {
+	Title         string          `json:"title"`
+	UID           string          `json:"uid"`
+	Tags          []string        `json:"tags"`
+	Refresh       string          `json:"refresh"`
+	SchemaVersion int             `json:"schemaVersion"`
+	Time          grafanaTime     `json:"time"`
+	Templating    grafanaTemplate `json:"templating"`
+	Panels        []grafanaPanel  `json:"panels"`
+}
+
+type grafanaTime struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplate struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string             `json:"name"`
+	Label      string             `json:"label,omitempty"`
+	Type       string             `json:"type"`
+	Query      interface{}        `json:"query"`
+	Datasource *grafanaDatasource `json:"datasource,omitempty"`
+	Multi      bool               `json:"multi,omitempty"`
+	IncludeAll bool               `json:"includeAll,omitempty"`
+	AllValue   string             `json:"allValue,omitempty"`
+	Refresh    int                `json:"refresh,omitempty"`
+}
+
+type grafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID          int                `json:"id"`
+	Title       string             `json:"title"`
+	Type        string             `json:"type"`
+	Datasource  *grafanaDatasource `json:"datasource"`
+	GridPos     grafanaGridPos     `json:"gridPos"`
+	Targets     []grafanaTarget    `json:"targets"`
+	FieldConfig grafanaFieldConfig `json:"fieldConfig"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	Format       string `json:"format,omitempty"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults grafanaFieldDefaults `json:"defaults"`
+}
+
+type grafanaFieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+// datasourceVariable is the dashboard variable choosing the Prometheus datasource.
+const datasourceVariable = "${datasource}"
+
+// selector matches the series of the phases, targets and operations chosen in the dashboard.
+const selector = `phase=~"$phase", target=~"$target", operation=~"$operation"`
+
+// runDashboards implements the dashboards subcommand, which generates Grafana dashboards for the
+// metrics spectroperf exports.
+func runDashboards(args []string) {
+	if len(args) == 0 || args[0] != "generate" {
+		zap.L().Fatal("Usage: spectroperf dashboards generate [flags]")
+	}
+
+	fs := flag.NewFlagSet("dashboards generate", flag.ExitOnError)
+	output := fs.String("output", "", "path to write the dashboard to (default stdout)")
+	title := fs.String("title", "spectroperf", "title of the dashboard")
+	fs.Parse(args[1:])
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			zap.L().Fatal("Failed to create dashboard file", zap.Error(err))
+		}
+		defer file.Close()
+		out = file
+	}
+
+	err := writeDashboard(out, *title)
+	if err != nil {
+		zap.L().Fatal("Failed to generate dashboard", zap.Error(err))
+	}
+}
+
+func writeDashboard(out io.Writer, title string) error {
+	datasource := &grafanaDatasource{Type: "prometheus", UID: datasourceVariable}
+	// All matches any value rather than each value seen, to include series without a target from
+	// runs that do not compare targets.
+	labelVariable := func(name string, metric string) grafanaVariable {
+		return grafanaVariable{
+			Name:       name,
+			Type:       "query",
+			Query:      fmt.Sprintf("label_values(%s, %s)", metric, name),
+			Datasource: datasource,
+			Multi:      true,
+			IncludeAll: true,
+			AllValue:   ".*",
+			Refresh:    2,
+		}
+	}
+
+	panels := []struct {
+		title   string
+		unit    string
+		targets []grafanaTarget
+	}{
+		{"Operations per second", "ops", []grafanaTarget{
+			{Expr: fmt.Sprintf("sum by (operation, target) (rate(operations_total{%s}[$__rate_interval]))", selector), LegendFormat: "{{operation}} {{target}}"},
+		}},
+		{"Failed operations per second", "ops", []grafanaTarget{
+			{Expr: fmt.Sprintf("sum by (operation, target) (rate(operations_failed_total{%s}[$__rate_interval]))", selector), LegendFormat: "{{operation}} {{target}}"},
+		}},
+		{"Median operation duration", "ms", []grafanaTarget{
+			{Expr: fmt.Sprintf("histogram_quantile(0.5, sum by (le, operation, target) (rate(operation_duration_milliseconds_bucket{%s}[$__rate_interval])))", selector), LegendFormat: "{{operation}} {{target}}"},
+		}},
+		{"99th percentile operation duration", "ms", []grafanaTarget{
+			{Expr: fmt.Sprintf("histogram_quantile(0.99, sum by (le, operation, target) (rate(operation_duration_milliseconds_bucket{%s}[$__rate_interval])))", selector), LegendFormat: "{{operation}} {{target}}"},
+		}},
+		{"Users", "short", []grafanaTarget{
+			{Expr: "active_users", LegendFormat: "active"},
+			{Expr: "idle_users", LegendFormat: "idle"},
+		}},
+		{"HTTP responses per second", "reqps", []grafanaTarget{
+			{Expr: `sum by (class) (rate(http_responses_total{phase=~"$phase"}[$__rate_interval]))`, LegendFormat: "{{class}}"},
+		}},
+	}
+
+	dashboard := grafanaDashboard{
+		Title:         title,
+		UID:           "spectroperf",
+		Tags:          []string{"spectroperf"},
+		Refresh:       "10s",
+		SchemaVersion: 39,
+		Time:          grafanaTime{From: "now-1h", To: "now"},
+		Templating: grafanaTemplate{List: []grafanaVariable{
+			{Name: "datasource", Label: "Datasource", Type: "datasource", Query: "prometheus"},
+			labelVariable("phase", "operations_total"),
+			labelVariable("target", "operations_total"),
+			labelVariable("operation", "operations_total"),
+		}},
+	}
+	for i, panel := range panels {
+		for j := range panel.targets {
+			panel.targets[j].RefID = string(rune('A' + j))
+		}
+		dashboard.Panels = append(dashboard.Panels, grafanaPanel{
+			ID:          i + 1,
+			Title:       panel.title,
+			Type:        "timeseries",
+			Datasource:  datasource,
+			GridPos:     grafanaGridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8},
+			Targets:     panel.targets,
+			FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: panel.unit}},
+		})
+	}
+
+	// The distribution of durations, in the buckets of the histogram
+	dashboard.Panels = append(dashboard.Panels, grafanaPanel{
+		ID:         len(dashboard.Panels) + 1,
+		Title:      "Operation duration distribution",
+		Type:       "heatmap",
+		Datasource: datasource,
+		GridPos:    grafanaGridPos{H: 8, W: 24, X: 0, Y: (len(panels) + 1) / 2 * 8},
+		Targets: []grafanaTarget{
+			{RefID: "A", Expr: fmt.Sprintf("sum by (le) (rate(operation_duration_milliseconds_bucket{%s}[$__rate_interval]))", selector), LegendFormat: "{{le}}", Format: "heatmap"},
+		},
+		FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: "ms"}},
+	})
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(dashboard), "failed to encode dashboard")
+}
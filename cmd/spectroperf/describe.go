@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/couchbaselabs/spectroperf"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"go.uber.org/zap"
+)
+
+// runDescribe implements the describe subcommand, which documents the operations of a workload
+// without connecting to a cluster.
+func runDescribe(args []string) {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	name := fs.String("workload", "", "workload name")
+	format := fs.String("format", "markdown", "output format, markdown or json")
+	fs.Parse(args)
+
+	w, ok := spectroperf.SampleWorkload(*name)
+	if !ok {
+		zap.L().Fatal("Unknown workload type", zap.String("workload", *name))
+	}
+
+	var err error
+	switch *format {
+	case "markdown":
+		err = describeMarkdown(os.Stdout, *name, w)
+	case "json":
+		err = describeJSON(os.Stdout, *name, w)
+	default:
+		zap.L().Fatal("Unknown describe format", zap.String("format", *format))
+	}
+	if err != nil {
+		zap.L().Fatal("Failed to describe workload", zap.Error(err))
+	}
+}
+
+func describeMarkdown(out io.Writer, name string, w workload.Workload) error {
+	var sb strings.Builder
+	operations := w.Operations()
+
+	fmt.Fprintf(&sb, "# Workload %s\n\n", name)
+	fmt.Fprintf(&sb, "| Operation | Description | Services | Writes |\n")
+	fmt.Fprintf(&sb, "|---|---|---|---|\n")
+	for _, op := range w.Describe() {
+		fmt.Fprintf(&sb, "| %s | %s | %s | %t |\n", op.Name, op.Description, strings.Join(op.Services, ", "), op.Writes)
+	}
+
+	fmt.Fprintf(&sb, "\n## Default transition probabilities\n\n")
+	fmt.Fprintf(&sb, "Each row gives the probability of the next operation, following the operation named in the first column.\n\n")
+	fmt.Fprintf(&sb, "| From \\ To | %s |\n", strings.Join(operations, " | "))
+	fmt.Fprintf(&sb, "|---%s|\n", strings.Repeat("|---", len(operations)))
+	for i, row := range w.Probabilities() {
+		cells := make([]string, len(row))
+		for j, p := range row {
+			cells[j] = fmt.Sprintf("%g", p)
+		}
+		fmt.Fprintf(&sb, "| %s | %s |\n", operations[i], strings.Join(cells, " | "))
+	}
+
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+func describeJSON(out io.Writer, name string, w workload.Workload) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Workload      string                   `json:"workload"`
+		Operations    []workload.OperationInfo `json:"operations"`
+		Probabilities [][]float64              `json:"probabilities"`
+	}{
+		Workload:      name,
+		Operations:    w.Describe(),
+		Probabilities: w.Probabilities(),
+	})
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	importCompletedRequests = "completed-requests"
+	importCSV               = "csv"
+)
+
+// sessionGap is the longest pause between the requests of a client that is counted as think
+// time, beyond which the client is taken to have started a new session.
+const sessionGap = 10 * time.Minute
+
+// completedRequestTimeLayouts are the layouts the query service has given the requestTime of
+// completed requests in.
+var completedRequestTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999 -0700",
+}
+
+// keyPatterns find the document key a request reads or writes, where its statement names one.
+var keyPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)USE\s+KEYS\s*\[?\s*["']([^"']+)["']`),
+	regexp.MustCompile(`(?i)META\(\s*\w*\s*\)\.id\s*=\s*["']([^"']+)["']`),
+}
+
+// accessEntry is a request captured from production traffic.
+type accessEntry struct {
+	time    time.Time
+	client  string
+	request string
+	key     string
+}
+
+// operationMatchers map the requests of an access log to the operations of a workload, each
+// given on the command line as name=regexp.
+type operationMatchers []operationMatcher
+
+type operationMatcher struct {
+	operation string
+	pattern   *regexp.Regexp
+}
+
+func (m *operationMatchers) String() string {
+	return fmt.Sprint(*m)
+}
+
+func (m *operationMatchers) Set(value string) error {
+	operation, pattern, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("operation %s must be given as name=regexp", value)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.Wrapf(err, "invalid pattern for operation %s", operation)
+	}
+	*m = append(*m, operationMatcher{operation: operation, pattern: re})
+	return nil
+}
+
+// match returns the first operation whose pattern matches the request, if any does.
+func (m operationMatchers) match(request string) (string, bool) {
+	for _, matcher := range m {
+		if matcher.pattern.MatchString(request) {
+			return matcher.operation, true
+		}
+	}
+	return "", false
+}
+
+// importedConfig is the config file synthesised from an access log, which can be given to
+// spectroperf with --config.
+type importedConfig struct {
+	Workload         string                        `yaml:"workload"`
+	NumUsers         int                           `yaml:"num-users,omitempty"`
+	ThinkTime        string                        `yaml:"think-time,omitempty"`
+	MarkovChain      map[string]map[string]float64 `yaml:"markov-chain,omitempty"`
+	OperationWeights map[string]float64            `yaml:"operation-weights,omitempty"`
+	MarkovNormalize  bool                          `yaml:"markov-normalize,omitempty"`
+	Phases           []importedPhase               `yaml:"phases,omitempty"`
+}
+
+// importedPhase is the phase of the config that runs at the throughput of the access log.
+type importedPhase struct {
+	Name       string  `yaml:"name"`
+	Duration   string  `yaml:"duration"`
+	Throughput float64 `yaml:"throughput"`
+}
+
+// runImport implements the import subcommand, which synthesises the config of a workload from
+// captured production traffic, so that a run approximates its mix of operations, the order
+// clients run them in, their think time and throughput.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	name := fs.String("workload", "", "workload to synthesise the config of")
+	format := fs.String("format", "", "format of the access log, completed-requests for the JSON output of system:completed_requests or csv for a CSV with time, request, and optionally client and key columns (default by file extension)")
+	output := fs.String("output", "", "path to write the config to (default stdout)")
+	var matchers operationMatchers
+	fs.Var(&matchers, "operation", "operation of the workload and a regexp matching its requests, as name=regexp, repeated for each operation (default requests containing the name of an operation)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		zap.L().Fatal("Usage: spectroperf import --workload <name> [flags] <access log>")
+	}
+
+	w, ok := spectroperf.SampleWorkload(*name)
+	if !ok {
+		zap.L().Fatal("Unknown workload type", zap.String("workload", *name))
+	}
+	operations := w.Operations()
+	if len(matchers) == 0 {
+		for _, operation := range operations {
+			matchers = append(matchers, operationMatcher{operation: operation, pattern: regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(operation) + `\b`)})
+		}
+	}
+	for _, matcher := range matchers {
+		if !slices.Contains(operations, matcher.operation) {
+			zap.L().Fatal("Unknown operation", zap.String("workload", *name), zap.String("operation", matcher.operation))
+		}
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = importCompletedRequests
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = importCSV
+		}
+	}
+	entries, err := readAccessLog(path, *format)
+	if err != nil {
+		zap.L().Fatal("Failed to read access log", zap.String("path", path), zap.Error(err))
+	}
+
+	cfg, notes, err := synthesiseConfig(*name, operations, entries, matchers)
+	if err != nil {
+		zap.L().Fatal("Failed to synthesise workload", zap.Error(err))
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			zap.L().Fatal("Failed to create output file", zap.Error(err))
+		}
+		defer out.Close()
+	}
+	err = writeImportedConfig(out, path, cfg, notes)
+	if err != nil {
+		zap.L().Fatal("Failed to write config", zap.Error(err))
+	}
+}
+
+func readAccessLog(path string, format string) ([]accessEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case importCompletedRequests:
+		return parseCompletedRequests(data)
+	case importCSV:
+		return parseAccessCSV(data)
+	default:
+		return nil, fmt.Errorf("unknown access log format %s, expected %s or %s", format, importCompletedRequests, importCSV)
+	}
+}
+
+// parseCompletedRequests parses the output of SELECT * FROM system:completed_requests, as the
+// response of the query service, a JSON array or one request per line, with each request either bare or under its keyspace alias.
+// Requests are attributed to a client by the users that ran them and the address they came from.
+func parseCompletedRequests(data []byte) ([]accessEntry, error) {
+	var requests []map[string]any
+	trimmed := bytes.TrimSpace(data)
+	var response struct {
+		Results []map[string]any `json:"results"`
+	}
+	if bytes.HasPrefix(trimmed, []byte("{")) && json.Unmarshal(trimmed, &response) == nil && response.Results != nil {
+		requests = response.Results
+	} else if bytes.HasPrefix(trimmed, []byte("[")) {
+		err := json.Unmarshal(trimmed, &requests)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse completed requests")
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			var request map[string]any
+			err := dec.Decode(&request)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse completed requests")
+			}
+			requests = append(requests, request)
+		}
+	}
+
+	entries := make([]accessEntry, 0, len(requests))
+	for i, request := range requests {
+		if inner, ok := request["completed_requests"].(map[string]any); ok {
+			request = inner
+		}
+		requestTime, _ := request["requestTime"].(string)
+		t, err := parseRequestTime(requestTime)
+		if err != nil {
+			return nil, errors.Wrapf(err, "request %d", i+1)
+		}
+
+		statement, _ := request["statement"].(string)
+		if statement == "" {
+			statement, _ = request["preparedText"].(string)
+		}
+		users, _ := request["users"].(string)
+		remote, _ := request["remoteAddr"].(string)
+		host, _, _ := strings.Cut(remote, ":")
+		entries = append(entries, accessEntry{
+			time:    t,
+			client:  users + "@" + host,
+			request: statement,
+			key:     statementKey(statement),
+		})
+	}
+	return entries, nil
+}
+
+func parseRequestTime(value string) (time.Time, error) {
+	for _, layout := range completedRequestTimeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised requestTime %q", value)
+}
+
+func statementKey(statement string) string {
+	for _, pattern := range keyPatterns {
+		if match := pattern.FindStringSubmatch(statement); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// parseAccessCSV parses an access log with a header naming its columns, of which time and
+// request are required and client and key are optional.  Times are RFC 3339 or seconds since
+// the epoch.
+func parseAccessCSV(data []byte) ([]accessEntry, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse access log")
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("access log is empty")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"time", "request"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("access log has no %s column", required)
+		}
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	entries := make([]accessEntry, 0, len(records)-1)
+	for i, record := range records[1:] {
+		value := column(record, "time")
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			seconds, perr := strconv.ParseFloat(value, 64)
+			if perr != nil {
+				return nil, fmt.Errorf("line %d: unrecognised time %q", i+2, value)
+			}
+			t = time.Unix(0, int64(seconds*float64(time.Second)))
+		}
+		entries = append(entries, accessEntry{
+			time:    t,
+			client:  column(record, "client"),
+			request: column(record, "request"),
+			key:     column(record, "key"),
+		})
+	}
+	return entries, nil
+}
+
+// synthesiseConfig approximates the traffic of an access log with the operations of a workload.
+// The transitions between the operations of each client give the markov chain, or without
+// clients the mix of operations gives their weights, and the pauses between them the think time.
+// Notes describe what the config does not capture, to be written alongside it.
+func synthesiseConfig(name string, operations []string, entries []accessEntry, matchers operationMatchers) (importedConfig, []string, error) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].time.Before(entries[j].time)
+	})
+
+	counts := map[string]float64{}
+	transitions := map[string]map[string]float64{}
+	last := map[string]accessEntry{}
+	lastOperation := map[string]string{}
+	keys := map[string]int{}
+	var matched, unmatched, gaps int
+	var thinking time.Duration
+	var first, final time.Time
+	for _, entry := range entries {
+		operation, ok := matchers.match(entry.request)
+		if !ok {
+			unmatched++
+			continue
+		}
+		matched++
+		counts[operation]++
+		if entry.key != "" {
+			keys[entry.key]++
+		}
+		if first.IsZero() {
+			first = entry.time
+		}
+		final = entry.time
+
+		if entry.client == "" {
+			continue
+		}
+		if previous, ok := last[entry.client]; ok {
+			gap := entry.time.Sub(previous.time)
+			if gap <= sessionGap {
+				from := lastOperation[entry.client]
+				if transitions[from] == nil {
+					transitions[from] = map[string]float64{}
+				}
+				transitions[from][operation]++
+				thinking += gap
+				gaps++
+			}
+		}
+		last[entry.client] = entry
+		lastOperation[entry.client] = operation
+	}
+	if matched == 0 {
+		return importedConfig{}, nil, fmt.Errorf("none of the %d requests matched an operation of workload %s", len(entries), name)
+	}
+
+	cfg := importedConfig{Workload: name, NumUsers: len(last)}
+	notes := []string{fmt.Sprintf("%d requests matched operations of %s over %s", matched, name, final.Sub(first).Round(time.Second))}
+	if unmatched > 0 {
+		notes = append(notes, fmt.Sprintf("%d requests matched no operation and were left out", unmatched))
+	}
+
+	weights := map[string]float64{}
+	for operation, count := range counts {
+		weights[operation] = roundProbability(count / float64(matched))
+	}
+	if gaps == 0 {
+		cfg.OperationWeights = weights
+		notes = append(notes, "requests were not attributed to clients, so operations follow each other at random in the mix of the log")
+	} else {
+		// Operations that no client followed with another, including those users start from
+		// but the log never had, run the overall mix after them
+		cfg.MarkovChain = map[string]map[string]float64{}
+		cfg.MarkovNormalize = true
+		for _, operation := range operations {
+			row := transitions[operation]
+			if row == nil {
+				cfg.MarkovChain[operation] = weights
+				continue
+			}
+			var total float64
+			for _, count := range row {
+				total += count
+			}
+			cfg.MarkovChain[operation] = map[string]float64{}
+			for next, count := range row {
+				cfg.MarkovChain[operation][next] = roundProbability(count / total)
+			}
+		}
+		mean := (thinking / time.Duration(gaps)).Round(time.Millisecond)
+		cfg.ThinkTime = "exponential:" + mean.String()
+	}
+
+	if duration := final.Sub(first).Round(time.Second); duration > 0 {
+		cfg.Phases = []importedPhase{{
+			Name:       "imported",
+			Duration:   duration.String(),
+			Throughput: math.Round(float64(matched)/duration.Seconds()*100) / 100,
+		}}
+	}
+
+	if len(keys) > 0 {
+		notes = append(notes, keySkewNote(keys))
+	}
+	return cfg, notes, nil
+}
+
+// keySkewNote describes how concentrated the requests were on their busiest keys, which the
+// workloads choose for themselves.
+func keySkewNote(keys map[string]int) string {
+	counts := make([]int, 0, len(keys))
+	total := 0
+	for _, count := range keys {
+		counts = append(counts, count)
+		total += count
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+
+	busiest := max(len(counts)/100, 1)
+	share := 0
+	for _, count := range counts[:busiest] {
+		share += count
+	}
+	return fmt.Sprintf("the busiest %d of %d keys took %.1f%% of the requests that named one, the workload chooses its own keys so this skew is not reproduced",
+		busiest, len(counts), float64(share)/float64(total)*100)
+}
+
+func roundProbability(p float64) float64 {
+	return math.Round(p*10000) / 10000
+}
+
+func writeImportedConfig(out io.Writer, path string, cfg importedConfig, notes []string) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Imported from %s\n", filepath.Base(path))
+	for _, note := range notes {
+		fmt.Fprintf(&sb, "# %s\n", note)
+	}
+	sb.Write(data)
+	_, err = io.WriteString(out, sb.String())
+	return err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/couchbaselabs/spectroperf"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// initSettings are the settings of a starter config, which are prompted for when init runs
+// interactively.
+type initSettings struct {
+	workload    string
+	connstr     string
+	dapiConnstr string
+	bucket      string
+	scope       string
+	collection  string
+}
+
+// runInit implements the init subcommand, which writes a starter config file for a workload, with
+// the defaults of its settings commented and the default markov chain of the workload spelled out
+// by operation name, ready to be edited.  Without --workload it prompts for each setting not given
+// as a flag.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	var settings initSettings
+	fs.StringVar(&settings.workload, "workload", "", "workload to write the config of (default prompt for it)")
+	fs.StringVar(&settings.connstr, "connstr", "couchbase://localhost", "connection string of the cluster under test")
+	fs.StringVar(&settings.dapiConnstr, "dapi-connstr", "", "connection string for data api, for the user-profile-dapi workload")
+	fs.StringVar(&settings.bucket, "bucket", "data", "bucket name")
+	fs.StringVar(&settings.scope, "scope", "identity", "scope name")
+	fs.StringVar(&settings.collection, "collection", "profiles", "collection name")
+	output := fs.String("output", "spectroperf.yaml", "path to write the config to, or - for stdout")
+	force := fs.Bool("force", false, "overwrite the output file if it exists")
+	fs.Parse(args)
+
+	if settings.workload == "" {
+		err := promptSettings(os.Stdin, os.Stdout, fs, &settings)
+		if err != nil {
+			zap.L().Fatal("Failed to read settings", zap.Error(err))
+		}
+	}
+	w, ok := spectroperf.SampleWorkload(settings.workload)
+	if !ok {
+		zap.L().Fatal("Unknown workload type", zap.String("workload", settings.workload), zap.Strings("workloads", spectroperf.WorkloadNames()))
+	}
+
+	if *output == "-" {
+		err := writeStarterConfig(os.Stdout, settings, w)
+		if err != nil {
+			zap.L().Fatal("Failed to write config", zap.Error(err))
+		}
+		return
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	out, err := os.OpenFile(*output, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		zap.L().Fatal("Output file already exists, pass --force to overwrite it", zap.String("path", *output))
+	}
+	if err != nil {
+		zap.L().Fatal("Failed to create output file", zap.Error(err))
+	}
+	defer out.Close()
+	err = writeStarterConfig(out, settings, w)
+	if err != nil {
+		zap.L().Fatal("Failed to write config", zap.Error(err))
+	}
+	fmt.Printf("Wrote %s, run it with:\n\n  spectroperf --config %s --profile smoke\n", *output, *output)
+}
+
+// promptSettings asks for the workload, and each setting that was not given as a flag, offering
+// its default.
+func promptSettings(in *os.File, out io.Writer, fs *flag.FlagSet, settings *initSettings) error {
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("--workload must be given when not run from a terminal")
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	reader := bufio.NewReader(in)
+	names := spectroperf.WorkloadNames()
+	fmt.Fprintln(out, "Workloads:")
+	for i, name := range names {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, name)
+	}
+	for settings.workload == "" {
+		answer, err := prompt(reader, out, "Workload", names[0])
+		if err != nil {
+			return err
+		}
+		if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= len(names) {
+			answer = names[i-1]
+		}
+		if _, ok := spectroperf.SampleWorkload(answer); !ok {
+			fmt.Fprintf(out, "Unknown workload %s\n", answer)
+			continue
+		}
+		settings.workload = answer
+	}
+
+	questions := []struct {
+		flag  string
+		label string
+		value *string
+	}{
+		{"connstr", "Connection string", &settings.connstr},
+		{"dapi-connstr", "Data API connection string", &settings.dapiConnstr},
+		{"bucket", "Bucket", &settings.bucket},
+		{"scope", "Scope", &settings.scope},
+		{"collection", "Collection", &settings.collection},
+	}
+	for _, question := range questions {
+		if given[question.flag] || (question.flag == "dapi-connstr" && settings.workload != "user-profile-dapi") {
+			continue
+		}
+		*question.value, err = prompt(reader, out, question.label, *question.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prompt asks a question, returning the answer or the default if none is given.
+func prompt(reader *bufio.Reader, out io.Writer, label string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(out, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(out, "%s: ", label)
+	}
+	answer, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", errors.Wrapf(err, "no answer for %s", strings.ToLower(label))
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// writeStarterConfig writes a config file with a base section for the workload and a smoke
+// profile to check it with.  Settings that are left at their defaults are written commented, and
+// the default markov chain of the workload is written by operation name, leaving out transitions
+// that never happen, so that the file passes the checks of a config file as it is.
+func writeStarterConfig(out io.Writer, settings initSettings, w workload.Workload) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Starter config for the %s workload, written by spectroperf init.\n", settings.workload)
+	fmt.Fprintf(&sb, "# Commented settings show their defaults. See spectroperf -h for every setting.\n")
+	fmt.Fprintf(&sb, "base:\n")
+	fmt.Fprintf(&sb, "  connstr: %s\n", yamlScalar(settings.connstr))
+	if settings.workload == "user-profile-dapi" {
+		fmt.Fprintf(&sb, "  dapi-connstr: %s\n", yamlScalar(settings.dapiConnstr))
+	}
+	fmt.Fprintf(&sb, "  # username: Administrator\n")
+	fmt.Fprintf(&sb, "  # The password is best given in the SPECTROPERF_PASSWORD environment variable\n")
+	fmt.Fprintf(&sb, "  # password-file: /path/to/password\n")
+	fmt.Fprintf(&sb, "  # CA certificate trusted in addition to the system roots, empty for the system roots only\n")
+	fmt.Fprintf(&sb, "  cert: \"\"\n")
+	fmt.Fprintf(&sb, "  bucket: %s\n", yamlScalar(settings.bucket))
+	fmt.Fprintf(&sb, "  scope: %s\n", yamlScalar(settings.scope))
+	fmt.Fprintf(&sb, "  collection: %s\n", yamlScalar(settings.collection))
+	fmt.Fprintf(&sb, "  workload: %s\n", settings.workload)
+	if settings.workload == "existing-data" {
+		fmt.Fprintf(&sb, "  # How the keys of the documents already in the collection are found: sample, query, or query:<statement>\n")
+		fmt.Fprintf(&sb, "  # existing-keys: sample\n")
+	}
+	fmt.Fprintf(&sb, "  # num-items: 200000\n")
+	fmt.Fprintf(&sb, "  # num-users: 50000\n")
+	fmt.Fprintf(&sb, "  # run-time: 5m\n")
+	fmt.Fprintf(&sb, "  # think-time: uniform:400ms-5s\n")
+
+	fmt.Fprintf(&sb, "  # The operations of the workload are:\n")
+	for _, op := range w.Describe() {
+		fmt.Fprintf(&sb, "  #   %s: %s\n", op.Name, op.Description)
+	}
+	fmt.Fprintf(&sb, "  # Each row gives the probability of the next operation after the operation it is named\n")
+	fmt.Fprintf(&sb, "  # for, and must sum to 1.  Operations left out of a row are never run after it.\n")
+	fmt.Fprintf(&sb, "  markov-chain:\n")
+	operations := w.Operations()
+	for i, row := range w.Probabilities() {
+		fmt.Fprintf(&sb, "    %s:\n", operations[i])
+		for j, p := range row {
+			if p == 0 {
+				continue
+			}
+			fmt.Fprintf(&sb, "      %s: %s\n", operations[j], strconv.FormatFloat(p, 'g', -1, 64))
+		}
+	}
+
+	fmt.Fprintf(&sb, "profiles:\n")
+	fmt.Fprintf(&sb, "  # A short run with a small dataset, to check the cluster and config before a full run\n")
+	fmt.Fprintf(&sb, "  smoke:\n")
+	fmt.Fprintf(&sb, "    num-items: 1000\n")
+	fmt.Fprintf(&sb, "    num-users: 10\n")
+	fmt.Fprintf(&sb, "    run-time: 1m\n")
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+// yamlScalar quotes a value if it would not otherwise be read back as the same string.
+func yamlScalar(value string) string {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return strconv.Quote(value)
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
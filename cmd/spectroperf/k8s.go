@@ -0,0 +1,259 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Where the generated pods mount the config file and the secret holding the credentials.
+const (
+	k8sConfigDir  = "/etc/spectroperf/config"
+	k8sConfigFile = "config.yaml"
+	k8sSecretsDir = "/etc/spectroperf/secrets"
+	k8sMetrics    = 2112
+)
+
+// Kubernetes objects, with only the fields spectroperf sets.
+type k8sObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	Spec       interface{}       `yaml:"spec,omitempty"`
+}
+
+type k8sMetadata struct {
+	Name        string            `yaml:"name,omitempty"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type k8sJobSpec struct {
+	CompletionMode string         `yaml:"completionMode"`
+	Completions    int            `yaml:"completions"`
+	Parallelism    int            `yaml:"parallelism"`
+	BackoffLimit   int            `yaml:"backoffLimit"`
+	Template       k8sPodTemplate `yaml:"template"`
+}
+
+type k8sPodTemplate struct {
+	Metadata k8sMetadata `yaml:"metadata"`
+	Spec     k8sPodSpec  `yaml:"spec"`
+}
+
+type k8sPodSpec struct {
+	RestartPolicy string         `yaml:"restartPolicy"`
+	Containers    []k8sContainer `yaml:"containers"`
+	Volumes       []k8sVolume    `yaml:"volumes,omitempty"`
+}
+
+type k8sContainer struct {
+	Name         string           `yaml:"name"`
+	Image        string           `yaml:"image"`
+	Args         []string         `yaml:"args"`
+	Env          []k8sEnvVar      `yaml:"env"`
+	Ports        []k8sPort        `yaml:"ports"`
+	VolumeMounts []k8sVolumeMount `yaml:"volumeMounts,omitempty"`
+}
+
+type k8sEnvVar struct {
+	Name      string        `yaml:"name"`
+	Value     string        `yaml:"value,omitempty"`
+	ValueFrom *k8sEnvSource `yaml:"valueFrom,omitempty"`
+}
+
+type k8sEnvSource struct {
+	FieldRef k8sFieldRef `yaml:"fieldRef"`
+}
+
+type k8sFieldRef struct {
+	FieldPath string `yaml:"fieldPath"`
+}
+
+type k8sPort struct {
+	Name          string `yaml:"name"`
+	ContainerPort int    `yaml:"containerPort,omitempty"`
+	Port          int    `yaml:"port,omitempty"`
+}
+
+type k8sVolume struct {
+	Name      string           `yaml:"name"`
+	ConfigMap *k8sVolumeMap    `yaml:"configMap,omitempty"`
+	Secret    *k8sVolumeSecret `yaml:"secret,omitempty"`
+}
+
+type k8sVolumeMap struct {
+	Name string `yaml:"name"`
+}
+
+type k8sVolumeSecret struct {
+	SecretName string `yaml:"secretName"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	ReadOnly  bool   `yaml:"readOnly"`
+}
+
+type k8sServiceSpec struct {
+	ClusterIP string            `yaml:"clusterIP"`
+	Selector  map[string]string `yaml:"selector"`
+	Ports     []k8sPort         `yaml:"ports"`
+}
+
+// k8sJob describes the Kubernetes Job to generate for a run.
+type k8sJob struct {
+	name      string
+	namespace string
+	image     string
+	workers   int
+	runId     string
+	config    string
+	profile   string
+	secret    string
+	args      []string
+}
+
+func runK8s(args []string) {
+	if len(args) == 0 || args[0] != "generate" {
+		zap.L().Fatal("Usage: spectroperf k8s generate [flags] [-- run flags]")
+	}
+
+	job := k8sJob{}
+	fs := flag.NewFlagSet("k8s generate", flag.ExitOnError)
+	fs.StringVar(&job.name, "name", "spectroperf", "name of the Job, and of the ConfigMap and Service generated alongside it")
+	fs.StringVar(&job.namespace, "namespace", "", "namespace to run in (default the namespace the manifest is applied to)")
+	fs.StringVar(&job.image, "image", "", "container image holding the spectroperf command line")
+	fs.IntVar(&job.workers, "workers", 1, "number of pods to spread the run across, each running the whole config")
+	fs.StringVar(&job.runId, "run-id", strconv.FormatUint(rand.Uint64(), 36), "identifier for the run, each pod runs with this and its index as its run ID (default random)")
+	fs.StringVar(&job.config, "config", "", "path to a YAML config file to run with, which is put in a ConfigMap")
+	fs.StringVar(&job.profile, "profile", "", "named profile from the config file to run with")
+	fs.StringVar(&job.secret, "secret", "", "name of a Secret holding the username and password of the cluster under test")
+	output := fs.String("output", "", "path to write the manifest to (default stdout)")
+	fs.Parse(args[1:])
+	job.args = fs.Args()
+
+	if job.image == "" {
+		zap.L().Fatal("An --image is required")
+	}
+	if job.workers < 1 {
+		zap.L().Fatal("--workers must be at least 1", zap.Int("workers", job.workers))
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			zap.L().Fatal("Failed to create manifest file", zap.Error(err))
+		}
+		defer file.Close()
+		out = file
+	}
+
+	err := writeK8sManifest(out, job)
+	if err != nil {
+		zap.L().Fatal("Failed to generate manifest", zap.Error(err))
+	}
+}
+
+// writeK8sManifest writes the objects of an indexed Job running the workers of a run, which share
+// the documents of the run under its key namespace, along with a ConfigMap holding the config
+// file and a headless Service through which Prometheus discovers the metrics of every worker.
+func writeK8sManifest(out io.Writer, job k8sJob) error {
+	labels := map[string]string{"app.kubernetes.io/name": "spectroperf", "spectroperf/run-id": job.runId}
+	var objects []k8sObject
+
+	args := []string{"--run-id", job.runId + "-$(JOB_INDEX)", "--key-namespace", job.runId}
+	container := k8sContainer{
+		Name:  "spectroperf",
+		Image: job.image,
+		Env: []k8sEnvVar{{
+			Name:      "JOB_INDEX",
+			ValueFrom: &k8sEnvSource{FieldRef: k8sFieldRef{FieldPath: "metadata.annotations['batch.kubernetes.io/job-completion-index']"}},
+		}},
+		Ports: []k8sPort{{Name: "metrics", ContainerPort: k8sMetrics}},
+	}
+	var volumes []k8sVolume
+
+	if job.config != "" {
+		data, err := os.ReadFile(job.config)
+		if err != nil {
+			return errors.Wrap(err, "failed to read config file")
+		}
+		objects = append(objects, k8sObject{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Metadata:   k8sMetadata{Name: job.name, Namespace: job.namespace, Labels: labels},
+			Data:       map[string]string{k8sConfigFile: string(data)},
+		})
+		args = append(args, "--config", k8sConfigDir+"/"+k8sConfigFile)
+		volumes = append(volumes, k8sVolume{Name: "config", ConfigMap: &k8sVolumeMap{Name: job.name}})
+		container.VolumeMounts = append(container.VolumeMounts, k8sVolumeMount{Name: "config", MountPath: k8sConfigDir, ReadOnly: true})
+	}
+	if job.profile != "" {
+		args = append(args, "--profile", job.profile)
+	}
+	if job.secret != "" {
+		volumes = append(volumes, k8sVolume{Name: "secrets", Secret: &k8sVolumeSecret{SecretName: job.secret}})
+		container.VolumeMounts = append(container.VolumeMounts, k8sVolumeMount{Name: "secrets", MountPath: k8sSecretsDir, ReadOnly: true})
+		container.Env = append(container.Env, k8sEnvVar{Name: "SPECTROPERF_SECRETS_DIR", Value: k8sSecretsDir})
+	}
+	container.Args = append(args, job.args...)
+
+	objects = append(objects,
+		k8sObject{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Metadata:   k8sMetadata{Name: job.name, Namespace: job.namespace, Labels: labels},
+			Spec: k8sServiceSpec{
+				ClusterIP: "None",
+				Selector:  labels,
+				Ports:     []k8sPort{{Name: "metrics", Port: k8sMetrics}},
+			},
+		},
+		k8sObject{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Metadata:   k8sMetadata{Name: job.name, Namespace: job.namespace, Labels: labels},
+			Spec: k8sJobSpec{
+				CompletionMode: "Indexed",
+				Completions:    job.workers,
+				Parallelism:    job.workers,
+				BackoffLimit:   0,
+				Template: k8sPodTemplate{
+					Metadata: k8sMetadata{
+						Labels: labels,
+						Annotations: map[string]string{
+							"prometheus.io/scrape": "true",
+							"prometheus.io/port":   strconv.Itoa(k8sMetrics),
+						},
+					},
+					Spec: k8sPodSpec{
+						RestartPolicy: "Never",
+						Containers:    []k8sContainer{container},
+						Volumes:       volumes,
+					},
+				},
+			},
+		},
+	)
+
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	for _, object := range objects {
+		err := encoder.Encode(object)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode %s", object.Kind)
+		}
+	}
+	return encoder.Close()
+}
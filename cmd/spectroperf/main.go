@@ -0,0 +1,176 @@
+//   Copyright 2024 Couchbase, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//   http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"slices"
+
+	"github.com/couchbaselabs/spectroperf"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"go.uber.org/zap"
+)
+
+func init() {
+	zap.ReplaceGlobals(zap.Must(zap.NewProduction())) // TODO: replace this with a logger from CLI
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "describe" {
+		runDescribe(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "scenario" {
+		runScenario(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dashboards" {
+		runDashboards(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "k8s" {
+		runK8s(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		runInit(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		runCleanup(os.Args[2:])
+		return
+	}
+
+	cfg, err := spectroperf.ParseArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		zap.L().Fatal("Invalid configuration", zap.Error(err))
+	}
+
+	logger, err := spectroperf.NewLogger(cfg)
+	if err != nil {
+		zap.L().Fatal("Failed to set up logging", zap.Error(err))
+	}
+	zap.ReplaceGlobals(logger)
+	defer logger.Sync()
+
+	result, err := spectroperf.Runner{Config: cfg}.Run(context.Background())
+	if err != nil {
+		zap.L().Fatal("Failed to run workload", zap.Error(err))
+	}
+
+	if result.Paused > 0 {
+		zap.L().Info("Run was paused", zap.Duration("paused", result.Paused), zap.Duration("active", result.End.Sub(result.Start)-result.Paused))
+	}
+	for _, summary := range result.Operations {
+		fields := []zap.Field{
+			zap.String("phase", summary.Phase),
+			zap.String("target", summary.Target),
+			zap.String("operation", summary.Operation),
+			zap.Uint64("attempts", summary.Attempts),
+			zap.Uint64("failures", summary.Failures),
+			zap.Uint64("timeouts", summary.Timeouts),
+			zap.Uint64("unexpected", summary.Unexpected),
+			zap.Float64("p50Ms", summary.P50),
+			zap.Float64("p99Ms", summary.P99),
+		}
+		if cfg.CorrectOmission {
+			fields = append(fields, zap.Float64("correctedP99Ms", summary.CorrectedP99))
+		}
+		zap.L().Info("Operation summary", fields...)
+	}
+	for _, row := range result.Transitions {
+		zap.L().Info("Transition summary",
+			zap.String("phase", row.Phase),
+			zap.String("target", row.Target),
+			zap.String("from", row.From),
+			zap.Uint64("transitions", row.Count),
+			zap.Float64("maxDeviation", row.MaxDeviation()),
+		)
+	}
+	if len(result.Timeline) > 1 {
+		var throughput, errorRate, p99 []float64
+		for _, sample := range result.Timeline {
+			throughput = append(throughput, sample.Throughput)
+			errorRate = append(errorRate, sample.ErrorRate)
+			p99 = append(p99, sample.P99)
+		}
+		zap.L().Info("Timeline summary",
+			zap.Int("samples", len(result.Timeline)),
+			zap.String("opsPerSecond", workload.Sparkline(throughput)),
+			zap.Float64("minOpsPerSecond", slices.Min(throughput)),
+			zap.Float64("maxOpsPerSecond", slices.Max(throughput)),
+			zap.String("errorRate", workload.Sparkline(errorRate)),
+			zap.Float64("maxErrorRate", slices.Max(errorRate)),
+			zap.String("p99Ms", workload.Sparkline(p99)),
+			zap.Float64("maxP99Ms", slices.Max(p99)),
+		)
+	}
+	if result.Capacity != nil {
+		for _, step := range result.Capacity.Steps {
+			zap.L().Info("Capacity search summary",
+				zap.String("phase", step.Phase),
+				zap.Float64("targetOpsPerSecond", step.Target),
+				zap.Float64("opsPerSecond", step.Throughput),
+				zap.Float64("errorRate", step.ErrorRate),
+				zap.Float64("p99Ms", step.P99),
+				zap.Bool("passed", step.Passed),
+			)
+		}
+		if result.Capacity.MaxThroughput > 0 {
+			zap.L().Info("Maximum sustainable throughput", zap.Float64("opsPerSecond", result.Capacity.MaxThroughput))
+		} else {
+			zap.L().Warn("No step of the capacity search passed")
+		}
+	}
+	if start := result.Topology.Start; start != nil {
+		zap.L().Info("Cluster topology",
+			zap.Strings("versions", start.Versions()),
+			zap.Int("nodes", len(start.Nodes)),
+			zap.String("bucket", start.Bucket.Name),
+			zap.Int("replicas", start.Bucket.Replicas),
+			zap.String("evictionPolicy", start.Bucket.EvictionPolicy),
+			zap.String("storageBackend", start.Bucket.StorageBackend),
+			zap.Int("quotaMB", start.Bucket.QuotaMB),
+		)
+	}
+	if result.Topology.Changed() {
+		zap.L().Warn("Cluster topology changed during the run",
+			zap.Int("nodesAtStart", len(result.Topology.Start.Nodes)),
+			zap.Int("nodesAtEnd", len(result.Topology.End.Nodes)),
+			zap.Strings("versionsAtEnd", result.Topology.End.Versions()),
+		)
+	}
+	for _, validation := range result.Validations {
+		if validation.Err != nil {
+			zap.L().Error("Validation summary", zap.String("target", validation.Target), zap.Bool("passed", false), zap.Error(validation.Err))
+			continue
+		}
+		zap.L().Info("Validation summary", zap.String("target", validation.Target), zap.Bool("passed", true))
+	}
+
+	if result.Aborted != nil {
+		zap.L().Fatal("Run aborted", zap.String("runId", result.RunId), zap.Error(result.Aborted))
+	}
+	zap.L().Info("Run complete", zap.String("runId", result.RunId), zap.Int("seed", result.Seed))
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// scenarioFile is the layout of a scenario file, which runs a sequence of steps, each a run of
+// spectroperf with its own options.  Options too complex for a flag, such as phases, go in a
+// profile of the config file:
+//
+//	config: spectroperf.yaml
+//	steps:
+//	  - name: profiles
+//	    profile: soak
+//	  - name: profiles-dapi
+//	    reuse-data: true
+//	    options:
+//	      workload: user-profile-dapi
+//	      run-time: 30m
+type scenarioFile struct {
+	// Config is the config file every step is run with, relative to the scenario file
+	Config string         `yaml:"config"`
+	Steps  []scenarioStep `yaml:"steps"`
+}
+
+// scenarioStep is a single run of a scenario.
+type scenarioStep struct {
+	Name    string `yaml:"name"`
+	Profile string `yaml:"profile"`
+	// ReuseData skips loading documents, running over those the steps before it loaded
+	ReuseData bool           `yaml:"reuse-data"`
+	Options   map[string]any `yaml:"options"`
+}
+
+// stepResult is the outcome of a step, for the combined report of a scenario.
+type stepResult struct {
+	Name     string        `json:"name"`
+	RunId    string        `json:"runId"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// Operations are summarised from the report the step wrote, so that the steps can be compared
+	Operations []stepOperation `json:"operations,omitempty"`
+}
+
+// stepOperation is the summary of an operation of a step, with its throughput over the time the
+// step was running rather than paused.
+type stepOperation struct {
+	workload.OperationSummary
+	Throughput float64 `json:"opsPerSecond"`
+}
+
+// stepReport is the part of the report of a run that the scenario report is made from.
+type stepReport struct {
+	Start      time.Time                   `json:"start"`
+	End        time.Time                   `json:"end"`
+	Paused     time.Duration               `json:"paused"`
+	Operations []workload.OperationSummary `json:"operations"`
+	Aborted    string                      `json:"aborted"`
+}
+
+// runScenario implements the scenario subcommand, which runs each step of a scenario file in turn
+// and reports the outcome of every step.
+func runScenario(args []string) {
+	if len(args) == 0 || args[0] != "run" {
+		zap.L().Fatal("Usage: spectroperf scenario run [flags] <scenario file>")
+	}
+
+	fs := flag.NewFlagSet("scenario run", flag.ExitOnError)
+	runId := fs.String("run-id", strconv.FormatUint(rand.Uint64(), 36), "identifier for the scenario, each step is run with this and its name as its run ID (default random)")
+	report := fs.String("report", "", "path to write the combined report of the steps to as JSON")
+	keepGoing := fs.Bool("keep-going", false, "run the remaining steps after a step fails")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		zap.L().Fatal("Expected a single scenario file")
+	}
+
+	path := fs.Arg(0)
+	scenario, err := readScenario(path)
+	if err != nil {
+		zap.L().Fatal("Invalid scenario", zap.String("scenario", path), zap.Error(err))
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		zap.L().Fatal("Failed to find spectroperf executable", zap.Error(err))
+	}
+	reports, err := os.MkdirTemp("", "spectroperf-scenario-")
+	if err != nil {
+		zap.L().Fatal("Failed to create directory for step reports", zap.Error(err))
+	}
+
+	var results []stepResult
+	for i, step := range scenario.Steps {
+		// Every step shares the key namespace of the scenario, so that each loads its documents
+		// over the same keys rather than adding more, or reuses those loaded before it.
+		result := stepResult{Name: step.Name, RunId: *runId + "-" + step.Name, Start: time.Now()}
+		reportPath := filepath.Join(reports, step.Name+".json")
+		stepArgs := []string{"--run-id", result.RunId, "--key-namespace", *runId, "--report-file", reportPath}
+		if step.ReuseData {
+			stepArgs = append(stepArgs, "--skip-load")
+		}
+		if scenario.Config != "" {
+			stepArgs = append(stepArgs, "--config", scenario.Config)
+		}
+		if step.Profile != "" {
+			stepArgs = append(stepArgs, "--profile", step.Profile)
+		}
+		stepArgs = append(stepArgs, optionFlags(step.Options)...)
+
+		zap.L().Info("Starting scenario step", zap.Int("step", i+1), zap.String("name", step.Name), zap.Strings("args", stepArgs))
+		cmd := exec.Command(executable, stepArgs...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+		result.Duration = time.Since(result.Start).Round(time.Second)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		written, reportErr := readStepReport(reportPath)
+		if reportErr != nil {
+			zap.L().Error("Failed to read the report of a scenario step", zap.String("name", step.Name), zap.Error(reportErr))
+		} else {
+			result.Operations = written.operations()
+			if written.Aborted != "" {
+				result.Error = written.Aborted
+			}
+		}
+		results = append(results, result)
+
+		if err != nil && !*keepGoing {
+			zap.L().Error("Scenario step failed, skipping the remaining steps", zap.String("name", step.Name), zap.Error(err))
+			break
+		}
+	}
+
+	os.RemoveAll(reports)
+
+	scenarioReport(os.Stdout, results)
+	if *report != "" {
+		err = writeScenarioReport(*report, results)
+		if err != nil {
+			zap.L().Fatal("Failed to write scenario report", zap.Error(err))
+		}
+	}
+	failed := len(results) < len(scenario.Steps)
+	for _, result := range results {
+		failed = failed || result.Error != ""
+	}
+	if failed {
+		zap.L().Fatal("Scenario failed", zap.String("runId", *runId))
+	}
+}
+
+// readScenario reads and checks a scenario file, resolving the config file it names relative to
+// the scenario file.
+func readScenario(path string) (scenarioFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenarioFile{}, errors.Wrap(err, "failed to read scenario file")
+	}
+
+	var scenario scenarioFile
+	err = yaml.Unmarshal(data, &scenario)
+	if err != nil {
+		return scenarioFile{}, errors.Wrapf(err, "failed to parse scenario file %s", path)
+	}
+
+	if len(scenario.Steps) == 0 {
+		return scenarioFile{}, fmt.Errorf("scenario has no steps")
+	}
+	names := map[string]bool{}
+	for i := range scenario.Steps {
+		step := &scenario.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if names[step.Name] {
+			return scenarioFile{}, fmt.Errorf("scenario has more than one step named %s", step.Name)
+		}
+		names[step.Name] = true
+		if step.ReuseData && i == 0 {
+			return scenarioFile{}, fmt.Errorf("step %s reuses data but is the first step, so no data was loaded before it", step.Name)
+		}
+		if step.Profile != "" && scenario.Config == "" {
+			return scenarioFile{}, fmt.Errorf("step %s uses a profile but the scenario has no config file", step.Name)
+		}
+		for option, value := range step.Options {
+			switch value.(type) {
+			case map[string]any, []any:
+				return scenarioFile{}, fmt.Errorf("step %s option %s is not a single value, set it in a profile of the config file instead", step.Name, option)
+			}
+		}
+	}
+
+	if scenario.Config != "" && !filepath.IsAbs(scenario.Config) {
+		scenario.Config = filepath.Join(filepath.Dir(path), scenario.Config)
+	}
+	return scenario, nil
+}
+
+// optionFlags turns the options of a step into command line flags, in a stable order.
+func optionFlags(options map[string]any) []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := make([]string, len(names))
+	for i, name := range names {
+		flags[i] = fmt.Sprintf("--%s=%v", name, options[name])
+	}
+	return flags
+}
+
+// readStepReport reads the report a step wrote at the end of its run.
+func readStepReport(path string) (stepReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return stepReport{}, errors.Wrap(err, "failed to read step report")
+	}
+	var report stepReport
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		return stepReport{}, errors.Wrapf(err, "failed to parse step report %s", path)
+	}
+	return report, nil
+}
+
+// operations returns the summary of each operation of the step with its throughput.
+func (r stepReport) operations() []stepOperation {
+	active := r.End.Sub(r.Start) - r.Paused
+	operations := make([]stepOperation, len(r.Operations))
+	for i, summary := range r.Operations {
+		operations[i].OperationSummary = summary
+		if active > 0 {
+			operations[i].Throughput = float64(summary.Attempts) / active.Seconds()
+		}
+	}
+	return operations
+}
+
+// scenarioReport writes a table of the outcome of each step, and one of the operations of every
+// step to compare them by.
+func scenarioReport(out io.Writer, results []stepResult) {
+	fmt.Fprintf(out, "| Step | Run ID | Start | Duration | Result |\n")
+	fmt.Fprintf(out, "|---|---|---|---|---|\n")
+	for _, result := range results {
+		outcome := "ok"
+		if result.Error != "" {
+			outcome = "failed: " + result.Error
+		}
+		fmt.Fprintf(out, "| %s | %s | %s | %s | %s |\n", result.Name, result.RunId, result.Start.Format(time.RFC3339), result.Duration, outcome)
+	}
+
+	fmt.Fprintf(out, "\n| Step | Phase | Target | Operation | Attempts | Failures | Ops/s | p50 ms | p99 ms |\n")
+	fmt.Fprintf(out, "|---|---|---|---|---|---|---|---|---|\n")
+	for _, result := range results {
+		for _, op := range result.Operations {
+			fmt.Fprintf(out, "| %s | %s | %s | %s | %d | %d | %.1f | %.3f | %.3f |\n", result.Name, op.Phase, op.Target, op.Operation, op.Attempts, op.Failures, op.Throughput, op.P50, op.P99)
+		}
+	}
+}
+
+func writeScenarioReport(path string, results []stepResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode scenario report")
+	}
+	return errors.Wrap(os.WriteFile(path, data, 0644), "failed to write scenario report")
+}
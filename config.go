@@ -0,0 +1,651 @@
+package spectroperf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the options for a spectroperf run. Values can come from a YAML config file and
+// command line flags, with any flags given on the command line taking precedence over the file.
+type Config struct {
+	RunId            string             `yaml:"run-id"`
+	KeyNamespace     string             `yaml:"key-namespace"`
+	IndexPrefix      string             `yaml:"index-prefix"`
+	ReuseIndexes     bool               `yaml:"reuse-indexes"`
+	SkipLoad         bool               `yaml:"skip-load"`
+	IndexReplicas    int                `yaml:"index-replicas"`
+	IndexDeferBuild  bool               `yaml:"index-defer-build"`
+	IndexPartitionBy string             `yaml:"index-partition-by"`
+	IndexTimeout     time.Duration      `yaml:"index-build-timeout"`
+	IndexPoll        time.Duration      `yaml:"index-poll-interval"`
+	IndexPollBackoff string             `yaml:"index-poll-backoff"`
+	Teardown         bool               `yaml:"teardown"`
+	TeardownData     bool               `yaml:"teardown-data"`
+	ColdStart        bool               `yaml:"cold-start"`
+	Seed             int                `yaml:"seed"`
+	KeyShards        int                `yaml:"key-shards"`
+	KeySharedFrac    float64            `yaml:"key-shared-fraction"`
+	ConfigFile       string             `yaml:"-"`
+	Profile          string             `yaml:"-"`
+	Connstr          string             `yaml:"connstr"`
+	Cert             string             `yaml:"cert"`
+	Username         string             `yaml:"username"`
+	Password         string             `yaml:"password"`
+	PasswordFile     string             `yaml:"password-file"`
+	SecretsDir       string             `yaml:"secrets-dir"`
+	ClientCert       string             `yaml:"client-cert"`
+	ClientKey        string             `yaml:"client-key"`
+	Bucket           string             `yaml:"bucket"`
+	Scope            string             `yaml:"scope"`
+	Collection       string             `yaml:"collection"`
+	NumItems         int                `yaml:"num-items"`
+	NumUsers         int                `yaml:"num-users"`
+	TargetResidency  float64            `yaml:"target-residency"`
+	TlsSkipVerify    bool               `yaml:"tls-skip-verify"`
+	Target           string             `yaml:"target"`
+	MockLatency      time.Duration      `yaml:"mock-latency"`
+	Workload         string             `yaml:"workload"`
+	PluginArgs       string             `yaml:"plugin-args"`
+	DapiConnstr      string             `yaml:"dapi-connstr"`
+	CompareConnstr   string             `yaml:"compare-connstr"`
+	CompareDapi      string             `yaml:"compare-dapi-connstr"`
+	CompareWorkload  string             `yaml:"compare-workload"`
+	DapiMaxConns     int                `yaml:"dapi-max-conns-per-host"`
+	DapiMaxIdleConns int                `yaml:"dapi-max-idle-conns-per-host"`
+	DapiIdleTimeout  time.Duration      `yaml:"dapi-idle-timeout"`
+	DapiHTTPVersion  string             `yaml:"dapi-http-version"`
+	DapiTLSSessions  int                `yaml:"dapi-tls-session-cache"`
+	SetupTimeout     time.Duration      `yaml:"setup-timeout"`
+	LoadVia          string             `yaml:"load-via"`
+	MgmtUsers        int                `yaml:"mgmt-users"`
+	MgmtInterval     time.Duration      `yaml:"mgmt-interval"`
+	MgmtURL          string             `yaml:"mgmt-url"`
+	RbacUsers        int                `yaml:"rbac-users"`
+	RbacUsersFile    string             `yaml:"rbac-users-file"`
+	RampUsers        int                `yaml:"ramp-start-users"`
+	RampUp           time.Duration      `yaml:"ramp-up"`
+	RampDown         time.Duration      `yaml:"ramp-down"`
+	IdleUsers        int                `yaml:"idle-users"`
+	IdleInterval     time.Duration      `yaml:"idle-interval"`
+	Workers          int                `yaml:"workers"`
+	RunTime          time.Duration      `yaml:"run-time"`
+	CapacitySearch   bool               `yaml:"capacity-search"`
+	CapacityStart    float64            `yaml:"capacity-start"`
+	CapacityStepTime time.Duration      `yaml:"capacity-step-duration"`
+	CapacityMaxP99   time.Duration      `yaml:"capacity-max-p99"`
+	CapacityMaxError float64            `yaml:"capacity-max-error-rate"`
+	CapacityAccuracy float64            `yaml:"capacity-accuracy"`
+	CapacityMaxSteps int                `yaml:"capacity-max-steps"`
+	CoolDown         time.Duration      `yaml:"cool-down"`
+	Phases           []PhaseConfig      `yaml:"phases"`
+	MarkovChain      *markovChainConfig `yaml:"markov-chain"`
+	OperationWeights map[string]float64 `yaml:"operation-weights"`
+	OnlyOperation    string             `yaml:"only-operation"`
+	CompoundOps      string             `yaml:"compound-operations"`
+	WriteRatio       string             `yaml:"write-ratio"`
+	MarkovEpsilon    float64            `yaml:"markov-epsilon"`
+	MarkovNormalize  bool               `yaml:"markov-normalize"`
+	ThinkTime        string             `yaml:"think-time"`
+	OpThinkTimes     map[string]string  `yaml:"operation-think-times"`
+	MaxErrorRate     float64            `yaml:"max-error-rate"`
+	OpMaxErrorRates  map[string]float64 `yaml:"operation-max-error-rates"`
+	OpDeadline       time.Duration      `yaml:"operation-deadline"`
+	OpDeadlines      map[string]string  `yaml:"operation-deadlines"`
+	EgressBandwidth  int64              `yaml:"egress-bandwidth"`
+	IngressBandwidth int64              `yaml:"ingress-bandwidth"`
+	ErrorWindow      time.Duration      `yaml:"error-window"`
+	ErrorMinOps      int                `yaml:"error-min-operations"`
+	ErrorAction      string             `yaml:"error-budget-action"`
+	ErrorLogInterval time.Duration      `yaml:"error-log-interval"`
+	ProgressInterval time.Duration      `yaml:"progress-interval"`
+	TimelineInterval time.Duration      `yaml:"timeline-interval"`
+	CorrectOmission  bool               `yaml:"correct-coordinated-omission"`
+	NativeHistograms bool               `yaml:"native-histograms"`
+	LogLevel         string             `yaml:"log-level"`
+	LogFile          string             `yaml:"log-file"`
+	LogFileLevel     string             `yaml:"log-file-level"`
+	LogFileMaxSize   int64              `yaml:"log-file-max-size"`
+	LogFileMaxAge    time.Duration      `yaml:"log-file-max-age"`
+	ClientStatsEvery time.Duration      `yaml:"client-stats-interval"`
+	Soak             bool               `yaml:"soak"`
+	Pprof            bool               `yaml:"pprof"`
+	ProfileCPU       string             `yaml:"profile-cpu"`
+	ProfileMem       string             `yaml:"profile-mem"`
+	MetricsSink      string             `yaml:"metrics-sink"`
+	StatsdAddr       string             `yaml:"statsd-addr"`
+	StatsdPrefix     string             `yaml:"statsd-prefix"`
+	StatsdTags       string             `yaml:"statsd-tags"`
+	ControlAPI       bool               `yaml:"control-api"`
+	StatsInterval    time.Duration      `yaml:"cluster-stats-interval"`
+	StatsFile        string             `yaml:"cluster-stats-file"`
+	PromURL          string             `yaml:"prometheus-url"`
+	PromUsername     string             `yaml:"prometheus-username"`
+	PromPassword     string             `yaml:"prometheus-password"`
+	PromToken        string             `yaml:"prometheus-bearer-token"`
+	PromCert         string             `yaml:"prometheus-cert"`
+	PromSkipVerify   bool               `yaml:"prometheus-tls-skip-verify"`
+	PromScrapeWait   time.Duration      `yaml:"prometheus-scrape-wait"`
+	UploadURL        string             `yaml:"upload-url"`
+	UploadEndpoint   string             `yaml:"upload-endpoint"`
+	UploadRegion     string             `yaml:"upload-region"`
+	ReportFile       string             `yaml:"report-file"`
+	Generator        string             `yaml:"generator"`
+	NoCompression    bool               `yaml:"disable-compression"`
+	CompressMinSize  int                `yaml:"compression-min-size"`
+	CompressMinRatio float64            `yaml:"compression-min-ratio"`
+	SDKMetrics       bool               `yaml:"sdk-metrics"`
+	GeneratorFields  map[string]string  `yaml:"generator-fields"`
+	ScanSize         string             `yaml:"scan-size"`
+	LockMode         string             `yaml:"lock-mode"`
+	LockDuration     time.Duration      `yaml:"lock-duration"`
+	LockHold         time.Duration      `yaml:"lock-hold"`
+	ReplicaReads     float64            `yaml:"replica-reads"`
+	BatchSize        int                `yaml:"batch-size"`
+	Mutations        string             `yaml:"mutation-semantics"`
+	ChurnPolicy      string             `yaml:"churn-policy"`
+	EventRetention   time.Duration      `yaml:"event-retention"`
+	EventWindow      time.Duration      `yaml:"event-window"`
+	SessionTTL       time.Duration      `yaml:"session-ttl"`
+	SessionChurn     float64            `yaml:"session-churn"`
+	InboxMessages    int                `yaml:"inbox-messages"`
+	ExistingKeys     string             `yaml:"existing-keys"`
+	QueryAdhoc       bool               `yaml:"query-adhoc"`
+	QueryConsistency string             `yaml:"query-consistency"`
+	QueryParallelism int                `yaml:"query-max-parallelism"`
+	QueryExpectRows  string             `yaml:"query-expect-rows"`
+	RecordTrace      string             `yaml:"record-trace"`
+	ReplayTrace      string             `yaml:"replay-trace"`
+}
+
+// PhaseConfig is one phase of a run plan given in the config file.  A phase without a user count
+// runs num-users users, and one without a markov chain or operation weights uses those of the run.
+type PhaseConfig struct {
+	Name             string             `yaml:"name"`
+	Duration         time.Duration      `yaml:"duration"`
+	Users            int                `yaml:"users"`
+	Throughput       float64            `yaml:"throughput"`
+	MarkovChain      *markovChainConfig `yaml:"markov-chain"`
+	OperationWeights map[string]float64 `yaml:"operation-weights"`
+	MaxErrorRate     float64            `yaml:"max-error-rate"`
+}
+
+// passwordEnv names the environment variable that supplies the cluster password when it is not
+// given as a flag or in the config file.
+const passwordEnv = "SPECTROPERF_PASSWORD"
+
+// secretsDirEnv names the environment variable that supplies the secrets directory.
+const secretsDirEnv = "SPECTROPERF_SECRETS_DIR"
+
+// redactedSecret replaces secrets when the config is logged.
+const redactedSecret = "<redacted>"
+
+// loadSecrets replaces any secrets in cfg that were given as a path to a file with the file contents.
+// A secrets directory, such as a mounted Kubernetes secret, may hold a file per secret named after
+// its flag, which is used only for secrets not given otherwise, on the command line, in the config
+// file or, for the password, in its environment variable, and unless a file for that secret was
+// given explicitly.  The client certificate and key are files already, so are used where they are.
+func loadSecrets(cfg *Config, given map[string]bool) error {
+	if cfg.SecretsDir != "" {
+		secrets := map[string]*string{
+			"username":                &cfg.Username,
+			"password":                &cfg.Password,
+			"prometheus-password":     &cfg.PromPassword,
+			"prometheus-bearer-token": &cfg.PromToken,
+		}
+		if _, ok := os.LookupEnv(passwordEnv); ok {
+			delete(secrets, "password")
+		}
+		for name, value := range secrets {
+			if given[name] {
+				continue
+			}
+			secret, err := readSecretFile(filepath.Join(cfg.SecretsDir, name))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			*value = secret
+		}
+
+		// The key may also be named after --cert-key-file, its alias, when there is no client-key
+		files := []struct {
+			names []string
+			value *string
+		}{
+			{names: []string{"client-cert"}, value: &cfg.ClientCert},
+			{names: []string{"client-key", "cert-key-file"}, value: &cfg.ClientKey},
+		}
+		for _, file := range files {
+			if slices.ContainsFunc(file.names, func(name string) bool { return given[name] }) {
+				continue
+			}
+			for _, name := range file.names {
+				path := filepath.Join(cfg.SecretsDir, name)
+				_, err := os.Stat(path)
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				} else if err != nil {
+					return errors.Wrapf(err, "failed to read secret file %s", path)
+				}
+				*file.value = path
+				break
+			}
+		}
+	}
+
+	if cfg.PasswordFile != "" {
+		password, err := readSecretFile(cfg.PasswordFile)
+		if err != nil {
+			return err
+		}
+		cfg.Password = password
+	}
+
+	return nil
+}
+
+// readSecretFile returns the contents of a file holding a single secret, without the trailing
+// newline that editors and secret stores tend to add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read secret file %s", path)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// redacted returns a copy of the config that is safe to log.
+func (c Config) redacted() Config {
+	if c.Password != "" {
+		c.Password = redactedSecret
+	}
+	if c.PromPassword != "" {
+		c.PromPassword = redactedSecret
+	}
+	if c.PromToken != "" {
+		c.PromToken = redactedSecret
+	}
+
+	return c
+}
+
+// hash returns a short hash of the config, which is the same for runs with the same options
+// whatever their run ID, without revealing any secrets.
+func (c Config) hash() (string, error) {
+	c = c.redacted()
+	c.RunId = ""
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal config")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// buildPhases returns the run plan for the workload.  Without any phases in the config, the run is a
+// single phase of num-users users ramped up and down as configured.
+func buildPhases(cfg Config, w workload.Workload) ([]workload.Phase, error) {
+	operations := w.Operations()
+
+	// The markov chain or operation weights, when given, replace the markov chain of the workload in
+	// every phase that does not have its own.
+	chainOpts := markovOptions{epsilon: cfg.MarkovEpsilon, normalize: cfg.MarkovNormalize}
+	defaultChain, err := resolveMarkovChain(operations, cfg.MarkovChain, cfg.OperationWeights, chainOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only running some operations is for quick targeted tests, so it overrides the operation mix
+	// of every phase.
+	if cfg.OnlyOperation != "" {
+		if defaultChain != nil {
+			return nil, fmt.Errorf("only-operation must not be given with a markov chain or operation weights")
+		}
+		weights, err := parseOnlyOperation(cfg.OnlyOperation)
+		if err != nil {
+			return nil, err
+		}
+		defaultChain, err = weightsToMarkovChain(operations, weights)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid only-operation")
+		}
+	}
+
+	var phases []workload.Phase
+	if len(cfg.Phases) == 0 {
+		phases = []workload.Phase{{
+			Name:          "run",
+			Duration:      cfg.RunTime,
+			Users:         cfg.NumUsers,
+			Probabilities: defaultChain,
+			Ramp: workload.Ramp{
+				StartUsers: cfg.RampUsers,
+				Up:         cfg.RampUp,
+				Down:       cfg.RampDown,
+			},
+		}}
+	}
+	for i, pc := range cfg.Phases {
+		phase := workload.Phase{
+			Name:         pc.Name,
+			Duration:     pc.Duration,
+			Users:        pc.Users,
+			Throughput:   pc.Throughput,
+			MaxErrorRate: pc.MaxErrorRate,
+		}
+		if phase.Name == "" {
+			phase.Name = fmt.Sprintf("phase-%d", i+1)
+		}
+		if phase.Users == 0 {
+			phase.Users = cfg.NumUsers
+		}
+		if phase.Duration <= 0 {
+			return nil, fmt.Errorf("phase %s must have a positive duration", phase.Name)
+		}
+		if phase.Throughput < 0 {
+			return nil, fmt.Errorf("phase %s must not have a negative throughput", phase.Name)
+		}
+		if phase.MaxErrorRate < 0 || phase.MaxErrorRate > 1 {
+			return nil, fmt.Errorf("phase %s must have a max error rate between 0 and 1", phase.Name)
+		}
+		phase.Probabilities, err = resolveMarkovChain(operations, pc.MarkovChain, pc.OperationWeights, chainOpts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid operation mix for phase %s", phase.Name)
+		}
+		if phase.Probabilities == nil || cfg.OnlyOperation != "" {
+			phase.Probabilities = defaultChain
+		}
+		phases = append(phases, phase)
+	}
+
+	// The write ratio sweeps the mix of reads and writes of whichever chain each phase runs.
+	ratio, err := parseWriteRatio(cfg.WriteRatio)
+	if err != nil {
+		return nil, err
+	}
+	if ratio >= 0 {
+		writes := make([]bool, len(operations))
+		for _, info := range w.Describe() {
+			if i := slices.Index(operations, info.Name); i >= 0 {
+				writes[i] = info.Writes
+			}
+		}
+		for i := range phases {
+			chain := phases[i].Probabilities
+			if chain == nil {
+				chain = w.Probabilities()
+			}
+			phases[i].Probabilities, err = applyWriteRatio(operations, chain, writes, ratio)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid write ratio for phase %s", phases[i].Name)
+			}
+		}
+	}
+
+	return phases, nil
+}
+
+// buildThinkTimes parses the think time for the workload and any operations with their own.
+func buildThinkTimes(cfg Config, operations []string) (workload.ThinkTimes, error) {
+	thinkTimes := workload.ThinkTimes{
+		Default:    workload.DefaultThinkTime,
+		Operations: map[string]workload.ThinkTime{},
+	}
+
+	var err error
+	if cfg.ThinkTime != "" {
+		thinkTimes.Default, err = workload.ParseThinkTime(cfg.ThinkTime)
+		if err != nil {
+			return workload.ThinkTimes{}, err
+		}
+	}
+
+	for operation, spec := range cfg.OpThinkTimes {
+		if !slices.Contains(operations, operation) {
+			return workload.ThinkTimes{}, fmt.Errorf("think time given for unknown operation %s", operation)
+		}
+		thinkTimes.Operations[operation], err = workload.ParseThinkTime(spec)
+		if err != nil {
+			return workload.ThinkTimes{}, errors.Wrapf(err, "invalid think time for operation %s", operation)
+		}
+	}
+
+	return thinkTimes, nil
+}
+
+// Error budget actions, for what to do when an operation fails too often.
+const (
+	errorActionAbort     = "abort"
+	errorActionOperation = "stop-operation"
+)
+
+// buildErrorBudget checks the error budget of the run, which aborts the run or stops operations
+// that fail more often than the max error rate.
+func buildErrorBudget(cfg Config, operations []string) (workload.ErrorBudget, error) {
+	budget := workload.ErrorBudget{
+		MaxErrorRate:  cfg.MaxErrorRate,
+		Operations:    cfg.OpMaxErrorRates,
+		Window:        cfg.ErrorWindow,
+		MinOperations: cfg.ErrorMinOps,
+	}
+
+	switch cfg.ErrorAction {
+	case errorActionAbort:
+	case errorActionOperation:
+		budget.DisableOperation = true
+	default:
+		return workload.ErrorBudget{}, fmt.Errorf("unknown error budget action %s, expected %s or %s", cfg.ErrorAction, errorActionAbort, errorActionOperation)
+	}
+
+	if budget.MaxErrorRate < 0 || budget.MaxErrorRate > 1 {
+		return workload.ErrorBudget{}, fmt.Errorf("max error rate must be between 0 and 1")
+	}
+	for operation, rate := range budget.Operations {
+		if !slices.Contains(operations, operation) {
+			return workload.ErrorBudget{}, fmt.Errorf("max error rate given for unknown operation %s", operation)
+		}
+		if rate < 0 || rate > 1 {
+			return workload.ErrorBudget{}, fmt.Errorf("max error rate for operation %s must be between 0 and 1", operation)
+		}
+	}
+	if budget.Window <= 0 {
+		return workload.ErrorBudget{}, fmt.Errorf("error window must be positive")
+	}
+
+	return budget, nil
+}
+
+// buildDeadlines checks the deadlines of the operations of the run, which fail operations that
+// run for too long.
+func buildDeadlines(cfg Config, operations []string) (workload.Deadlines, error) {
+	if cfg.OpDeadline < 0 {
+		return workload.Deadlines{}, fmt.Errorf("operation deadline must not be negative")
+	}
+	deadlines := workload.Deadlines{
+		Default:    cfg.OpDeadline,
+		Operations: map[string]time.Duration{},
+	}
+
+	for operation, spec := range cfg.OpDeadlines {
+		if !slices.Contains(operations, operation) {
+			return workload.Deadlines{}, fmt.Errorf("deadline given for unknown operation %s", operation)
+		}
+		deadline, err := time.ParseDuration(spec)
+		if err != nil {
+			return workload.Deadlines{}, errors.Wrapf(err, "invalid deadline for operation %s", operation)
+		}
+		if deadline < 0 {
+			return workload.Deadlines{}, fmt.Errorf("deadline for operation %s must not be negative", operation)
+		}
+		deadlines.Operations[operation] = deadline
+	}
+
+	return deadlines, nil
+}
+
+// buildCapacitySearch returns the capacity search of the run, or nil if it runs its phases.  The
+// search runs steps like the single phase of a run without phases, at a fixed number of users.
+func buildCapacitySearch(cfg Config) (*workload.CapacitySearch, error) {
+	if !cfg.CapacitySearch {
+		return nil, nil
+	}
+	switch {
+	case len(cfg.Phases) > 0:
+		return nil, fmt.Errorf("a capacity search cannot be given phases")
+	case cfg.RampUp > 0 || cfg.RampDown > 0:
+		return nil, fmt.Errorf("a capacity search cannot ramp users up or down")
+	case cfg.MgmtUsers > 0:
+		return nil, fmt.Errorf("a capacity search cannot run management users, which run for a fixed duration")
+	case cfg.RecordTrace != "" || cfg.ReplayTrace != "":
+		return nil, fmt.Errorf("a capacity search cannot record or replay a trace")
+	}
+	search := &workload.CapacitySearch{
+		Start:        cfg.CapacityStart,
+		StepDuration: cfg.CapacityStepTime,
+		MaxP99:       cfg.CapacityMaxP99,
+		MaxErrorRate: cfg.CapacityMaxError,
+		Accuracy:     cfg.CapacityAccuracy,
+		MaxSteps:     cfg.CapacityMaxSteps,
+	}
+	err := search.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return search, nil
+}
+
+// configFile is the layout of a YAML config file.  The base section applies to every run, and
+// each named profile is layered on top of it (or on top of the profile it inherits from), so
+// only the options that differ need to be listed in a profile.
+//
+//	base:
+//	  connstr: couchbases://cb.example.com
+//	  bucket: data
+//	profiles:
+//	  smoke:
+//	    num-items: 1000
+//	    num-users: 10
+//	  capella:
+//	    inherits: smoke
+//	    tls-skip-verify: true
+type configFile struct {
+	Base     yaml.Node            `yaml:"base"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+type profileHeader struct {
+	Inherits string `yaml:"inherits"`
+}
+
+// loadConfigFile applies the base section of the config file at path to cfg, followed by the
+// chain of profiles leading to the named profile.  An empty profile applies only the base section.
+// It returns the names of the options those sections set.
+func loadConfigFile(path string, profile string, cfg *Config) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config file")
+	}
+
+	var file configFile
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config file %s", path)
+	}
+
+	// Check the whole file, not only the profile being run, so that mistakes are found whichever
+	// profile is run first.  Every problem is reported at once, each with its line.
+	var errs schemaErrors
+	var root yaml.Node
+	err = yaml.Unmarshal(data, &root)
+	if err == nil && len(root.Content) > 0 {
+		checkSchema(root.Content[0], reflect.TypeOf(configFile{}), "", nil, &errs)
+	}
+	// A value of the wrong type is reported with its line, rather than leaving the option unset.
+	// Each section is decoded on its own for this, as the profile run applies only some of them.
+	typeErrors := func(node *yaml.Node) {
+		var scratch Config
+		var typeErr *yaml.TypeError
+		if errors.As(node.Decode(&scratch), &typeErr) {
+			errs = append(errs, typeErr.Errors...)
+		}
+	}
+	if !file.Base.IsZero() {
+		checkConfigSchema(&file.Base, nil, &errs)
+		typeErrors(&file.Base)
+	}
+	for _, node := range file.Profiles {
+		checkConfigSchema(&node, []string{"inherits"}, &errs)
+		typeErrors(&node)
+	}
+
+	layers, err := profileChain(file.Profiles, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if !file.Base.IsZero() {
+		layers = append([]yaml.Node{file.Base}, layers...)
+	}
+
+	var set []string
+	for _, layer := range layers {
+		for i := 0; i+1 < len(layer.Content); i += 2 {
+			if key := layer.Content[i].Value; key != "inherits" && !slices.Contains(set, key) {
+				set = append(set, key)
+			}
+		}
+		err = layer.Decode(cfg)
+		var typeErr *yaml.TypeError
+		if err != nil && !errors.As(err, &typeErr) {
+			return nil, errors.Wrapf(err, "failed to decode config file %s", path)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Wrapf(errs.sort(), "invalid config file %s", path)
+	}
+	return set, nil
+}
+
+// profileChain returns the config nodes for the named profile and the profiles it inherits from,
+// ordered from the furthest ancestor to the profile itself.
+func profileChain(profiles map[string]yaml.Node, name string) ([]yaml.Node, error) {
+	var chain []yaml.Node
+	seen := map[string]bool{}
+
+	for name != "" {
+		if seen[name] {
+			return nil, fmt.Errorf("profile %s inherits from itself", name)
+		}
+		seen[name] = true
+
+		node, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("profile %s not found in config file", name)
+		}
+
+		var header profileHeader
+		err := node.Decode(&header)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode profile %s", name)
+		}
+
+		chain = append([]yaml.Node{node}, chain...)
+		name = header.Inherits
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,19 @@
+// Package spectroperf runs simulated users against a Couchbase cluster, each moving between the
+// operations of a workload with the probabilities of a markov chain, and measures their
+// latency and throughput.
+//
+// The spectroperf command line is a thin wrapper around a Runner, which other test harnesses
+// can use to drive runs programmatically:
+//
+//	cfg, err := spectroperf.ParseArgs([]string{"--connstr", "couchbase://localhost", "--workload", "user-profile"})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := spectroperf.Runner{Config: cfg, Metrics: spectroperf.LocalSink{}}.Run(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	for _, op := range result.Operations {
+//		fmt.Println(op.Operation, op.Attempts, op.P99)
+//	}
+package spectroperf
@@ -0,0 +1,250 @@
+package spectroperf
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/couchbaselabs/spectroperf/workload/dapi"
+	"github.com/couchbaselabs/spectroperf/workload/workloads"
+	"github.com/pkg/errors"
+)
+
+// ParseArgs parses the command line arguments of a run into its config, along with the config
+// file and secrets they name.  Flags given as arguments override the config file.
+func ParseArgs(args []string) (Config, error) {
+	cfg := Config{}
+	fs := flag.NewFlagSet("spectroperf", flag.ContinueOnError)
+	fs.StringVar(&cfg.RunId, "run-id", strconv.FormatUint(rand.Uint64(), 36), "identifier for this run (default random)")
+	fs.StringVar(&cfg.KeyNamespace, "key-namespace", "", "prefix for every document key, so that concurrent runs do not share documents, or none for no prefix (default the run ID)")
+	fs.StringVar(&cfg.IndexPrefix, "index-prefix", "", "prefix for the name of every index the workload creates, so that workloads sharing a cluster keep their indexes apart")
+	fs.BoolVar(&cfg.ReuseIndexes, "reuse-indexes", false, "skip creating the indexes of the workload, which must already exist")
+	fs.BoolVar(&cfg.SkipLoad, "skip-load", false, "skip loading documents, running over those an earlier run with the same key namespace and num-items loaded")
+	fs.IntVar(&cfg.IndexReplicas, "index-replicas", 0, "number of replicas of each index the workload creates")
+	fs.BoolVar(&cfg.IndexDeferBuild, "index-defer-build", false, "create the indexes of the workload deferred, and build them together once the workload is set up")
+	fs.StringVar(&cfg.IndexPartitionBy, "index-partition-by", "", "expressions to hash partition each index the workload creates by, such as META().id")
+	fs.DurationVar(&cfg.IndexTimeout, "index-build-timeout", 0, "how long to wait for each index to build before failing the setup, or 0 to wait until the setup timeout")
+	fs.DurationVar(&cfg.IndexPoll, "index-poll-interval", time.Second, "how long to wait before first checking whether deferred indexes have built")
+	fs.StringVar(&cfg.IndexPollBackoff, "index-poll-backoff", workload.IndexPollExponential, "how the wait between checks of whether indexes have built grows: linear or exponential, up to 30s")
+	fs.BoolVar(&cfg.Teardown, "teardown", false, "drop the indexes created by the run when it ends")
+	fs.BoolVar(&cfg.TeardownData, "teardown-data", false, "remove the documents loaded by the run when it ends")
+	fs.BoolVar(&cfg.ColdStart, "cold-start", false, "restart the bucket under test after the setup, so the run starts against an empty cache and fresh connections")
+	fs.IntVar(&cfg.Seed, "seed", rand.Intn(math.MaxInt32), "seed for generated documents, key selection and operation choice, to make runs reproducible (default random)")
+	fs.StringVar(&cfg.ConfigFile, "config", "", "path to a YAML config file")
+	fs.StringVar(&cfg.Profile, "profile", "", "named profile from the config file to run with")
+	fs.StringVar(&cfg.Connstr, "connstr", "", "connection string of the cluster under test")
+	fs.StringVar(&cfg.Cert, "cert", "rootCA.crt", "path to a CA certificate file trusted in addition to the system roots, empty for the system roots only")
+	fs.StringVar(&cfg.Username, "username", "Administrator", "username for cluster under test")
+	fs.StringVar(&cfg.Password, "password", envOrDefault(passwordEnv, "password"), "password of the cluster under test, defaults to $"+passwordEnv+" if set")
+	fs.StringVar(&cfg.PasswordFile, "password-file", "", "path to a file containing the password of the cluster under test")
+	fs.StringVar(&cfg.ClientCert, "client-cert", "", "path to a client certificate to authenticate with instead of a password")
+	fs.StringVar(&cfg.ClientKey, "client-key", "", "path to the private key of the client certificate")
+	fs.StringVar(&cfg.ClientKey, "cert-key-file", "", "alias of --client-key")
+	fs.StringVar(&cfg.SecretsDir, "secrets-dir", os.Getenv(secretsDirEnv), "directory of mounted secret files named after their flags, e.g. username, password and client-key, defaults to $"+secretsDirEnv)
+	fs.StringVar(&cfg.Bucket, "bucket", "data", "bucket name")
+	fs.StringVar(&cfg.Scope, "scope", "identity", "scope name")
+	fs.StringVar(&cfg.Collection, "collection", "profiles", "collection name")
+	fs.IntVar(&cfg.NumItems, "num-items", 200000, "number of docs to create")
+	fs.StringVar(&cfg.Generator, "generator", "fake", "documents to load: fake, json-dir:<dir>, csv:<file>, sample:<cbexport file> or binary:<size>[:<compressibility>]")
+	fs.BoolVar(&cfg.NoCompression, "disable-compression", false, "disable compression of documents sent and received by the SDK")
+	fs.IntVar(&cfg.CompressMinSize, "compression-min-size", 32, "smallest document in bytes the SDK compresses")
+	fs.Float64Var(&cfg.CompressMinRatio, "compression-min-ratio", 0.83, "largest ratio of compressed to original size at which the SDK sends a document compressed")
+	fs.BoolVar(&cfg.SDKMetrics, "sdk-metrics", false, "record the operation, dispatch and server durations the SDK reports, and log its threshold and orphaned response reports")
+	fs.Float64Var(&cfg.TargetResidency, "target-residency", 0, "size num-items so that this fraction of the documents fit in the bucket's memory quota, e.g. 0.5")
+	fs.IntVar(&cfg.NumUsers, "num-users", 50000, "number of concurrent simulated users accessing the data")
+	fs.IntVar(&cfg.KeyShards, "key-shards", 0, "split the items into this many shards, each runner picking items from its own, so runners do not contend for the same documents, 0 to share every item")
+	fs.Float64Var(&cfg.KeySharedFrac, "key-shared-fraction", 0, "with key shards, fraction of items picked from every item rather than the runner's shard, keeping a hot set shared by all runners")
+	fs.DurationVar(&cfg.RunTime, "run-time", 5*time.Minute, "how long to run the workload for, unless phases are given in the config file")
+	fs.BoolVar(&cfg.CapacitySearch, "capacity-search", false, "instead of running for run-time, search for the highest throughput sustained within capacity-max-p99 and capacity-max-error-rate")
+	fs.Float64Var(&cfg.CapacityStart, "capacity-start", 100, "target throughput in operations per second of the first step of a capacity search, doubled each step until one fails")
+	fs.DurationVar(&cfg.CapacityStepTime, "capacity-step-duration", time.Minute, "how long each step of a capacity search runs for")
+	fs.DurationVar(&cfg.CapacityMaxP99, "capacity-max-p99", 0, "highest p99 latency of the operations of a step of a capacity search that passes, 0 for no limit")
+	fs.Float64Var(&cfg.CapacityMaxError, "capacity-max-error-rate", 0.01, "highest fraction of the operations of a step of a capacity search that may fail for it to pass")
+	fs.Float64Var(&cfg.CapacityAccuracy, "capacity-accuracy", 0.05, "end a capacity search once the gap between the highest throughput that passed and the lowest that failed is within this fraction")
+	fs.IntVar(&cfg.CapacityMaxSteps, "capacity-max-steps", 20, "most steps a capacity search runs")
+	fs.IntVar(&cfg.RampUsers, "ramp-start-users", 0, "number of users started immediately, before ramping up to num-users")
+	fs.DurationVar(&cfg.RampUp, "ramp-up", 0, "period over which the remaining users are started")
+	fs.DurationVar(&cfg.RampDown, "ramp-down", 0, "period at the end of the run over which users are stopped")
+	fs.StringVar(&cfg.CompoundOps, "compound-operations", "", "comma separated operations made up of operations of the workload run in order, to name in the markov chain or operation weights, e.g. login=fetchProfile+findRelatedProfiles")
+	fs.StringVar(&cfg.OnlyOperation, "only-operation", "", "comma separated operations to run instead of the workload's mix, each with an optional relative weight, e.g. fetchProfile:0.8,updateProfile:0.2")
+	fs.StringVar(&cfg.WriteRatio, "write-ratio", "", "fraction of operations that write, from 0 to 1, rescaling the markov chain of every phase between the operations of the workload that read and write")
+	fs.Float64Var(&cfg.MarkovEpsilon, "markov-epsilon", defaultMarkovEpsilon, "how far the probabilities of each markov chain row may sum from 1")
+	fs.BoolVar(&cfg.MarkovNormalize, "markov-normalize", false, "scale each markov chain row to sum to 1 instead of rejecting rows that do not")
+	fs.StringVar(&cfg.ThinkTime, "think-time", "", "think time before each operation: none, fixed:<d>, uniform:<min>-<max> or exponential:<mean> (default uniform:400ms-5s)")
+	fs.StringVar(&cfg.ScanSize, "scan-size", "", "number of documents read by each range scan: fixed:<n>, uniform:<min>-<max> or exponential:<mean> (default uniform:10-1000)")
+	fs.StringVar(&cfg.LockMode, "lock-mode", workloads.LockModeFlag, "how lockProfile locks a profile: flag to just disable it, or pessimistic to hold a lock on it with GetAndLock while doing so")
+	fs.DurationVar(&cfg.LockDuration, "lock-duration", 15*time.Second, "in pessimistic lock mode, how long the server keeps a profile locked if it is not unlocked")
+	fs.DurationVar(&cfg.LockHold, "lock-hold", 0, "in pessimistic lock mode, how long a profile is held locked before it is written and unlocked")
+	fs.Float64Var(&cfg.ReplicaReads, "replica-reads", 0, "fraction of fetchProfile reads made from any replica rather than the active copy, e.g. 0.2")
+	fs.IntVar(&cfg.BatchSize, "batch-size", 10, "number of documents read or written by each bulk operation")
+	fs.StringVar(&cfg.Mutations, "mutation-semantics", "", "how operations write back documents they read: upsert, replace or insert, optionally per operation as <operation>=<semantics>, comma separated (default each workload's own)")
+	fs.StringVar(&cfg.ChurnPolicy, "churn-policy", workloads.ChurnPolicyRecycle, "keys insertProfile inserts under: grow for new keys, or recycle for the keys of the loaded profiles")
+	fs.DurationVar(&cfg.EventRetention, "event-retention", time.Hour, "how long events appended by the time-series workload last before they expire")
+	fs.DurationVar(&cfg.EventWindow, "event-window", 5*time.Minute, "window of recent events queried by the time-series workload")
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", 30*time.Minute, "how long a session of the session-store workload lasts since it was last touched")
+	fs.Float64Var(&cfg.SessionChurn, "session-churn", 0.1, "chance that a session-store request is followed by the user logging out and back in")
+	fs.IntVar(&cfg.InboxMessages, "inbox-messages", 10, "most messages each inbox of the inbox workload starts with")
+	fs.StringVar(&cfg.ExistingKeys, "existing-keys", workloads.ExistingKeysSample, "how the existing-data workload discovers up to num-items keys: sample, query, or query:<statement> returning keys")
+	fs.BoolVar(&cfg.QueryAdhoc, "query-adhoc", true, "plan every query afresh, or with false, prepare each statement once and reuse its plan")
+	fs.StringVar(&cfg.QueryConsistency, "query-consistency", workload.ScanConsistencyNotBounded, "scan consistency of queries, not_bounded or request_plus")
+	fs.IntVar(&cfg.QueryParallelism, "query-max-parallelism", 0, "maximum parallelism of each query, 0 for the server default")
+	fs.StringVar(&cfg.QueryExpectRows, "query-expect-rows", "findProfile>=1", "comma separated numbers of rows the queries of operations are expected to return, as <operation>>=<n>, <operation><=<n> or <operation>=<n>, counting other results as unexpected")
+	fs.StringVar(&cfg.RecordTrace, "record-trace", "", "path to record every operation of the run to, for replaying later")
+	fs.StringVar(&cfg.ReplayTrace, "replay-trace", "", "path to a recorded trace to replay instead of running the workload")
+	fs.DurationVar(&cfg.CoolDown, "cool-down", 0, "after the run, keep probing for up to this long and report when latency returns to the pre-run baseline")
+	fs.IntVar(&cfg.IdleUsers, "idle-users", 0, "number of mostly idle users to run alongside num-users")
+	fs.DurationVar(&cfg.IdleInterval, "idle-interval", 3*time.Minute, "average time between operations of an idle user")
+	fs.IntVar(&cfg.Workers, "workers", workload.DefaultWorkers, "maximum number of operations run at once, shared by all the simulated users")
+	fs.BoolVar(&cfg.TlsSkipVerify, "tls-skip-verify", false, "skip TLS certificate verification")
+	fs.StringVar(&cfg.Target, "target", backendCluster, "what to run against: cluster for the cluster at --connstr, or mock for an in-memory backend serving the Data API, to develop and demo workloads without a cluster")
+	fs.DurationVar(&cfg.MockLatency, "mock-latency", 0, "latency added to every request to the mock backend")
+	fs.StringVar(&cfg.Workload, "workload", "", "workload name, or plugin:<path> for an external workload run as a process or loaded from a Go plugin ending in .so")
+	fs.StringVar(&cfg.PluginArgs, "plugin-args", "", "space separated arguments to start the process of a plugin workload with")
+	fs.StringVar(&cfg.DapiConnstr, "dapi-connstr", "", "connection string for data api")
+	fs.StringVar(&cfg.CompareConnstr, "compare-connstr", "", "connection string of a second cluster to run the workload against side by side, with metrics labelled by target")
+	fs.StringVar(&cfg.CompareDapi, "compare-dapi-connstr", "", "data api connection string of the second cluster to compare against")
+	fs.StringVar(&cfg.CompareWorkload, "compare-workload", "", "a second workload to run side by side, e.g. user-profile-dapi to compare with user-profile")
+	fs.IntVar(&cfg.RbacUsers, "rbac-users", 0, "create this many users for the run, each runner authenticating as one of them in turn, and remove them afterwards")
+	fs.StringVar(&cfg.RbacUsersFile, "rbac-users-file", "", "path to a file of existing username:password pairs, one per line, for runners to authenticate as in turn")
+	fs.IntVar(&cfg.DapiMaxConns, "dapi-max-conns-per-host", dapi.DefaultTransport.MaxConnsPerHost, "maximum connections to the data api, 0 for no limit")
+	fs.IntVar(&cfg.DapiMaxIdleConns, "dapi-max-idle-conns-per-host", dapi.DefaultTransport.MaxIdleConnsPerHost, "idle connections to the data api kept for reuse")
+	fs.DurationVar(&cfg.DapiIdleTimeout, "dapi-idle-timeout", dapi.DefaultTransport.IdleConnTimeout, "how long an idle connection to the data api is kept, 0 for no limit")
+	fs.StringVar(&cfg.DapiHTTPVersion, "dapi-http-version", dapi.DefaultTransport.HTTPVersion, "HTTP version for the data api, 1.1 or 2")
+	fs.IntVar(&cfg.DapiTLSSessions, "dapi-tls-session-cache", 0, "number of TLS sessions cached for resuming connections to the data api, 0 for no resumption")
+	fs.Float64Var(&cfg.MaxErrorRate, "max-error-rate", 0, "abort the run when more than this fraction of an operation fails within the error window, 0 for no limit")
+	fs.DurationVar(&cfg.OpDeadline, "operation-deadline", 0, "cancel each operation that runs for longer than this, failing it, 0 for no deadline")
+	fs.Int64Var(&cfg.EgressBandwidth, "egress-bandwidth", 0, "bytes per second all users together may send in requests, to simulate clients on a constrained network, 0 for unlimited")
+	fs.Int64Var(&cfg.IngressBandwidth, "ingress-bandwidth", 0, "bytes per second all users together may receive in responses, to simulate clients on a constrained network, 0 for unlimited")
+	fs.DurationVar(&cfg.ErrorWindow, "error-window", 30*time.Second, "how far back failures are counted against the max error rate")
+	fs.IntVar(&cfg.ErrorMinOps, "error-min-operations", 100, "attempts of an operation within the error window before its error rate is checked")
+	fs.StringVar(&cfg.ErrorAction, "error-budget-action", errorActionAbort, "what to do when an operation exceeds the max error rate, abort or stop-operation")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "level of messages to log, debug logs every failed operation in full")
+	fs.StringVar(&cfg.LogFile, "log-file", "", "path to also write JSON logs to, with the console logs made readable rather than JSON")
+	fs.StringVar(&cfg.LogFileLevel, "log-file-level", "debug", "level of messages to write to the log file, debug writes every failed operation in full")
+	fs.Int64Var(&cfg.LogFileMaxSize, "log-file-max-size", 100, "size in megabytes at which the log file is rotated, 0 to never rotate it")
+	fs.DurationVar(&cfg.LogFileMaxAge, "log-file-max-age", 0, "how long to keep rotated log files for, 0 to keep them forever")
+	fs.DurationVar(&cfg.ClientStatsEvery, "client-stats-interval", 0, "how often to log the memory, goroutines and CPU of spectroperf itself, 0 for never")
+	fs.BoolVar(&cfg.Soak, "soak", false, "preset for runs lasting days: log nothing below info, keep rotated log files for a week, record no native histograms and log the stats of spectroperf every 10m")
+	fs.DurationVar(&cfg.ErrorLogInterval, "error-log-interval", workload.ErrorLogInterval, "how often to log a summary of failed operations, 0 to log every failure")
+	fs.DurationVar(&cfg.ProgressInterval, "progress-interval", workload.ProgressInterval, "how often to log a line with the progress, throughput, error rate and latency of the run, 0 for never")
+	fs.DurationVar(&cfg.TimelineInterval, "timeline-interval", workload.TimelineInterval, "how often to sample the throughput, error rate and latency of the run for the timeline in its report, 0 for never")
+	fs.BoolVar(&cfg.CorrectOmission, "correct-coordinated-omission", false, "also report the duration of operations from when they were scheduled to start, correcting for coordinated omission")
+	fs.BoolVar(&cfg.NativeHistograms, "native-histograms", false, "also record operation durations in Prometheus native histograms, whose buckets adapt to the range of the durations, for finer percentiles")
+	fs.BoolVar(&cfg.Pprof, "pprof", false, "expose the Go profiler of spectroperf under /debug/pprof/ on the metrics server")
+	fs.StringVar(&cfg.ProfileCPU, "profile-cpu", "", "path to write a CPU profile of spectroperf during the run to")
+	fs.StringVar(&cfg.ProfileMem, "profile-mem", "", "path to write a memory profile of spectroperf at the end of the run to")
+	fs.StringVar(&cfg.MetricsSink, "metrics-sink", metricsSinkPrometheus, "where to send operation metrics, prometheus to expose them on :2112/metrics or statsd to send them to a statsd or DogStatsD server")
+	fs.StringVar(&cfg.StatsdAddr, "statsd-addr", "localhost:8125", "address of the statsd server for the statsd metrics sink")
+	fs.StringVar(&cfg.StatsdPrefix, "statsd-prefix", "spectroperf.", "prefix for the name of every metric sent to statsd")
+	fs.StringVar(&cfg.StatsdTags, "statsd-tags", "", "comma separated tags to add to every metric sent to statsd, each as name:value")
+	fs.BoolVar(&cfg.ControlAPI, "control-api", false, "serve /control on the metrics server to change the users, throughput and markov chain of the current phase during the run")
+	fs.DurationVar(&cfg.StatsInterval, "cluster-stats-interval", 0, "how often to sample the stats of the bucket from the cluster during the run, 0 for never")
+	fs.StringVar(&cfg.StatsFile, "cluster-stats-file", "", "path to write the cluster stats samples to as CSV, alongside the client throughput and latency")
+	fs.StringVar(&cfg.PromURL, "prometheus-url", "", "address of a Prometheus compatible server scraping spectroperf to query the run summary from, instead of the metrics of spectroperf itself")
+	fs.StringVar(&cfg.PromUsername, "prometheus-username", "", "username to authenticate with prometheus using basic auth")
+	fs.StringVar(&cfg.PromPassword, "prometheus-password", "", "password to authenticate with prometheus using basic auth")
+	fs.StringVar(&cfg.PromToken, "prometheus-bearer-token", "", "bearer token to authenticate with prometheus")
+	fs.StringVar(&cfg.PromCert, "prometheus-cert", "", "path to a CA certificate to trust for prometheus")
+	fs.BoolVar(&cfg.PromSkipVerify, "prometheus-tls-skip-verify", false, "skip TLS certificate verification for prometheus")
+	fs.DurationVar(&cfg.PromScrapeWait, "prometheus-scrape-wait", 15*time.Second, "how long to wait after the run for prometheus to scrape the last metrics before querying it")
+	fs.StringVar(&cfg.UploadURL, "upload-url", "", "s3:// or gs:// bucket and prefix to upload the report, config, log file, trace and cluster stats of the run to under its run ID, with credentials from $"+uploadAccessKeyEnv+" and $"+uploadSecretKeyEnv)
+	fs.StringVar(&cfg.UploadEndpoint, "upload-endpoint", "", "address of an S3 compatible store to upload to instead of AWS or GCS")
+	fs.StringVar(&cfg.UploadRegion, "upload-region", "", "region of the bucket to upload to (default $AWS_REGION or us-east-1 for S3)")
+	fs.StringVar(&cfg.ReportFile, "report-file", "", "path to write the report of the run to as JSON, as it is uploaded, however the run ends")
+	fs.DurationVar(&cfg.SetupTimeout, "setup-timeout", time.Hour, "deadline for loading data and creating indexes, 0 for no deadline")
+	fs.StringVar(&cfg.LoadVia, "load-via", loadViaSDK, "how setup loads documents: sdk, or dapi to load them through the Data API at --dapi-connstr when the SDK ports cannot be reached")
+	fs.IntVar(&cfg.MgmtUsers, "mgmt-users", 0, "number of users polling the management REST API alongside the workload, as monitoring agents do")
+	fs.DurationVar(&cfg.MgmtInterval, "mgmt-interval", 10*time.Second, "time between requests of each management API user")
+	fs.StringVar(&cfg.MgmtURL, "mgmt-url", "", "address of the management REST API (default derived from connstr)")
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// given is the settings given on the command line or in the config file, rather than left at
+	// their defaults.
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	if cfg.ConfigFile != "" {
+		set, err := loadConfigFile(cfg.ConfigFile, cfg.Profile, &cfg)
+		if err != nil {
+			return Config{}, errors.Wrapf(err, "failed to load config file %s", cfg.ConfigFile)
+		}
+		for _, name := range set {
+			given[name] = true
+		}
+
+		// Parse again so that flags given on the command line override the config file.
+		err = fs.Parse(args)
+		if err != nil {
+			return Config{}, err
+		}
+	} else if cfg.Profile != "" {
+		return Config{}, fmt.Errorf("profile %s can only be used with a config file", cfg.Profile)
+	}
+
+	if cfg.Soak {
+		err = applySoak(fs, &cfg, given)
+		if err != nil {
+			return Config{}, errors.Wrap(err, "failed to apply soak preset")
+		}
+	}
+
+	switch cfg.KeyNamespace {
+	case "":
+		cfg.KeyNamespace = cfg.RunId
+	case "none":
+		cfg.KeyNamespace = ""
+	}
+
+	err = loadSecrets(&cfg, given)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to load secrets")
+	}
+	return cfg, nil
+}
+
+// soakDefaults are the settings --soak changes when they are not given, so that they sit beneath the
+// config file and command line, which override them even with the default value.
+var soakDefaults = map[string]string{
+	"log-file-max-age":      "168h",
+	"client-stats-interval": "10m",
+}
+
+// applySoak bounds what a run lasting days accumulates.  Debug logging, which logs every failed
+// operation in full, is turned off even if asked for, as are native histograms, whose buckets
+// grow with the range of durations seen.
+func applySoak(fs *flag.FlagSet, cfg *Config, given map[string]bool) error {
+	for name, value := range soakDefaults {
+		if given[name] {
+			continue
+		}
+		err := fs.Lookup(name).Value.Set(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value %s for %s", value, name)
+		}
+	}
+	if cfg.LogLevel == "debug" {
+		cfg.LogLevel = "info"
+	}
+	if cfg.LogFileLevel == "debug" {
+		cfg.LogFileLevel = "info"
+	}
+	cfg.NativeHistograms = false
+	return nil
+}
+
+func envOrDefault(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,48 @@
+package spectroperf
+
+import (
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+)
+
+// resolveIdentities returns the users runners authenticate as: those in the users file, users
+// created for the run, or none.  created is true when the users should be dropped after the run.
+func resolveIdentities(cfg Config, cluster *gocb.Cluster) (identities []workload.Identity, created bool, err error) {
+	switch {
+	case cfg.RbacUsersFile != "":
+		identities, err = workload.ReadIdentities(cfg.RbacUsersFile)
+		return identities, false, err
+	case cfg.RbacUsers > 0:
+		identities, err = workload.CreateIdentities(cluster, cfg.Bucket, cfg.RbacUsers)
+		return identities, true, err
+	default:
+		return nil, false, nil
+	}
+}
+
+// connectIdentities opens a connection to the cluster as each identity, returning the scope under
+// test for each by username.
+func connectIdentities(cfg Config, opts gocb.ClusterOptions, identities []workload.Identity) (map[string]*gocb.Scope, error) {
+	scopes := make(map[string]*gocb.Scope, len(identities))
+	for _, identity := range identities {
+		opts.Authenticator = gocb.PasswordAuthenticator{
+			Username: identity.Username,
+			Password: identity.Password,
+		}
+		cluster, err := gocb.Connect(cfg.Connstr, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to connect as user %s", identity.Username)
+		}
+
+		bucket := cluster.Bucket(cfg.Bucket)
+		err = bucket.WaitUntilReady(30*time.Second, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to connect to bucket as user %s", identity.Username)
+		}
+		scopes[identity.Username] = bucket.Scope(cfg.Scope)
+	}
+	return scopes, nil
+}
@@ -0,0 +1,117 @@
+//go:build integration
+
+package spectroperf_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	tccouchbase "github.com/testcontainers/testcontainers-go/modules/couchbase"
+)
+
+// startMockDapi serves the parts of the Data API the user-profile-dapi workload uses by default
+// in front of the containerized cluster, which does not run the Data API itself.  Documents are
+// read and written with the SDK, with their CAS as their ETag, and queries are passed through to
+// the query service.  Sub-document requests are not served.
+func startMockDapi(ctx context.Context, c *gocb.Cluster) (*httptest.Server, error) {
+	host, err := cluster.Host(ctx)
+	if err != nil {
+		return nil, err
+	}
+	port, err := cluster.MappedPort(ctx, tccouchbase.QUERY_PORT)
+	if err != nil {
+		return nil, err
+	}
+	query := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: fmt.Sprintf("%s:%d", host, port.Int())})
+
+	mux := http.NewServeMux()
+	documents := "/v1/buckets/{bucket}/scopes/{scope}/collections/{collection}/documents/{id}"
+	mux.HandleFunc("GET "+documents, func(w http.ResponseWriter, r *http.Request) {
+		result, err := mockCollection(c, r).Get(r.PathValue("id"), &gocb.GetOptions{Context: r.Context(), Transcoder: gocb.NewRawJSONTranscoder()})
+		if err != nil {
+			writeMockError(w, err)
+			return
+		}
+		var content []byte
+		err = result.Content(&content)
+		if err != nil {
+			writeMockError(w, err)
+			return
+		}
+		w.Header().Set("ETag", strconv.FormatUint(uint64(result.Cas()), 10))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	})
+	mux.HandleFunc("PUT "+documents, func(w http.ResponseWriter, r *http.Request) {
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var expiry time.Duration
+		if expires := r.Header.Get("Expires"); expires != "" {
+			at, err := http.ParseTime(expires)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			expiry = max(time.Until(at), time.Second)
+		}
+
+		coll := mockCollection(c, r)
+		var result *gocb.MutationResult
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			cas, err := strconv.ParseUint(ifMatch, 10, 64)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result, err = coll.Replace(r.PathValue("id"), content, &gocb.ReplaceOptions{Context: r.Context(), Transcoder: gocb.NewRawJSONTranscoder(), Cas: gocb.Cas(cas), Expiry: expiry})
+		} else {
+			result, err = coll.Upsert(r.PathValue("id"), content, &gocb.UpsertOptions{Context: r.Context(), Transcoder: gocb.NewRawJSONTranscoder(), Expiry: expiry})
+		}
+		if err != nil {
+			writeMockError(w, err)
+			return
+		}
+		w.Header().Set("ETag", strconv.FormatUint(uint64(result.Cas()), 10))
+	})
+	mux.HandleFunc("DELETE "+documents, func(w http.ResponseWriter, r *http.Request) {
+		_, err := mockCollection(c, r).Remove(r.PathValue("id"), &gocb.RemoveOptions{Context: r.Context()})
+		if err != nil {
+			writeMockError(w, err)
+		}
+	})
+	mux.HandleFunc("POST /_p/query/query/service", func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = "/query/service"
+		query.ServeHTTP(w, r)
+	})
+	return httptest.NewServer(mux), nil
+}
+
+func mockCollection(c *gocb.Cluster, r *http.Request) *gocb.Collection {
+	return c.Bucket(r.PathValue("bucket")).Scope(r.PathValue("scope")).Collection(r.PathValue("collection"))
+}
+
+// writeMockError responds with the status the Data API gives for an error of the SDK.
+func writeMockError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, gocb.ErrDocumentNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, gocb.ErrCasMismatch):
+		status = http.StatusPreconditionFailed
+	case errors.Is(err, gocb.ErrTimeout):
+		status = http.StatusGatewayTimeout
+	}
+	http.Error(w, err.Error(), status)
+}
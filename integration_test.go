@@ -0,0 +1,252 @@
+//go:build integration
+
+package spectroperf_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf"
+	"github.com/testcontainers/testcontainers-go"
+	tccouchbase "github.com/testcontainers/testcontainers-go/modules/couchbase"
+)
+
+// The integration tests run a short version of every workload against Couchbase Server in a
+// container, started once for all of them, and check the invariants of the result of each run.
+// They need Docker, and are only built with the integration tag:
+//
+//	go test -tags integration -timeout 30m ./
+
+const (
+	itUsername   = "Administrator"
+	itPassword   = "password"
+	itBucket     = "data"
+	itScope      = "identity"
+	itCollection = "profiles"
+)
+
+// Environment variables overriding the image the cluster runs and how long each workload runs.
+const (
+	itImageEnv   = "SPECTROPERF_IT_IMAGE"
+	itRunTimeEnv = "SPECTROPERF_IT_RUN_TIME"
+)
+
+// cluster is the containerized cluster shared by the tests, and dapiURL the mock Data API in
+// front of it.
+var (
+	cluster      *tccouchbase.CouchbaseContainer
+	clusterConn  string
+	dapiURL      string
+	itRunTime    = time.Minute
+	itImage      = "couchbase:enterprise-7.6.2"
+	maxFailRatio = 0.05
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegration(m))
+}
+
+func runIntegration(m *testing.M) int {
+	if image := os.Getenv(itImageEnv); image != "" {
+		itImage = image
+	}
+	if runTime := os.Getenv(itRunTimeEnv); runTime != "" {
+		d, err := time.ParseDuration(runTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid $%s: %s\n", itRunTimeEnv, err)
+			return 1
+		}
+		itRunTime = d
+	}
+
+	ctx := context.Background()
+	var err error
+	cluster, err = tccouchbase.Run(ctx, itImage,
+		tccouchbase.WithAdminCredentials(itUsername, itPassword),
+		tccouchbase.WithBuckets(tccouchbase.NewBucket(itBucket).WithQuota(512).WithPrimaryIndex(false)),
+	)
+	defer func() {
+		err := testcontainers.TerminateContainer(cluster)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to terminate cluster: %s\n", err)
+		}
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start cluster: %s\n", err)
+		return 1
+	}
+	clusterConn, err = cluster.ConnectionString(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get connection string: %s\n", err)
+		return 1
+	}
+
+	gocbCluster, err := gocb.Connect(clusterConn, gocb.ClusterOptions{Username: itUsername, Password: itPassword})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to cluster: %s\n", err)
+		return 1
+	}
+	defer gocbCluster.Close(nil)
+	err = createCollection(gocbCluster)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create collection: %s\n", err)
+		return 1
+	}
+
+	dapi, err := startMockDapi(ctx, gocbCluster)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start mock data api: %s\n", err)
+		return 1
+	}
+	defer dapi.Close()
+	dapiURL = dapi.URL
+
+	return m.Run()
+}
+
+// createCollection creates the collection every workload runs against.
+func createCollection(c *gocb.Cluster) error {
+	bucket := c.Bucket(itBucket)
+	err := bucket.WaitUntilReady(time.Minute, nil)
+	if err != nil {
+		return err
+	}
+	manager := bucket.CollectionsV2()
+	err = manager.CreateScope(itScope, nil)
+	if err != nil {
+		return err
+	}
+	return manager.CreateCollection(itScope, itCollection, nil, nil)
+}
+
+// TestWorkloads runs each workload for a short time and checks the result of the run.  They run
+// in order, sharing the collection, so that user-profile-dapi finds profiles with the index
+// user-profile creates, and existing-data discovers the documents the others loaded.
+func TestWorkloads(t *testing.T) {
+	workloads := []struct {
+		name string
+		args []string
+	}{
+		{name: "user-profile"},
+		{name: "time-series"},
+		{name: "session-store"},
+		{name: "inbox"},
+		{name: "document-metadata"},
+		{name: "user-profile-dapi", args: []string{"--dapi-connstr", dapiURL}},
+		{name: "existing-data"},
+	}
+
+	// The runs share the process, so each result must cover only its own operations
+	for _, w := range workloads {
+		t.Run(w.name, func(t *testing.T) {
+			result := runWorkload(t, w.name, w.args...)
+			checkResult(t, w.name, result)
+		})
+	}
+}
+
+// runWorkload runs a workload with a small number of documents and users for the run time of
+// the integration tests.
+func runWorkload(t *testing.T, name string, extra ...string) spectroperf.RunResult {
+	t.Helper()
+	args := []string{
+		"--workload", name,
+		"--connstr", clusterConn,
+		"--cert", "",
+		"--username", itUsername,
+		"--password", itPassword,
+		"--bucket", itBucket,
+		"--scope", itScope,
+		"--collection", itCollection,
+		"--num-items", "1000",
+		"--num-users", "20",
+		"--think-time", "fixed:50ms",
+		"--run-time", itRunTime.String(),
+		"--run-id", "it-" + name,
+		"--index-prefix", "it_",
+		"--progress-interval", "0",
+	}
+	cfg, err := spectroperf.ParseArgs(append(args, extra...))
+	if err != nil {
+		t.Fatalf("invalid arguments: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), itRunTime+10*time.Minute)
+	defer cancel()
+	result, err := spectroperf.Runner{Config: cfg, Metrics: spectroperf.LocalSink{}}.Run(ctx)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	return result
+}
+
+// checkResult checks the invariants of the result of a run: it ran to the end, attempted
+// operations of its own workload with few failures, its summary and transitions are consistent,
+// and its validations passed.
+func checkResult(t *testing.T, name string, result spectroperf.RunResult) {
+	t.Helper()
+	if result.Aborted != nil {
+		t.Errorf("run aborted: %s", result.Aborted)
+	}
+	if !result.End.After(result.Start) {
+		t.Errorf("run ended at %s, not after it started at %s", result.End, result.Start)
+	}
+
+	w, _ := spectroperf.SampleWorkload(name)
+	var attempts uint64
+	for _, summary := range result.Operations {
+		if !slices.Contains(w.Operations(), summary.Operation) {
+			t.Errorf("summary has operation %s, which is not one of the workload", summary.Operation)
+		}
+		attempts += summary.Attempts
+		if summary.Failures > summary.Attempts {
+			t.Errorf("%s failed %d times in %d attempts", summary.Operation, summary.Failures, summary.Attempts)
+		}
+		if summary.Timeouts > summary.Failures {
+			t.Errorf("%s timed out %d times in %d failures", summary.Operation, summary.Timeouts, summary.Failures)
+		}
+		if summary.Unexpected > summary.Attempts {
+			t.Errorf("%s returned unexpected results %d times in %d attempts", summary.Operation, summary.Unexpected, summary.Attempts)
+		}
+		if summary.P50 > summary.P99 {
+			t.Errorf("%s has a p50 of %.3fms above its p99 of %.3fms", summary.Operation, summary.P50, summary.P99)
+		}
+		if summary.Attempts > 0 && float64(summary.Failures)/float64(summary.Attempts) > maxFailRatio {
+			t.Errorf("%s failed %d times in %d attempts, more than %.0f%%", summary.Operation, summary.Failures, summary.Attempts, maxFailRatio*100)
+		}
+	}
+	if attempts == 0 {
+		t.Errorf("no operations were attempted")
+	}
+
+	for _, row := range result.Transitions {
+		if !slices.Contains(w.Operations(), row.From) {
+			t.Errorf("transitions have a row for operation %s, which is not one of the workload", row.From)
+		}
+		var count uint64
+		for _, transition := range row.Transitions {
+			count += transition.Count
+			if transition.Observed < 0 || transition.Observed > 1 || transition.Expected < 0 || transition.Expected > 1 {
+				t.Errorf("transition from %s to %s has fractions outside 0 to 1: observed %f, expected %f", row.From, transition.To, transition.Observed, transition.Expected)
+			}
+		}
+		if count != row.Count {
+			t.Errorf("transitions from %s add up to %d, not %d", row.From, count, row.Count)
+		}
+		if deviation := row.MaxDeviation(); math.IsNaN(deviation) || deviation > 1 {
+			t.Errorf("transitions from %s deviate from the chain by %f", row.From, deviation)
+		}
+	}
+
+	for _, validation := range result.Validations {
+		if validation.Err != nil {
+			t.Errorf("validation of %s failed: %s", validation.Target, validation.Err)
+		}
+	}
+}
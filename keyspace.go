@@ -0,0 +1,80 @@
+package spectroperf
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// keyspaceTimeout bounds each request made to check the keyspace of the run.
+const keyspaceTimeout = 10 * time.Second
+
+// waitForKeyspace waits for the bucket of the run to be ready, then checks that its scope and
+// collection exist, before anything is loaded or run.  Otherwise a keyspace that does not exist
+// only shows up as operations timing out, or as documents and indexes that end up somewhere other
+// than intended, so a missing bucket, scope or collection fails naming it and the flag to fix it,
+// along with those that do exist.  Where the user cannot list what exists, the check is skipped.
+func waitForKeyspace(cluster *gocb.Cluster, bucket *gocb.Bucket, cfg Config) error {
+	err := bucket.WaitUntilReady(5*time.Second, nil)
+	if err != nil {
+		if missing := missingBucket(cluster, cfg.Bucket, err); missing != nil {
+			return missing
+		}
+		return errors.Wrapf(err, "failed to connect to bucket %s", cfg.Bucket)
+	}
+
+	scopes, err := bucket.Collections().GetAllScopes(&gocb.GetAllScopesOptions{Timeout: keyspaceTimeout})
+	if err != nil {
+		zap.L().Warn("Not checking the scope and collection exist, failed to list them", zap.String("bucket", cfg.Bucket), zap.Error(err))
+		return nil
+	}
+	i := slices.IndexFunc(scopes, func(s gocb.ScopeSpec) bool { return s.Name == cfg.Scope })
+	if i < 0 {
+		names := make([]string, len(scopes))
+		for j, scope := range scopes {
+			names[j] = scope.Name
+		}
+		return fmt.Errorf("scope %s does not exist in bucket %s, set --scope to an existing one (%s) or create it", cfg.Scope, cfg.Bucket, strings.Join(names, ", "))
+	}
+	collections := scopes[i].Collections
+	if !slices.ContainsFunc(collections, func(c gocb.CollectionSpec) bool { return c.Name == cfg.Collection }) {
+		names := make([]string, len(collections))
+		for j, collection := range collections {
+			names[j] = collection.Name
+		}
+		return fmt.Errorf("collection %s does not exist in scope %s of bucket %s, set --collection to an existing one (%s) or create it", cfg.Collection, cfg.Scope, cfg.Bucket, strings.Join(names, ", "))
+	}
+
+	zap.L().Info("Using keyspace", zap.String("keyspace", fmt.Sprintf("`%s`.`%s`.`%s`", cfg.Bucket, cfg.Scope, cfg.Collection)))
+	return nil
+}
+
+// missingBucket returns an error naming the buckets that exist if the bucket that could not be
+// connected to does not, or nil if it does or the buckets cannot be listed.
+func missingBucket(cluster *gocb.Cluster, name string, cause error) error {
+	buckets, err := cluster.Buckets().GetAllBuckets(&gocb.GetAllBucketsOptions{Timeout: keyspaceTimeout})
+	if err != nil {
+		if errors.Is(cause, gocb.ErrBucketNotFound) {
+			return fmt.Errorf("bucket %s does not exist, set --bucket to an existing bucket or create it", name)
+		}
+		return nil
+	}
+	if _, ok := buckets[name]; ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(buckets))
+	for bucket := range buckets {
+		names = append(names, bucket)
+	}
+	slices.Sort(names)
+	if len(names) == 0 {
+		return fmt.Errorf("bucket %s does not exist, and the cluster has no buckets, create it or set --bucket", name)
+	}
+	return fmt.Errorf("bucket %s does not exist, set --bucket to an existing one (%s) or create it", name, strings.Join(names, ", "))
+}
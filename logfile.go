@@ -0,0 +1,182 @@
+package spectroperf
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// rotatedTimeFormat names rotated log files by when they were rotated, so that they sort in
+// order.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// NewLogger returns the logger for a run.  Without a log file, JSON logs are written to
+// stderr.  With one, every log at the log file level is written to it as JSON, including each
+// failed operation in full at debug level, while the console gets a readable line for each log
+// at the log level.
+func NewLogger(cfg Config) (*zap.Logger, error) {
+	level, err := zap.ParseAtomicLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid log level")
+	}
+	if cfg.LogFile == "" {
+		logConfig := zap.NewProductionConfig()
+		logConfig.Level = level
+		return logConfig.Build()
+	}
+
+	fileLevel, err := zap.ParseAtomicLevel(cfg.LogFileLevel)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid log file level")
+	}
+	file, err := openRotatingFile(cfg.LogFile, cfg.LogFileMaxSize*1024*1024, cfg.LogFileMaxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	fileEncoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	consoleConfig := zap.NewDevelopmentEncoderConfig()
+	consoleConfig.EncodeTime = zapcore.TimeEncoderOfLayout(time.TimeOnly)
+	consoleEncoder := zapcore.NewConsoleEncoder(consoleConfig)
+
+	core := zapcore.NewTee(
+		zapcore.NewCore(fileEncoder, file, fileLevel),
+		zapcore.NewCore(consoleEncoder, zapcore.Lock(os.Stdout), level),
+	)
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zap.DPanicLevel)), nil
+}
+
+// rotatingFile is a log file that is rotated once it grows past a maximum size, keeping the
+// rotated files until they are older than a maximum age.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	file    *os.File
+	size    int64
+}
+
+// openRotatingFile appends to the log file at path, rotating it once it is maxSize bytes, or
+// never if zero, and removing rotated files older than maxAge, or never if zero.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	f.prune()
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to open log file")
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Sync()
+}
+
+// rotate renames the log file after the time it was rotated and starts a new one.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to close log file")
+	}
+
+	prefix, ext := rotatedName(f.path)
+	err = os.Rename(f.path, prefix+time.Now().Format(rotatedTimeFormat)+ext)
+	if err != nil {
+		return errors.Wrap(err, "failed to rotate log file")
+	}
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// prune removes the rotated log files that are older than the maximum age.
+func (f *rotatingFile) prune() {
+	if f.maxAge <= 0 {
+		return
+	}
+
+	prefix, ext := rotatedName(f.path)
+	rotated, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return
+	}
+	for _, path := range rotated {
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > f.maxAge {
+			os.Remove(path)
+		}
+	}
+}
+
+// rotatedName returns what the name of a file rotated from the log file at path starts and ends
+// with, either side of the time it was rotated, such as spectroperf- and .log for
+// spectroperf.log.
+func rotatedName(path string) (string, string) {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-", ext
+}
+
+// rotatedSince returns the files rotated from the log file at path at or after a time, oldest
+// first.  Each of them holds logs written after that time, along with any written before it to
+// the same file.
+func rotatedSince(path string, since time.Time) ([]string, error) {
+	prefix, ext := rotatedName(path)
+	rotated, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list rotated log files")
+	}
+	var files []string
+	for _, file := range rotated {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(file, prefix), ext)
+		at, err := time.ParseInLocation(rotatedTimeFormat, stamp, time.Local)
+		if err != nil || at.Before(since.Truncate(time.Millisecond)) {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
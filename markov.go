@@ -0,0 +1,286 @@
+package spectroperf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultMarkovEpsilon is how far the probabilities of a markov chain row may sum from 1, to allow
+// for rounding in hand written chains.
+const defaultMarkovEpsilon = 1e-6
+
+// markovOptions control how strictly a configured markov chain is validated.
+type markovOptions struct {
+	// epsilon is the tolerance on the sum of each row, or zero for the default.
+	epsilon float64
+	// normalize scales every row to sum to exactly 1 instead of rejecting rows outside epsilon.
+	normalize bool
+}
+
+// markovChainConfig is a markov chain as given in a config file, either as rows of probabilities
+// in the order of the workload operations, or as a map from each operation to the probabilities
+// of the operations that follow it:
+//
+//	markov-chain:
+//	  login:
+//	    fetchProfile: 0.8
+//	    logout: 0.2
+type markovChainConfig struct {
+	rows  [][]float64
+	named map[string]map[string]float64
+}
+
+func (c *markovChainConfig) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return node.Decode(&c.rows)
+	case yaml.MappingNode:
+		return node.Decode(&c.named)
+	default:
+		return fmt.Errorf("line %d: markov-chain must be a list of rows or a map of operations", node.Line)
+	}
+}
+
+// controlChainResolver returns a resolver for the markov chains and operation weights given to the
+// control API, which are parsed the same way as those in a config file.
+func controlChainResolver(opts markovOptions) workload.ChainResolver {
+	return func(operations []string, raw json.RawMessage, weights map[string]float64) ([][]float64, error) {
+		var chain *markovChainConfig
+		if raw != nil {
+			chain = &markovChainConfig{}
+			if err := yaml.Unmarshal(raw, chain); err != nil {
+				return nil, err
+			}
+		}
+		return resolveMarkovChain(operations, chain, weights, opts)
+	}
+}
+
+// resolveMarkovChain returns the markov chain for a configured chain or set of operation weights,
+// of which at most one may be given, or nil if neither is.
+func resolveMarkovChain(operations []string, chain *markovChainConfig, weights map[string]float64, opts markovOptions) ([][]float64, error) {
+	if chain != nil && weights != nil {
+		return nil, fmt.Errorf("must not have both a markov chain and operation weights")
+	}
+	if weights != nil {
+		return weightsToMarkovChain(operations, weights)
+	}
+	if chain == nil {
+		return nil, nil
+	}
+
+	probabilities := chain.rows
+	if chain.named != nil {
+		var err error
+		probabilities, err = namedToMarkovChain(operations, chain.named)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return validateMarkovChain(operations, probabilities, opts)
+}
+
+// namedToMarkovChain orders a markov chain keyed by operation name as the workload operations.
+// Every operation needs a row, and operations left out of a row are never chosen after it.
+func namedToMarkovChain(operations []string, named map[string]map[string]float64) ([][]float64, error) {
+	for from, row := range named {
+		if !slices.Contains(operations, from) {
+			return nil, fmt.Errorf("markov chain has a row for unknown operation %s", from)
+		}
+		for to := range row {
+			if !slices.Contains(operations, to) {
+				return nil, fmt.Errorf("markov chain row %s has a probability for unknown operation %s", from, to)
+			}
+		}
+	}
+
+	probabilities := make([][]float64, len(operations))
+	for i, from := range operations {
+		row, ok := named[from]
+		if !ok {
+			return nil, fmt.Errorf("markov chain has no row for operation %s", from)
+		}
+		probabilities[i] = make([]float64, len(operations))
+		for j, to := range operations {
+			probabilities[i][j] = row[to]
+		}
+	}
+	return probabilities, nil
+}
+
+// weightsToMarkovChain expands a relative weight for each operation into a markov chain in which
+// every row is the same, so the next operation is always chosen in proportion to the weights.
+// Operations without a weight are never chosen.
+func weightsToMarkovChain(operations []string, weights map[string]float64) ([][]float64, error) {
+	var total float64
+	for operation, weight := range weights {
+		if !slices.Contains(operations, operation) {
+			return nil, fmt.Errorf("weight given for unknown operation %s", operation)
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("operation %s has a negative weight", operation)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("operation weights must not all be zero")
+	}
+
+	row := make([]float64, len(operations))
+	for i, operation := range operations {
+		row[i] = weights[operation] / total
+	}
+
+	chain := make([][]float64, len(operations))
+	for i := range chain {
+		chain[i] = row
+	}
+	return chain, nil
+}
+
+// parseOnlyOperation parses a comma separated list of operations to run, each with an optional
+// relative weight, e.g. fetchProfile:0.8,updateProfile:0.2.  Operations without a weight have a
+// weight of 1.
+func parseOnlyOperation(value string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	for _, entry := range strings.Split(value, ",") {
+		operation, weightStr, hasWeight := strings.Cut(strings.TrimSpace(entry), ":")
+		if operation == "" {
+			return nil, fmt.Errorf("only-operation %q has an empty operation name", value)
+		}
+		if _, ok := weights[operation]; ok {
+			return nil, fmt.Errorf("only-operation gives operation %s more than once", operation)
+		}
+
+		weight := 1.0
+		if hasWeight {
+			var err error
+			weight, err = strconv.ParseFloat(weightStr, 64)
+			if err != nil {
+				return nil, fmt.Errorf("only-operation has an invalid weight %q for operation %s", weightStr, operation)
+			}
+		}
+		weights[operation] = weight
+	}
+	return weights, nil
+}
+
+// validateMarkovChain checks that a markov chain has a row and column for every operation, and
+// that the probabilities in each row sum to 1 within the epsilon.  With normalize set, rows are
+// scaled to sum to 1 instead.  It returns the chain to run with.
+func validateMarkovChain(operations []string, probabilities [][]float64, opts markovOptions) ([][]float64, error) {
+	epsilon := opts.epsilon
+	if epsilon == 0 {
+		epsilon = defaultMarkovEpsilon
+	}
+
+	if len(probabilities) != len(operations) {
+		return nil, fmt.Errorf("markov chain has %d rows, expected %d, one for each operation", len(probabilities), len(operations))
+	}
+
+	validated := make([][]float64, len(probabilities))
+	for i, row := range probabilities {
+		if len(row) != len(operations) {
+			return nil, fmt.Errorf("markov chain row %d (%s) has %d probabilities, expected %d", i, operations[i], len(row), len(operations))
+		}
+
+		var sum float64
+		for j, p := range row {
+			if p < 0 {
+				return nil, fmt.Errorf("markov chain row %d (%s) has a negative probability for %s", i, operations[i], operations[j])
+			}
+			sum += p
+		}
+
+		validated[i] = row
+		if opts.normalize {
+			if sum == 0 {
+				return nil, fmt.Errorf("markov chain row %d (%s) cannot be normalized, its probabilities are all zero", i, operations[i])
+			}
+			validated[i] = make([]float64, len(row))
+			for j, p := range row {
+				validated[i][j] = p / sum
+			}
+		} else if math.Abs(sum-1) > epsilon {
+			return nil, fmt.Errorf("markov chain row %d (%s) sums to %g, not 1 within %g", i, operations[i], sum, epsilon)
+		}
+	}
+
+	return validated, nil
+}
+
+// parseWriteRatio parses the fraction of operations that should write, or returns a negative
+// ratio if none was given.
+func parseWriteRatio(value string) (float64, error) {
+	if value == "" {
+		return -1, nil
+	}
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return 0, fmt.Errorf("write ratio %q must be a number between 0 and 1", value)
+	}
+	return ratio, nil
+}
+
+// applyWriteRatio rescales each row of a markov chain so that the operation after it is one of
+// the writes with probability ratio, keeping the probabilities of the writes, and of the reads,
+// relative to each other.  A row that never leads to a write, or never to a read, is given the
+// average probabilities of the writes or reads over the whole chain instead.
+func applyWriteRatio(operations []string, probabilities [][]float64, writes []bool, ratio float64) ([][]float64, error) {
+	// The average probability of moving to each operation, for rows without any reads or writes
+	average := make([]float64, len(operations))
+	var averageWrites, averageReads float64
+	for j := range operations {
+		for _, row := range probabilities {
+			average[j] += row[j] / float64(len(probabilities))
+		}
+		if writes[j] {
+			averageWrites += average[j]
+		} else {
+			averageReads += average[j]
+		}
+	}
+	if ratio > 0 && averageWrites == 0 {
+		return nil, fmt.Errorf("write ratio %g needs write operations, but the operation mix has none", ratio)
+	}
+	if ratio < 1 && averageReads == 0 {
+		return nil, fmt.Errorf("write ratio %g needs read operations, but the operation mix has none", ratio)
+	}
+
+	rescaled := make([][]float64, len(probabilities))
+	for i, row := range probabilities {
+		var rowWrites, rowReads float64
+		for j, p := range row {
+			if writes[j] {
+				rowWrites += p
+			} else {
+				rowReads += p
+			}
+		}
+
+		rescaled[i] = make([]float64, len(row))
+		for j, p := range row {
+			share, total, target := p, rowReads, 1-ratio
+			if writes[j] {
+				total, target = rowWrites, ratio
+			}
+			if total == 0 {
+				share, total = average[j], averageReads
+				if writes[j] {
+					total = averageWrites
+				}
+			}
+			if target > 0 {
+				rescaled[i][j] = target * share / total
+			}
+		}
+	}
+	return rescaled, nil
+}
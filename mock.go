@@ -0,0 +1,60 @@
+package spectroperf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/couchbaselabs/spectroperf/workload/mock"
+	"go.uber.org/zap"
+)
+
+// What a run is made against: a Couchbase cluster, or the in-memory mock backend, which serves
+// the Data API in process for developing and demoing workloads without a cluster.
+const (
+	backendCluster = "cluster"
+	backendMock    = "mock"
+)
+
+// checkMockTarget checks that a run against the mock backend only uses what it has.  It only
+// serves the Data API, so the workload must make its requests through the Data API, and nothing
+// may need the SDK or the management API of a cluster.
+func checkMockTarget(cfg Config, custom bool) error {
+	if !custom && cfg.Workload != "user-profile-dapi" && !strings.HasPrefix(cfg.Workload, pluginPrefix) {
+		return fmt.Errorf("workload %s needs a cluster, only user-profile-dapi and %s<path> workloads run against the mock", cfg.Workload, pluginPrefix)
+	}
+	unsupported := []struct {
+		option string
+		used   bool
+	}{
+		{"--compare-connstr, --compare-dapi-connstr or --compare-workload", cfg.CompareConnstr != "" || cfg.CompareDapi != "" || cfg.CompareWorkload != ""},
+		{"--dapi-connstr", cfg.DapiConnstr != ""},
+		{"--target-residency", cfg.TargetResidency > 0},
+		{"--rbac-users", cfg.RbacUsers > 0},
+		{"--sdk-metrics", cfg.SDKMetrics},
+		{"--cold-start", cfg.ColdStart},
+		{"--cool-down", cfg.CoolDown > 0},
+		{"--mgmt-users", cfg.MgmtUsers > 0},
+		{"--cluster-stats-interval", cfg.StatsInterval > 0},
+		{"--teardown-data", cfg.TeardownData},
+	}
+	for _, u := range unsupported {
+		if u.used {
+			return fmt.Errorf("%s cannot be used against the mock", u.option)
+		}
+	}
+	return nil
+}
+
+// startMock starts the mock backend and points the run at its Data API, loading the documents of
+// the workload through it.
+func startMock(cfg *Config) (*mock.Server, error) {
+	server := mock.NewServer(cfg.MockLatency)
+	url, err := server.Start()
+	if err != nil {
+		return nil, err
+	}
+	cfg.DapiConnstr = url
+	cfg.LoadVia = loadViaDapi
+	zap.L().Info("Started mock backend", zap.String("dapiConnstr", url), zap.Duration("latency", cfg.MockLatency))
+	return server, nil
+}
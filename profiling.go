@@ -0,0 +1,53 @@
+package spectroperf
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/pkg/errors"
+)
+
+// startProfiling starts writing a CPU profile of spectroperf to cpuPath, if given, returning a
+// function that stops it and writes a heap profile to memPath, if given.
+func startProfiling(cpuPath string, memPath string) (func() error, error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		var err error
+		cpuFile, err = os.Create(cpuPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create CPU profile")
+		}
+		err = pprof.StartCPUProfile(cpuFile)
+		if err != nil {
+			cpuFile.Close()
+			return nil, errors.Wrap(err, "failed to start CPU profile")
+		}
+	}
+
+	return func() error {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			err := cpuFile.Close()
+			if err != nil {
+				return errors.Wrap(err, "failed to write CPU profile")
+			}
+		}
+
+		if memPath != "" {
+			memFile, err := os.Create(memPath)
+			if err != nil {
+				return errors.Wrap(err, "failed to create memory profile")
+			}
+			defer memFile.Close()
+
+			// Collect garbage first so the profile shows what is live at the end of the run
+			runtime.GC()
+			err = pprof.WriteHeapProfile(memFile)
+			if err != nil {
+				return errors.Wrap(err, "failed to write memory profile")
+			}
+		}
+		return nil
+	}, nil
+}
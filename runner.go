@@ -0,0 +1,769 @@
+package spectroperf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brianvoe/gofakeit"
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/couchbaselabs/spectroperf/workload/workloads"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Metrics sinks, for where operation metrics are sent.
+const (
+	metricsSinkPrometheus = "prometheus"
+	metricsSinkStatsd     = "statsd"
+)
+
+// clientMonitorInterval is how often the resource usage of spectroperf is checked for saturation
+const clientMonitorInterval = 10 * time.Second
+
+// baselineProbes is the number of probes taken before the run to measure the baseline latency
+const baselineProbes = 10
+
+// A MetricsSink is where the operation metrics of a run are sent.  Whichever sink is used, the
+// metrics are also kept in the process to summarise in the result of the run.
+type MetricsSink interface {
+	Start(w workload.Workload) error
+}
+
+// PrometheusSink serves the metrics for Prometheus to scrape at :2112/metrics, along with the
+// status of the run.
+type PrometheusSink struct {
+	// Control serves the control API, resolving the markov chains it is given, when set
+	Control workload.ChainResolver
+}
+
+func (s PrometheusSink) Start(w workload.Workload) error {
+	workload.InitMetrics(w)
+	if s.Control != nil {
+		workload.EnableControl(s.Control)
+	}
+	return nil
+}
+
+// StatsdSink sends the metrics to a statsd or DogStatsD server.
+type StatsdSink struct {
+	Addr   string
+	Prefix string
+	// Tags are added to every metric, each as name:value
+	Tags []string
+}
+
+func (s StatsdSink) Start(w workload.Workload) error {
+	return workload.UseStatsd(s.Addr, s.Prefix, s.Tags)
+}
+
+// LocalSink only keeps the metrics in the process, for harnesses that read the result of the run
+// rather than serve its metrics.
+type LocalSink struct{}
+
+func (LocalSink) Start(w workload.Workload) error {
+	workload.UseLocalMetrics()
+	return nil
+}
+
+// metricsSink returns the metrics sink named by the config.
+func metricsSink(cfg Config) (MetricsSink, error) {
+	switch cfg.MetricsSink {
+	case metricsSinkPrometheus:
+		sink := PrometheusSink{}
+		if cfg.ControlAPI {
+			sink.Control = controlChainResolver(markovOptions{epsilon: cfg.MarkovEpsilon, normalize: cfg.MarkovNormalize})
+		}
+		return sink, nil
+	case metricsSinkStatsd:
+		sink := StatsdSink{Addr: cfg.StatsdAddr, Prefix: cfg.StatsdPrefix}
+		if cfg.StatsdTags != "" {
+			sink.Tags = strings.Split(cfg.StatsdTags, ",")
+		}
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("unknown metrics sink %s", cfg.MetricsSink)
+	}
+}
+
+// A Runner runs a workload against a cluster, loading its documents, running the phases of its
+// run plan and tearing it down as its config asks.  It lets other test harnesses drive
+// spectroperf as a library, as its command line does.
+type Runner struct {
+	Config Config
+	// Workload is run instead of the workload named by the config, when set
+	Workload workload.Workload
+	// Metrics is where operation metrics are sent, or the sink named by the config when nil
+	Metrics MetricsSink
+}
+
+// RunResult is the outcome of a run.
+type RunResult struct {
+	RunId string
+	Seed  int
+	Start time.Time
+	End   time.Time
+	// Paused is how long the run was paused for between Start and End
+	Paused time.Duration
+	// Operations summarise each operation attempted in each phase and target
+	Operations []workload.OperationSummary
+	// Transitions are those taken between the operations of each phase and target, compared with
+	// the probabilities of the markov chain
+	Transitions []workload.TransitionRow
+	// Timeline is the throughput, error rate and latency of the run over each interval of it
+	Timeline []workload.TimelineSample
+	// Topology is the nodes of the cluster and settings of the bucket at the start and end of the
+	// run, which is empty against the mock
+	Topology workload.Topology
+	// Setup is the timeline of the stages that loaded and set up each target before the run
+	Setup []workload.SetupStage
+	// Capacity is the outcome of the capacity search, when the run was one
+	Capacity    *workload.CapacityResult
+	Validations []Validation
+	// Aborted is why the run stopped before the end of its run plan, such as exceeding its error
+	// budget, or nil if it ran to the end
+	Aborted error
+}
+
+// Run runs the workload until the end of its run plan, the context is done, or it is
+// interrupted.  It returns an error if the run could not be set up, in which case nothing was
+// run; a run that was aborted part way through returns its result, with the reason in Aborted.
+func (r Runner) Run(ctx context.Context) (result RunResult, err error) {
+	cfg := r.Config
+	begun := time.Now()
+	var setupStages []workload.SetupStage
+	zap.L().Info("Parsed configuration", zap.String("config", fmt.Sprintf("%+v", cfg.redacted())))
+	mocked := cfg.Target == backendMock
+	switch {
+	case mocked:
+		err := checkMockTarget(cfg, r.Workload != nil)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "invalid mock target")
+		}
+	case cfg.Target != backendCluster:
+		return RunResult{}, fmt.Errorf("unknown target %s, expected %s or %s", cfg.Target, backendCluster, backendMock)
+	case cfg.Connstr == "":
+		return RunResult{}, fmt.Errorf("no connection string provided")
+	}
+
+	// Check the artifacts can be uploaded before running rather than after.  The report is written
+	// and the artifacts uploaded however the run ends, so that the log file of a run that failed is
+	// not lost with the machine it ran on.
+	if cfg.UploadURL != "" {
+		_, storeErr := newObjectStore(cfg.UploadURL, cfg.UploadEndpoint, cfg.UploadRegion)
+		if storeErr != nil {
+			return RunResult{}, errors.Wrap(storeErr, "failed to set up artifact upload")
+		}
+	}
+	if cfg.UploadURL != "" || cfg.ReportFile != "" {
+		defer func() {
+			reported := result
+			if err != nil {
+				reported = RunResult{RunId: cfg.RunId, Seed: cfg.Seed, Start: begun, End: time.Now(), Setup: setupStages, Aborted: err}
+			}
+			if cfg.ReportFile != "" {
+				reportErr := writeRunReport(cfg.ReportFile, reported)
+				if reportErr != nil {
+					zap.L().Error("Failed to write run report", zap.Error(reportErr))
+				}
+			}
+			if cfg.UploadURL != "" {
+				uploadErr := uploadArtifacts(cfg, reported, begun)
+				if uploadErr != nil {
+					zap.L().Error("Failed to upload artifacts", zap.Error(uploadErr))
+				}
+			}
+		}()
+	}
+
+	auth, err := newClusterAuth(cfg)
+	if err != nil {
+		return RunResult{}, err
+	}
+	caCertPool, clientCerts := auth.rootCAs, auth.clientCerts
+	dapiUsername, dapiPassword := auth.dapiUsername, auth.dapiPassword
+	opts := auth.opts
+	if cfg.SDKMetrics {
+		gocb.SetLogger(workload.SDKLogger())
+		opts.Tracer = workload.SDKTracer(gocb.NewThresholdLoggingTracer(nil))
+		opts.Meter = workload.SDKMeter()
+	}
+
+	// Against the mock there is no cluster, and the workload reaches the mock through the Data API
+	var cluster *gocb.Cluster
+	var bucket *gocb.Bucket
+	var collection *gocb.Collection
+	var scope *gocb.Scope
+	if mocked {
+		server, err := startMock(&cfg)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to start mock backend")
+		}
+		defer server.Close()
+	} else {
+		cluster, err = gocb.Connect(cfg.Connstr, opts)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to connect to cluster")
+		}
+		defer cluster.Close(nil)
+
+		bucket = cluster.Bucket(cfg.Bucket)
+		scope = bucket.Scope(cfg.Scope)
+		collection = scope.Collection(cfg.Collection)
+
+		err = waitForKeyspace(cluster, bucket, cfg)
+		if err != nil {
+			return RunResult{}, err
+		}
+	}
+
+	configHash, err := cfg.hash()
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "failed to hash configuration")
+	}
+	workload.SetRunInfo(cfg.RunId, configHash)
+	workload.KeyNamespace = cfg.KeyNamespace
+	workload.IndexPrefix = cfg.IndexPrefix
+	workload.ReuseIndexes = cfg.ReuseIndexes
+	workload.SkipLoad = cfg.SkipLoad
+	workload.Indexes = workload.IndexSettings{
+		Replicas:     cfg.IndexReplicas,
+		Deferred:     cfg.IndexDeferBuild,
+		PartitionBy:  cfg.IndexPartitionBy,
+		BuildTimeout: cfg.IndexTimeout,
+		PollInterval: cfg.IndexPoll,
+		PollBackoff:  cfg.IndexPollBackoff,
+	}
+	err = workload.Indexes.Validate()
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid index settings")
+	}
+	expectedRows, err := workload.ParseRowExpectations(cfg.QueryExpectRows)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid query settings")
+	}
+	workload.Queries = workload.QuerySettings{
+		Adhoc:           cfg.QueryAdhoc,
+		ScanConsistency: cfg.QueryConsistency,
+		MaxParallelism:  cfg.QueryParallelism,
+		ExpectedRows:    expectedRows,
+	}
+	err = workload.Queries.Validate()
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid query settings")
+	}
+	workload.Partitioning = workload.KeyPartitioning{Shards: cfg.KeyShards, Shared: cfg.KeySharedFrac}
+	err = workload.Partitioning.Validate()
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid key partitioning")
+	}
+	workload.RandSeed = cfg.Seed
+	workload.ErrorLogInterval = cfg.ErrorLogInterval
+	workload.ProgressInterval = cfg.ProgressInterval
+	workload.TimelineInterval = cfg.TimelineInterval
+	workload.Pprof = cfg.Pprof
+	workload.CorrectCoordinatedOmission = cfg.CorrectOmission
+	workload.NativeHistograms = cfg.NativeHistograms
+	workload.ClientStatsInterval = cfg.ClientStatsEvery
+	if cfg.EgressBandwidth < 0 || cfg.IngressBandwidth < 0 {
+		return RunResult{}, errors.New("bandwidth caps must not be negative")
+	}
+	workload.Bandwidth.Egress = cfg.EgressBandwidth
+	workload.Bandwidth.Ingress = cfg.IngressBandwidth
+	gofakeit.Seed(int64(cfg.Seed))
+	zap.L().Info("Using random seed", zap.Int("seed", cfg.Seed))
+
+	generator, err := workload.ParseGenerator(cfg.Generator, cfg.GeneratorFields)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "failed to load document generator")
+	}
+
+	if cfg.TargetResidency > 0 {
+		cfg.NumItems, err = itemsForResidency(cluster, bucket, cfg.Workload, generator, cfg.TargetResidency)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to size items for target residency")
+		}
+		zap.L().Info("Sized items for target residency", zap.Float64("residency", cfg.TargetResidency), zap.Int("numItems", cfg.NumItems))
+	}
+
+	// Runners can each authenticate as their own user, to benchmark the server side cost of many
+	// users rather than one.
+	identities, createdIdentities, err := resolveIdentities(cfg, cluster)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "failed to set up users for runners")
+	}
+	// The users created for the run are removed however it ends, so that none are left on the
+	// cluster by a run that failed to set up.
+	if createdIdentities {
+		defer func() {
+			dropErr := workload.DropIdentities(cluster, identities)
+			if dropErr != nil {
+				zap.L().Error("Failed to remove runner users", zap.Error(dropErr))
+			}
+		}()
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.TlsSkipVerify,
+		RootCAs:            caCertPool,
+		Certificates:       clientCerts,
+	}
+	// Report the progress of indexes while waiting for them to build, where the management API
+	// can be found.  The mock has no indexes.
+	if !mocked {
+		if mgmtURL, err := managementURL(cfg); err == nil {
+			workload.IndexProgress = workload.NewIndexStatus(mgmtURL, dapiUsername, dapiPassword, tlsConfig)
+		} else {
+			zap.L().Warn("Not reporting index build progress", zap.Error(err))
+		}
+	}
+
+	env := workloadEnv{
+		opts:         opts,
+		bucket:       bucket,
+		collection:   collection,
+		tlsConfig:    tlsConfig,
+		dapiUsername: dapiUsername,
+		dapiPassword: dapiPassword,
+		identities:   identities,
+		generator:    generator,
+	}
+	w := r.Workload
+	if w == nil {
+		w, err = buildWorkload(cfg, env)
+		if err != nil {
+			return RunResult{}, errors.Wrapf(err, "failed to create workload %s", cfg.Workload)
+		}
+		defer closeWorkload(w)
+	}
+	// Compound operations model page-level actions of users out of the operations of the workload.
+	compounds, err := workload.ParseCompounds(cfg.CompoundOps)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid compound operations")
+	}
+	w, err = workload.WithCompounds(w, compounds)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid compound operations")
+	}
+
+	// An A/B run splits the users between the workload and another, against another cluster or
+	// over another API, with the same schedule of operations, to compare them side by side.
+	targets := []workload.Target{{Workload: w}}
+	compareCfg, comparing := compareConfig(cfg)
+	var compareEnv workloadEnv
+	if comparing {
+		if cfg.RecordTrace != "" || cfg.ReplayTrace != "" {
+			return RunResult{}, fmt.Errorf("traces cannot be recorded or replayed while comparing targets")
+		}
+		compareEnv = env
+		if compareCfg.Connstr != cfg.Connstr {
+			if len(identities) > 0 {
+				return RunResult{}, fmt.Errorf("runner users cannot be used while comparing against another cluster")
+			}
+			var compareCluster *gocb.Cluster
+			compareCluster, compareEnv.bucket, err = connectBucket(compareCfg, opts)
+			if err != nil {
+				return RunResult{}, errors.Wrap(err, "failed to connect to comparison cluster")
+			}
+			defer compareCluster.Close(nil)
+			compareEnv.collection = compareEnv.bucket.Scope(cfg.Scope).Collection(cfg.Collection)
+		}
+		compareW, err := buildWorkload(compareCfg, compareEnv)
+		if err != nil {
+			return RunResult{}, errors.Wrapf(err, "failed to create comparison workload %s", compareCfg.Workload)
+		}
+		defer closeWorkload(compareW)
+		compareW, err = workload.WithCompounds(compareW, compounds)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "invalid compound operations for comparison workload")
+		}
+		targets = []workload.Target{{Name: targetA, Workload: w}, {Name: targetB, Workload: compareW}}
+	}
+
+	phases, err := buildPhases(cfg, w)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid run plan")
+	}
+	capacity, err := buildCapacitySearch(cfg)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid capacity search")
+	}
+	// Each workload runs its own markov chain, so a chain given for one cannot be used for another
+	// with different operations.
+	if comparing && !slices.Equal(targets[1].Workload.Operations(), w.Operations()) {
+		for _, phase := range phases {
+			if phase.Probabilities != nil {
+				return RunResult{}, fmt.Errorf("the operation mix cannot be changed while comparing workloads with different operations, %s and %s", cfg.Workload, compareCfg.Workload)
+			}
+		}
+	}
+
+	thinkTimes, err := buildThinkTimes(cfg, w.Operations())
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid think time")
+	}
+
+	errorBudget, err := buildErrorBudget(cfg, w.Operations())
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid error budget")
+	}
+
+	workload.OperationDeadlines, err = buildDeadlines(cfg, w.Operations())
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "invalid operation deadline")
+	}
+
+	sink := r.Metrics
+	if sink == nil {
+		sink, err = metricsSink(cfg)
+		if err != nil {
+			return RunResult{}, err
+		}
+	}
+	err = sink.Start(w)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "failed to set up metrics")
+	}
+
+	zap.L().Info("Setting up for workload", zap.String("workload", cfg.Workload))
+	workload.SetRunState(workload.RunStateSetup)
+
+	// call the setup function on the workload.
+	loader, err := newLoader(cfg, env)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "failed to set up data loading")
+	}
+	stages, err := workload.Setup(w, cfg.NumItems, scope, loader, cfg.SetupTimeout)
+	setupStages = append(setupStages, targetStages(stages, targets[0].Name)...)
+	if err != nil {
+		return RunResult{}, errors.Wrap(err, "failed to setup workload")
+	}
+	if comparing {
+		compareLoader, err := newLoader(compareCfg, compareEnv)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to set up comparison data loading")
+		}
+		stages, err := workload.Setup(targets[1].Workload, cfg.NumItems, compareEnv.bucket.Scope(cfg.Scope), compareLoader, cfg.SetupTimeout)
+		setupStages = append(setupStages, targetStages(stages, targets[1].Name)...)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to setup comparison workload")
+		}
+	}
+
+	time.Sleep(5 * time.Second)
+
+	if cfg.ColdStart {
+		err = coldStart(ctx, cfg, bucket, dapiUsername, dapiPassword, tlsConfig)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to cold start")
+		}
+		// A comparison against another cluster restarts its bucket too, found from its connection
+		// string as --mgmt-url names the management API of the cluster under test
+		if comparing && compareCfg.Connstr != cfg.Connstr {
+			compareCold := compareCfg
+			compareCold.MgmtURL = ""
+			err = coldStart(ctx, compareCold, compareEnv.bucket, dapiUsername, dapiPassword, tlsConfig)
+			if err != nil {
+				return RunResult{}, errors.Wrap(err, "failed to cold start comparison cluster")
+			}
+		}
+	}
+
+	// Record what the cluster is made of, so the results can be read knowing its versions and
+	// bucket settings, and whether they changed during the run.
+	var topology workload.Topology
+	if !mocked {
+		topology.Start = captureTopology(ctx, cfg, dapiUsername, dapiPassword, tlsConfig)
+	}
+
+	zap.L().Info("Running workload…\n")
+	// Measure the latency of an idle cluster, to see how long it takes to return to it after the run.
+	var probe *workload.Probe
+	var baseline time.Duration
+	if cfg.CoolDown > 0 {
+		probe = workload.NewProbe(collection, cfg.NumItems, time.Second)
+		baseline, err = probe.Baseline(ctx, baselineProbes)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to measure baseline latency")
+		}
+		zap.L().Info("Measured baseline latency", zap.Duration("baseline", baseline))
+	}
+
+	// Monitoring agents polling the management API run for as long as the workload, at a low rate of
+	// their own, so their impact on the latency of the workload can be measured.  They stop with the
+	// workload, even when it ends early.
+	var wg sync.WaitGroup
+	mgmtCtx, stopMgmt := context.WithCancel(ctx)
+	defer stopMgmt()
+	if cfg.MgmtUsers > 0 {
+		mgmtURL, err := managementURL(cfg)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to find management API address")
+		}
+		mgmt := workloads.NewMgmt(mgmtURL, cfg.Bucket, dapiUsername, dapiPassword, tlsConfig)
+
+		var duration time.Duration
+		for _, phase := range phases {
+			duration += phase.Duration
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workload.RunTargetsContext(mgmtCtx, []workload.Target{{Workload: mgmt}}, []workload.Phase{{Name: "mgmt", Duration: duration, Users: cfg.MgmtUsers}}, workload.RunOptions{
+				ThinkTimes: workload.ThinkTimes{Default: workload.ThinkTime{Distribution: "fixed", Min: cfg.MgmtInterval}},
+			})
+		}()
+	}
+
+	// Sample the stats of the cluster alongside the workload, to line them up with its latency.
+	var clusterStats *workload.ClusterStats
+	stopClusterStats := func() {}
+	if cfg.StatsInterval > 0 {
+		mgmtURL, err := managementURL(cfg)
+		if err != nil {
+			return RunResult{}, errors.Wrap(err, "failed to find management API address")
+		}
+		clusterStats = workload.NewClusterStats(mgmtURL, cfg.Bucket, dapiUsername, dapiPassword, tlsConfig, cfg.StatsInterval)
+		statsCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			clusterStats.Run(statsCtx)
+		}()
+		stopClusterStats = func() {
+			cancel()
+			<-done
+		}
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	go workload.MonitorClient(monitorCtx, clientMonitorInterval)
+
+	stopProfiling, err := startProfiling(cfg.ProfileCPU, cfg.ProfileMem)
+	if err != nil {
+		stopMonitor()
+		stopClusterStats()
+		return RunResult{}, errors.Wrap(err, "failed to start profiling")
+	}
+
+	result = RunResult{RunId: cfg.RunId, Seed: cfg.Seed, Start: time.Now(), Topology: topology, Setup: setupStages}
+	if cfg.ReplayTrace != "" {
+		workload.SetRunState(workload.RunStateRunning)
+		err = workload.Replay(w, cfg.ReplayTrace, identities)
+		if err != nil {
+			result.Aborted = errors.Wrap(err, "failed to replay trace")
+		}
+	} else {
+		runOpts := workload.RunOptions{
+			ThinkTimes: thinkTimes,
+			Idle: workload.IdleUsers{
+				Users:    cfg.IdleUsers,
+				Interval: cfg.IdleInterval,
+			},
+			Identities:  identities,
+			ErrorBudget: errorBudget,
+			Workers:     cfg.Workers,
+			Main:        true,
+			Capacity:    capacity,
+		}
+		if cfg.RecordTrace != "" {
+			runOpts.Recorder, err = workload.NewTraceRecorder(cfg.RecordTrace)
+			if err != nil {
+				stopMonitor()
+				stopClusterStats()
+				return RunResult{}, errors.Wrap(err, "failed to start recording trace")
+			}
+		}
+
+		result.Aborted = workload.RunTargetsContext(ctx, targets, phases, runOpts)
+		if capacity != nil {
+			searched := capacity.Result()
+			result.Capacity = &searched
+		}
+
+		if runOpts.Recorder != nil {
+			err = runOpts.Recorder.Close()
+			if err != nil {
+				zap.L().Error("Failed to save trace", zap.Error(err))
+			}
+		}
+	}
+	stopMgmt()
+
+	if probe != nil {
+		workload.SetRunState(workload.RunStateCoolDown)
+		zap.L().Info("Cooling down", zap.Duration("period", cfg.CoolDown))
+		recovery, recovered := probe.CoolDown(context.Background(), baseline, cfg.CoolDown)
+		if recovered {
+			zap.L().Info("Latency returned to baseline", zap.Duration("baseline", baseline), zap.Duration("recovery", recovery))
+		} else {
+			zap.L().Warn("Latency did not return to baseline during cool-down", zap.Duration("baseline", baseline), zap.Duration("period", cfg.CoolDown))
+		}
+	}
+
+	workload.SetRunState(workload.RunStateTeardown)
+
+	if !mocked {
+		result.Topology.End = captureTopology(context.Background(), cfg, dapiUsername, dapiPassword, tlsConfig)
+	}
+
+	// Check the data is consistent with the operations that ran, before teardown removes it.
+	result.Validations = validateTargets(context.Background(), targets)
+
+	// Tear down what the run set up, so repeated runs on a shared cluster do not accumulate it.
+	if cfg.Teardown {
+		for _, target := range targets {
+			err = workload.Cleanup(context.Background(), target.Workload)
+			if err != nil {
+				zap.L().Error("Failed to clean up workload", zap.String("target", target.Name), zap.Error(err))
+			}
+		}
+		err = workload.DropIndexes(context.Background())
+		if err != nil {
+			zap.L().Error("Failed to drop indexes", zap.Error(err))
+		}
+	}
+	if cfg.TeardownData {
+		err = workload.RemoveData(context.Background(), cfg.NumItems, collection)
+		if err == nil && comparing && compareEnv.collection != collection {
+			err = workload.RemoveData(context.Background(), cfg.NumItems, compareEnv.collection)
+		}
+		if err != nil {
+			zap.L().Error("Failed to remove loaded documents", zap.Error(err))
+		}
+	}
+
+	wg.Wait()
+	stopMonitor()
+	stopClusterStats()
+	err = stopProfiling()
+	if err != nil {
+		zap.L().Error("Failed to save profiles", zap.Error(err))
+	}
+
+	result.End = time.Now()
+	result.Paused = workload.PausedSince(result.Start)
+	result.Operations, err = summariseRun(cfg, result.Start, result.End)
+	if err != nil {
+		zap.L().Error("Failed to summarise operations", zap.Error(err))
+	}
+	result.Transitions, err = workload.SummariseTransitions()
+	if err != nil {
+		zap.L().Error("Failed to summarise transitions", zap.Error(err))
+	}
+	result.Timeline = workload.Timeline()
+
+	if clusterStats != nil {
+		err = reportClusterStats(cfg.StatsFile, clusterStats.Samples())
+		if err != nil {
+			zap.L().Error("Failed to report cluster stats", zap.Error(err))
+		}
+	}
+
+	if result.Aborted != nil {
+		workload.SetRunState(workload.RunStateAborted)
+	} else {
+		workload.SetRunState(workload.RunStateComplete)
+	}
+	return result, nil
+}
+
+// clusterAuth is how the SDK and the Data API client trust and authenticate to the cluster.
+type clusterAuth struct {
+	opts         gocb.ClusterOptions
+	rootCAs      *x509.CertPool
+	clientCerts  []tls.Certificate
+	dapiUsername string
+	dapiPassword string
+}
+
+// newClusterAuth loads the certificates and credentials of the config.
+func newClusterAuth(cfg Config) (clusterAuth, error) {
+	// Trust the system roots along with the given CA, for both the SDK and the Data API client.
+	caCertPool, err := x509.SystemCertPool()
+	if err != nil {
+		zap.L().Warn("Failed to load system certificate pool", zap.Error(err))
+		caCertPool = x509.NewCertPool()
+	}
+	if cfg.Cert != "" {
+		caCert, err := os.ReadFile(cfg.Cert)
+		if err != nil {
+			return clusterAuth{}, errors.Wrap(err, "failed to read certificate")
+		}
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return clusterAuth{}, fmt.Errorf("no certificates found in certificate file %s", cfg.Cert)
+		}
+	}
+
+	// TODO: add a param to set this up if debugging gocb issues.  Probably with the system logger.
+	// gocb.SetLogger(gocb.VerboseStdioLogger())
+
+	// With a client certificate the DAPI requests are authenticated by the TLS handshake, so no
+	// basic auth credentials are sent.
+	var clientCerts []tls.Certificate
+	dapiUsername, dapiPassword := cfg.Username, cfg.Password
+	var authenticator gocb.Authenticator = gocb.PasswordAuthenticator{
+		Username: cfg.Username,
+		Password: cfg.Password,
+	}
+	if cfg.ClientCert != "" {
+		clientCert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return clusterAuth{}, errors.Wrapf(err, "failed to load client certificate %s", cfg.ClientCert)
+		}
+		clientCerts = append(clientCerts, clientCert)
+		authenticator = gocb.CertificateAuthenticator{ClientCertificate: &clientCert}
+		dapiUsername, dapiPassword = "", ""
+	}
+
+	opts := gocb.ClusterOptions{
+		Authenticator: authenticator,
+		SecurityConfig: gocb.SecurityConfig{
+			TLSSkipVerify: cfg.TlsSkipVerify,
+			TLSRootCAs:    caCertPool,
+		},
+		CompressionConfig: gocb.CompressionConfig{
+			Disabled: cfg.NoCompression,
+			MinSize:  uint32(cfg.CompressMinSize),
+			MinRatio: cfg.CompressMinRatio,
+		},
+	}
+	return clusterAuth{
+		opts:         opts,
+		rootCAs:      caCertPool,
+		clientCerts:  clientCerts,
+		dapiUsername: dapiUsername,
+		dapiPassword: dapiPassword,
+	}, nil
+}
+
+// coldStart restarts the bucket under test, emptying its cache and closing the connections of the
+// SDK to it, and waits for the SDK to reconnect.
+func coldStart(ctx context.Context, cfg Config, bucket *gocb.Bucket, username string, password string, tlsConfig *tls.Config) error {
+	mgmtURL, err := managementURL(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to find management API address")
+	}
+	if cfg.SetupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.SetupTimeout)
+		defer cancel()
+	}
+
+	err = workload.RestartBucket(ctx, mgmtURL, cfg.Bucket, username, password, tlsConfig)
+	if err != nil {
+		return err
+	}
+	err = bucket.WaitUntilReady(time.Minute, &gocb.WaitUntilReadyOptions{Context: ctx})
+	if err != nil {
+		return errors.Wrapf(err, "bucket %s did not become ready after restarting", cfg.Bucket)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package spectroperf
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"gopkg.in/yaml.v3"
+)
+
+// schemaErrors are the problems found in a config file, each prefixed with its line.
+type schemaErrors []string
+
+func (e *schemaErrors) add(node *yaml.Node, format string, args ...any) {
+	*e = append(*e, fmt.Sprintf("line %d: %s", node.Line, fmt.Sprintf(format, args...)))
+}
+
+func (e schemaErrors) Error() string {
+	return strings.Join(e, "\n")
+}
+
+func (e schemaErrors) sort() schemaErrors {
+	slices.SortFunc(e, func(a string, b string) int {
+		return cmp.Or(cmp.Compare(lineOf(a), lineOf(b)), strings.Compare(a, b))
+	})
+	return slices.Compact(e)
+}
+
+// lineOf returns the line a problem was found on.
+func lineOf(problem string) int {
+	var line int
+	fmt.Sscanf(problem, "line %d:", &line)
+	return line
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	nodeType        = reflect.TypeOf(yaml.Node{})
+)
+
+// configEnums are the values allowed for the keys of Config that take one of a set, checked with
+// the line they are on rather than once the run has started.
+func configEnums() map[string][]string {
+	return map[string][]string{
+		"target":              {backendCluster, backendMock},
+		"load-via":            {loadViaSDK, loadViaDapi},
+		"metrics-sink":        {metricsSinkPrometheus, metricsSinkStatsd},
+		"error-budget-action": {errorActionAbort, errorActionOperation},
+	}
+}
+
+// checkConfigSchema checks a layer of the config file against the fields of Config, so that a
+// misspelled key, or a workload or think time that does not exist, fails with its line instead of
+// being ignored.  extra are keys allowed on top of those of Config, such as the inherits of a
+// profile.
+func checkConfigSchema(node *yaml.Node, extra []string, errs *schemaErrors) {
+	checkSchema(node, reflect.TypeOf(Config{}), "", extra, errs)
+}
+
+// checkSchema checks that every key of a mapping is a field of the struct t, descending into the
+// fields that are structs or lists of structs.  Fields that decode themselves, or are kept as
+// nodes to decode later, are left to do so.
+func checkSchema(node *yaml.Node, t reflect.Type, path string, extra []string, errs *schemaErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nodeType || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			errs.add(node, "%s must be a mapping of keys to values", describePath(path))
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if slices.Contains(extra, key.Value) {
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				errs.add(key, "unknown key %s%s", joinPath(path, key.Value), suggestKey(key.Value, fields))
+				continue
+			}
+			if path == "" {
+				checkConfigValue(key.Value, value, errs)
+			}
+			checkSchema(value, field, joinPath(path, key.Value), nil, errs)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			checkSchema(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), nil, errs)
+		}
+	}
+}
+
+// checkConfigValue checks the value of a key of Config that must be one of a set.
+func checkConfigValue(key string, value *yaml.Node, errs *schemaErrors) {
+	if value.Kind != yaml.ScalarNode {
+		if key == "operation-think-times" && value.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(value.Content); i += 2 {
+				checkThinkTime(value.Content[i+1], errs)
+			}
+		}
+		return
+	}
+
+	switch key {
+	case "workload", "compare-workload":
+		if value.Value != "" && !slices.Contains(workloadNames, value.Value) && !strings.HasPrefix(value.Value, pluginPrefix) {
+			errs.add(value, "unknown workload %s, expected one of %s or %s<path>", value.Value, strings.Join(workloadNames, ", "), pluginPrefix)
+		}
+	case "think-time":
+		checkThinkTime(value, errs)
+	default:
+		allowed, ok := configEnums()[key]
+		if ok && value.Value != "" && !slices.Contains(allowed, value.Value) {
+			errs.add(value, "unknown %s %s, expected one of %s", key, value.Value, strings.Join(allowed, ", "))
+		}
+	}
+}
+
+func checkThinkTime(value *yaml.Node, errs *schemaErrors) {
+	if value.Value == "" {
+		return
+	}
+	_, err := workload.ParseThinkTime(value.Value)
+	if err != nil {
+		errs.add(value, "%s", err.Error())
+	}
+}
+
+// yamlFields returns the type of each field of a struct by its key in YAML.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// suggestKey returns a hint naming the key closest to a misspelled one, if any is close enough to
+// be what was meant.
+func suggestKey(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", len(key)/3+1
+	for name := range fields {
+		distance := editDistance(key, name)
+		if distance < bestDistance || (distance == bestDistance && best != "" && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// editDistance returns the number of characters that must be inserted, deleted or replaced to
+// turn a into b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "the config"
+	}
+	return path
+}
@@ -0,0 +1,91 @@
+package spectroperf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+)
+
+const (
+	// residencySamples is the number of documents generated to estimate the average document size
+	residencySamples = 1000
+	// itemMetadataBytes approximates the memory used by the metadata of each item, which is always resident
+	itemMetadataBytes = 56
+	// highWaterMark is the fraction of the bucket quota that the data service fills before ejecting items
+	highWaterMark = 0.85
+)
+
+// itemsForResidency estimates how many documents the workload must load for only the target
+// fraction of them to fit in the memory of the bucket, given its RAM quota, replicas and number of
+// data nodes.
+func itemsForResidency(cluster *gocb.Cluster, bucket *gocb.Bucket, workloadName string, generator workload.Generator, residency float64) (int, error) {
+	if residency <= 0 || residency > 1 {
+		return 0, fmt.Errorf("target residency %g must be greater than 0 and at most 1", residency)
+	}
+
+	settings, err := cluster.Buckets().GetBucket(bucket.Name(), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get bucket settings")
+	}
+
+	nodes, err := dataNodes(bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	w, ok := SampleWorkload(workloadName)
+	if !ok {
+		return 0, fmt.Errorf("unknown workload %s", workloadName)
+	}
+	w = workload.WithGenerator(w, generator)
+
+	var docBytes, keyBytes int
+	for i := 0; i < residencySamples; i++ {
+		doc := w.GenerateDocument(fmt.Sprintf("u%d", i))
+		data, ok := doc.Data.([]byte)
+		if !ok {
+			data, err = json.Marshal(doc.Data)
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to marshal sample document")
+			}
+		}
+		docBytes += len(data)
+		keyBytes += len(doc.Name)
+	}
+	avgDoc := float64(docBytes) / residencySamples
+	avgKey := float64(keyBytes) / residencySamples
+
+	// Every copy of an item keeps its key and metadata in memory, along with the value of the
+	// resident fraction of items.
+	quotaBytes := float64(settings.RAMQuotaMB) * 1024 * 1024 * float64(nodes) * highWaterMark
+	copies := float64(1 + settings.NumReplicas)
+	itemBytes := copies * (itemMetadataBytes + avgKey + residency*avgDoc)
+
+	return int(quotaBytes / itemBytes), nil
+}
+
+// dataNodes returns the number of nodes running the data service for the bucket.
+func dataNodes(bucket *gocb.Bucket) (int, error) {
+	result, err := bucket.Ping(&gocb.PingOptions{ServiceTypes: []gocb.ServiceType{gocb.ServiceTypeKeyValue}})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to ping data nodes")
+	}
+
+	hosts := map[string]bool{}
+	for _, endpoint := range result.Services[gocb.ServiceTypeKeyValue] {
+		host, _, err := net.SplitHostPort(endpoint.Remote)
+		if err != nil {
+			host = endpoint.Remote
+		}
+		hosts[host] = true
+	}
+
+	if len(hosts) == 0 {
+		return 0, errors.New("no data nodes found")
+	}
+	return len(hosts), nil
+}
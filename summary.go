@@ -0,0 +1,50 @@
+package spectroperf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// summariseRun summarises the operations of the run, from the Prometheus server if one is
+// configured, or otherwise from the metrics recorded by this process.
+func summariseRun(cfg Config, start time.Time, end time.Time) ([]workload.OperationSummary, error) {
+	if cfg.PromURL == "" {
+		return workload.SummariseOperationMetrics()
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.PromSkipVerify}
+	if cfg.PromCert != "" {
+		caCert, err := os.ReadFile(cfg.PromCert)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read prometheus certificate")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in prometheus certificate file %s", cfg.PromCert)
+		}
+	}
+
+	// The last metrics of the run are only in Prometheus once it has scraped them, so the run is
+	// queried up to the end of the wait rather than the end of the run.
+	zap.L().Info("Waiting for prometheus to scrape the run", zap.Duration("wait", cfg.PromScrapeWait))
+	time.Sleep(cfg.PromScrapeWait)
+	end = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return workload.SummariseFromPrometheus(ctx, workload.PrometheusServer{
+		URL:         cfg.PromURL,
+		Username:    cfg.PromUsername,
+		Password:    cfg.PromPassword,
+		BearerToken: cfg.PromToken,
+		TLSConfig:   tlsConfig,
+	}, cfg.RunId, start, end)
+}
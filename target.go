@@ -0,0 +1,341 @@
+package spectroperf
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/couchbaselabs/spectroperf/workload/dapi"
+	"github.com/couchbaselabs/spectroperf/workload/workloads"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Names of the targets of a comparison run, which label their metrics.
+const (
+	targetA = "a"
+	targetB = "b"
+)
+
+// workloadEnv is what a workload needs to run against a cluster.
+type workloadEnv struct {
+	opts         gocb.ClusterOptions
+	bucket       *gocb.Bucket
+	collection   *gocb.Collection
+	tlsConfig    *tls.Config
+	dapiUsername string
+	dapiPassword string
+	identities   []workload.Identity
+	generator    workload.Generator
+}
+
+// buildWorkload returns the workload named in the config, connected to the cluster, loading the
+// documents of the generator of the run if one was given.
+func buildWorkload(cfg Config, env workloadEnv) (workload.Workload, error) {
+	w, err := newWorkload(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+	return workload.WithGenerator(w, env.generator), nil
+}
+
+// pluginPrefix marks a workload name as the path of an external workload.
+const pluginPrefix = "plugin:"
+
+// builtinWorkload is a workload built into spectroperf, with how it is created for a run, and how
+// a sample of it is created without a cluster.
+type builtinWorkload struct {
+	name   string
+	sample func() workload.Workload
+	build  func(cfg Config, env workloadEnv) (workload.Workload, error)
+}
+
+// builtinWorkloads are the built in workloads, in the order they are listed, which newWorkload
+// creates and SampleWorkload samples.
+var builtinWorkloads = []builtinWorkload{
+	{
+		name:   "user-profile",
+		sample: func() workload.Workload { return workloads.NewUserProfile(0, nil, nil) },
+		build: func(cfg Config, env workloadEnv) (workload.Workload, error) {
+			profile := workloads.NewUserProfile(cfg.NumItems, env.bucket.Scope(cfg.Scope), env.collection)
+			if len(env.identities) > 0 {
+				scopes, err := connectIdentities(cfg, env.opts, env.identities)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to connect as runner users")
+				}
+				profile = profile.WithIdentities(scopes)
+			}
+			if cfg.ScanSize != "" {
+				scanSize, err := workload.ParseScanSize(cfg.ScanSize)
+				if err != nil {
+					return nil, errors.Wrap(err, "invalid scan size")
+				}
+				profile = profile.WithScanSize(scanSize)
+			}
+			profile, err := profile.WithLocking(cfg.LockMode, cfg.LockDuration, cfg.LockHold)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid locking")
+			}
+			profile, err = profile.WithReplicaReads(cfg.ReplicaReads)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid replica reads")
+			}
+			profile, err = profile.WithBatchSize(cfg.BatchSize)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid batch size")
+			}
+			profile, err = profile.WithChurnPolicy(cfg.ChurnPolicy)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid churn policy")
+			}
+			mutations, err := workload.ParseMutationSemantics(cfg.Mutations)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid mutation semantics")
+			}
+			return profile.WithMutationSemantics(mutations), nil
+		},
+	},
+	{
+		name:   "time-series",
+		sample: func() workload.Workload { return workloads.NewTimeSeries(0, nil, nil) },
+		build: func(cfg Config, env workloadEnv) (workload.Workload, error) {
+			series, err := workloads.NewTimeSeries(cfg.NumItems, env.bucket.Scope(cfg.Scope), env.collection).WithRetention(cfg.EventRetention, cfg.EventWindow)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid event retention")
+			}
+			return series, nil
+		},
+	},
+	{
+		name:   "session-store",
+		sample: func() workload.Workload { return workloads.NewSessionStore(0, nil) },
+		build: func(cfg Config, env workloadEnv) (workload.Workload, error) {
+			sessions, err := workloads.NewSessionStore(cfg.NumItems, env.collection).WithSessions(cfg.SessionTTL, cfg.SessionChurn)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid sessions")
+			}
+			return sessions, nil
+		},
+	},
+	{
+		name:   "inbox",
+		sample: func() workload.Workload { return workloads.NewInbox(0, nil) },
+		build: func(cfg Config, env workloadEnv) (workload.Workload, error) {
+			inbox, err := workloads.NewInbox(cfg.NumItems, env.collection).WithMessages(cfg.InboxMessages)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid inbox")
+			}
+			return inbox, nil
+		},
+	},
+	{
+		name:   "document-metadata",
+		sample: func() workload.Workload { return workloads.NewDocumentMetadata(0, nil) },
+		build: func(cfg Config, env workloadEnv) (workload.Workload, error) {
+			return workloads.NewDocumentMetadata(cfg.NumItems, env.collection), nil
+		},
+	},
+	{
+		name:   "existing-data",
+		sample: func() workload.Workload { return workloads.NewExistingData(0, nil, nil) },
+		build: func(cfg Config, env workloadEnv) (workload.Workload, error) {
+			if cfg.TeardownData {
+				return nil, fmt.Errorf("--teardown-data would remove documents the existing-data workload did not load")
+			}
+			existing, err := workloads.NewExistingData(cfg.NumItems, env.bucket.Scope(cfg.Scope), env.collection).WithDiscovery(cfg.ExistingKeys)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid existing keys")
+			}
+			mutations, err := workload.ParseMutationSemantics(cfg.Mutations)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid mutation semantics")
+			}
+			return existing.WithMutationSemantics(mutations), nil
+		},
+	},
+	{
+		name: "user-profile-dapi",
+		sample: func() workload.Workload {
+			return workloads.NewUserProfileDapi("", "", "", "", 0, "", "", nil, dapi.DefaultTransport)
+		},
+		build: func(cfg Config, env workloadEnv) (workload.Workload, error) {
+			transport, err := dapiTransport(cfg)
+			if err != nil {
+				return nil, err
+			}
+			return workloads.NewUserProfileDapi(cfg.DapiConnstr, cfg.Bucket, cfg.Scope, cfg.Collection, cfg.NumItems, env.dapiUsername, env.dapiPassword, env.tlsConfig, transport), nil
+		},
+	},
+}
+
+// workloadNames are the names of the built in workloads.
+var workloadNames = func() []string {
+	names := make([]string, len(builtinWorkloads))
+	for i, w := range builtinWorkloads {
+		names[i] = w.name
+	}
+	return names
+}()
+
+// WorkloadNames returns the names of the built in workloads.
+func WorkloadNames() []string {
+	return slices.Clone(workloadNames)
+}
+
+func newWorkload(cfg Config, env workloadEnv) (workload.Workload, error) {
+	if path, ok := strings.CutPrefix(cfg.Workload, pluginPrefix); ok {
+		return workloads.NewExternal(path, strings.Fields(cfg.PluginArgs), workloads.ExternalConfig{
+			Connstr:       cfg.Connstr,
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			Cert:          cfg.Cert,
+			ClientCert:    cfg.ClientCert,
+			ClientKey:     cfg.ClientKey,
+			TLSSkipVerify: cfg.TlsSkipVerify,
+			Bucket:        cfg.Bucket,
+			Scope:         cfg.Scope,
+			Collection:    cfg.Collection,
+			NumItems:      cfg.NumItems,
+			Seed:          cfg.Seed,
+			KeyNamespace:  cfg.KeyNamespace,
+			IndexPrefix:   cfg.IndexPrefix,
+			DapiConnstr:   cfg.DapiConnstr,
+		})
+	}
+
+	i := slices.IndexFunc(builtinWorkloads, func(w builtinWorkload) bool { return w.name == cfg.Workload })
+	if i < 0 {
+		return nil, fmt.Errorf("unknown workload type %s", cfg.Workload)
+	}
+	return builtinWorkloads[i].build(cfg, env)
+}
+
+// closeWorkload releases what the workload holds once the run is over, such as the process of an
+// external workload.
+func closeWorkload(w workload.Workload) {
+	err := workload.Close(w)
+	if err != nil {
+		zap.L().Error("Failed to close workload", zap.Error(err))
+	}
+}
+
+// dapiTransport returns the transport of the connections to the Data API.
+func dapiTransport(cfg Config) (dapi.Transport, error) {
+	transport := dapi.Transport{
+		MaxConnsPerHost:     cfg.DapiMaxConns,
+		MaxIdleConnsPerHost: cfg.DapiMaxIdleConns,
+		IdleConnTimeout:     cfg.DapiIdleTimeout,
+		HTTPVersion:         cfg.DapiHTTPVersion,
+		TLSSessionCache:     cfg.DapiTLSSessions,
+	}
+	err := transport.Validate()
+	if err != nil {
+		return dapi.Transport{}, errors.Wrap(err, "invalid Data API transport")
+	}
+	return transport, nil
+}
+
+// Ways the documents of the setup can be loaded.
+const (
+	loadViaSDK  = "sdk"
+	loadViaDapi = "dapi"
+)
+
+// newLoader returns the loader of the documents of the setup named in the config.
+func newLoader(cfg Config, env workloadEnv) (workload.Loader, error) {
+	switch cfg.LoadVia {
+	case loadViaSDK:
+		return workload.CollectionLoader(env.collection), nil
+	case loadViaDapi:
+		if cfg.DapiConnstr == "" {
+			return nil, fmt.Errorf("loading via the Data API needs --dapi-connstr")
+		}
+		transport, err := dapiTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return dapi.NewClient(cfg.DapiConnstr, cfg.Bucket, cfg.Scope, cfg.Collection, env.dapiUsername, env.dapiPassword, env.tlsConfig, transport), nil
+	default:
+		return nil, fmt.Errorf("unknown way to load data %s, expected %s or %s", cfg.LoadVia, loadViaSDK, loadViaDapi)
+	}
+}
+
+// Validation is the outcome of validating the data of a target at the end of the run.
+type Validation struct {
+	Target string
+	// Err is why the data was found inconsistent, or nil if it passed
+	Err error
+}
+
+// validateTargets validates the data of each target whose workload can validate it.
+func validateTargets(ctx context.Context, targets []workload.Target) []Validation {
+	var validations []Validation
+	for _, target := range targets {
+		validated, err := workload.Validate(ctx, target.Workload)
+		if validated {
+			validations = append(validations, Validation{Target: target.Name, Err: err})
+		}
+	}
+	return validations
+}
+
+// compareConfig returns the config of the target compared against in an A/B run, which differs
+// from the config of the run only in the cluster it connects to and the workload it runs.  It
+// returns false if no comparison was asked for.
+func compareConfig(cfg Config) (Config, bool) {
+	if cfg.CompareConnstr == "" && cfg.CompareDapi == "" && cfg.CompareWorkload == "" {
+		return Config{}, false
+	}
+
+	compare := cfg
+	if cfg.CompareConnstr != "" {
+		compare.Connstr = cfg.CompareConnstr
+	}
+	if cfg.CompareDapi != "" {
+		compare.DapiConnstr = cfg.CompareDapi
+	}
+	if cfg.CompareWorkload != "" {
+		compare.Workload = cfg.CompareWorkload
+	}
+	return compare, true
+}
+
+// targetStages labels the setup stages of a target with its name, which is empty unless targets
+// are compared.
+func targetStages(stages []workload.SetupStage, target string) []workload.SetupStage {
+	for i := range stages {
+		stages[i].Target = target
+	}
+	return stages
+}
+
+// connectBucket connects to the bucket under test of a cluster, checking its scope and collection
+// exist.
+func connectBucket(cfg Config, opts gocb.ClusterOptions) (*gocb.Cluster, *gocb.Bucket, error) {
+	cluster, err := gocb.Connect(cfg.Connstr, opts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to connect to cluster")
+	}
+
+	bucket := cluster.Bucket(cfg.Bucket)
+	err = waitForKeyspace(cluster, bucket, cfg)
+	if err != nil {
+		cluster.Close(nil)
+		return nil, nil, err
+	}
+	return cluster, bucket, nil
+}
+
+// SampleWorkload returns the named workload without any connection to a cluster, which can only
+// be used to inspect its operations and generate documents.
+func SampleWorkload(name string) (workload.Workload, bool) {
+	i := slices.IndexFunc(builtinWorkloads, func(w builtinWorkload) bool { return w.name == name })
+	if i < 0 {
+		return nil, false
+	}
+	return builtinWorkloads[i].sample(), true
+}
@@ -0,0 +1,336 @@
+package spectroperf
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables holding the credentials artifacts are uploaded with.  For GCS these are
+// HMAC keys of a service account.
+const (
+	uploadAccessKeyEnv = "SPECTROPERF_UPLOAD_ACCESS_KEY"
+	uploadSecretKeyEnv = "SPECTROPERF_UPLOAD_SECRET_KEY"
+)
+
+// uploadTimeout bounds how long uploading the artifacts of a run may take.
+const uploadTimeout = 10 * time.Minute
+
+// runReport is the outcome of a run as uploaded, with its errors as messages.
+type runReport struct {
+	RunId       string                      `json:"runId"`
+	Seed        int                         `json:"seed"`
+	Start       time.Time                   `json:"start"`
+	End         time.Time                   `json:"end"`
+	Paused      time.Duration               `json:"paused"`
+	Operations  []workload.OperationSummary `json:"operations"`
+	Transitions []workload.TransitionRow    `json:"transitions,omitempty"`
+	Timeline    []workload.TimelineSample   `json:"timeline,omitempty"`
+	Topology    workload.Topology           `json:"topology"`
+	Setup       []setupStageReport          `json:"setup,omitempty"`
+	Capacity    *workload.CapacityResult    `json:"capacity,omitempty"`
+	Validations []validationReport          `json:"validations,omitempty"`
+	Aborted     string                      `json:"aborted,omitempty"`
+}
+
+type validationReport struct {
+	Target string `json:"target"`
+	Error  string `json:"error,omitempty"`
+}
+
+type setupStageReport struct {
+	Name     string        `json:"name"`
+	Target   string        `json:"target,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	TimedOut bool          `json:"timedOut,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func newRunReport(result RunResult) runReport {
+	report := runReport{
+		RunId:       result.RunId,
+		Seed:        result.Seed,
+		Start:       result.Start,
+		End:         result.End,
+		Paused:      result.Paused,
+		Operations:  result.Operations,
+		Transitions: result.Transitions,
+		Timeline:    result.Timeline,
+		Topology:    result.Topology,
+		Capacity:    result.Capacity,
+	}
+	for _, stage := range result.Setup {
+		s := setupStageReport{Name: stage.Name, Target: stage.Target, Start: stage.Start, Duration: stage.Duration, TimedOut: stage.TimedOut}
+		if stage.Err != nil {
+			s.Error = stage.Err.Error()
+		}
+		report.Setup = append(report.Setup, s)
+	}
+	for _, validation := range result.Validations {
+		v := validationReport{Target: validation.Target}
+		if validation.Err != nil {
+			v.Error = validation.Err.Error()
+		}
+		report.Validations = append(report.Validations, v)
+	}
+	if result.Aborted != nil {
+		report.Aborted = result.Aborted.Error()
+	}
+	return report
+}
+
+// writeRunReport writes the report of a run to a file as JSON, as it is uploaded.
+func writeRunReport(path string, result RunResult) error {
+	data, err := json.MarshalIndent(newRunReport(result), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode run report")
+	}
+	return errors.Wrap(os.WriteFile(path, data, 0644), "failed to write run report")
+}
+
+// objectStore uploads objects to an S3 compatible bucket, signing requests with AWS signature
+// version 4, which GCS also accepts with HMAC keys.
+type objectStore struct {
+	endpoint  string
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+// newObjectStore returns a store for an s3:// or gs:// URL naming a bucket and a prefix within
+// it.  The endpoint overrides that of the scheme, for S3 compatible stores such as MinIO.
+func newObjectStore(rawURL string, endpoint string, region string) (*objectStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upload URL")
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("upload URL %s has no bucket", rawURL)
+	}
+
+	store := &objectStore{
+		region:    region,
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		accessKey: envOrDefault(uploadAccessKeyEnv, os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey: envOrDefault(uploadSecretKeyEnv, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{},
+	}
+	switch u.Scheme {
+	case "s3":
+		if store.region == "" {
+			store.region = envOrDefault("AWS_REGION", "us-east-1")
+		}
+		store.endpoint = "https://s3." + store.region + ".amazonaws.com"
+	case "gs":
+		if store.region == "" {
+			store.region = "auto"
+		}
+		store.endpoint = "https://storage.googleapis.com"
+	default:
+		return nil, fmt.Errorf("upload URL %s must be s3:// or gs://", rawURL)
+	}
+	if endpoint != "" {
+		store.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	if store.accessKey == "" || store.secretKey == "" {
+		return nil, fmt.Errorf("no upload credentials, set $%s and $%s", uploadAccessKeyEnv, uploadSecretKeyEnv)
+	}
+	return store, nil
+}
+
+// put uploads an object under the prefix of the store.
+func (s *objectStore) put(ctx context.Context, name string, body []byte, contentType string) error {
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+	path := "/" + awsEscape(s.bucket) + "/" + awsEscape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "failed to build upload of %s", name)
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, path, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload %s", name)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload of %s failed with status %d: %s", name, resp.StatusCode, message)
+	}
+	return nil
+}
+
+// sign adds an AWS signature version 4 to a request with the given escaped path and body.
+func (s *objectStore) sign(req *http.Request, path string, body []byte, now time.Time) {
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, path, "", canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// awsEscape escapes each segment of a path as AWS signatures expect, leaving only unreserved
+// characters and the slashes between segments.
+func awsEscape(path string) string {
+	var sb strings.Builder
+	for _, b := range []byte(path) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || strings.IndexByte("-_.~/", b) >= 0 {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// artifact is a file uploaded at the end of a run.
+type artifact struct {
+	name        string
+	body        []byte
+	contentType string
+}
+
+// uploadArtifacts uploads the report of the run, its redacted config and whichever of the log
+// file, operation trace and cluster stats it wrote, under the run ID, so that they outlive the
+// machine the run was on.  The log files rotated since the run began are uploaded along with the
+// current one.  Every artifact is attempted even if one fails.
+func uploadArtifacts(cfg Config, result RunResult, begun time.Time) error {
+	store, err := newObjectStore(cfg.UploadURL, cfg.UploadEndpoint, cfg.UploadRegion)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	report, err := json.MarshalIndent(newRunReport(result), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode run report")
+	}
+	config, err := yaml.Marshal(cfg.redacted())
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+	artifacts := []artifact{
+		{"report.json", report, "application/json"},
+		{"config.yaml", config, "application/yaml"},
+	}
+
+	// The log file is synced first so that it holds everything logged so far
+	zap.L().Sync()
+	type artifactFile struct {
+		path        string
+		contentType string
+	}
+	var files []artifactFile
+	var failed []string
+	if cfg.LogFile != "" {
+		rotated, err := rotatedSince(cfg.LogFile, begun)
+		if err != nil {
+			zap.L().Error("Failed to find rotated log files", zap.Error(err))
+			failed = append(failed, "rotated log files")
+		}
+		for _, path := range rotated {
+			files = append(files, artifactFile{path, "application/x-ndjson"})
+		}
+	}
+	files = append(files,
+		artifactFile{cfg.LogFile, "application/x-ndjson"},
+		artifactFile{cfg.RecordTrace, "application/x-ndjson"},
+		artifactFile{cfg.StatsFile, "text/csv"},
+	)
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		body, err := os.ReadFile(file.path)
+		if err != nil {
+			zap.L().Error("Failed to read artifact", zap.String("path", file.path), zap.Error(err))
+			failed = append(failed, file.path)
+			continue
+		}
+		artifacts = append(artifacts, artifact{filepath.Base(file.path), body, file.contentType})
+	}
+
+	for _, artifact := range artifacts {
+		err := store.put(ctx, cfg.RunId+"/"+artifact.name, artifact.body, artifact.contentType)
+		if err != nil {
+			zap.L().Error("Failed to upload artifact", zap.String("artifact", artifact.name), zap.Error(err))
+			failed = append(failed, artifact.name)
+			continue
+		}
+		zap.L().Info("Uploaded artifact", zap.String("artifact", artifact.name), zap.Int("bytes", len(artifact.body)))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to upload %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
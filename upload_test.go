@@ -0,0 +1,66 @@
+package spectroperf_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/couchbaselabs/spectroperf"
+)
+
+// TestUploadOnSetupFailure checks that a run that fails during setup, after the upload store was
+// checked, still uploads a report carrying the error.
+func TestUploadOnSetupFailure(t *testing.T) {
+	var mu sync.Mutex
+	uploaded := map[string][]byte{}
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		uploaded[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer store.Close()
+	t.Setenv("SPECTROPERF_UPLOAD_ACCESS_KEY", "access")
+	t.Setenv("SPECTROPERF_UPLOAD_SECRET_KEY", "secret")
+
+	cfg, err := spectroperf.ParseArgs([]string{
+		"--connstr", "couchbase://localhost",
+		"--cert", t.TempDir() + "/missing.crt",
+		"--run-id", "setup-failure",
+		"--upload-url", "s3://artifacts/runs",
+		"--upload-endpoint", store.URL,
+	})
+	if err != nil {
+		t.Fatalf("invalid arguments: %s", err)
+	}
+	_, runErr := spectroperf.Runner{Config: cfg}.Run(context.Background())
+	if runErr == nil {
+		t.Fatalf("run with a missing certificate did not fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	body, ok := uploaded["/artifacts/runs/setup-failure/report.json"]
+	if !ok {
+		t.Fatalf("no report was uploaded, got %d other artifacts", len(uploaded))
+	}
+	var report struct {
+		RunId   string `json:"runId"`
+		Aborted string `json:"aborted"`
+	}
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		t.Fatalf("invalid report: %s", err)
+	}
+	if report.RunId != "setup-failure" {
+		t.Errorf("report has run ID %q, not setup-failure", report.RunId)
+	}
+	if report.Aborted == "" || !strings.Contains(report.Aborted, runErr.Error()) {
+		t.Errorf("report was aborted with %q, not the error of the run %q", report.Aborted, runErr)
+	}
+}
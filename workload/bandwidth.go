@@ -0,0 +1,77 @@
+package workload
+
+import (
+	"sync"
+	"time"
+)
+
+// Bandwidth caps the bytes per second sent and received by the operations of all runners
+// together, so that clients on constrained networks, such as behind a mobile gateway, can be
+// simulated.  A cap of zero leaves that direction unlimited.
+var Bandwidth struct {
+	Egress  int64
+	Ingress int64
+}
+
+// bandwidthLimiters are the token buckets shared by all runners for each direction, created at
+// the start of the run.
+var bandwidthLimiters = map[string]*tokenBucket{}
+
+// startBandwidthLimits creates the token buckets for the bandwidth caps of the run.
+func startBandwidthLimits() {
+	bandwidthLimiters = map[string]*tokenBucket{}
+	if Bandwidth.Egress > 0 {
+		bandwidthLimiters[PayloadRequest] = newTokenBucket(Bandwidth.Egress)
+	}
+	if Bandwidth.Ingress > 0 {
+		bandwidthLimiters[PayloadResponse] = newTokenBucket(Bandwidth.Ingress)
+	}
+}
+
+// tokenBucket holds up to a second's worth of bytes, refilled at its rate.  Taking more bytes than
+// it holds leaves it in debt, which the taker waits out, so payloads larger than the bucket are
+// still sent at the rate of the cap.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	filled time.Time
+}
+
+func newTokenBucket(bytesPerSecond int64) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		filled: time.Now(),
+	}
+}
+
+// take removes size bytes from the bucket, returning how long to wait for them.
+func (b *tokenBucket) take(size int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.filled).Seconds()*b.rate)
+	b.filled = now
+	b.tokens -= float64(size)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttle holds back the operation being run until the bandwidth cap of the direction allows a
+// payload of size bytes, counting the time it waited.
+func (r Runctx) throttle(direction string, size int) {
+	limiter, ok := bandwidthLimiters[direction]
+	if !ok {
+		return
+	}
+	wait := limiter.take(size)
+	if wait <= 0 {
+		return
+	}
+	bandwidthThrottled.WithLabelValues(r.phase, r.target, direction).Add(wait.Seconds())
+	time.Sleep(wait)
+}
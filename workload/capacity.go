@@ -0,0 +1,171 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// capacityShortfall is the fraction of its target throughput a step of a capacity search must
+// reach to pass, as falling short means the users are waiting on the cluster.
+const capacityShortfall = 0.9
+
+// A CapacitySearch finds the highest throughput the cluster sustains within a p99 latency and
+// error rate.  It runs steps of a fixed duration, each a phase limited to a target throughput,
+// doubling the target from Start until a step exceeds a threshold, and then searching between the
+// highest target that passed and the lowest that failed, halving the gap each step.
+type CapacitySearch struct {
+	// Start is the target throughput of the first step in operations per second
+	Start float64
+	// StepDuration is how long each step runs for
+	StepDuration time.Duration
+	// MaxP99 is the highest p99 latency of the operations of a step that passes, or zero for no
+	// limit, and MaxErrorRate the highest fraction of them that may fail
+	MaxP99       time.Duration
+	MaxErrorRate float64
+	// Accuracy ends the search once the gap between the highest target that passed and the
+	// lowest that failed is within this fraction of the latter
+	Accuracy float64
+	// MaxSteps ends the search after this many steps, however wide the gap still is
+	MaxSteps int
+
+	mu    sync.Mutex
+	steps []CapacityStep
+}
+
+// A CapacityStep is the outcome of one step of a capacity search.
+type CapacityStep struct {
+	Phase string `json:"phase"`
+	// Target is the throughput the step was limited to, and Throughput what it reached, both in
+	// operations per second
+	Target     float64 `json:"target"`
+	Throughput float64 `json:"throughput"`
+	ErrorRate  float64 `json:"errorRate"`
+	// P99 is the 99th percentile duration of the operations in milliseconds
+	P99    float64 `json:"p99Ms"`
+	Passed bool    `json:"passed"`
+}
+
+// CapacityResult is the outcome of a capacity search.
+type CapacityResult struct {
+	Steps []CapacityStep `json:"steps"`
+	// MaxThroughput is the throughput reached by the step with the highest target that passed,
+	// the most the cluster sustained within the thresholds, or zero if no step passed
+	MaxThroughput float64 `json:"maxThroughput"`
+}
+
+// Validate checks that the search can run.
+func (s *CapacitySearch) Validate() error {
+	if s.Start <= 0 {
+		return fmt.Errorf("capacity search start %g must be positive", s.Start)
+	}
+	if s.StepDuration <= 0 {
+		return fmt.Errorf("capacity search step duration %s must be positive", s.StepDuration)
+	}
+	if s.MaxP99 < 0 {
+		return fmt.Errorf("capacity search max p99 %s must not be negative", s.MaxP99)
+	}
+	if s.MaxErrorRate < 0 || s.MaxErrorRate > 1 {
+		return fmt.Errorf("capacity search max error rate %g must be between 0 and 1", s.MaxErrorRate)
+	}
+	if s.Accuracy <= 0 || s.Accuracy >= 1 {
+		return fmt.Errorf("capacity search accuracy %g must be greater than 0 and less than 1", s.Accuracy)
+	}
+	if s.MaxSteps < 1 {
+		return fmt.Errorf("capacity search max steps %d must be at least 1", s.MaxSteps)
+	}
+	return nil
+}
+
+// Result returns the steps run so far, and the most throughput sustained in them.
+func (s *CapacitySearch) Result() CapacityResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := CapacityResult{Steps: append([]CapacityStep(nil), s.steps...)}
+	best := 0.0
+	for _, step := range s.steps {
+		if step.Passed && step.Target > best {
+			best = step.Target
+			result.MaxThroughput = step.Throughput
+		}
+	}
+	return result
+}
+
+// run runs the steps of the search, each a phase like template limited to the target throughput of
+// the step, until the gap is within the accuracy, the steps run out or the context is done.
+func (s *CapacitySearch) run(ctx context.Context, template Phase, runStep func(Phase)) {
+	target := s.Start
+	passed, failed := 0.0, 0.0
+	for i := 1; i <= s.MaxSteps && ctx.Err() == nil; i++ {
+		phase := template
+		phase.Name = fmt.Sprintf("capacity-%d", i)
+		phase.Duration = s.StepDuration
+		phase.Throughput = target
+
+		before, err := clientTotals()
+		if err != nil {
+			zap.L().Error("Failed to gather client metrics, stopping capacity search", zap.Error(err))
+			return
+		}
+		start := time.Now()
+		runStep(phase)
+		if ctx.Err() != nil {
+			return
+		}
+		after, err := clientTotals()
+		if err != nil {
+			zap.L().Error("Failed to gather client metrics, stopping capacity search", zap.Error(err))
+			return
+		}
+
+		step := s.measure(phase, time.Since(start), after.since(before))
+		s.mu.Lock()
+		s.steps = append(s.steps, step)
+		s.mu.Unlock()
+		zap.L().Info("Capacity search step",
+			zap.String("phase", step.Phase),
+			zap.Float64("targetOpsPerSecond", step.Target),
+			zap.Float64("opsPerSecond", step.Throughput),
+			zap.Float64("errorRate", step.ErrorRate),
+			zap.Float64("p99Ms", step.P99),
+			zap.Bool("passed", step.Passed),
+		)
+
+		if step.Passed {
+			passed = target
+		} else {
+			failed = target
+		}
+		// Step up until a target fails, then search between the highest that passed and it
+		if failed == 0 {
+			target *= 2
+			continue
+		}
+		if failed-passed <= s.Accuracy*failed {
+			return
+		}
+		target = (passed + failed) / 2
+	}
+}
+
+// measure returns the outcome of a step from the operations it ran.
+func (s *CapacitySearch) measure(phase Phase, elapsed time.Duration, diff operationTotals) CapacityStep {
+	step := CapacityStep{
+		Phase:      phase.Name,
+		Target:     phase.Throughput,
+		Throughput: float64(diff.attempts) / elapsed.Seconds(),
+	}
+	if diff.attempts == 0 {
+		return step
+	}
+	step.ErrorRate = float64(diff.failures) / float64(diff.attempts)
+	step.P99 = diff.p99()
+	step.Passed = step.ErrorRate <= s.MaxErrorRate &&
+		(s.MaxP99 == 0 || step.P99 <= float64(s.MaxP99.Microseconds())/1000) &&
+		step.Throughput >= capacityShortfall*step.Target
+	return step
+}
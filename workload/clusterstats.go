@@ -0,0 +1,180 @@
+package workload
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// A ClusterSample is the state of the cluster and of the client over one interval of the run,
+// so that server side behaviour can be lined up against the latency users saw.
+type ClusterSample struct {
+	Time time.Time `json:"time"`
+	// ServerOps is the operations per second on the bucket
+	ServerOps float64 `json:"serverOps"`
+	// CacheMissRatio is the percentage of reads that missed the cache
+	CacheMissRatio float64 `json:"cacheMissRatio"`
+	// DiskWriteQueue is the number of items waiting to be written to disk
+	DiskWriteQueue float64 `json:"diskWriteQueue"`
+	// CPU is the CPU utilization of the cluster as a percentage
+	CPU float64 `json:"cpu"`
+	// ClientOps is the operations per second of the workload
+	ClientOps float64 `json:"clientOps"`
+	// ClientFailures is the failed operations per second of the workload
+	ClientFailures float64 `json:"clientFailures"`
+	// ClientP99 is the 99th percentile duration of operations in milliseconds
+	ClientP99 float64 `json:"clientP99"`
+}
+
+// ClusterStats periodically samples the stats of the bucket under test from the management REST
+// API, along with the operations of the workload over the same interval.
+type ClusterStats struct {
+	statsURL string
+	username string
+	password string
+	interval time.Duration
+	client   *http.Client
+
+	mu      sync.Mutex
+	samples []ClusterSample
+	// every is how many intervals each sample covers, which doubles whenever the samples are
+	// thinned
+	every int
+}
+
+// maxClusterSamples bounds the samples kept over a long run.  Once reached, every other sample is
+// dropped and samples are taken half as often, so that they still cover the whole run evenly.
+const maxClusterSamples = 10000
+
+// NewClusterStats returns a sampler of the stats of a bucket, which authenticates as username
+// unless it is empty.
+func NewClusterStats(baseURL string, bucket string, username string, password string, tlsConfig *tls.Config, interval time.Duration) *ClusterStats {
+	return &ClusterStats{
+		statsURL: strings.TrimSuffix(baseURL, "/") + "/pools/default/buckets/" + url.PathEscape(bucket) + "/stats?zoom=minute",
+		username: username,
+		password: password,
+		interval: interval,
+		client:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: interval},
+		every:    1,
+	}
+}
+
+// Run samples the stats once every interval until the context is done.
+func (s *ClusterStats) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	last, err := clientTotals()
+	if err != nil {
+		zap.L().Warn("Failed to gather client metrics", zap.Error(err))
+	}
+	lastTime := time.Now()
+	for ticks := 1; ; ticks++ {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// The client stats of a sample cover every interval since the last one
+			if ticks%s.every != 0 {
+				continue
+			}
+
+			sample, err := s.serverSample(ctx)
+			if err != nil {
+				zap.L().Warn("Failed to sample cluster stats", zap.Error(err))
+			}
+			sample.Time = now
+
+			totals, err := clientTotals()
+			if err != nil {
+				zap.L().Warn("Failed to gather client metrics", zap.Error(err))
+			}
+			interval := totals.since(last)
+			seconds := now.Sub(lastTime).Seconds()
+			sample.ClientOps = float64(interval.attempts) / seconds
+			sample.ClientFailures = float64(interval.failures) / seconds
+			sample.ClientP99 = bucketQuantile(0.99, interval.bounds, interval.counts, interval.observations)
+			last, lastTime = totals, now
+
+			s.mu.Lock()
+			s.samples = append(s.samples, sample)
+			if len(s.samples) == maxClusterSamples {
+				s.thin()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// thin drops every other sample, keeping the latest, and halves how often samples are taken.
+func (s *ClusterStats) thin() {
+	kept := s.samples[:0]
+	for i := (len(s.samples) + 1) % 2; i < len(s.samples); i += 2 {
+		kept = append(kept, s.samples[i])
+	}
+	s.samples = kept
+	s.every *= 2
+}
+
+// Samples returns the samples taken so far.
+func (s *ClusterStats) Samples() []ClusterSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ClusterSample(nil), s.samples...)
+}
+
+// bucketStats is the part of the bucket stats response that is sampled.  Each stat is a series of
+// recent samples, of which the last is the latest.
+type bucketStats struct {
+	Op struct {
+		Samples map[string][]float64 `json:"samples"`
+	} `json:"op"`
+}
+
+func (s *ClusterStats) serverSample(ctx context.Context) (ClusterSample, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.statsURL, nil)
+	if err != nil {
+		return ClusterSample{}, errors.Wrap(err, "failed to build stats request")
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ClusterSample{}, errors.Wrap(err, "stats request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ClusterSample{}, fmt.Errorf("stats request returned unexpected status code %d", resp.StatusCode)
+	}
+
+	var stats bucketStats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return ClusterSample{}, errors.Wrap(err, "could not decode stats")
+	}
+
+	latest := func(name string) float64 {
+		series := stats.Op.Samples[name]
+		if len(series) == 0 {
+			return 0
+		}
+		return series[len(series)-1]
+	}
+	return ClusterSample{
+		ServerOps:      latest("ops"),
+		CacheMissRatio: latest("ep_cache_miss_rate"),
+		DiskWriteQueue: latest("disk_write_queue"),
+		CPU:            latest("cpu_utilization_rate"),
+	}, nil
+}
@@ -0,0 +1,176 @@
+package workload
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Eviction policies of Couchbase buckets, which are persisted, and of ephemeral buckets, which
+// are not.
+const (
+	evictionValueOnly = "valueOnly"
+	evictionFull      = "fullEviction"
+)
+
+// restartStartTimeout is how long a bucket may take to begin restarting after its eviction policy
+// changes, before it is taken to have restarted too quickly to be seen.
+const restartStartTimeout = 30 * time.Second
+
+// bucketAdmin changes the settings of a bucket through the management REST API.
+type bucketAdmin struct {
+	bucketURL string
+	username  string
+	password  string
+	client    *http.Client
+}
+
+// bucketInfo is the part of the bucket details response needed to restart it.
+type bucketInfo struct {
+	EvictionPolicy string `json:"evictionPolicy"`
+	Nodes          []struct {
+		Status string `json:"status"`
+	} `json:"nodes"`
+}
+
+// healthy returns whether the bucket is up on every node.
+func (b bucketInfo) healthy() bool {
+	for _, node := range b.Nodes {
+		if node.Status != "healthy" {
+			return false
+		}
+	}
+	return len(b.Nodes) > 0
+}
+
+// RestartBucket empties the cache of a Couchbase bucket, so that a run starts against a cold
+// cache, by restarting it, which the cluster does whenever the eviction policy of the bucket
+// changes.  The policy is changed and changed back, so the bucket keeps its settings, and each
+// restart is waited for until the bucket has warmed up on every node.  Ephemeral buckets are
+// refused, as restarting them would lose their data.  Changing the settings of a bucket needs the
+// bucket admin role, and authenticates as username unless it is empty.
+func RestartBucket(ctx context.Context, baseURL string, bucket string, username string, password string, tlsConfig *tls.Config) error {
+	admin := bucketAdmin{
+		bucketURL: strings.TrimSuffix(baseURL, "/") + "/pools/default/buckets/" + url.PathEscape(bucket),
+		username:  username,
+		password:  password,
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 30 * time.Second},
+	}
+
+	info, err := admin.info(ctx)
+	if err != nil {
+		return err
+	}
+	var other string
+	switch info.EvictionPolicy {
+	case evictionValueOnly:
+		other = evictionFull
+	case evictionFull:
+		other = evictionValueOnly
+	default:
+		return fmt.Errorf("bucket %s has eviction policy %s, so is ephemeral and would lose its data if restarted", bucket, info.EvictionPolicy)
+	}
+
+	for _, policy := range []string{other, info.EvictionPolicy} {
+		zap.L().Info("Restarting bucket", zap.String("bucket", bucket), zap.String("evictionPolicy", policy))
+		start := time.Now()
+		err = admin.setEvictionPolicy(ctx, policy)
+		if err != nil {
+			return err
+		}
+		err = admin.waitRestarted(ctx)
+		if err != nil {
+			return err
+		}
+		zap.L().Info("Bucket restarted", zap.String("bucket", bucket), zap.Duration("took", time.Since(start)))
+	}
+	return nil
+}
+
+func (a bucketAdmin) do(req *http.Request) (*http.Response, error) {
+	if a.username != "" {
+		req.SetBasicAuth(a.username, a.password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s returned unexpected status code %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (a bucketAdmin) info(ctx context.Context) (bucketInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.bucketURL, nil)
+	if err != nil {
+		return bucketInfo{}, errors.Wrap(err, "failed to build bucket request")
+	}
+	resp, err := a.do(req)
+	if err != nil {
+		return bucketInfo{}, errors.Wrap(err, "bucket request failed")
+	}
+	defer resp.Body.Close()
+
+	var info bucketInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return bucketInfo{}, errors.Wrap(err, "could not decode bucket details")
+	}
+	return info, nil
+}
+
+func (a bucketAdmin) setEvictionPolicy(ctx context.Context, policy string) error {
+	form := url.Values{"evictionPolicy": {policy}}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.bucketURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "failed to build bucket settings request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to change eviction policy to %s", policy)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// waitRestarted waits for the bucket to go down on some node, unless it restarts too quickly to
+// be seen, and then to come back up on every node.
+func (a bucketAdmin) waitRestarted(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	down := false
+	deadline := time.Now().Add(restartStartTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(context.Cause(ctx), "bucket did not restart")
+		case <-ticker.C:
+		}
+
+		info, err := a.info(ctx)
+		if err != nil {
+			// The management API can be briefly unavailable while the bucket restarts
+			zap.L().Debug("Failed to check bucket restart", zap.Error(err))
+			continue
+		}
+		healthy := info.healthy()
+		if !healthy {
+			down = true
+		}
+		if healthy && (down || time.Now().After(deadline)) {
+			return nil
+		}
+	}
+}
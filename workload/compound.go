@@ -0,0 +1,150 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A Compound is an operation made up of other operations of the workload run one after the other,
+// such as a login that fetches a profile and then finds related ones, to model the page-level
+// actions of users.  Each step is measured as an operation of its own, and the compound as a
+// whole measures the end to end latency of the action.
+type Compound struct {
+	Name  string
+	Steps []string
+}
+
+// ParseCompounds parses a comma separated list of compound operations, each a name and the
+// operations it runs joined by +, such as login=fetchProfile+findRelatedProfiles.
+func ParseCompounds(spec string) ([]Compound, error) {
+	var compounds []Compound
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, steps, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("compound operation %q must be a name and its operations, such as login=fetchProfile+findRelatedProfiles", part)
+		}
+		compound := Compound{Name: name}
+		for _, step := range strings.Split(steps, "+") {
+			step = strings.TrimSpace(step)
+			if step == "" {
+				return nil, fmt.Errorf("compound operation %s has an empty step", name)
+			}
+			compound.Steps = append(compound.Steps, step)
+		}
+		compounds = append(compounds, compound)
+	}
+	return compounds, nil
+}
+
+// compoundWorkload adds compound operations to a workload.
+type compoundWorkload struct {
+	Workload
+	compounds []Compound
+}
+
+// WithCompounds returns the workload with the compound operations added after its own, or the
+// workload itself if there are none.  The steps of a compound must be operations of the workload,
+// not other compounds.  Compounds are never chosen by the markov chain of the workload, so they
+// only run when the chain or operation weights of the run name them.
+func WithCompounds(w Workload, compounds []Compound) (Workload, error) {
+	if len(compounds) == 0 {
+		return w, nil
+	}
+	operations := w.Operations()
+	for i, compound := range compounds {
+		if slices.Contains(operations, compound.Name) {
+			return nil, fmt.Errorf("compound operation %s has the name of an operation of the workload", compound.Name)
+		}
+		if slices.ContainsFunc(compounds[:i], func(c Compound) bool { return c.Name == compound.Name }) {
+			return nil, fmt.Errorf("compound operation %s is given more than once", compound.Name)
+		}
+		for _, step := range compound.Steps {
+			if !slices.Contains(operations, step) {
+				return nil, fmt.Errorf("compound operation %s runs unknown operation %s", compound.Name, step)
+			}
+		}
+	}
+	return compoundWorkload{Workload: w, compounds: compounds}, nil
+}
+
+func (w compoundWorkload) Operations() []string {
+	operations := w.Workload.Operations()
+	for _, compound := range w.compounds {
+		operations = append(operations, compound.Name)
+	}
+	return operations
+}
+
+// Probabilities extends the markov chain of the workload with compounds that are never moved to.
+// After a compound, users carry on as they would after its last step.
+func (w compoundWorkload) Probabilities() [][]float64 {
+	operations := w.Workload.Operations()
+	var probabilities [][]float64
+	for _, row := range w.Workload.Probabilities() {
+		probabilities = append(probabilities, append(slices.Clone(row), make([]float64, len(w.compounds))...))
+	}
+	for _, compound := range w.compounds {
+		last := slices.Index(operations, compound.Steps[len(compound.Steps)-1])
+		probabilities = append(probabilities, slices.Clone(probabilities[last]))
+	}
+	return probabilities
+}
+
+func (w compoundWorkload) Describe() []OperationInfo {
+	infos := w.Workload.Describe()
+	for _, compound := range w.compounds {
+		info := OperationInfo{
+			Name:        compound.Name,
+			Description: "Compound operation running " + strings.Join(compound.Steps, ", then "),
+		}
+		for _, step := range compound.Steps {
+			i := slices.IndexFunc(infos, func(o OperationInfo) bool { return o.Name == step })
+			if i < 0 {
+				continue
+			}
+			for _, service := range infos[i].Services {
+				if !slices.Contains(info.Services, service) {
+					info.Services = append(info.Services, service)
+				}
+			}
+			info.Writes = info.Writes || infos[i].Writes
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (w compoundWorkload) Functions() map[string]func(ctx context.Context, rctx Runctx) error {
+	functions := w.Workload.Functions()
+	for _, compound := range w.compounds {
+		functions[compound.Name] = compound.run
+	}
+	return functions
+}
+
+// Unwrap returns the workload the compounds were added to, so its hooks are still called.
+func (w compoundWorkload) Unwrap() Workload {
+	return w.Workload
+}
+
+// run runs each step of the compound in turn as an operation of its own, stopping at the first to
+// fail.  Each step passes its result on to the next, and the last passes its result on to the
+// operation after the compound.
+func (c Compound) run(ctx context.Context, rctx Runctx) error {
+	for _, step := range c.Steps {
+		err := rctx.step(ctx, step)
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", step, err)
+		}
+	}
+	_, result := rctx.Previous()
+	rctx.SetResult(result)
+	return nil
+}
@@ -0,0 +1,264 @@
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A ChainResolver returns the markov chain over the operations of a workload for a markov chain,
+// given as rows or as a map of operations, or a set of operation weights, as in a config file.
+type ChainResolver func(operations []string, chain json.RawMessage, weights map[string]float64) ([][]float64, error)
+
+// ControlRequest changes the load of the phase being run.  Fields left out are not changed.
+type ControlRequest struct {
+	// Users is the number of regular users, split between the targets
+	Users *int `json:"users,omitempty"`
+	// Throughput is the target operations per second across all users, or zero for no target
+	Throughput *float64 `json:"throughput,omitempty"`
+	// MarkovChain and OperationWeights replace the markov chain of the phase, as in a config file
+	MarkovChain      json.RawMessage    `json:"markov-chain,omitempty"`
+	OperationWeights map[string]float64 `json:"operation-weights,omitempty"`
+	// Paused pauses or resumes every user of the run
+	Paused *bool `json:"paused,omitempty"`
+}
+
+// ControlState is the load of the phase being run.
+type ControlState struct {
+	Phase      string  `json:"phase"`
+	Users      int     `json:"users"`
+	Throughput float64 `json:"throughput"`
+	Paused     bool    `json:"paused"`
+}
+
+// phaseControl adjusts the load of a running phase.
+type phaseControl struct {
+	name    string
+	runs    []*phaseRun
+	start   time.Time
+	end     time.Time
+	newUser func(shared *phaseRun, id int, start time.Time, stopAfter time.Duration) *virtualUser
+	// add hands new users to the scheduler of the phase until it finishes
+	add      chan []*virtualUser
+	finished chan struct{}
+}
+
+// control is the control API of the run, which adjusts whichever phase is running.
+var control struct {
+	mu       sync.Mutex
+	resolver ChainResolver
+	phase    *phaseControl
+}
+
+// EnableControl serves the control API at /control on the metrics server, resolving the markov
+// chains it is given with resolver, or stops serving it when resolver is nil.
+func EnableControl(resolver ChainResolver) {
+	control.mu.Lock()
+	control.resolver = resolver
+	control.mu.Unlock()
+}
+
+// startControl makes a phase the one adjusted by the control API, until it finishes.
+func startControl(p *phaseControl) {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	control.phase = p
+}
+
+// finishControl stops adjusting a phase, once its scheduler is no longer taking new users.
+func finishControl(p *phaseControl) {
+	close(p.finished)
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	if control.phase == p {
+		control.phase = nil
+	}
+}
+
+func (p *phaseControl) state() ControlState {
+	state := ControlState{Phase: p.name, Paused: Paused()}
+	for _, run := range p.runs {
+		state.Users += run.users.count()
+		if limiter := run.limiter.Load(); limiter != nil {
+			state.Throughput += limiter.rate()
+		}
+	}
+	return state
+}
+
+// apply changes the load of the phase as the request asks, checking every change before making
+// any of them.
+func (p *phaseControl) apply(req ControlRequest, resolver ChainResolver) error {
+	if req.Users != nil && *req.Users < len(p.runs) {
+		return fmt.Errorf("users %d must be at least the number of targets, %d", *req.Users, len(p.runs))
+	}
+	if req.Users != nil && (Paused() || req.Paused != nil && *req.Paused) {
+		return fmt.Errorf("users cannot be changed while the run is paused")
+	}
+	if req.Throughput != nil && *req.Throughput < 0 {
+		return fmt.Errorf("throughput %g must not be negative", *req.Throughput)
+	}
+	chains := make([][][]float64, len(p.runs))
+	if req.MarkovChain != nil || req.OperationWeights != nil {
+		if resolver == nil {
+			return fmt.Errorf("the markov chain cannot be changed")
+		}
+		for i, run := range p.runs {
+			chain, err := resolver(run.operations, req.MarkovChain, req.OperationWeights)
+			if err != nil {
+				return err
+			}
+			chains[i] = chain
+		}
+	}
+
+	for i, run := range p.runs {
+		if chains[i] != nil {
+			run.probabilities.Store(&chains[i])
+		}
+		if req.Throughput != nil {
+			if *req.Throughput == 0 {
+				run.limiter.Store(nil)
+			} else if limiter := run.limiter.Load(); limiter != nil {
+				limiter.setRate(*req.Throughput / float64(len(p.runs)))
+			} else {
+				run.limiter.Store(newRateLimiter(*req.Throughput / float64(len(p.runs))))
+			}
+		}
+	}
+
+	if req.Users != nil {
+		var added []*virtualUser
+		now := time.Now()
+		// The phase ends later by the time the run has been paused for
+		end := p.end.Add(PausedSince(p.start))
+		for _, run := range p.runs {
+			for _, id := range run.users.resize(*req.Users / len(p.runs)) {
+				added = append(added, p.newUser(run, id, now, end.Sub(now)))
+			}
+		}
+		if len(added) > 0 {
+			select {
+			case p.add <- added:
+			case <-p.finished:
+				return fmt.Errorf("phase %s finished before users could be added", p.name)
+			}
+		}
+	}
+
+	if req.Paused != nil {
+		if *req.Paused {
+			PauseRun()
+		} else {
+			ResumeRun()
+		}
+	}
+	return nil
+}
+
+// serveControl reports the load of the phase being run, and changes it when posted a
+// ControlRequest.
+func serveControl(w http.ResponseWriter, r *http.Request) {
+	control.mu.Lock()
+	p := control.phase
+	resolver := control.resolver
+	control.mu.Unlock()
+	if resolver == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if p == nil {
+		http.Error(w, "no phase is running", http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req ControlRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid control request: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		err = p.apply(req, resolver)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		zap.L().Info("Adjusted load", zap.String("phase", p.name), zap.Any("request", req))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(p.state())
+	if err != nil {
+		zap.L().Warn("Failed to write control state", zap.Error(err))
+	}
+}
+
+// userLimit is the number of regular users of a target that run, which the control API may
+// change during the phase.
+type userLimit struct {
+	mu      sync.Mutex
+	limit   int
+	running map[int]bool
+	// next is the id given to the next user started, after every id the phase has given out, idle
+	// users included
+	next int
+}
+
+// newUserLimit returns the limit of a target starting with users regular users, numbered from 0,
+// whose users added later are numbered from next.
+func newUserLimit(users int, next int) *userLimit {
+	l := &userLimit{limit: users, running: map[int]bool{}, next: next}
+	for id := 0; id < users; id++ {
+		l.running[id] = true
+	}
+	return l
+}
+
+// keep returns whether a regular user should carry on running, forgetting it if not.
+func (l *userLimit) keep(id int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.running) <= l.limit {
+		return true
+	}
+	delete(l.running, id)
+	return false
+}
+
+// stopped forgets a regular user that reached the end of its run.
+func (l *userLimit) stopped(id int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.running, id)
+}
+
+func (l *userLimit) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return min(l.limit, len(l.running))
+}
+
+// resize changes the number of regular users, returning the ids of the users to start, which no
+// other user of the phase has had.  Users over the limit stop when their next operation is planned.
+func (l *userLimit) resize(users int) []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = users
+	var start []int
+	for len(l.running) < users {
+		l.running[l.next] = true
+		start = append(start, l.next)
+		l.next++
+	}
+	return start
+}
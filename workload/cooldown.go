@@ -0,0 +1,115 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// probeWindow is the number of recent probes whose median latency is compared to the baseline
+	probeWindow = 5
+	// recoveryTolerance is how far above the baseline the median probe latency may be and still
+	// count as having returned to the baseline
+	recoveryTolerance = 1.2
+)
+
+// A Probe measures the latency of single gets of loaded documents, outside of the workload, to
+// compare the responsiveness of the cluster before and after a run.
+type Probe struct {
+	coll     *gocb.Collection
+	numItems int
+	interval time.Duration
+	r        *rand.Rand
+}
+
+func NewProbe(coll *gocb.Collection, numItems int, interval time.Duration) *Probe {
+	return &Probe{
+		coll:     coll,
+		numItems: numItems,
+		interval: interval,
+		r:        rand.New(rand.NewSource(int64(RandSeed))),
+	}
+}
+
+func (p *Probe) sample(ctx context.Context) (time.Duration, error) {
+	key := NamespacedKey(fmt.Sprintf("u%d", p.r.Intn(p.numItems)))
+
+	start := time.Now()
+	_, err := p.coll.Get(key, &gocb.GetOptions{Context: ctx})
+	if err != nil {
+		return 0, errors.Wrap(err, "probe get failed")
+	}
+	return time.Since(start), nil
+}
+
+// Baseline returns the median latency of count probes, taken one interval apart.
+func (p *Probe) Baseline(ctx context.Context, count int) (time.Duration, error) {
+	var latencies []time.Duration
+	for i := 0; i < count; i++ {
+		latency, err := p.sample(ctx)
+		if err != nil {
+			return 0, err
+		}
+		latencies = append(latencies, latency)
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(p.interval):
+		}
+	}
+
+	return median(latencies), nil
+}
+
+// CoolDown keeps probing for up to period after the load has stopped, returning how long it took
+// for the median latency of the most recent probes to return to within the tolerance of the
+// baseline.  It returns false if the latency did not recover within the period.
+func (p *Probe) CoolDown(ctx context.Context, baseline time.Duration, period time.Duration) (time.Duration, bool) {
+	start := time.Now()
+	deadline := time.After(period)
+	threshold := time.Duration(float64(baseline) * recoveryTolerance)
+
+	var window []time.Duration
+	for {
+		latency, err := p.sample(ctx)
+		if err != nil {
+			// A failed probe is as slow as it gets, so restart the window
+			zap.L().Debug("Cool-down probe failed", zap.Error(err))
+			window = window[:0]
+		} else {
+			window = append(window, latency)
+			if len(window) > probeWindow {
+				window = window[1:]
+			}
+		}
+
+		if len(window) == probeWindow && median(window) <= threshold {
+			return time.Since(start), true
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Since(start), false
+		case <-deadline:
+			return time.Since(start), false
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+func median(latencies []time.Duration) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
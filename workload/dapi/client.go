@@ -0,0 +1,302 @@
+// Package dapi is a client for the Couchbase Data API, shared by the workloads that use it.  Every
+// request authenticates as the identity of the runner if it has one, is retried while throttled,
+// and records its responses and connections in the metrics of the run.
+package dapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxRetries is how many times a request is retried while it is throttled
+	maxRetries = 3
+	// retryBackoff is the wait before the first retry of a throttled request without a
+	// Retry-After header, doubling for each retry after
+	retryBackoff = 100 * time.Millisecond
+
+	// LookupInPath and MutateInPath are appended to the URL of a document for sub-document
+	// requests
+	LookupInPath = "/lookupin"
+	MutateInPath = "/mutatein"
+)
+
+// ErrCasMismatch is returned by a conditional write to a document that changed since its ETag.
+var ErrCasMismatch = errors.New("document changed since it was read")
+
+// StatusError is an unexpected response status, classified so that throttling, server and client
+// errors can be told apart in the logs.
+type StatusError struct {
+	Status int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status code %d", strings.ReplaceAll(workload.StatusClass(e.Status), "_", " "), e.Status)
+}
+
+// A Client makes requests to the Data API for the documents of one collection.
+type Client struct {
+	connstr    string
+	bucket     string
+	scope      string
+	collection string
+	username   string
+	password   string
+	http       *http.Client
+}
+
+// NewClient returns a client for the collection, which authenticates as username unless it is
+// empty, such as when a client certificate in the TLS config authenticates instead.
+func NewClient(connstr string, bucket string, scope string, collection string, username string, password string, tlsConfig *tls.Config, transport Transport) *Client {
+	return &Client{
+		connstr:    connstr,
+		bucket:     bucket,
+		scope:      scope,
+		collection: collection,
+		username:   username,
+		password:   password,
+		http:       transport.client(tlsConfig),
+	}
+}
+
+// DocumentURL returns the URL of a document in the collection.
+func (c *Client) DocumentURL(id string) string {
+	return fmt.Sprintf("%s/v1/buckets/%s/scopes/%s/collections/%s/documents/%s", c.connstr, c.bucket, c.scope, c.collection, id)
+}
+
+// Do sends a request with the credentials of the runner, returning the response whatever its
+// status.  Throttled requests are retried after waiting as long as the Data API asks.
+func (c *Client) Do(rctx workload.Runctx, req *http.Request) (*http.Response, error) {
+	if identity, ok := rctx.Identity(); ok {
+		req.SetBasicAuth(identity.Username, identity.Password)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	req = rctx.TraceHTTP(req)
+	for attempt := 0; ; attempt++ {
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute %s request: %s", req.Method, err.Error())
+		}
+		rctx.ObserveHTTPResponse(req.Method, resp.StatusCode)
+		if resp.StatusCode != http.StatusTooManyRequests || attempt == maxRetries {
+			return resp, nil
+		}
+
+		// Throttled, so back off for as long as the Data API asks before trying again
+		wait := retryAfter(resp.Header.Get("Retry-After"), attempt)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind %s request body: %s", req.Method, err.Error())
+			}
+		}
+		rctx.ObserveHTTPRetry(req.Method)
+	}
+}
+
+// retryAfter returns how long to wait before retrying a throttled request, from a Retry-After
+// header given in seconds or as a date, or an exponential backoff if there is none.
+func retryAfter(header string, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return retryBackoff << attempt
+}
+
+// send makes a request with an optional JSON body, returning the response if its status is one
+// of those expected, which defaults to 200.  The caller must close the body of the response.
+func (c *Client) send(ctx context.Context, rctx workload.Runctx, method string, url string, body interface{}, header http.Header, expected ...int) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not marshal request body")
+		}
+		reader = bytes.NewReader(jsonBytes)
+		rctx.ObservePayload(workload.PayloadRequest, len(jsonBytes))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to build %s request", method)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.Do(rctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(expected) == 0 {
+		expected = []int{http.StatusOK}
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			resp.Body = rctx.PayloadBody(resp.Body)
+			return resp, nil
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, StatusError{Status: resp.StatusCode}
+}
+
+// decode reads a JSON response body into value, or discards it if value is nil.
+func decode(resp *http.Response, value interface{}) error {
+	defer resp.Body.Close()
+	if value == nil {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return errors.Wrap(err, "could not read response body")
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "could not read response body")
+	}
+	err = json.Unmarshal(bodyBytes, value)
+	if err != nil {
+		return errors.Wrapf(err, "could not unmarshal response body - %s", string(bodyBytes))
+	}
+	return nil
+}
+
+// GetDocument reads a document into value, returning its ETag, which identifies its CAS.
+func (c *Client) GetDocument(ctx context.Context, rctx workload.Runctx, id string, value interface{}) (string, error) {
+	resp, err := c.send(ctx, rctx, "GET", c.DocumentURL(id), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	etag := resp.Header.Get("ETag")
+	return etag, decode(resp, value)
+}
+
+// WriteOptions are the optional conditions and expiry of a document write.
+type WriteOptions struct {
+	// IfMatch only writes the document if it still has this ETag
+	IfMatch string
+	// Expiry is when the document expires, or zero for never
+	Expiry time.Time
+}
+
+// UpsertDocument writes a document, returning its new ETag.  A write conditional on an ETag the
+// document no longer has fails with ErrCasMismatch.
+func (c *Client) UpsertDocument(ctx context.Context, rctx workload.Runctx, id string, value interface{}, opts *WriteOptions) (string, error) {
+	header := http.Header{}
+	if opts != nil && opts.IfMatch != "" {
+		header.Set("If-Match", opts.IfMatch)
+	}
+	if opts != nil && !opts.Expiry.IsZero() {
+		header.Set("Expires", opts.Expiry.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := c.send(ctx, rctx, "PUT", c.DocumentURL(id), value, header, http.StatusOK, http.StatusCreated)
+	var statusErr StatusError
+	if errors.As(err, &statusErr) && statusErr.Status == http.StatusPreconditionFailed {
+		return "", ErrCasMismatch
+	}
+	if err != nil {
+		return "", err
+	}
+	etag := resp.Header.Get("ETag")
+	return etag, decode(resp, nil)
+}
+
+// Load writes a document loaded by the setup, so that the Data API can load documents where the
+// ports of the SDK cannot be reached.  Documents that are not JSON cannot be written.
+func (c *Client) Load(ctx context.Context, rctx workload.Runctx, doc workload.DocType) error {
+	if _, ok := doc.Data.([]byte); ok {
+		return fmt.Errorf("binary document %s cannot be loaded through the Data API", doc.Name)
+	}
+	_, err := c.UpsertDocument(ctx, rctx, doc.Name, doc.Data, nil)
+	return err
+}
+
+// DeleteDocument removes a document.
+func (c *Client) DeleteDocument(ctx context.Context, rctx workload.Runctx, id string) error {
+	resp, err := c.send(ctx, rctx, "DELETE", c.DocumentURL(id), nil, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// SubdocOp is a single operation of a sub-document request.
+type SubdocOp struct {
+	Operation string      `json:"operation"`
+	Path      string      `json:"path"`
+	Value     interface{} `json:"value,omitempty"`
+}
+
+// LookupIn reads paths of a document, decoding the response into result if it is not nil.
+func (c *Client) LookupIn(ctx context.Context, rctx workload.Runctx, id string, ops []SubdocOp, result interface{}) error {
+	resp, err := c.send(ctx, rctx, "POST", c.DocumentURL(id)+LookupInPath, ops, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, result)
+}
+
+// MutateIn changes paths of a document.
+func (c *Client) MutateIn(ctx context.Context, rctx workload.Runctx, id string, ops []SubdocOp) error {
+	resp, err := c.send(ctx, rctx, "POST", c.DocumentURL(id)+MutateInPath, ops, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// Query runs a statement through the query service proxy of the Data API, with the named
+// parameters and the query settings of the run, decoding the response into result.
+func (c *Client) Query(ctx context.Context, rctx workload.Runctx, statement string, params map[string]interface{}, result interface{}) error {
+	payload := workload.Queries.RESTParams()
+	payload["statement"] = statement
+	for name, value := range params {
+		payload["$"+name] = value
+	}
+
+	resp, err := c.send(ctx, rctx, "POST", fmt.Sprintf("%s/_p/query/query/service", c.connstr), payload, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, result)
+}
+
+// Search runs a search request against an index in the scope of the client, through the search
+// service proxy of the Data API, decoding the response into result.
+func (c *Client) Search(ctx context.Context, rctx workload.Runctx, index string, request interface{}, result interface{}) error {
+	url := fmt.Sprintf("%s/_p/fts/api/bucket/%s/scope/%s/index/%s/query", c.connstr, c.bucket, c.scope, index)
+	resp, err := c.send(ctx, rctx, "POST", url, request, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, result)
+}
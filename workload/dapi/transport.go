@@ -0,0 +1,58 @@
+package dapi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Transport tunes the connections the Data API workloads make, to study the effect of
+// connection pooling on latency.
+type Transport struct {
+	// MaxConnsPerHost caps the connections to the Data API, or zero for no limit
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost is how many idle connections are kept for reuse
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept, or zero for no limit
+	IdleConnTimeout time.Duration
+	// HTTPVersion is 1.1, or 2 to negotiate HTTP/2 where the Data API supports it
+	HTTPVersion string
+	// TLSSessionCache is how many TLS sessions are cached for resumption, or zero for none
+	TLSSessionCache int
+}
+
+// DefaultTransport allows up to 500 connections over HTTP/1.1, keeping Go's default of 2 of
+// them idle.
+var DefaultTransport = Transport{
+	MaxConnsPerHost:     500,
+	MaxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost,
+	HTTPVersion:         "1.1",
+}
+
+// Validate checks the transport settings.
+func (t Transport) Validate() error {
+	if t.HTTPVersion != "1.1" && t.HTTPVersion != "2" {
+		return fmt.Errorf("unknown HTTP version %s, expected 1.1 or 2", t.HTTPVersion)
+	}
+	if t.MaxConnsPerHost < 0 || t.MaxIdleConnsPerHost < 0 || t.TLSSessionCache < 0 {
+		return fmt.Errorf("connection and session limits must not be negative")
+	}
+	return nil
+}
+
+func (t Transport) client(tlsConfig *tls.Config) *http.Client {
+	if tlsConfig != nil && t.TLSSessionCache > 0 {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(t.TLSSessionCache)
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		MaxConnsPerHost:     t.MaxConnsPerHost,
+		MaxIdleConnsPerHost: t.MaxIdleConnsPerHost,
+		IdleConnTimeout:     t.IdleConnTimeout,
+		ForceAttemptHTTP2:   t.HTTPVersion == "2",
+	}
+	return &http.Client{Transport: tr}
+}
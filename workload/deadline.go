@@ -0,0 +1,36 @@
+package workload
+
+import (
+	"context"
+	"time"
+)
+
+// Deadlines bound how long each operation may run before its context is cancelled, so that an
+// operation that hangs fails rather than stalling its user for the rest of the run.
+type Deadlines struct {
+	// Default is the deadline of every operation, or zero for none
+	Default time.Duration
+	// Operations overrides Default for particular operations
+	Operations map[string]time.Duration
+}
+
+// OperationDeadlines are the deadlines of the operations of the run.
+var OperationDeadlines Deadlines
+
+// For returns the deadline of an operation, or zero if it has none.
+func (d Deadlines) For(operation string) time.Duration {
+	if deadline, ok := d.Operations[operation]; ok {
+		return deadline
+	}
+	return d.Default
+}
+
+// withDeadline returns the context an operation runs with, which is cancelled once the operation
+// has run for longer than its deadline.
+func (d Deadlines) withDeadline(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	deadline := d.For(operation)
+	if deadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, deadline)
+}
@@ -0,0 +1,157 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errorBuckets is how many buckets the sliding window of an error budget is divided into.
+const errorBuckets = 10
+
+// An ErrorBudget is how many operations may fail before the run is aborted, so that a broken
+// configuration fails fast rather than running to the end with every operation failing.
+type ErrorBudget struct {
+	// MaxErrorRate is the fraction of the operations in the window that may fail, or zero for no
+	// budget.
+	MaxErrorRate float64
+	// Operations overrides MaxErrorRate for particular operations.
+	Operations map[string]float64
+	// Window is how far back failures are counted.
+	Window time.Duration
+	// MinOperations is how many times an operation must be attempted within the window before its
+	// error rate is checked, so that a handful of early failures do not abort the run.
+	MinOperations int
+	// DisableOperation stops running an operation that exceeds its budget instead of aborting the
+	// run, which is then only aborted if every operation is stopped.
+	DisableOperation bool
+}
+
+// ErrorBudgetExceeded is the reason a run was aborted.
+type ErrorBudgetExceeded struct {
+	Phase     string
+	Operation string
+	Rate      float64
+	Window    time.Duration
+}
+
+func (e ErrorBudgetExceeded) Error() string {
+	return fmt.Sprintf("%.1f%% of %s operations failed in the last %s of phase %s, exceeding the error budget", e.Rate*100, e.Operation, e.Window, e.Phase)
+}
+
+func (b ErrorBudget) rateFor(operation string) float64 {
+	if rate, ok := b.Operations[operation]; ok {
+		return rate
+	}
+	return b.MaxErrorRate
+}
+
+// enabled returns whether any operation has a budget.
+func (b ErrorBudget) enabled() bool {
+	if b.MaxErrorRate > 0 {
+		return true
+	}
+	for _, rate := range b.Operations {
+		if rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// errorBucket counts the operations attempted and failed during one slice of the window.
+type errorBucket struct {
+	slice    int64
+	attempts int
+	failures int
+}
+
+// circuitBreaker tracks the error rate of each operation of a phase over a sliding window,
+// stopping operations or aborting the run once they exceed the error budget.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	budget   ErrorBudget
+	phase    string
+	width    time.Duration
+	buckets  map[string]*[errorBuckets]errorBucket
+	disabled map[string]bool
+	run      map[string]bool
+	abort    context.CancelCauseFunc
+}
+
+func newCircuitBreaker(budget ErrorBudget, phase string, operations []string, abort context.CancelCauseFunc) *circuitBreaker {
+	cb := &circuitBreaker{
+		budget:   budget,
+		phase:    phase,
+		width:    max(budget.Window/errorBuckets, time.Millisecond),
+		buckets:  map[string]*[errorBuckets]errorBucket{},
+		disabled: map[string]bool{},
+		run:      map[string]bool{},
+		abort:    abort,
+	}
+	for _, operation := range operations {
+		cb.buckets[operation] = &[errorBuckets]errorBucket{}
+	}
+	return cb
+}
+
+// allowed returns whether an operation may still be run.
+func (cb *circuitBreaker) allowed(operation string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return !cb.disabled[operation]
+}
+
+// record counts the outcome of an operation, and trips the breaker if the operation has now
+// exceeded its budget.
+func (cb *circuitBreaker) record(operation string, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.run[operation] = true
+	limit := cb.budget.rateFor(operation)
+	if limit <= 0 || cb.disabled[operation] {
+		return
+	}
+
+	slice := time.Now().UnixNano() / int64(cb.width)
+	buckets := cb.buckets[operation]
+	bucket := &buckets[slice%errorBuckets]
+	if bucket.slice != slice {
+		*bucket = errorBucket{slice: slice}
+	}
+	bucket.attempts++
+	if err == nil {
+		return
+	}
+	bucket.failures++
+
+	var attempts, failures int
+	for _, b := range buckets {
+		if slice-b.slice < errorBuckets {
+			attempts += b.attempts
+			failures += b.failures
+		}
+	}
+	rate := float64(failures) / float64(attempts)
+	if attempts < cb.budget.MinOperations || rate <= limit {
+		return
+	}
+
+	exceeded := ErrorBudgetExceeded{Phase: cb.phase, Operation: operation, Rate: rate, Window: cb.budget.Window}
+	if !cb.budget.DisableOperation {
+		zap.L().Error("Error budget exceeded, aborting run", zap.Error(exceeded))
+		cb.abort(exceeded)
+		return
+	}
+
+	cb.disabled[operation] = true
+	zap.L().Error("Error budget exceeded, no longer running operation", zap.String("operation", operation), zap.Error(exceeded))
+	// Operations the markov chain never chooses are not waited on
+	if len(cb.disabled) == len(cb.run) {
+		zap.L().Error("Every operation has exceeded its error budget, aborting run", zap.String("phase", cb.phase))
+		cb.abort(exceeded)
+	}
+}
@@ -0,0 +1,109 @@
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrorLogInterval is how often failed operations are summarised in the log, with a line for each
+// distinct error and how many times it occurred.  With an interval of zero, every failure is
+// logged as it happens.  Every failure is always logged at debug level.
+var ErrorLogInterval = 10 * time.Second
+
+// maxDistinctErrors limits how many distinct errors are counted in each interval, as errors that
+// include a document key can each be distinct.  Errors beyond the limit are counted together.
+const maxDistinctErrors = 100
+
+// errorKey identifies the failures of an operation with the same error.
+type errorKey struct {
+	operation string
+	message   string
+}
+
+// errorLog counts failed operations between summaries.
+type errorLog struct {
+	mu     sync.Mutex
+	counts map[errorKey]int
+	other  map[string]int
+	since  time.Time
+}
+
+var operationErrors = &errorLog{counts: map[errorKey]int{}, other: map[string]int{}, since: time.Now()}
+
+// record logs or counts a failed operation.
+func (l *errorLog) record(operation string, err error) {
+	zap.L().Debug("operation failed", zap.String("operation", operation), zap.Error(err))
+	if ErrorLogInterval <= 0 {
+		zap.L().Error("operation failed", zap.String("operation", operation), zap.Error(err))
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := errorKey{operation: operation, message: err.Error()}
+	if _, ok := l.counts[key]; ok || len(l.counts) < maxDistinctErrors {
+		l.counts[key]++
+	} else {
+		l.other[operation]++
+	}
+}
+
+// flush logs a line for each distinct error since the last flush, most frequent first.
+func (l *errorLog) flush() {
+	l.mu.Lock()
+	counts, other, since := l.counts, l.other, l.since
+	l.counts, l.other, l.since = map[errorKey]int{}, map[string]int{}, time.Now()
+	l.mu.Unlock()
+
+	keys := make([]errorKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return counts[keys[i]] > counts[keys[j]]
+	})
+
+	period := time.Since(since).Round(time.Second)
+	for _, key := range keys {
+		zap.L().Error(fmt.Sprintf("%s: %s ×%d in last %s", key.operation, key.message, counts[key], period),
+			zap.String("operation", key.operation), zap.Int("count", counts[key]))
+	}
+	for operation, count := range other {
+		zap.L().Error(fmt.Sprintf("%s: other errors ×%d in last %s", operation, count, period),
+			zap.String("operation", operation), zap.Int("count", count))
+	}
+}
+
+// summariseErrors periodically logs a summary of failed operations, returning a function that
+// stops and logs the failures since the last summary.
+func summariseErrors() func() {
+	if ErrorLogInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ErrorLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				operationErrors.flush()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		operationErrors.flush()
+	}
+}
@@ -0,0 +1,293 @@
+package workload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Generator produces the documents a workload loads, in place of the random documents the
+// workload generates itself.
+type Generator interface {
+	// Generate returns the document to store under the key id
+	Generate(id string) DocType
+}
+
+// ParseGenerator parses a generator given as one of:
+//
+//	fake
+//	json-dir:<directory of JSON files>
+//	csv:<CSV file>
+//	sample:<cbexport of a sample dataset, such as travel-sample>
+//	binary:<size in bytes>[:<compressibility>]
+//
+// fake is the workload's own generator, for which nil is returned.  fields maps the fields of the
+// documents generated from a CSV file to the columns they are taken from, and every column is used
+// under its own name if it is empty.
+func ParseGenerator(spec string, fields map[string]string) (Generator, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	if kind != "fake" && kind != "" && path == "" {
+		return nil, fmt.Errorf("%s generator is missing its parameters, given after %s:", kind, kind)
+	}
+
+	var g Generator
+	var err error
+	switch kind {
+	case "", "fake":
+		return nil, nil
+	case "json-dir":
+		g, err = LoadJSONDir(path)
+	case "csv":
+		g, err = LoadCSV(path, fields)
+	case "sample":
+		g, err = LoadSampleDataset(path)
+	case "binary":
+		g, err = parseBinaryGenerator(path)
+	default:
+		return nil, fmt.Errorf("unknown generator %s, expected fake, json-dir, csv, sample or binary", kind)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid generator %s", spec)
+	}
+	return g, nil
+}
+
+// datasetGenerator generates documents from a fixed dataset.  Each key always gets the same
+// document, so runs with the same keys load the same data.
+type datasetGenerator struct {
+	docs []json.RawMessage
+}
+
+func newDatasetGenerator(docs []json.RawMessage) (datasetGenerator, error) {
+	if len(docs) == 0 {
+		return datasetGenerator{}, fmt.Errorf("dataset has no documents")
+	}
+	return datasetGenerator{docs: docs}, nil
+}
+
+func (g datasetGenerator) Generate(id string) DocType {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return DocType{Name: id, Data: g.docs[h.Sum64()%uint64(len(g.docs))]}
+}
+
+// LoadJSONDir returns a generator of the documents in the .json files of a directory.  A file
+// holds either a single document, or an array of documents.
+func LoadJSONDir(dir string) (Generator, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list documents")
+	}
+	sort.Strings(paths)
+
+	var docs []json.RawMessage
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read document")
+		}
+		fileDocs, err := decodeDocuments(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid document %s", path)
+		}
+		docs = append(docs, fileDocs...)
+	}
+	return newDatasetGenerator(docs)
+}
+
+// LoadCSV returns a generator of a document for each row of a CSV file, whose first row names the
+// columns.  fields maps the fields of each document to the columns they are taken from, and every
+// column is used under its own name if it is empty.  Values are stored as strings.
+func LoadCSV(path string, fields map[string]string) (Generator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open CSV file")
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CSV header")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	if len(fields) == 0 {
+		fields = map[string]string{}
+		for _, name := range header {
+			fields[name] = name
+		}
+	}
+	for field, column := range fields {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("field %s is mapped to unknown column %s", field, column)
+		}
+	}
+
+	var docs []json.RawMessage
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CSV row")
+		}
+
+		doc := map[string]string{}
+		for field, column := range fields {
+			doc[field] = row[columns[column]]
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal CSV row")
+		}
+		docs = append(docs, data)
+	}
+	return newDatasetGenerator(docs)
+}
+
+// LoadSampleDataset returns a generator of the documents of a sample dataset, such as
+// travel-sample, exported with cbexport in either the lines or list format.  The keys of the
+// dataset are not kept, documents are stored under the keys of the workload.
+func LoadSampleDataset(path string) (Generator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read sample dataset")
+	}
+
+	var docs []json.RawMessage
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		docs, err = decodeDocuments(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid sample dataset")
+		}
+		return newDatasetGenerator(docs)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		doc := bytes.TrimSpace(scanner.Bytes())
+		if len(doc) == 0 {
+			continue
+		}
+		if !json.Valid(doc) {
+			return nil, fmt.Errorf("invalid document on line %d of sample dataset", line)
+		}
+		docs = append(docs, json.RawMessage(bytes.Clone(doc)))
+	}
+	return newDatasetGenerator(docs)
+}
+
+// binaryPattern is the repetitive content of binary documents.
+var binaryPattern = []byte("spectroperf ")
+
+// binaryGenerator generates non-JSON documents of a fixed size, of which the compressible fraction
+// repeats a short pattern and the rest is random, so how well they compress can be controlled.
+type binaryGenerator struct {
+	size            int
+	compressibility float64
+}
+
+func parseBinaryGenerator(params string) (Generator, error) {
+	sizeParam, compressibilityParam, hasCompressibility := strings.Cut(params, ":")
+	size, err := strconv.Atoi(sizeParam)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("binary document size %s must be a positive number of bytes", sizeParam)
+	}
+
+	g := binaryGenerator{size: size}
+	if hasCompressibility {
+		g.compressibility, err = strconv.ParseFloat(compressibilityParam, 64)
+		if err != nil || g.compressibility < 0 || g.compressibility > 1 {
+			return nil, fmt.Errorf("binary document compressibility %s must be between 0 and 1", compressibilityParam)
+		}
+	}
+	return g, nil
+}
+
+func (g binaryGenerator) Generate(id string) DocType {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	r := rand.New(rand.NewSource(int64(h.Sum64()) + int64(RandSeed)))
+
+	data := make([]byte, g.size)
+	repetitive := int(float64(g.size) * g.compressibility)
+	for i := 0; i < repetitive; i += len(binaryPattern) {
+		copy(data[i:repetitive], binaryPattern)
+	}
+	r.Read(data[repetitive:])
+	return DocType{Name: id, Data: data}
+}
+
+// decodeDocuments decodes a single document, or an array of documents.
+func decodeDocuments(data []byte) ([]json.RawMessage, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var docs []json.RawMessage
+		err := json.Unmarshal(data, &docs)
+		return docs, err
+	}
+
+	var doc json.RawMessage
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{doc}, nil
+}
+
+// generatedWorkload is a workload whose documents come from a generator.
+type generatedWorkload struct {
+	Workload
+	generator Generator
+}
+
+// WithGenerator returns the workload with the documents it loads produced by the generator, or the
+// workload itself if the generator is nil.  Operations that create documents themselves still
+// generate their own.
+func WithGenerator(w Workload, generator Generator) Workload {
+	if generator == nil {
+		return w
+	}
+	return generatedWorkload{Workload: w, generator: generator}
+}
+
+func (w generatedWorkload) GenerateDocument(id string) DocType {
+	return w.generator.Generate(id)
+}
+
+// GenerateDocuments generates each document in turn, rather than with the wrapped workload.
+func (w generatedWorkload) GenerateDocuments(ids []string) []DocType {
+	docs := make([]DocType, len(ids))
+	for i, id := range ids {
+		docs[i] = w.generator.Generate(id)
+	}
+	return docs
+}
+
+// Preloaded returns false, as the documents come from the generator and are always loaded, even
+// if the wrapped workload does not generate any of its own.
+func (w generatedWorkload) Preloaded() bool {
+	return false
+}
+
+// Unwrap returns the workload whose documents are generated, so its hooks are still called.
+func (w generatedWorkload) Unwrap() Workload {
+	return w.Workload
+}
@@ -0,0 +1,86 @@
+package workload
+
+import (
+	"context"
+	"io"
+)
+
+// A Cleaner is a workload that removes what it created beyond the documents and indexes of the
+// setup, such as documents its operations inserted, when the run is torn down.
+type Cleaner interface {
+	Cleanup(ctx context.Context) error
+}
+
+// A Validator is a workload that checks its data is consistent with the operations it ran, such
+// as that every profile it locked is still disabled, when the run ends.
+type Validator interface {
+	// Validate returns an error describing the first inconsistency found
+	Validate(ctx context.Context) error
+}
+
+// A Preloader is a workload whose documents may already be in the collection, such as one running
+// over existing data, for which the setup loads no documents when Preloaded returns true.
+type Preloader interface {
+	Preloaded() bool
+}
+
+// A BatchGenerator is a workload that generates many documents at once more cheaply than one at a
+// time, such as an external workload that is asked for them in one write, which the setup uses to
+// generate the documents it loads.
+type BatchGenerator interface {
+	GenerateDocuments(ids []string) []DocType
+}
+
+// unwrapper is a workload wrapping another, such as one whose documents come from a generator.
+type unwrapper interface {
+	Unwrap() Workload
+}
+
+// hook returns the workload, or the workload it wraps, as a T if it implements it.
+func hook[T any](w Workload) (T, bool) {
+	for {
+		if h, ok := w.(T); ok {
+			return h, true
+		}
+		u, ok := w.(unwrapper)
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// Cleanup calls the Cleanup method of the workload if it has one.
+func Cleanup(ctx context.Context, w Workload) error {
+	if c, ok := hook[Cleaner](w); ok {
+		return c.Cleanup(ctx)
+	}
+	return nil
+}
+
+// Validate calls the Validate method of the workload if it has one, returning false if it does
+// not.
+func Validate(ctx context.Context, w Workload) (bool, error) {
+	if v, ok := hook[Validator](w); ok {
+		return true, v.Validate(ctx)
+	}
+	return false, nil
+}
+
+// Preloaded returns whether the documents of the workload are already in the collection.
+func Preloaded(w Workload) bool {
+	if p, ok := hook[Preloader](w); ok {
+		return p.Preloaded()
+	}
+	return false
+}
+
+// Close calls the Close method of the workload if it has one, such as to stop the process running
+// an external workload.
+func Close(w Workload) error {
+	if c, ok := hook[io.Closer](w); ok {
+		return c.Close()
+	}
+	return nil
+}
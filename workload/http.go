@@ -0,0 +1,114 @@
+package workload
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatusClass classifies an HTTP status code as success, throttled, client_error or server_error.
+func StatusClass(code int) string {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return "throttled"
+	case code >= 500:
+		return "server_error"
+	case code >= 400:
+		return "client_error"
+	default:
+		return "success"
+	}
+}
+
+// ObserveHTTPResponse records the status of a response to a request made by an operation.
+func (r Runctx) ObserveHTTPResponse(method string, code int) {
+	httpResponses.WithLabelValues(r.phase, r.target, method, strconv.Itoa(code), StatusClass(code)).Inc()
+}
+
+// ObserveHTTPRetry records that a throttled request was retried.
+func (r Runctx) ObserveHTTPRetry(method string) {
+	httpRetries.WithLabelValues(r.phase, r.target, method).Inc()
+}
+
+// Steps of an HTTP request timed by TraceHTTP.
+const (
+	httpStepDNS     = "dns"
+	httpStepConnect = "connect"
+	httpStepTLS     = "tls"
+	httpStepTTFB    = "ttfb"
+)
+
+// observeHTTPStep records how long a step of a request took since it started.
+func (r Runctx) observeHTTPStep(step string, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	httpStepDuration.WithLabelValues(r.operation, r.phase, r.target, step).Observe(float64(time.Since(start).Microseconds()) / 1000)
+}
+
+// TraceHTTP returns the request with a trace attached that records, for each connection the
+// request uses, whether it was reused from the pool, and how long each step of the request took:
+// resolving the host, connecting, the TLS handshake and waiting for the first byte of the
+// response after the request was written.  Steps a reused connection skips are not recorded.
+func (r Runctx) TraceHTTP(req *http.Request) *http.Request {
+	// The dialer may resolve and connect from other goroutines, racing connections to several
+	// addresses, so the start of each step is guarded and only the first connection is timed.
+	var mu sync.Mutex
+	var dnsStart, connectStart, tlsStart, wrote time.Time
+	started := func(at *time.Time) {
+		mu.Lock()
+		if at.IsZero() {
+			*at = time.Now()
+		}
+		mu.Unlock()
+	}
+	done := func(step string, at *time.Time) {
+		mu.Lock()
+		start := *at
+		*at = time.Time{}
+		mu.Unlock()
+		r.observeHTTPStep(step, start)
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			httpConnections.WithLabelValues(r.phase, r.target, strconv.FormatBool(info.Reused), strconv.FormatBool(info.WasIdle)).Inc()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			started(&dnsStart)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err == nil {
+				done(httpStepDNS, &dnsStart)
+			}
+		},
+		ConnectStart: func(string, string) {
+			started(&connectStart)
+		},
+		ConnectDone: func(_ string, _ string, err error) {
+			if err == nil {
+				done(httpStepConnect, &connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			started(&tlsStart)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				done(httpStepTLS, &tlsStart)
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				started(&wrote)
+			}
+		},
+		GotFirstResponseByte: func() {
+			done(httpStepTTFB, &wrote)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
@@ -0,0 +1,124 @@
+package workload
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// An Identity is a cluster user that runners authenticate as, so that the cost of authenticating
+// and auditing many distinct users is part of the benchmark.  Runners are given identities in
+// turn, so with fewer identities than runners several runners share each one.
+type Identity struct {
+	Username string
+	Password string
+}
+
+// identityRoles are the roles granted on the bucket to the identities created for a run, enough
+// for the operations of the workloads but not for setting them up.
+var identityRoles = []string{"data_reader", "data_writer", "query_select"}
+
+// CreateIdentities creates count users with access to the bucket, named after the key namespace
+// so that concurrent runs do not share users.
+func CreateIdentities(cluster *gocb.Cluster, bucket string, count int) ([]Identity, error) {
+	prefix := "spectroperf"
+	if KeyNamespace != "" {
+		prefix += "-" + KeyNamespace
+	}
+
+	var roles []gocb.Role
+	for _, role := range identityRoles {
+		roles = append(roles, gocb.Role{Name: role, Bucket: bucket})
+	}
+
+	identities := make([]Identity, count)
+	for i := range identities {
+		password, err := randomPassword()
+		if err != nil {
+			return nil, err
+		}
+		identities[i] = Identity{
+			Username: fmt.Sprintf("%s-%d", prefix, i),
+			Password: password,
+		}
+
+		err = cluster.Users().UpsertUser(gocb.User{
+			Username: identities[i].Username,
+			Password: identities[i].Password,
+			Roles:    roles,
+		}, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create user %s", identities[i].Username)
+		}
+	}
+
+	zap.L().Info("Created users for runners", zap.Int("users", count))
+	return identities, nil
+}
+
+// DropIdentities removes the users created by CreateIdentities.
+func DropIdentities(cluster *gocb.Cluster, identities []Identity) error {
+	for _, identity := range identities {
+		err := cluster.Users().DropUser(identity.Username, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to drop user %s", identity.Username)
+		}
+	}
+	return nil
+}
+
+// ReadIdentities reads existing users from a file with a username:password pair on each line.
+// Blank lines and lines starting with # are ignored.
+func ReadIdentities(path string) ([]Identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open users file")
+	}
+	defer file.Close()
+
+	var identities []Identity
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, password, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("users file %s line %d is not username:password", path, line)
+		}
+		identities = append(identities, Identity{Username: username, Password: password})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read users file")
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("users file %s has no users", path)
+	}
+
+	return identities, nil
+}
+
+// identityFor returns the identity of a runner, or nil if there are none.
+func identityFor(identities []Identity, runnerId int) *Identity {
+	if len(identities) == 0 {
+		return nil
+	}
+	return &identities[runnerId%len(identities)]
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate password")
+	}
+	return hex.EncodeToString(b), nil
+}
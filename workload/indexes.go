@@ -0,0 +1,314 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ReuseIndexes skips creating the indexes of workloads, which must already exist, such as from an
+// earlier run against the same cluster, to save the time spent checking for them.
+var ReuseIndexes bool
+
+// IndexSettings control how the indexes of every workload are created, so that query operations
+// run against indexes configured as they would be in production.
+type IndexSettings struct {
+	// Replicas is the number of replicas of each index
+	Replicas int
+	// Deferred creates each index without building it, and builds every index of a collection
+	// together once the workload is set up
+	Deferred bool
+	// PartitionBy is the expressions each index is hash partitioned by, or empty to not partition
+	PartitionBy string
+	// BuildTimeout is how long to wait for indexes to build, or zero to wait until the setup
+	// deadline
+	BuildTimeout time.Duration
+	// PollInterval is how long to wait before first checking whether indexes have built
+	PollInterval time.Duration
+	// PollBackoff is linear to check at multiples of the poll interval, or exponential to double
+	// the wait after each check, in either case waiting no longer than maxIndexPollInterval
+	PollBackoff string
+}
+
+const (
+	IndexPollLinear      = "linear"
+	IndexPollExponential = "exponential"
+)
+
+// maxIndexPollInterval is the longest wait between checks of whether indexes have built.
+const maxIndexPollInterval = 30 * time.Second
+
+// Indexes are the settings used to create every index.
+var Indexes = IndexSettings{PollInterval: time.Second, PollBackoff: IndexPollExponential}
+
+// IndexProgress reads the build progress of indexes while waiting for them, or is nil to not
+// report it.
+var IndexProgress *IndexStatus
+
+// Validate checks that the settings are ones the index service understands.
+func (s IndexSettings) Validate() error {
+	if s.Replicas < 0 {
+		return fmt.Errorf("index replicas %d must not be negative", s.Replicas)
+	}
+	if s.BuildTimeout < 0 {
+		return fmt.Errorf("index build timeout %s must not be negative", s.BuildTimeout)
+	}
+	if s.PollInterval <= 0 {
+		return fmt.Errorf("index poll interval %s must be positive", s.PollInterval)
+	}
+	switch s.PollBackoff {
+	case IndexPollLinear, IndexPollExponential:
+	default:
+		return fmt.Errorf("unknown index poll backoff %s, expected %s or %s", s.PollBackoff, IndexPollLinear, IndexPollExponential)
+	}
+	return nil
+}
+
+// buildContext returns a context that is done once indexes have taken too long to build.
+func (s IndexSettings) buildContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.BuildTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.BuildTimeout)
+}
+
+// nextPoll returns how long to wait before the next check of whether indexes have built, given
+// the wait before the last.
+func (s IndexSettings) nextPoll(last time.Duration) time.Duration {
+	next := last + s.PollInterval
+	if s.PollBackoff == IndexPollExponential {
+		next = 2 * last
+	}
+	return min(next, maxIndexPollInterval)
+}
+
+// statement returns the statement creating the named index on fields of the collection.
+func (s IndexSettings) statement(collection *gocb.Collection, name string, fields []string) (string, error) {
+	statement := fmt.Sprintf("CREATE INDEX `%s` ON `%s`(%s)", name, collection.Name(), strings.Join(fields, ","))
+	if s.PartitionBy != "" {
+		statement += fmt.Sprintf(" PARTITION BY HASH(%s)", s.PartitionBy)
+	}
+
+	with := map[string]interface{}{}
+	if s.Replicas > 0 {
+		with["num_replica"] = s.Replicas
+	}
+	if s.Deferred {
+		with["defer_build"] = true
+	}
+	if len(with) > 0 {
+		opts, err := json.Marshal(with)
+		if err != nil {
+			return "", err
+		}
+		statement += " WITH " + string(opts)
+	}
+	return statement, nil
+}
+
+// createdIndex is an index created by the run, which teardown drops.
+type createdIndex struct {
+	collection *gocb.Collection
+	name       string
+}
+
+var (
+	createdMu      sync.Mutex
+	createdIndexes []createdIndex
+	// deferredIndexes are the indexes created by the run that have not been built yet
+	deferredIndexes []createdIndex
+)
+
+// CreateQueryIndex creates a secondary index on fields of the collection with the index settings,
+// named with the index prefix of the run, unless indexes are reused.  An index that already exists
+// is left as it is, and only indexes created by the run are dropped by DropIndexes.  Deferred
+// indexes are left to BuildDeferredIndexes, and the build time of the others is recorded.
+func CreateQueryIndex(ctx context.Context, collection *gocb.Collection, name string, fields []string) error {
+	name = IndexName(name)
+	if ReuseIndexes {
+		zap.L().Info("Reusing index", zap.String("index", name))
+		return nil
+	}
+
+	statement, err := Indexes.statement(collection, name, fields)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", name)
+	}
+
+	buildCtx, cancel := Indexes.buildContext(ctx)
+	defer cancel()
+	start := time.Now()
+	scope := collection.Bucket().Scope(collection.ScopeName())
+	rows, err := scope.Query(statement, &gocb.QueryOptions{Context: buildCtx})
+	if err == nil {
+		err = rows.Close()
+	}
+	if errors.Is(err, gocb.ErrIndexExists) {
+		return nil
+	}
+	if errors.Is(buildCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("index %s did not build within %s", name, Indexes.BuildTimeout)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", name)
+	}
+
+	createdMu.Lock()
+	defer createdMu.Unlock()
+	index := createdIndex{collection: collection, name: name}
+	createdIndexes = append(createdIndexes, index)
+	if Indexes.Deferred {
+		deferredIndexes = append(deferredIndexes, index)
+		return nil
+	}
+	// Without defer_build, creating the index waits for it to be built
+	recordIndexBuild(name, time.Since(start))
+	return nil
+}
+
+// BuildDeferredIndexes builds the deferred indexes created by the run, and waits for them to come
+// online, recording how long each collection took to build.
+func BuildDeferredIndexes(ctx context.Context) error {
+	createdMu.Lock()
+	defer createdMu.Unlock()
+
+	byCollection := map[*gocb.Collection][]string{}
+	for _, index := range deferredIndexes {
+		byCollection[index.collection] = append(byCollection[index.collection], index.name)
+	}
+	for collection, names := range byCollection {
+		start := time.Now()
+		_, err := collection.QueryIndexes().BuildDeferredIndexes(&gocb.BuildDeferredQueryIndexOptions{Context: ctx})
+		if err != nil {
+			return errors.Wrapf(err, "failed to build indexes on %s", collection.Name())
+		}
+		err = waitForIndexes(ctx, collection, names)
+		if err != nil {
+			return errors.Wrapf(err, "failed waiting for indexes on %s to build", collection.Name())
+		}
+		for _, name := range names {
+			recordIndexBuild(name, time.Since(start))
+		}
+	}
+	deferredIndexes = nil
+	return nil
+}
+
+// waitForIndexes waits until the named indexes of the collection are online, checking with the
+// backoff of the index settings and logging their progress, until the build timeout passes or
+// the context is done.
+func waitForIndexes(ctx context.Context, collection *gocb.Collection, names []string) error {
+	ctx, cancel := Indexes.buildContext(ctx)
+	defer cancel()
+
+	wait := Indexes.PollInterval
+	for {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("indexes %s did not build in time", strings.Join(names, ", "))
+			}
+			return ctx.Err()
+		}
+
+		indexes, err := collection.QueryIndexes().GetAllIndexes(&gocb.GetAllQueryIndexesOptions{Context: ctx})
+		if err != nil {
+			return errors.Wrap(err, "failed to get index states")
+		}
+		online := 0
+		for _, index := range indexes {
+			if slices.Contains(names, index.Name) && index.State == "online" {
+				online++
+			}
+		}
+		if online == len(names) {
+			return nil
+		}
+
+		logIndexProgress(ctx, collection, names)
+		wait = Indexes.nextPoll(wait)
+	}
+}
+
+// logIndexProgress logs how far each of the named indexes has been built, if progress is read.
+func logIndexProgress(ctx context.Context, collection *gocb.Collection, names []string) {
+	if IndexProgress == nil {
+		return
+	}
+	progress, err := IndexProgress.Progress(ctx, collection.Bucket().Name(), collection.ScopeName(), collection.Name(), names)
+	if err != nil {
+		zap.L().Warn("Failed to read index build progress", zap.Error(err))
+		return
+	}
+	for _, name := range names {
+		if percent, ok := progress[name]; ok {
+			zap.L().Info("Building index", zap.String("index", name), zap.Float64("progress", percent))
+		}
+	}
+}
+
+// recordIndexBuild logs and exports how long an index took to build.
+func recordIndexBuild(name string, duration time.Duration) {
+	zap.L().Info("Built index", zap.String("index", name), zap.Duration("duration", duration))
+	indexBuildDuration.WithLabelValues(name).Set(duration.Seconds())
+}
+
+// DropIndexes drops the indexes created by the run.
+func DropIndexes(ctx context.Context) error {
+	createdMu.Lock()
+	defer createdMu.Unlock()
+
+	for _, index := range createdIndexes {
+		err := index.collection.QueryIndexes().DropIndex(index.name, &gocb.DropQueryIndexOptions{Context: ctx, IgnoreIfNotExists: true})
+		if err != nil {
+			return errors.Wrapf(err, "failed to drop index %s", index.name)
+		}
+		zap.L().Info("Dropped index", zap.String("index", index.name))
+	}
+	createdIndexes = nil
+	return nil
+}
+
+// RemoveData removes the numItems documents loaded by Setup, stopping at the first failure.
+// Documents that were already removed are skipped.
+func RemoveData(ctx context.Context, numItems int, coll *gocb.Collection) error {
+	numConc := 2000
+	workChan := make(chan string, numConc)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var wg sync.WaitGroup
+
+	wg.Add(numConc)
+	for i := 0; i < numConc; i++ {
+		go func() {
+			defer wg.Done()
+			for key := range workChan {
+				_, err := coll.Remove(key, &gocb.RemoveOptions{Context: ctx})
+				if err != nil && !errors.Is(err, gocb.ErrDocumentNotFound) {
+					cancel(errors.Wrap(err, "Data removal failed."))
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < numItems && ctx.Err() == nil; i++ {
+		select {
+		case workChan <- NamespacedKey(fmt.Sprintf("u%d", i)):
+		case <-ctx.Done():
+		}
+	}
+	close(workChan)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
@@ -0,0 +1,93 @@
+package workload
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IndexStatus reads how far the indexes of the cluster have been built from the management REST
+// API, to report progress while waiting for them.
+type IndexStatus struct {
+	statusURL string
+	username  string
+	password  string
+	client    *http.Client
+}
+
+// NewIndexStatus returns a reader of the status of indexes, which authenticates as username
+// unless it is empty.
+func NewIndexStatus(baseURL string, username string, password string, tlsConfig *tls.Config) *IndexStatus {
+	return &IndexStatus{
+		statusURL: strings.TrimSuffix(baseURL, "/") + "/indexStatus",
+		username:  username,
+		password:  password,
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 10 * time.Second},
+	}
+}
+
+// indexStatusResponse is the part of the index status response that is read.
+type indexStatusResponse struct {
+	Indexes []struct {
+		Index      string  `json:"index"`
+		Bucket     string  `json:"bucket"`
+		Scope      string  `json:"scope"`
+		Collection string  `json:"collection"`
+		Status     string  `json:"status"`
+		Progress   float64 `json:"progress"`
+	} `json:"indexes"`
+}
+
+// Progress returns the percentage of each of the named indexes of a collection that has been
+// built.  Indexes the cluster does not report are left out.
+func (s *IndexStatus) Progress(ctx context.Context, bucket string, scope string, collection string, names []string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.statusURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build index status request")
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "index status request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("index status request returned unexpected status code %d", resp.StatusCode)
+	}
+
+	var status indexStatusResponse
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode index status")
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	progress := map[string]float64{}
+	for _, index := range status.Indexes {
+		if index.Bucket != bucket || index.Scope != scope || index.Collection != collection {
+			continue
+		}
+		// Replicas are reported as "name (replica n)", and the index is as far along as its
+		// slowest replica
+		name, _, _ := strings.Cut(index.Index, " (replica")
+		if !wanted[name] {
+			continue
+		}
+		if done, ok := progress[name]; !ok || index.Progress < done {
+			progress[name] = index.Progress
+		}
+	}
+	return progress, nil
+}
@@ -1,34 +1,378 @@
 package workload
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 var (
+	// durationBuckets are the buckets of every histogram of durations in milliseconds, from 150µs
+	// to 2.5s, each half as wide again as the one below it
+	durationBuckets = []float64{0.150, 0.225, 0.338, 0.506, 0.759, 1.139, 1.709, 2.563, 3.844, 5.767, 8.650, 12.975, 19.462, 29.193, 43.789, 65.684, 98.526, 147.789, 221.684, 332.526, 498.789, 748.183, 1122.274, 1683.411, 2525.117}
+
 	// Prometheus metrics for attempted and failed operations
 	opsAttempted = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "operations_total",
-			Help: "How many user operations are attempted, partitioned by operation.",
+			Help: "How many user operations are attempted, partitioned by operation, phase and target.",
 		},
-		[]string{"operation"},
+		[]string{"operation", "phase", "target"},
 	)
 	opsFailed = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "operations_failed_total",
-			Help: "How many user operations failed, partitioned by operation.",
+			Help: "How many user operations failed, partitioned by operation, phase and target.",
+		},
+		[]string{"operation", "phase", "target"},
+	)
+	opsTimedOut = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "operations_timed_out_total",
+			Help: "How many user operations failed by running past their deadline, which are also counted as failed, partitioned by operation, phase and target.",
+		},
+		[]string{"operation", "phase", "target"},
+	)
+	opTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "operation_transitions_total",
+			Help: "How many times users moved from one operation of the markov chain to the next, partitioned by the operation moved from and to, phase and target.",
+		},
+		[]string{"from", "to", "phase", "target"},
+	)
+	opDurationOpts = prometheus.HistogramOpts{
+		Name:    "operation_duration_milliseconds",
+		Help:    "Duration of user operations in milliseconds, partitioned by operation, phase and target.",
+		Buckets: durationBuckets,
+	}
+	opDuration = prometheus.NewHistogramVec(opDurationOpts, []string{"operation", "phase", "target"})
+
+	opCorrectedDurationOpts = prometheus.HistogramOpts{
+		Name:    "operation_corrected_duration_milliseconds",
+		Help:    "Duration of user operations in milliseconds from when they were scheduled to start, correcting for coordinated omission, partitioned by operation, phase and target.",
+		Buckets: durationBuckets,
+	}
+	opCorrectedDuration = prometheus.NewHistogramVec(opCorrectedDurationOpts, []string{"operation", "phase", "target"})
+
+	schedulerLag = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "scheduler_lag_milliseconds",
+			Help:    "Time from when user operations were scheduled to start to when they started in milliseconds, partitioned by phase and target.",
+			Buckets: durationBuckets,
+		},
+		[]string{"phase", "target"},
+	)
+	scanItems = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "scan_items",
+			Help:    "Number of documents read by each KV range scan, partitioned by operation, phase and target.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+		[]string{"operation", "phase", "target"},
+	)
+	scanFirstItem = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "scan_first_item_milliseconds",
+			Help:    "Time from starting a KV range scan to receiving its first document in milliseconds, partitioned by operation, phase and target.",
+			Buckets: durationBuckets,
+		},
+		[]string{"operation", "phase", "target"},
+	)
+	payloadBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payload_bytes",
+			Help:    "Size of the documents, query results and HTTP bodies sent and received by operations in bytes, partitioned by operation, phase, target and direction.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		},
+		[]string{"operation", "phase", "target", "direction"},
+	)
+	bandwidthThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bandwidth_throttled_seconds_total",
+			Help: "Time operations were held back by the bandwidth cap in seconds, partitioned by phase, target and direction.",
+		},
+		[]string{"phase", "target", "direction"},
+	)
+	lockContention = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lock_contention_total",
+			Help: "How many attempts to lock a document found it already locked, partitioned by operation, phase and target.",
+		},
+		[]string{"operation", "phase", "target"},
+	)
+	replicaReads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "replica_read_responses_total",
+			Help: "How many documents read from any replica came from the active copy or a replica, partitioned by operation, phase, target and copy.",
+		},
+		[]string{"operation", "phase", "target", "copy"},
+	)
+	batchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "bulk_batch_duration_milliseconds",
+			Help:    "Duration of batches of bulk KV operations in milliseconds, partitioned by operation, phase, target and batch size.",
+			Buckets: durationBuckets,
+		},
+		[]string{"operation", "phase", "target", "batch_size"},
+	)
+	batchItemsFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bulk_items_failed_total",
+			Help: "How many items of batches of bulk KV operations failed, partitioned by operation, phase, target and batch size.",
+		},
+		[]string{"operation", "phase", "target", "batch_size"},
+	)
+	mutationVisible = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mutation_visible_milliseconds",
+			Help:    "Time from starting a write to a request_plus query returning it in milliseconds, partitioned by operation, phase and target.",
+			Buckets: durationBuckets,
+		},
+		[]string{"operation", "phase", "target"},
+	)
+	mutations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mutations_total",
+			Help: "How many whole documents operations wrote back, partitioned by operation, phase, target, semantics (upsert, replace or insert) and outcome.",
+		},
+		[]string{"operation", "phase", "target", "semantics", "outcome"},
+	)
+	mutationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mutation_duration_milliseconds",
+			Help:    "Duration of writes of whole documents by operations in milliseconds, partitioned by operation, phase, target and semantics.",
+			Buckets: durationBuckets,
 		},
-		[]string{"operation"},
+		[]string{"operation", "phase", "target", "semantics"},
 	)
-	opDuration = prometheus.NewHistogramVec(
+	queryRows = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    "operation_duration_milliseconds",
-			Help:    "Duration of user operations in milliseconds, partitioned by operation.",
-			Buckets: []float64{0.150, 0.225, 0.338, 0.506, 0.759, 1.139, 1.709, 2.563, 3.844, 5.767, 8.650, 12.975, 19.462, 29.193, 43.789, 65.684, 98.526, 147.789, 221.684, 332.526, 498.789, 748.183, 1122.274, 1683.411, 2525.117},
+			Name:    "query_rows",
+			Help:    "Number of rows returned by each query of an operation, partitioned by operation, phase and target.",
+			Buckets: append([]float64{0}, prometheus.ExponentialBuckets(1, 2, 16)...),
 		},
-		[]string{"operation"},
+		[]string{"operation", "phase", "target"},
 	)
+	queryUnexpected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "query_results_unexpected_total",
+			Help: "How many queries returned a number of rows outside that expected of their operation, partitioned by phase, target and operation.",
+		},
+		[]string{"phase", "target", "operation"},
+	)
+	httpResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_responses_total",
+			Help: "How many HTTP responses operations received, partitioned by phase, target, method, status code and class of status.",
+		},
+		[]string{"phase", "target", "method", "code", "class"},
+	)
+	httpRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_retries_total",
+			Help: "How many throttled HTTP requests were retried, partitioned by phase, target and method.",
+		},
+		[]string{"phase", "target", "method"},
+	)
+	httpConnections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_connections_total",
+			Help: "How many connections HTTP requests used, partitioned by phase, target, whether the connection was reused and whether it was idle.",
+		},
+		[]string{"phase", "target", "reused", "was_idle"},
+	)
+	httpStepDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_step_duration_milliseconds",
+			Help:    "Time taken by each step of HTTP requests in milliseconds, partitioned by operation, phase, target and step: dns, connect, tls or ttfb.",
+			Buckets: durationBuckets,
+		},
+		[]string{"operation", "phase", "target", "step"},
+	)
+	sdkOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sdk_operation_duration_milliseconds",
+			Help:    "Duration of the operations of the SDK in milliseconds, partitioned by service and SDK operation.",
+			Buckets: durationBuckets,
+		},
+		[]string{"service", "sdk_operation"},
+	)
+	sdkDispatchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sdk_dispatch_duration_milliseconds",
+			Help:    "Time from the SDK dispatching a request to the cluster to receiving its response in milliseconds, partitioned by service.",
+			Buckets: durationBuckets,
+		},
+		[]string{"service"},
+	)
+	sdkServerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "sdk_server_duration_milliseconds",
+			Help:    "Time the cluster reported spending on requests dispatched by the SDK in milliseconds, partitioned by service.",
+			Buckets: durationBuckets,
+		},
+		[]string{"service"},
+	)
+	activeUsers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "active_users",
+			Help: "How many simulated users are currently running operations.",
+		},
+	)
+	idleUsers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "idle_users",
+			Help: "How many mostly idle simulated users are currently connected.",
+		},
+	)
+	runPaused = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "run_paused",
+			Help: "Whether the run is paused, during which no operations are started and the phases do not advance.",
+		},
+	)
+	indexBuildDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "index_build_seconds",
+			Help: "How long each index created during setup took to build in seconds, partitioned by index.",
+		},
+		[]string{"index"},
+	)
+	setupStageDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "setup_stage_seconds",
+			Help: "How long each stage of the setup took in seconds, partitioned by stage.",
+		},
+		[]string{"stage"},
+	)
+)
+
+// NativeHistograms adds native buckets to the histograms of operation durations, alongside their
+// classic buckets, which Prometheus scrapes as a native histogram when it supports them.  Native
+// buckets are spaced by a fixed factor over whatever range the durations fall in, so percentiles
+// keep their resolution however much faster or slower the cluster is than the classic buckets
+// allow for.
+var NativeHistograms bool
 
-	// Maps from the operation to an attempted/failed metric labelled with the operation
-	attemptMetrics  = map[string]prometheus.Counter{}
-	failedMetrics   = map[string]prometheus.Counter{}
-	durationMetrics = map[string]prometheus.Observer{}
+const (
+	// nativeBucketFactor is the most each native bucket may be wider than the one below it
+	nativeBucketFactor = 1.1
+	// nativeMaxBuckets is the most native buckets a histogram keeps before widening them
+	nativeMaxBuckets = 160
 )
+
+// useDurationHistograms recreates the histograms of operation durations, with native buckets or
+// with only their classic ones, before they are registered.
+func useDurationHistograms(native bool) {
+	durationOpts, correctedOpts := opDurationOpts, opCorrectedDurationOpts
+	if native {
+		durationOpts, correctedOpts = withNativeBuckets(durationOpts), withNativeBuckets(correctedOpts)
+	}
+	opDuration = prometheus.NewHistogramVec(durationOpts, []string{"operation", "phase", "target"})
+	opCorrectedDuration = prometheus.NewHistogramVec(correctedOpts, []string{"operation", "phase", "target"})
+}
+
+// resetMetrics clears the metrics recorded by an earlier run in the process, along with the chains
+// its transitions are compared with.  It is called before the run starts any users, management
+// users included, so only the chains of this run are kept.
+func resetMetrics() {
+	vecs := []interface{ Reset() }{
+		opsAttempted, opsFailed, opsTimedOut, opDuration, opCorrectedDuration, schedulerLag,
+		opTransitions, indexBuildDuration, setupStageDuration, scanItems, scanFirstItem,
+		payloadBytes, bandwidthThrottled, lockContention, replicaReads, batchDuration,
+		batchItemsFailed, mutationVisible, mutations, mutationDuration, queryRows, queryUnexpected,
+		httpResponses, httpRetries, httpConnections, httpStepDuration, sdkOperationDuration,
+		sdkDispatchDuration, sdkServerDuration, clientSaturated,
+	}
+	for _, vec := range vecs {
+		vec.Reset()
+	}
+	activeUsers.Set(0)
+	idleUsers.Set(0)
+	runPaused.Set(0)
+	resetChains()
+}
+
+func withNativeBuckets(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	opts.NativeHistogramBucketFactor = nativeBucketFactor
+	opts.NativeHistogramMaxBucketNumber = nativeMaxBuckets
+	opts.NativeHistogramMinResetDuration = time.Hour
+	return opts
+}
+
+// operationMetrics maps from each operation to its attempted/failed/duration metric, labelled with
+// the operation, the phase of the run and the target, which is empty unless targets are compared.
+type operationMetrics struct {
+	attempts  map[string]prometheus.Counter
+	failures  map[string]prometheus.Counter
+	timeouts  map[string]prometheus.Counter
+	durations map[string]prometheus.Observer
+	// corrected is only recorded when correcting for coordinated omission
+	corrected map[string]prometheus.Observer
+	lag       prometheus.Observer
+	phase     string
+	target    string
+}
+
+func newOperationMetrics(operations []string, phase string, target string) operationMetrics {
+	m := operationMetrics{
+		attempts:  map[string]prometheus.Counter{},
+		failures:  map[string]prometheus.Counter{},
+		timeouts:  map[string]prometheus.Counter{},
+		durations: map[string]prometheus.Observer{},
+		lag:       schedulerLag.WithLabelValues(phase, target),
+		phase:     phase,
+		target:    target,
+	}
+
+	for _, operation := range operations {
+		m.attempts[operation] = opsAttempted.WithLabelValues(operation, phase, target)
+		m.failures[operation] = opsFailed.WithLabelValues(operation, phase, target)
+		m.timeouts[operation] = opsTimedOut.WithLabelValues(operation, phase, target)
+		m.durations[operation] = opDuration.WithLabelValues(operation, phase, target)
+	}
+	if CorrectCoordinatedOmission {
+		m.corrected = map[string]prometheus.Observer{}
+		for _, operation := range operations {
+			m.corrected[operation] = opCorrectedDuration.WithLabelValues(operation, phase, target)
+		}
+	}
+
+	return m
+}
+
+// transition records that a user moved from one operation of the chain to the next.
+func (m operationMetrics) transition(from string, to string) {
+	opTransitions.WithLabelValues(from, to, m.phase, m.target).Inc()
+}
+
+// ObserveLockContention records that an operation found a document it tried to lock already
+// locked by another user.
+func (r Runctx) ObserveLockContention(operation string) {
+	lockContention.WithLabelValues(operation, r.phase, r.target).Inc()
+}
+
+// ObserveReplicaRead records whether a document read from any replica was returned by the active
+// copy or a replica.
+func (r Runctx) ObserveReplicaRead(operation string, fromReplica bool) {
+	copy := "active"
+	if fromReplica {
+		copy = "replica"
+	}
+	replicaReads.WithLabelValues(operation, r.phase, r.target, copy).Inc()
+}
+
+// ObserveBatch records the duration of a batch of bulk operations, and how many of its items
+// failed, labelled with the size of the batch.
+func (r Runctx) ObserveBatch(operation string, size int, duration time.Duration, failed int) {
+	batchSize := strconv.Itoa(size)
+	batchDuration.WithLabelValues(operation, r.phase, r.target, batchSize).Observe(float64(duration.Microseconds()) / 1000)
+	batchItemsFailed.WithLabelValues(operation, r.phase, r.target, batchSize).Add(float64(failed))
+}
+
+// ObserveMutationVisible records how long it took from starting a write until a query could see
+// it, which characterizes the freshness of the index under load.
+func (r Runctx) ObserveMutationVisible(operation string, latency time.Duration) {
+	mutationVisible.WithLabelValues(operation, r.phase, r.target).Observe(float64(latency.Microseconds()) / 1000)
+}
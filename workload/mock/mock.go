@@ -0,0 +1,335 @@
+// Package mock is an in-memory backend serving the parts of the Data API the workloads use, so
+// that workload logic, markov chains, metrics and reports can be developed and demoed without a
+// Couchbase cluster.  It keeps documents in memory, serves sub-document requests on the fields of
+// JSON documents, answers the simple queries of the workloads and returns no search hits.
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Server is the mock backend, serving the Data API over HTTP on a local port.
+type Server struct {
+	// Latency is added to every request, so that runs against the mock have realistic durations
+	Latency time.Duration
+
+	mu   sync.Mutex
+	docs map[string]*document
+	cas  uint64
+	http *http.Server
+	url  string
+}
+
+type document struct {
+	value  json.RawMessage
+	cas    uint64
+	expiry time.Time
+}
+
+func (d *document) expired() bool {
+	return !d.expiry.IsZero() && time.Now().After(d.expiry)
+}
+
+// NewServer returns a mock backend with no documents.
+func NewServer(latency time.Duration) *Server {
+	return &Server{Latency: latency, docs: map[string]*document{}}
+}
+
+// Start serves the Data API on a free local port, returning its address.
+func (s *Server) Start() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen: %s", err.Error())
+	}
+
+	mux := http.NewServeMux()
+	documents := "/v1/buckets/{bucket}/scopes/{scope}/collections/{collection}/documents/{id}"
+	mux.HandleFunc("GET "+documents, s.getDocument)
+	mux.HandleFunc("PUT "+documents, s.putDocument)
+	mux.HandleFunc("DELETE "+documents, s.deleteDocument)
+	mux.HandleFunc("POST "+documents+"/lookupin", s.lookupIn)
+	mux.HandleFunc("POST "+documents+"/mutatein", s.mutateIn)
+	mux.HandleFunc("POST /_p/query/query/service", s.query)
+	mux.HandleFunc("POST /_p/fts/api/bucket/{bucket}/scope/{scope}/index/{index}/query", s.search)
+
+	s.http = &http.Server{Handler: s.delay(mux)}
+	s.url = "http://" + listener.Addr().String()
+	go s.http.Serve(listener)
+	return s.url, nil
+}
+
+// Close stops serving the Data API.
+func (s *Server) Close() error {
+	if s.http == nil {
+		return nil
+	}
+	return s.http.Shutdown(context.Background())
+}
+
+// delay adds the latency of the server to every request.
+func (s *Server) delay(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(s.Latency):
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// documentKey returns the key of the document a request is for, qualified by its collection.
+func documentKey(r *http.Request) string {
+	return keyspace(r.PathValue("bucket"), r.PathValue("scope"), r.PathValue("collection")) + "/" + r.PathValue("id")
+}
+
+func keyspace(bucket string, scope string, collection string) string {
+	return bucket + "/" + scope + "/" + collection
+}
+
+// lookup returns the document under key, unless it does not exist or has expired.  The lock of
+// the server must be held.
+func (s *Server) lookup(key string) (*document, bool) {
+	doc, ok := s.docs[key]
+	if !ok {
+		return nil, false
+	}
+	if doc.expired() {
+		delete(s.docs, key)
+		return nil, false
+	}
+	return doc, true
+}
+
+// store writes a document under key with a new CAS, returning the CAS.  The lock of the server
+// must be held.
+func (s *Server) store(key string, value json.RawMessage, expiry time.Time) uint64 {
+	s.cas++
+	s.docs[key] = &document{value: value, cas: s.cas, expiry: expiry}
+	return s.cas
+}
+
+func etag(cas uint64) string {
+	return strconv.FormatUint(cas, 10)
+}
+
+func (s *Server) getDocument(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	doc, ok := s.lookup(documentKey(r))
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", etag(doc.cas))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc.value)
+}
+
+func (s *Server) putDocument(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(body) {
+		http.Error(w, "document is not JSON", http.StatusBadRequest)
+		return
+	}
+	var expiry time.Time
+	if expires := r.Header.Get("Expires"); expires != "" {
+		expiry, err = http.ParseTime(expires)
+		if err != nil {
+			http.Error(w, "invalid Expires header", http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := documentKey(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, exists := s.lookup(key)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !exists {
+			http.Error(w, "document not found", http.StatusNotFound)
+			return
+		}
+		if ifMatch != etag(existing.cas) {
+			http.Error(w, "document changed", http.StatusPreconditionFailed)
+			return
+		}
+	}
+	w.Header().Set("ETag", etag(s.store(key, body, expiry)))
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (s *Server) deleteDocument(w http.ResponseWriter, r *http.Request) {
+	key := documentKey(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lookup(key); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	delete(s.docs, key)
+}
+
+// subdocOp is a single operation of a sub-document request, on a dot separated path of fields.
+type subdocOp struct {
+	Operation string          `json:"operation"`
+	Path      string          `json:"path"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+type subdocResult struct {
+	Value  json.RawMessage `json:"value,omitempty"`
+	Exists bool            `json:"exists"`
+}
+
+// subdocRequest decodes the operations of a sub-document request and the fields of the document
+// it is for, with the lock of the server held until done is called.
+func (s *Server) subdocRequest(w http.ResponseWriter, r *http.Request) (ops []subdocOp, doc *document, fields map[string]interface{}, done func()) {
+	err := json.NewDecoder(r.Body).Decode(&ops)
+	if err != nil {
+		http.Error(w, "invalid sub-document operations", http.StatusBadRequest)
+		return nil, nil, nil, nil
+	}
+	s.mu.Lock()
+	doc, ok := s.lookup(documentKey(r))
+	if !ok {
+		s.mu.Unlock()
+		http.Error(w, "document not found", http.StatusNotFound)
+		return nil, nil, nil, nil
+	}
+	err = json.Unmarshal(doc.value, &fields)
+	if err != nil {
+		s.mu.Unlock()
+		http.Error(w, "document is not a JSON object", http.StatusBadRequest)
+		return nil, nil, nil, nil
+	}
+	return ops, doc, fields, s.mu.Unlock
+}
+
+func (s *Server) lookupIn(w http.ResponseWriter, r *http.Request) {
+	ops, _, fields, done := s.subdocRequest(w, r)
+	if done == nil {
+		return
+	}
+	defer done()
+
+	results := make([]subdocResult, len(ops))
+	for i, op := range ops {
+		value, ok := getPath(fields, op.Path)
+		switch op.Operation {
+		case "get":
+			if ok {
+				results[i].Value, _ = json.Marshal(value)
+			}
+		case "exists":
+		default:
+			http.Error(w, "unknown lookup operation "+op.Operation, http.StatusBadRequest)
+			return
+		}
+		results[i].Exists = ok
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func (s *Server) mutateIn(w http.ResponseWriter, r *http.Request) {
+	ops, doc, fields, done := s.subdocRequest(w, r)
+	if done == nil {
+		return
+	}
+	defer done()
+
+	for _, op := range ops {
+		var value interface{}
+		if len(op.Value) > 0 {
+			err := json.Unmarshal(op.Value, &value)
+			if err != nil {
+				http.Error(w, "invalid value for "+op.Path, http.StatusBadRequest)
+				return
+			}
+		}
+		_, exists := getPath(fields, op.Path)
+		switch {
+		case op.Operation == "replace" && !exists, op.Operation == "remove" && !exists:
+			http.Error(w, "path not found "+op.Path, http.StatusNotFound)
+			return
+		case op.Operation == "insert" && exists:
+			http.Error(w, "path exists "+op.Path, http.StatusConflict)
+			return
+		case op.Operation == "replace", op.Operation == "insert", op.Operation == "upsert":
+			setPath(fields, op.Path, value)
+		case op.Operation == "remove":
+			setPath(fields, op.Path, nil)
+		default:
+			http.Error(w, "unknown mutation operation "+op.Operation, http.StatusBadRequest)
+			return
+		}
+	}
+
+	value, err := json.Marshal(fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag(s.store(documentKey(r), value, doc.expiry)))
+}
+
+// getPath returns the value of a dot separated path of fields.
+func getPath(fields map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = fields
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPath sets the value of a dot separated path of fields, creating the objects along it, or
+// removes it if the value is nil.
+func setPath(fields map[string]interface{}, path string, value interface{}) {
+	names := strings.Split(path, ".")
+	object := fields
+	for _, name := range names[:len(names)-1] {
+		child, ok := object[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			object[name] = child
+		}
+		object = child
+	}
+	if value == nil {
+		delete(object, names[len(names)-1])
+		return
+	}
+	object[names[len(names)-1]] = value
+}
+
+// search returns no hits, as the mock has no search indexes.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     map[string]int{"total": 1, "failed": 0, "successful": 1},
+		"hits":       []interface{}{},
+		"total_hits": 0,
+	})
+}
@@ -0,0 +1,203 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The mock answers the simple queries of the workloads rather than implementing SQL++: the
+// documents of one keyspace are filtered by the conditions comparing a field to a named parameter
+// with = or LIKE, joined by AND, and limited by LIMIT.  Each row is the document under the alias
+// of the keyspace along with its key as id, unless the statement selects RAW META().id or RAW
+// COUNT(*).
+var (
+	fromClause      = regexp.MustCompile("(?i)\\bFROM\\s+((?:`[^`]+`|\\w+)(?:\\.(?:`[^`]+`|\\w+)){0,2})(?:\\s+(?:AS\\s+)?(`[^`]+`|\\w+))?")
+	whereCondition  = regexp.MustCompile("(?i)`?(\\w+)`?\\s*(=|LIKE)\\s*\\$(\\w+)")
+	limitClause     = regexp.MustCompile(`(?i)\bLIMIT\s+(\d+)`)
+	selectRawID     = regexp.MustCompile(`(?i)^\s*SELECT\s+RAW\s+META\(\)\.id\b`)
+	selectRawCount  = regexp.MustCompile(`(?i)^\s*SELECT\s+RAW\s+COUNT\(\*\)`)
+	reservedAliases = map[string]bool{"WHERE": true, "LIMIT": true, "ORDER": true, "GROUP": true, "USE": true}
+)
+
+type condition struct {
+	field string
+	like  *regexp.Regexp
+	value interface{}
+}
+
+func (c condition) matches(fields map[string]interface{}) bool {
+	value, ok := getPath(fields, c.field)
+	if !ok {
+		return false
+	}
+	if c.like != nil {
+		s, ok := value.(string)
+		return ok && c.like.MatchString(s)
+	}
+	return reflect.DeepEqual(value, c.value)
+}
+
+// likePattern returns a regular expression matching what a LIKE pattern matches.
+func likePattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			expr.WriteString(".*")
+		case r == '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+func unquote(name string) string {
+	return strings.Trim(name, "`")
+}
+
+// query answers a request to the query service.
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	var request map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeQueryError(w, "invalid query request")
+		return
+	}
+	statement, _ := request["statement"].(string)
+
+	from := fromClause.FindStringSubmatch(statement)
+	if from == nil {
+		writeQueryError(w, "the mock only answers queries of a keyspace")
+		return
+	}
+	var parts []string
+	for _, part := range strings.Split(from[1], ".") {
+		parts = append(parts, unquote(part))
+	}
+	if len(parts) == 1 {
+		context, _ := request["query_context"].(string)
+		_, context, _ = strings.Cut(context, ":")
+		bucket, scope, ok := strings.Cut(context, ".")
+		if !ok {
+			writeQueryError(w, "the mock needs a query context for a keyspace that is not fully qualified")
+			return
+		}
+		parts = []string{unquote(bucket), unquote(scope), parts[0]}
+	}
+	if len(parts) != 3 {
+		writeQueryError(w, "the mock only answers queries of a collection")
+		return
+	}
+	alias := parts[2]
+	if from[2] != "" && !reservedAliases[strings.ToUpper(from[2])] {
+		alias = unquote(from[2])
+	}
+
+	var conditions []condition
+	where := statement[strings.Index(statement, from[0])+len(from[0]):]
+	for _, match := range whereCondition.FindAllStringSubmatch(where, -1) {
+		value, ok := request["$"+match[3]]
+		if !ok {
+			writeQueryError(w, fmt.Sprintf("no value for parameter $%s", match[3]))
+			return
+		}
+		c := condition{field: match[1], value: value}
+		if strings.EqualFold(match[2], "LIKE") {
+			pattern, ok := value.(string)
+			if !ok {
+				writeQueryError(w, fmt.Sprintf("parameter $%s of LIKE is not a string", match[3]))
+				return
+			}
+			c.like = likePattern(pattern)
+		}
+		conditions = append(conditions, c)
+	}
+	limit := -1
+	if match := limitClause.FindStringSubmatch(where); match != nil {
+		limit, _ = strconv.Atoi(match[1])
+	}
+
+	prefix := keyspace(parts[0], parts[1], parts[2]) + "/"
+	type row struct {
+		id     string
+		fields map[string]interface{}
+	}
+	var rows []row
+	s.mu.Lock()
+	for key := range s.docs {
+		id, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		doc, ok := s.lookup(key)
+		if !ok {
+			continue
+		}
+		var fields map[string]interface{}
+		if json.Unmarshal(doc.value, &fields) != nil {
+			continue
+		}
+		matched := true
+		for _, c := range conditions {
+			matched = matched && c.matches(fields)
+		}
+		if matched {
+			rows = append(rows, row{id: id, fields: fields})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+	if limit >= 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	results := []interface{}{}
+	switch {
+	case selectRawCount.MatchString(statement):
+		results = append(results, len(rows))
+	case selectRawID.MatchString(statement):
+		for _, row := range rows {
+			results = append(results, row.id)
+		}
+	default:
+		for _, row := range rows {
+			results = append(results, map[string]interface{}{"id": row.id, alias: row.fields})
+		}
+	}
+	body, err := json.Marshal(results)
+	if err != nil {
+		writeQueryError(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": json.RawMessage(body),
+		"status":  "success",
+		"metrics": map[string]int{"resultCount": len(results), "resultSize": len(body)},
+	})
+}
+
+func writeQueryError(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "fatal",
+		"errors": []map[string]interface{}{{"code": 3000, "msg": msg}},
+	})
+}
@@ -0,0 +1,122 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/pkg/errors"
+)
+
+// Semantics with which an operation writes a whole document it read.
+const (
+	// MutationUpsert overwrites the document whether or not it changed or still exists
+	MutationUpsert = "upsert"
+	// MutationReplace writes the document only if it is unchanged since it was read, by its CAS
+	MutationReplace = "replace"
+	// MutationInsert writes the document only if it does not exist, counting one that does as
+	// handled rather than failed
+	MutationInsert = "insert"
+)
+
+// Outcomes of mutations, which label mutations_total.
+const (
+	mutationOK          = "ok"
+	mutationExists      = "exists"
+	mutationCasMismatch = "cas_mismatch"
+	mutationNotFound    = "not_found"
+	mutationFailed      = "error"
+)
+
+// MutationSemantics are the semantics each operation writes documents with, with the empty
+// operation giving those of every other operation.
+type MutationSemantics map[string]string
+
+// ParseMutationSemantics parses semantics given as one of upsert, replace or insert for every
+// operation, optionally followed or replaced by comma separated <operation>=<semantics> pairs,
+// such as replace,lockProfile=upsert.
+func ParseMutationSemantics(spec string) (MutationSemantics, error) {
+	semantics := MutationSemantics{}
+	if spec == "" {
+		return semantics, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		operation, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			operation, value = "", operation
+		}
+		switch value {
+		case MutationUpsert, MutationReplace, MutationInsert:
+		default:
+			return nil, fmt.Errorf("unknown mutation semantics %s, expected %s, %s or %s", value, MutationUpsert, MutationReplace, MutationInsert)
+		}
+		semantics[operation] = value
+	}
+	return semantics, nil
+}
+
+// For returns the semantics of the operation, or def if none were given for it.
+func (s MutationSemantics) For(operation string, def string) string {
+	if semantics, ok := s[operation]; ok {
+		return semantics
+	}
+	if semantics, ok := s[""]; ok {
+		return semantics
+	}
+	return def
+}
+
+// A Mutation is a whole document written back by an operation that read it.
+type Mutation struct {
+	Key   string
+	Value interface{}
+	// Cas is that of the document when it was read, which replace checks
+	Cas        gocb.Cas
+	Transcoder gocb.Transcoder
+	// PreserveExpiry keeps the expiry of the document when it is upserted or replaced
+	PreserveExpiry bool
+}
+
+// Mutate writes a document with the given semantics, recording the outcome and duration of the
+// write by its semantics, as each exercises a different path through the server.  It returns
+// the CAS the document was written with, which is zero if it was not written, as an insert of a
+// document that already exists is counted as such rather than failing the operation.
+func (r Runctx) Mutate(ctx context.Context, coll *gocb.Collection, semantics string, m Mutation) (gocb.Cas, error) {
+	start := time.Now()
+	var result *gocb.MutationResult
+	var err error
+	switch semantics {
+	case MutationUpsert:
+		result, err = coll.Upsert(m.Key, m.Value, &gocb.UpsertOptions{Context: ctx, Transcoder: m.Transcoder, PreserveExpiry: m.PreserveExpiry})
+	case MutationReplace:
+		result, err = coll.Replace(m.Key, m.Value, &gocb.ReplaceOptions{Context: ctx, Transcoder: m.Transcoder, Cas: m.Cas, PreserveExpiry: m.PreserveExpiry})
+	case MutationInsert:
+		result, err = coll.Insert(m.Key, m.Value, &gocb.InsertOptions{Context: ctx, Transcoder: m.Transcoder})
+	default:
+		return 0, fmt.Errorf("unknown mutation semantics %s", semantics)
+	}
+
+	outcome := mutationOK
+	switch {
+	case err == nil:
+	case errors.Is(err, gocb.ErrDocumentExists) && semantics == MutationInsert:
+		outcome, err = mutationExists, nil
+	case errors.Is(err, gocb.ErrCasMismatch):
+		outcome = mutationCasMismatch
+	case errors.Is(err, gocb.ErrDocumentNotFound):
+		outcome = mutationNotFound
+	default:
+		outcome = mutationFailed
+	}
+	mutations.WithLabelValues(r.operation, r.phase, r.target, semantics, outcome).Inc()
+	mutationDuration.WithLabelValues(r.operation, r.phase, r.target, semantics).Observe(float64(time.Since(start).Microseconds()) / 1000)
+	if err != nil {
+		return 0, fmt.Errorf("%s of %s failed: %s", semantics, m.Key, err.Error())
+	}
+	if outcome != mutationOK {
+		return 0, nil
+	}
+	return result.Cas(), nil
+}
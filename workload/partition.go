@@ -0,0 +1,51 @@
+package workload
+
+import "fmt"
+
+// KeyPartitioning splits the items of a workload into shards, each owned by the runners whose
+// number falls in it, so that runners do not contend with each other over the same documents.
+// Contention between simulated users is real for some workloads, but makes update heavy workloads
+// measure CAS conflicts and lock waits of the client's own making when the intent is to measure
+// the throughput of the server.
+type KeyPartitioning struct {
+	// Shards is how many shards the items are split into, runner n owning shard n modulo Shards,
+	// or zero to let every runner pick from every item.  With at least as many shards as users,
+	// no two users share a shard.
+	Shards int
+	// Shared is the fraction of picks made from every item rather than the runner's own shard, a
+	// hot set shared by all runners that keeps some contention, as in production
+	Shared float64
+}
+
+// Partitioning is how the items picked by the operations of every workload are split between
+// runners.
+var Partitioning KeyPartitioning
+
+// Validate checks the number of shards and the shared fraction are in range.
+func (p KeyPartitioning) Validate() error {
+	if p.Shards < 0 {
+		return fmt.Errorf("key shards %d must not be negative", p.Shards)
+	}
+	if p.Shared < 0 || p.Shared > 1 {
+		return fmt.Errorf("shared key fraction %g must be between 0 and 1", p.Shared)
+	}
+	return nil
+}
+
+// Item returns a random item, of the numItems loaded, for the operation to act on.  It is drawn
+// from the shard of the runner when the items are partitioned, unless there are too few items to
+// give the runner a shard of its own.
+func (r Runctx) Item(numItems int) int32 {
+	p := Partitioning
+	if p.Shards <= 1 || (p.Shared > 0 && r.r.Float64() < p.Shared) {
+		return r.r.Int31n(int32(numItems))
+	}
+
+	shard := r.id % p.Shards
+	start := numItems * shard / p.Shards
+	end := numItems * (shard + 1) / p.Shards
+	if end <= start {
+		return r.r.Int31n(int32(numItems))
+	}
+	return int32(start) + r.r.Int31n(int32(end-start))
+}
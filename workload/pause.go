@@ -0,0 +1,142 @@
+package workload
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pauseInterval is a time the run was paused, which has no end while it still is.
+type pauseInterval struct {
+	start time.Time
+	end   time.Time
+}
+
+// runPause pauses every user of the run, such as through a maintenance window on the cluster.
+// The time spent paused is left out of the duration of the phases, which carry on where they
+// left off once the run is resumed.
+type runPause struct {
+	mu sync.Mutex
+	// pausing is closed while the run is paused, and resumed while it is not
+	pausing   chan struct{}
+	resumed   chan struct{}
+	intervals []pauseInterval
+}
+
+var currentPause = newRunPause()
+
+func newRunPause() *runPause {
+	p := &runPause{}
+	p.reset()
+	return p
+}
+
+// reset resumes the run, if it was paused, and forgets the times it was paused for, so that a
+// run does not start paused by an earlier run in the process.  It is called before the run
+// starts any users.
+func (p *runPause) reset() {
+	p.pausing = make(chan struct{})
+	p.resumed = make(chan struct{})
+	close(p.resumed)
+	p.intervals = nil
+}
+
+// resetPause resets the pause of the run, under its lock.
+func resetPause() {
+	currentPause.mu.Lock()
+	defer currentPause.mu.Unlock()
+	currentPause.reset()
+}
+
+// PauseRun pauses every user of the run after the operations they are running, returning false
+// if the run was already paused.
+func PauseRun() bool {
+	p := currentPause
+	p.mu.Lock()
+	if p.isPaused() {
+		p.mu.Unlock()
+		return false
+	}
+	p.intervals = append(p.intervals, pauseInterval{start: time.Now()})
+	p.resumed = make(chan struct{})
+	close(p.pausing)
+	p.mu.Unlock()
+
+	// The status reads how long the run was paused for, so is only updated once unlocked
+	runPaused.Set(1)
+	currentStatus.setPaused(true)
+	zap.L().Info("Paused run")
+	return true
+}
+
+// ResumeRun resumes the users of a paused run, returning false if the run was not paused.
+func ResumeRun() bool {
+	p := currentPause
+	p.mu.Lock()
+	if !p.isPaused() {
+		p.mu.Unlock()
+		return false
+	}
+	last := &p.intervals[len(p.intervals)-1]
+	last.end = time.Now()
+	paused := last.end.Sub(last.start)
+	p.pausing = make(chan struct{})
+	close(p.resumed)
+	p.mu.Unlock()
+
+	runPaused.Set(0)
+	currentStatus.setPaused(false)
+	zap.L().Info("Resumed run", zap.Duration("paused", paused))
+	return true
+}
+
+// Paused returns whether the run is paused.
+func Paused() bool {
+	currentPause.mu.Lock()
+	defer currentPause.mu.Unlock()
+	return currentPause.isPaused()
+}
+
+// PausedSince returns how long the run has been paused for since a time, including a pause
+// that has not yet ended.
+func PausedSince(since time.Time) time.Duration {
+	return currentPause.since(since)
+}
+
+func (p *runPause) isPaused() bool {
+	return len(p.intervals) > 0 && p.intervals[len(p.intervals)-1].end.IsZero()
+}
+
+// channels returns a channel that is closed when the run is paused, and one that is closed when
+// it is next resumed.
+func (p *runPause) channels() (<-chan struct{}, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pausing, p.resumed
+}
+
+func (p *runPause) since(since time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var paused time.Duration
+	now := time.Now()
+	for _, interval := range p.intervals {
+		end := interval.end
+		if end.IsZero() {
+			end = now
+		}
+		if end.After(since) {
+			paused += end.Sub(maxTime(interval.start, since))
+		}
+	}
+	return paused
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package workload
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handlePauseSignals pauses the run on SIGUSR1 and resumes it on SIGUSR2, until the returned
+// function is called.
+func handlePauseSignals() func() {
+	sigCh := make(chan os.Signal, 10)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-sigCh:
+				if sig == syscall.SIGUSR1 {
+					PauseRun()
+				} else {
+					ResumeRun()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
@@ -0,0 +1,7 @@
+package workload
+
+// handlePauseSignals does nothing, as Windows has no signals to pause and resume the run with, so
+// it can only be paused through the control API.
+func handlePauseSignals() func() {
+	return func() {}
+}
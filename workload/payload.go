@@ -0,0 +1,101 @@
+package workload
+
+import (
+	"io"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// Directions of the payloads of operations.
+const (
+	PayloadRequest  = "request"
+	PayloadResponse = "response"
+)
+
+// ObservePayload records the size in bytes of a payload sent or received by the operation being
+// run, so that throughput can be reported in bytes as well as operations, and holds the operation
+// back while the payload exceeds the Bandwidth of its direction.  Payloads sent outside of an
+// operation, such as while loading the documents of the setup, are neither recorded nor throttled.
+func (r Runctx) ObservePayload(direction string, size int) {
+	if r.operation == "" {
+		return
+	}
+	payloadBytes.WithLabelValues(r.operation, r.phase, r.target, direction).Observe(float64(size))
+	r.throttle(direction, size)
+}
+
+// PayloadTranscoder returns a transcoder that records the size of the documents an operation
+// writes and reads with it, encoding them with inner, or the default JSON transcoder if inner is
+// nil.
+func (r Runctx) PayloadTranscoder(inner gocb.Transcoder) gocb.Transcoder {
+	if inner == nil {
+		inner = gocb.NewJSONTranscoder()
+	}
+	return payloadTranscoder{inner: inner, rctx: r}
+}
+
+type payloadTranscoder struct {
+	inner gocb.Transcoder
+	rctx  Runctx
+}
+
+func (t payloadTranscoder) Encode(value interface{}) ([]byte, uint32, error) {
+	bytes, flags, err := t.inner.Encode(value)
+	if err == nil {
+		t.rctx.ObservePayload(PayloadRequest, len(bytes))
+	}
+	return bytes, flags, err
+}
+
+// Decode records the size of the document, only decoding it if out is not nil.
+func (t payloadTranscoder) Decode(bytes []byte, flags uint32, out interface{}) error {
+	t.rctx.ObservePayload(PayloadResponse, len(bytes))
+	if out == nil {
+		return nil
+	}
+	return t.inner.Decode(bytes, flags, out)
+}
+
+// contentResult is the result of reading a document, such as a GetResult.
+type contentResult interface {
+	Content(valuePtr interface{}) error
+}
+
+// ObserveContent records the size of a document read with a PayloadTranscoder, for operations
+// that do not otherwise decode the document.
+func ObserveContent(result contentResult) {
+	result.Content(nil)
+}
+
+// ObserveQueryResult records the size of the results of a query, as reported by the query
+// service once every row has been read.  It needs the metrics of the query, which SDKOptions asks
+// for.
+func (r Runctx) ObserveQueryResult(rows *gocb.QueryResult) {
+	meta, err := rows.MetaData()
+	if err != nil {
+		return
+	}
+	r.ObservePayload(PayloadResponse, int(meta.Metrics.ResultSize))
+}
+
+// PayloadBody returns an HTTP response body that records its size once it is closed.
+func (r Runctx) PayloadBody(body io.ReadCloser) io.ReadCloser {
+	return &payloadBody{ReadCloser: body, rctx: r}
+}
+
+type payloadBody struct {
+	io.ReadCloser
+	rctx Runctx
+	read int
+}
+
+func (b *payloadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *payloadBody) Close() error {
+	b.rctx.ObservePayload(PayloadResponse, b.read)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,85 @@
+package workload
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Phase is one step of a run plan.  A run made up of several phases can describe step load,
+// spike or soak tests, and the metrics recorded during each phase are labelled with its name.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+	Users    int
+	// Throughput is the target number of operations per second across all users, or zero to
+	// leave the rate of operations to the users think time alone.
+	Throughput float64
+	// Probabilities overrides the markov chain of the workload during this phase when set.
+	Probabilities [][]float64
+	Ramp          Ramp
+	// MaxErrorRate overrides the error rate allowed by the error budget of the run during this
+	// phase when set.
+	MaxErrorRate float64
+}
+
+// phaseRun is the state shared by all the runners of a phase.  The markov chain, throughput
+// limit and number of regular users may be changed by the control API while the phase runs.
+type phaseRun struct {
+	name          string
+	target        string
+	probabilities atomic.Pointer[[][]float64]
+	functions     map[string]func(context.Context, Runctx) error
+	operations    []string
+	metrics       operationMetrics
+	limiter       atomic.Pointer[rateLimiter]
+	users         *userLimit
+	recorder      *TraceRecorder
+	identities    []Identity
+	breaker       *circuitBreaker
+}
+
+// rateLimiter spaces operations evenly so that they do not exceed a target throughput.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	slot     time.Time
+}
+
+func newRateLimiter(opsPerSecond float64) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / opsPerSecond),
+	}
+}
+
+// setRate changes the target number of operations per second.
+func (l *rateLimiter) setRate(opsPerSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = time.Duration(float64(time.Second) / opsPerSecond)
+}
+
+// rate returns the target number of operations per second.
+func (l *rateLimiter) rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return float64(time.Second) / float64(l.interval)
+}
+
+// next reserves the next free slot, returning when it is and a channel that fires when it is
+// reached.  Slots left unused while operations run behind the target are not saved up for a later
+// burst.
+func (l *rateLimiter) next() (time.Time, <-chan time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.slot.Before(now) {
+		l.slot = now
+	}
+	slot := l.slot
+	l.slot = l.slot.Add(l.interval)
+
+	return slot, time.After(slot.Sub(now))
+}
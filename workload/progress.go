@@ -0,0 +1,144 @@
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ProgressInterval is how often a line summarising the progress of the run is logged, so that a
+// run without dashboards can be seen to be healthy.  With an interval of zero no progress is
+// logged.
+var ProgressInterval = 10 * time.Second
+
+// progressTopOperations is how many of the most frequent operations the progress line reports
+// the latency of.
+const progressTopOperations = 3
+
+// progressReport is the progress of the run over the last interval.
+type progressReport struct {
+	phase      string
+	elapsed    time.Duration
+	remaining  time.Duration
+	paused     bool
+	throughput float64
+	errorRate  float64
+	// p99s are the 99th percentile durations in milliseconds of the most frequent operations
+	operations []string
+	p99s       []float64
+}
+
+// newProgressReport reports the operations between two sets of totals, taken an interval apart.
+func newProgressReport(status RunStatus, last map[string]operationTotals, totals map[string]operationTotals, interval time.Duration) progressReport {
+	report := progressReport{
+		phase:     status.Phase,
+		elapsed:   time.Duration(status.Elapsed * float64(time.Second)).Round(time.Second),
+		remaining: time.Duration(status.Remaining * float64(time.Second)).Round(time.Second),
+		paused:    status.State == RunStatePaused,
+	}
+
+	var all operationTotals
+	diffs := map[string]operationTotals{}
+	for operation, t := range totals {
+		diff := t.since(last[operation])
+		if diff.attempts == 0 {
+			continue
+		}
+		diffs[operation] = diff
+		all = all.plus(diff)
+		report.operations = append(report.operations, operation)
+	}
+	if interval > 0 {
+		report.throughput = float64(all.attempts) / interval.Seconds()
+	}
+	if all.attempts > 0 {
+		report.errorRate = float64(all.failures) / float64(all.attempts)
+	}
+
+	sort.Slice(report.operations, func(i, j int) bool {
+		a, b := diffs[report.operations[i]], diffs[report.operations[j]]
+		if a.attempts != b.attempts {
+			return a.attempts > b.attempts
+		}
+		return report.operations[i] < report.operations[j]
+	})
+	report.operations = report.operations[:min(len(report.operations), progressTopOperations)]
+	for _, operation := range report.operations {
+		report.p99s = append(report.p99s, diffs[operation].p99())
+	}
+	return report
+}
+
+// String formats the report as a single line, such as
+//
+//	steady 2m10s elapsed, 5m50s left: 1520.3 ops/s, 0.21% errors, p99 login 12.3ms fetchProfile 8.1ms
+func (r progressReport) String() string {
+	var b strings.Builder
+	if r.phase != "" {
+		b.WriteString(r.phase + " ")
+	}
+	fmt.Fprintf(&b, "%s elapsed, %s left", r.elapsed, r.remaining)
+	if r.paused {
+		b.WriteString(": paused")
+		return b.String()
+	}
+	fmt.Fprintf(&b, ": %.1f ops/s, %.2f%% errors", r.throughput, r.errorRate*100)
+	if len(r.operations) > 0 {
+		b.WriteString(", p99")
+		for i, operation := range r.operations {
+			fmt.Fprintf(&b, " %s %.1fms", operation, r.p99s[i])
+		}
+	}
+	return b.String()
+}
+
+// logProgress periodically logs the progress of the run, returning a function that stops it.
+func logProgress() func() {
+	if ProgressInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ProgressInterval)
+		defer ticker.Stop()
+
+		last, err := operationTotalsByName()
+		if err != nil {
+			zap.L().Warn("Failed to gather client metrics", zap.Error(err))
+		}
+		lastAt := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			totals, err := operationTotalsByName()
+			if err != nil {
+				zap.L().Warn("Failed to gather client metrics", zap.Error(err))
+				continue
+			}
+			now := time.Now()
+			report := newProgressReport(currentStatus.snapshot(), last, totals, now.Sub(lastAt))
+			last, lastAt = totals, now
+
+			zap.L().Info(report.String(),
+				zap.String("phase", report.phase),
+				zap.Duration("elapsed", report.elapsed),
+				zap.Float64("opsPerSecond", report.throughput),
+				zap.Float64("errorRate", report.errorRate))
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
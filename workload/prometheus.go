@@ -0,0 +1,138 @@
+package workload
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// A PrometheusServer is a Prometheus compatible server, such as Thanos or Mimir, scraping the
+// metrics of the run, which the summary of the run can be queried from instead of the metrics of
+// this process.
+type PrometheusServer struct {
+	URL string
+	// Username and Password authenticate with basic auth when Username is set
+	Username string
+	Password string
+	// BearerToken authenticates with a bearer token when set
+	BearerToken string
+	TLSConfig   *tls.Config
+}
+
+// authRoundTripper adds the credentials of the server to every request.
+type authRoundTripper struct {
+	server PrometheusServer
+	next   http.RoundTripper
+}
+
+func (t authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if t.server.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+t.server.BearerToken)
+	} else if t.server.Username != "" {
+		req.SetBasicAuth(t.server.Username, t.server.Password)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// SummariseFromPrometheus summarises each operation that was attempted in each phase of the run
+// between start and end, from the metrics scraped by a Prometheus server, leaving out those of any
+// other run it scraped.
+func SummariseFromPrometheus(ctx context.Context, server PrometheusServer, runId string, start time.Time, end time.Time) ([]OperationSummary, error) {
+	if server.BearerToken != "" && server.Username != "" {
+		return nil, fmt.Errorf("prometheus must be authenticated with either basic auth or a bearer token, not both")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = server.TLSConfig
+	client, err := api.NewClient(api.Config{
+		Address:      server.URL,
+		RoundTripper: authRoundTripper{server: server, next: transport},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid prometheus address")
+	}
+	prom := promv1.NewAPI(client)
+
+	// Every series of the run, over the whole of it
+	series := fmt.Sprintf("{%s=%q}[%ds]", runLabel, runId, int(end.Sub(start).Seconds())+1)
+	queries := map[string]string{
+		"attempts":   fmt.Sprintf("sum by (phase, target, operation) (increase(operations_total%s))", series),
+		"failures":   fmt.Sprintf("sum by (phase, target, operation) (increase(operations_failed_total%s))", series),
+		"timeouts":   fmt.Sprintf("sum by (phase, target, operation) (increase(operations_timed_out_total%s))", series),
+		"unexpected": fmt.Sprintf("sum by (phase, target, operation) (increase(query_results_unexpected_total%s))", series),
+		"p50":        fmt.Sprintf("histogram_quantile(0.5, sum by (le, phase, target, operation) (increase(operation_duration_milliseconds_bucket%s)))", series),
+		"p99":        fmt.Sprintf("histogram_quantile(0.99, sum by (le, phase, target, operation) (increase(operation_duration_milliseconds_bucket%s)))", series),
+	}
+	if CorrectCoordinatedOmission {
+		queries["correctedP99"] = fmt.Sprintf("histogram_quantile(0.99, sum by (le, phase, target, operation) (increase(operation_corrected_duration_milliseconds_bucket%s)))", series)
+	}
+
+	type key struct{ phase, target, operation string }
+	summaries := map[key]*OperationSummary{}
+	for name, query := range queries {
+		value, _, err := prom.Query(ctx, query, end)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query prometheus for %s", name)
+		}
+		vector, ok := value.(model.Vector)
+		if !ok {
+			return nil, fmt.Errorf("prometheus returned a %s for %s, expected a vector", value.Type(), name)
+		}
+
+		for _, sample := range vector {
+			k := key{string(sample.Metric["phase"]), string(sample.Metric["target"]), string(sample.Metric["operation"])}
+			if summaries[k] == nil {
+				summaries[k] = &OperationSummary{Phase: k.phase, Target: k.target, Operation: k.operation}
+			}
+			summary := summaries[k]
+			switch name {
+			case "attempts":
+				summary.Attempts = uint64(sample.Value)
+			case "failures":
+				summary.Failures = uint64(sample.Value)
+			case "timeouts":
+				summary.Timeouts = uint64(sample.Value)
+			case "unexpected":
+				summary.Unexpected = uint64(sample.Value)
+			case "p50":
+				summary.P50 = float64(sample.Value)
+			case "p99":
+				summary.P99 = float64(sample.Value)
+			case "correctedP99":
+				summary.CorrectedP99 = float64(sample.Value)
+			}
+		}
+	}
+
+	var result []OperationSummary
+	for _, summary := range summaries {
+		if summary.Attempts > 0 {
+			result = append(result, *summary)
+		}
+	}
+	sortSummaries(result)
+	return result, nil
+}
+
+// sortSummaries orders summaries by phase, target and operation.
+func sortSummaries(summaries []OperationSummary) {
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Phase != b.Phase {
+			return a.Phase < b.Phase
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Operation < b.Operation
+	})
+}
@@ -0,0 +1,148 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	ScanConsistencyNotBounded  = "not_bounded"
+	ScanConsistencyRequestPlus = "request_plus"
+)
+
+// QuerySettings control how the query operations of every workload are executed, so that the
+// behaviour of the query engine can be compared under the same load.
+type QuerySettings struct {
+	// Adhoc plans every query afresh, rather than preparing it once and reusing the plan
+	Adhoc bool
+	// ScanConsistency is not_bounded or request_plus
+	ScanConsistency string
+	// MaxParallelism caps the parallelism of each query, or zero for the server default
+	MaxParallelism int
+	// ExpectedRows are the numbers of rows the queries of each operation are expected to return
+	ExpectedRows map[string]RowExpectation
+}
+
+// A RowExpectation bounds the number of rows a query is expected to return, with a negative Max
+// for no upper bound.
+type RowExpectation struct {
+	Min int
+	Max int
+}
+
+// Met returns whether rows is within the expected bounds.
+func (e RowExpectation) Met(rows int) bool {
+	return rows >= e.Min && (e.Max < 0 || rows <= e.Max)
+}
+
+// ParseRowExpectations parses comma separated expectations of the form <operation>>=<n>,
+// <operation><=<n> or <operation>=<n>, such as findProfile>=1.  Bounds given for the same
+// operation are combined, so findProfile>=1,findProfile<=10 expects between one and ten rows.
+func ParseRowExpectations(spec string) (map[string]RowExpectation, error) {
+	expectations := map[string]RowExpectation{}
+	if spec == "" {
+		return expectations, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		i := strings.IndexAny(part, "<>=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid row expectation %s, expected <operation>>=<n>, <operation><=<n> or <operation>=<n>", part)
+		}
+		operation, bound := part[:i], part[i:]
+		comparison := bound[:1]
+		if strings.HasPrefix(bound, ">=") || strings.HasPrefix(bound, "<=") {
+			comparison = bound[:2]
+		}
+		n, err := strconv.Atoi(bound[len(comparison):])
+		if err != nil || n < 0 || comparison == "<" || comparison == ">" {
+			return nil, fmt.Errorf("invalid row expectation %s, expected <operation>>=<n>, <operation><=<n> or <operation>=<n>", part)
+		}
+
+		expectation, ok := expectations[operation]
+		if !ok {
+			expectation = RowExpectation{Max: -1}
+		}
+		switch comparison {
+		case ">=":
+			expectation.Min = n
+		case "<=":
+			expectation.Max = n
+		case "=":
+			expectation.Min, expectation.Max = n, n
+		}
+		expectations[operation] = expectation
+	}
+	return expectations, nil
+}
+
+// Queries are the settings used by every query operation.
+var Queries = QuerySettings{Adhoc: true, ScanConsistency: ScanConsistencyNotBounded}
+
+// Validate checks that the settings are ones the query service understands.
+func (q QuerySettings) Validate() error {
+	switch q.ScanConsistency {
+	case ScanConsistencyNotBounded, ScanConsistencyRequestPlus:
+	default:
+		return fmt.Errorf("unknown scan consistency %s, expected %s or %s", q.ScanConsistency, ScanConsistencyNotBounded, ScanConsistencyRequestPlus)
+	}
+	if q.MaxParallelism < 0 {
+		return fmt.Errorf("max parallelism %d must not be negative", q.MaxParallelism)
+	}
+	for operation, expectation := range q.ExpectedRows {
+		if expectation.Max >= 0 && expectation.Max < expectation.Min {
+			return fmt.Errorf("%s is expected to return at least %d rows and at most %d", operation, expectation.Min, expectation.Max)
+		}
+	}
+	return nil
+}
+
+// SDKOptions returns the SDK options for a query with the given named parameters.
+func (q QuerySettings) SDKOptions(ctx context.Context, params map[string]interface{}) *gocb.QueryOptions {
+	opts := &gocb.QueryOptions{
+		Context:         ctx,
+		NamedParameters: params,
+		Adhoc:           q.Adhoc,
+		ScanConsistency: gocb.QueryScanConsistencyNotBounded,
+		MaxParallelism:  uint32(q.MaxParallelism),
+		Metrics:         true,
+	}
+	if q.ScanConsistency == ScanConsistencyRequestPlus {
+		opts.ScanConsistency = gocb.QueryScanConsistencyRequestPlus
+	}
+	return opts
+}
+
+// RESTParams returns the parameters of a request to the query REST API, which prepares and reuses
+// the plan of each statement automatically unless queries are adhoc.
+func (q QuerySettings) RESTParams() map[string]interface{} {
+	params := map[string]interface{}{
+		"scan_consistency": q.ScanConsistency,
+	}
+	if !q.Adhoc {
+		params["auto_prepare"] = true
+	}
+	if q.MaxParallelism > 0 {
+		params["max_parallelism"] = q.MaxParallelism
+	}
+	return params
+}
+
+// ObserveRows records the number of rows a query of the running operation returned, and counts
+// the query as returning unexpected results if the number is outside that expected of the
+// operation.  Unexpected results do not fail the operation, so that a query that silently returns
+// nothing, such as one against the wrong keyspace, shows up without distorting the failure rate.
+func (r Runctx) ObserveRows(rows int) {
+	queryRows.WithLabelValues(r.operation, r.phase, r.target).Observe(float64(rows))
+	expectation, ok := Queries.ExpectedRows[r.operation]
+	if !ok || expectation.Met(rows) {
+		return
+	}
+	queryUnexpected.WithLabelValues(r.phase, r.target, r.operation).Inc()
+	r.Logger().Debug("Query returned an unexpected number of rows", zap.String("operation", r.operation), zap.Int("rows", rows), zap.Int("min", expectation.Min), zap.Int("max", expectation.Max))
+}
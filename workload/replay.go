@@ -0,0 +1,78 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Replay runs the operations recorded in a trace file against the workload.  Each recorded runner
+// executes its operations in order, using the recorded keys, at the same offset from the start of
+// the run as when it was recorded.  Runners authenticate as the given identities, if any.
+func Replay(w Workload, tracePath string, identities []Identity) error {
+	runners, err := readTrace(tracePath)
+	if err != nil {
+		return err
+	}
+
+	functions := w.Functions()
+	metrics := map[string]operationMetrics{}
+	for _, records := range runners {
+		for _, rec := range records {
+			if _, ok := functions[rec.Operation]; !ok {
+				return fmt.Errorf("trace contains operation %s, which workload does not have", rec.Operation)
+			}
+			if _, ok := metrics[rec.Phase]; !ok {
+				metrics[rec.Phase] = newOperationMetrics(w.Operations(), rec.Phase, "")
+			}
+		}
+	}
+
+	ctx, cancelFn := signalContext(context.Background())
+	defer cancelFn()
+	defer summariseErrors()()
+
+	zap.L().Info("Replaying trace", zap.String("trace", tracePath), zap.Int("runners", len(runners)))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(len(runners))
+	for runnerId, records := range runners {
+		go replayLoop(ctx, functions, metrics, records, start, identityFor(identities, runnerId), runnerId, &wg)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func replayLoop(
+	ctx context.Context,
+	functions map[string]func(context.Context, Runctx) error,
+	metrics map[string]operationMetrics,
+	records []TraceRecord,
+	start time.Time,
+	identity *Identity,
+	runnerId int,
+	wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	activeUsers.Inc()
+	defer activeUsers.Dec()
+
+	runCtx := newRunctx(runnerId, "", identity)
+
+	for _, rec := range records {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start.Add(rec.Offset))):
+		}
+
+		runCtx.keys = &opKeys{replay: rec.Keys}
+		runCtx.phase = rec.Phase
+		executeOperation(ctx, metrics[rec.Phase], functions, rec.Operation, start.Add(rec.Offset), runCtx)
+	}
+}
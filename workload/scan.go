@@ -0,0 +1,89 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ScanSize is the distribution of the number of documents read by each KV range scan.
+type ScanSize struct {
+	// Distribution is one of fixed, uniform or exponential
+	Distribution string
+	// Min is the size of a fixed scan, and the smallest size of a uniform one
+	Min int
+	// Max is the largest size of a uniform scan
+	Max int
+	// Mean is the average size of an exponential scan
+	Mean int
+}
+
+// DefaultScanSize is a scan of between 10 and 1000 documents, chosen uniformly.
+var DefaultScanSize = ScanSize{Distribution: "uniform", Min: 10, Max: 1000}
+
+// ParseScanSize parses a scan size given as one of:
+//
+//	fixed:<documents>
+//	uniform:<min documents>-<max documents>
+//	exponential:<mean documents>
+func ParseScanSize(spec string) (ScanSize, error) {
+	distribution, params, _ := strings.Cut(spec, ":")
+
+	var err error
+	s := ScanSize{Distribution: distribution}
+	switch distribution {
+	case "fixed":
+		s.Min, err = strconv.Atoi(params)
+	case "uniform":
+		min, max, ok := strings.Cut(params, "-")
+		if !ok {
+			return ScanSize{}, fmt.Errorf("uniform scan size %s must be given as uniform:<min>-<max>", spec)
+		}
+		s.Min, err = strconv.Atoi(min)
+		if err == nil {
+			s.Max, err = strconv.Atoi(max)
+		}
+		if err == nil && s.Max < s.Min {
+			err = fmt.Errorf("maximum is less than the minimum")
+		}
+	case "exponential":
+		s.Mean, err = strconv.Atoi(params)
+	default:
+		return ScanSize{}, fmt.Errorf("unknown scan size distribution %s, expected fixed, uniform or exponential", distribution)
+	}
+	if err == nil && (s.Min < 0 || s.Mean < 0) {
+		err = fmt.Errorf("sizes must not be negative")
+	}
+	if err != nil {
+		return ScanSize{}, errors.Wrapf(err, "invalid scan size %s", spec)
+	}
+
+	return s, nil
+}
+
+// Sample returns the number of documents the next scan should read, which is always at least one.
+func (s ScanSize) Sample(r *rand.Rand) int {
+	var size int
+	switch s.Distribution {
+	case "fixed":
+		size = s.Min
+	case "uniform":
+		size = s.Min + r.Intn(s.Max-s.Min+1)
+	case "exponential":
+		size = int(r.ExpFloat64() * float64(s.Mean))
+	}
+	return max(size, 1)
+}
+
+// ObserveScan records the number of documents read by a scan, and how long it took for the first
+// of them to arrive, in the scan metrics of the operation.
+func (r Runctx) ObserveScan(operation string, items int, firstItem time.Duration) {
+	scanItems.WithLabelValues(operation, r.phase, r.target).Observe(float64(items))
+	if items > 0 {
+		scanFirstItem.WithLabelValues(operation, r.phase, r.target).Observe(float64(firstItem.Microseconds()) / 1000)
+	}
+}
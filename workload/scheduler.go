@@ -0,0 +1,313 @@
+package workload
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// DefaultWorkers is how many operations may run at once across all the users of a phase.  Users
+// spend most of their time thinking, so far fewer workers than users are needed.
+const DefaultWorkers = 1000
+
+// lagWarning is how far behind its schedule a user may fall before the workers are reported as
+// too few for the users.
+const lagWarning = 100 * time.Millisecond
+
+// A virtualUser is the state of a simulated user between its operations, which is run by
+// whichever worker is free when its next operation is due.
+type virtualUser struct {
+	phase     *phaseRun
+	id        int
+	r         *rand.Rand
+	runCtx    Runctx
+	thinkTime func(r *rand.Rand, operation string) time.Duration
+	users     prometheus.Gauge
+	// regular users, unlike idle users, are counted against the user limit of the phase
+	regular bool
+
+	startAt time.Time
+	stopAt  time.Time
+	started bool
+
+	// currOpIndex is the position of the user in the markov chain, and nextOpIndex the operation
+	// it runs when it is next due
+	currOpIndex int
+	nextOpIndex int
+	due         time.Time
+
+	// stop fires at the end of the run of the user, while it waits for the throughput limit.  It
+	// is one timer reset for each operation, rather than a timer each.
+	stop *time.Timer
+}
+
+func newVirtualUser(phase *phaseRun, id int, start time.Time, startAfter time.Duration, stopAfter time.Duration, thinkTime func(r *rand.Rand, operation string) time.Duration, users prometheus.Gauge) *virtualUser {
+	return &virtualUser{
+		phase:     phase,
+		id:        id,
+		thinkTime: thinkTime,
+		users:     users,
+		startAt:   start.Add(startAfter),
+		stopAt:    start.Add(stopAfter),
+		due:       start.Add(startAfter),
+	}
+}
+
+// begin connects the user at the start of its run.
+func (u *virtualUser) begin() {
+	u.started = true
+	u.users.Inc()
+	zap.L().Sugar().Debugf("Starting runner %d…", u.id)
+
+	u.runCtx = newRunctx(u.id, u.phase.name, identityFor(u.phase.identities, u.id))
+	u.runCtx.target = u.phase.target
+	u.r = u.runCtx.r
+}
+
+// end disconnects the user at the end of its run.
+func (u *virtualUser) end() {
+	if u.started {
+		u.users.Dec()
+	}
+	if u.regular {
+		u.phase.users.stopped(u.id)
+	}
+	if u.stop != nil {
+		u.stop.Stop()
+	}
+}
+
+// plan chooses the next operation of the user, and schedules it after the think time.
+func (u *virtualUser) plan() {
+	u.nextOpIndex = getNextOperation(u.currOpIndex, *u.phase.probabilities.Load(), u.r)
+	u.due = time.Now().Add(u.thinkTime(u.r, u.phase.operations[u.nextOpIndex]))
+}
+
+// run performs the next operation of the user.
+func (u *virtualUser) run(ctx context.Context) {
+	phase := u.phase
+	operation := phase.operations[u.nextOpIndex]
+	defer func() {
+		u.currOpIndex = u.nextOpIndex
+	}()
+
+	// hold back if the phase is limited to a target throughput, in which case the operation is
+	// intended for the slot the limit scheduled it in rather than when the user was ready, so that
+	// a late wake up still counts against its latency
+	intended := u.due
+	if limiter := phase.limiter.Load(); limiter != nil {
+		slot, reached := limiter.next()
+		if u.stop == nil {
+			u.stop = time.NewTimer(time.Until(u.stopAt))
+		} else {
+			if !u.stop.Stop() {
+				select {
+				case <-u.stop.C:
+				default:
+				}
+			}
+			u.stop.Reset(time.Until(u.stopAt))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-u.stop.C:
+			return
+		case <-reached:
+		}
+		intended = slot
+	}
+
+	phase.metrics.transition(phase.operations[u.currOpIndex], operation)
+
+	// an operation that has used up its error budget is passed over
+	if phase.breaker != nil && !phase.breaker.allowed(operation) {
+		return
+	}
+
+	if phase.recorder != nil {
+		u.runCtx.keys = &opKeys{}
+	}
+
+	start := time.Now()
+	err := executeOperation(ctx, phase.metrics, phase.functions, operation, intended, u.runCtx)
+	if phase.breaker != nil {
+		phase.breaker.record(operation, err)
+	}
+
+	if phase.recorder != nil {
+		phase.recorder.record(TraceRecord{
+			Runner:    u.id,
+			Phase:     phase.name,
+			Operation: operation,
+			Keys:      u.runCtx.keys.recorded(),
+			Offset:    start.Sub(phase.recorder.start),
+		})
+	}
+}
+
+// delay moves the schedule of a user later, such as by the time the run was paused for.
+func (u *virtualUser) delay(by time.Duration) {
+	u.startAt = u.startAt.Add(by)
+	u.stopAt = u.stopAt.Add(by)
+	u.due = u.due.Add(by)
+}
+
+// userQueue orders users by when their next operation is due.
+type userQueue []*virtualUser
+
+func (q userQueue) Len() int           { return len(q) }
+func (q userQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q userQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *userQueue) Push(x any)        { *q = append(*q, x.(*virtualUser)) }
+func (q *userQueue) Pop() any {
+	old := *q
+	u := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return u
+}
+
+// runUsers runs the users of a phase until each reaches the end of its run, multiplexing their
+// operations over a bounded number of workers rather than a goroutine for each user.  Users
+// received from add join the phase.  While the run is paused no operations are started, and
+// once it resumes every user carries on later by the time it was paused for.
+func runUsers(ctx context.Context, phaseName string, users []*virtualUser, workers int, add <-chan []*virtualUser) {
+	queue := make(userQueue, len(users))
+	copy(queue, users)
+	heap.Init(&queue)
+
+	work := make(chan *virtualUser)
+	// Big enough that a worker never waits to hand back a user, however many users join
+	done := make(chan *virtualUser, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for u := range work {
+				u.run(ctx)
+				done <- u
+			}
+		}()
+	}
+	defer close(work)
+
+	inFlight := 0
+	warned := false
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	// requeue plans the next operation of a user, unless its run is over or the phase has fewer
+	// users now.
+	requeue := func(u *virtualUser) {
+		if ctx.Err() == nil && (!u.regular || u.phase.users.keep(u.id)) {
+			u.plan()
+			if u.due.Before(u.stopAt) {
+				heap.Push(&queue, u)
+				return
+			}
+		}
+		u.end()
+	}
+
+	// pause holds back every user until the run is resumed.  Users finishing the operations in
+	// flight are set aside, as their next operations are planned from when the run resumes.
+	pause := func(resumed <-chan struct{}) {
+		began := time.Now()
+		var finished []*virtualUser
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				waiting = false
+			case <-resumed:
+				waiting = false
+			case u := <-done:
+				inFlight--
+				finished = append(finished, u)
+			case added := <-add:
+				for _, u := range added {
+					heap.Push(&queue, u)
+				}
+			}
+		}
+
+		// Delaying every user alike keeps the queue in order
+		paused := time.Since(began)
+		for _, u := range queue {
+			u.delay(paused)
+		}
+		for _, u := range finished {
+			u.delay(paused)
+			requeue(u)
+		}
+	}
+
+	for queue.Len() > 0 || inFlight > 0 {
+		pausing, resumed := currentPause.channels()
+		var next *virtualUser
+		var wait <-chan time.Time
+		if queue.Len() > 0 && ctx.Err() == nil {
+			next = queue[0]
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(next.due))
+			wait = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			// Let the operations in flight finish, then stop every user
+			for ; inFlight > 0; inFlight-- {
+				(<-done).end()
+			}
+			for _, u := range queue {
+				u.end()
+			}
+			return
+		case u := <-done:
+			inFlight--
+			requeue(u)
+		case added := <-add:
+			for _, u := range added {
+				heap.Push(&queue, u)
+			}
+		case <-pausing:
+			pause(resumed)
+		case <-wait:
+			heap.Pop(&queue)
+			if !next.started {
+				// Users ramped down before they are ramped up never start, but still give up their place
+				if !next.startAt.Before(next.stopAt) {
+					next.end()
+					continue
+				}
+				// The first operation of a user is chosen when it starts
+				next.begin()
+				requeue(next)
+				continue
+			}
+
+			if lag := time.Since(next.due); lag > lagWarning && !warned {
+				warned = true
+				zap.L().Warn("Every worker is busy, so users are running behind their think times", zap.String("phase", phaseName), zap.Int("workers", workers), zap.Duration("lag", lag))
+			}
+
+			select {
+			case work <- next:
+				inFlight++
+			case u := <-done:
+				// No worker was free, so hand the user back to the queue to wait its turn
+				inFlight--
+				heap.Push(&queue, next)
+				requeue(u)
+			case <-ctx.Done():
+				heap.Push(&queue, next)
+			}
+		}
+	}
+}
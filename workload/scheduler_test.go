@@ -0,0 +1,83 @@
+package workload
+
+import (
+	"context"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRunctxSharedAcrossGoroutines runs users whose operations fan out over several goroutines,
+// each using the state, keys and results of the runner through its own copy of the Runctx, for the race
+// detector to check that they are safe to share:
+//
+//	go test -race ./workload
+func TestRunctxSharedAcrossGoroutines(t *testing.T) {
+	const (
+		users  = 20
+		fanOut = 8
+	)
+
+	recorder, err := NewTraceRecorder(filepath.Join(t.TempDir(), "trace.ndjson"))
+	if err != nil {
+		t.Fatalf("failed to create trace recorder: %s", err)
+	}
+	defer recorder.Close()
+
+	var calls atomic.Int64
+	functions := map[string]func(context.Context, Runctx) error{
+		"fanOut": func(ctx context.Context, rctx Runctx) error {
+			calls.Add(1)
+			var wg sync.WaitGroup
+			for i := 0; i < fanOut; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					key := rctx.Key(strconv.Itoa(i))
+					rctx.Set(key, i)
+					if value, ok := State[int](rctx, key); !ok || value != i {
+						t.Errorf("state %s is %d, %t after setting it to %d", key, value, ok, i)
+					}
+					if _, ok := rctx.Get("shared"); !ok {
+						rctx.Set("shared", rctx.Rand().Int())
+					}
+					rctx.Delete(key)
+					PreviousResult[int](rctx)
+					rctx.SetResult(i)
+				}(i)
+			}
+			wg.Wait()
+			return nil
+		},
+	}
+
+	operations := []string{"fanOut"}
+	phase := &phaseRun{
+		name:       "race",
+		functions:  functions,
+		operations: operations,
+		metrics:    newOperationMetrics(operations, "race", ""),
+		users:      newUserLimit(users, users),
+		recorder:   recorder,
+	}
+	probabilities := [][]float64{{1}}
+	phase.probabilities.Store(&probabilities)
+
+	thinkTime := func(r *rand.Rand, operation string) time.Duration { return time.Millisecond }
+	start := time.Now()
+	var runners []*virtualUser
+	for i := 0; i < users; i++ {
+		u := newVirtualUser(phase, i, start, 0, 200*time.Millisecond, thinkTime, activeUsers)
+		u.regular = true
+		runners = append(runners, u)
+	}
+	runUsers(context.Background(), phase.name, runners, 4, nil)
+
+	if calls.Load() == 0 {
+		t.Fatalf("no operations ran")
+	}
+}
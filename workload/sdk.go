@@ -0,0 +1,173 @@
+package workload
+
+import (
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Names of the spans, attributes and metrics the SDK reports.
+const (
+	sdkSpanDispatch          = "dispatch_to_server"
+	sdkAttribService         = "db.couchbase.service"
+	sdkAttribServerDuration  = "db.couchbase.server_duration"
+	sdkMetricOperations      = "db.couchbase.operations"
+	sdkMetricAttribOperation = "db.operation"
+)
+
+// SDKTracer returns a tracer that records how long the SDK spent dispatching requests to the
+// cluster, and how long the cluster reported spending on them, passing every span on to inner,
+// such as a threshold logging tracer.  Together with the duration of SDK operations recorded by
+// SDKMeter, they show how much of the latency of an operation was spent queued in the client.
+func SDKTracer(inner gocb.RequestTracer) gocb.RequestTracer {
+	return sdkTracer{inner: inner}
+}
+
+type sdkTracer struct {
+	inner gocb.RequestTracer
+}
+
+func (t sdkTracer) RequestSpan(parentContext gocb.RequestSpanContext, operationName string) gocb.RequestSpan {
+	var parent *sdkSpan
+	if ctx, ok := parentContext.(sdkSpanContext); ok {
+		parent = ctx.span
+		parentContext = ctx.span.inner.Context()
+	}
+	return &sdkSpan{
+		inner:  t.inner.RequestSpan(parentContext, operationName),
+		name:   operationName,
+		parent: parent,
+		start:  time.Now(),
+	}
+}
+
+// AddRef and DecRef pass on the references the SDK counts to the inner tracer, which the
+// threshold logging tracer uses to start and stop logging.
+func (t sdkTracer) AddRef() int32 {
+	if tracer, ok := t.inner.(interface{ AddRef() int32 }); ok {
+		return tracer.AddRef()
+	}
+	return 0
+}
+
+func (t sdkTracer) DecRef() int32 {
+	if tracer, ok := t.inner.(interface{ DecRef() int32 }); ok {
+		return tracer.DecRef()
+	}
+	return 0
+}
+
+type sdkSpanContext struct {
+	span *sdkSpan
+}
+
+type sdkSpan struct {
+	inner  gocb.RequestSpan
+	name   string
+	parent *sdkSpan
+	start  time.Time
+
+	mu             sync.Mutex
+	service        string
+	serverDuration time.Duration
+}
+
+func (s *sdkSpan) Context() gocb.RequestSpanContext {
+	return sdkSpanContext{span: s}
+}
+
+func (s *sdkSpan) AddEvent(name string, timestamp time.Time) {
+	s.inner.AddEvent(name, timestamp)
+}
+
+func (s *sdkSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	switch key {
+	case sdkAttribService:
+		s.service, _ = value.(string)
+	case sdkAttribServerDuration:
+		s.serverDuration, _ = value.(time.Duration)
+	}
+	s.mu.Unlock()
+	s.inner.SetAttribute(key, value)
+}
+
+// End records the durations of dispatches, labelled with the service of the operation they were
+// dispatched for.
+func (s *sdkSpan) End() {
+	s.inner.End()
+	if s.name != sdkSpanDispatch {
+		return
+	}
+	service := "unknown"
+	for span := s; span != nil; span = span.parent {
+		span.mu.Lock()
+		found := span.service
+		span.mu.Unlock()
+		if found != "" {
+			service = found
+			break
+		}
+	}
+
+	sdkDispatchDuration.WithLabelValues(service).Observe(float64(time.Since(s.start).Microseconds()) / 1000)
+	s.mu.Lock()
+	serverDuration := s.serverDuration
+	s.mu.Unlock()
+	if serverDuration > 0 {
+		sdkServerDuration.WithLabelValues(service).Observe(float64(serverDuration.Microseconds()) / 1000)
+	}
+}
+
+// SDKMeter returns a meter that records the duration of each operation the SDK performs,
+// labelled with its service and the name the SDK gives it, such as get or query.
+func SDKMeter() gocb.Meter {
+	return sdkMeter{NoopMeter: &gocb.NoopMeter{}}
+}
+
+type sdkMeter struct {
+	*gocb.NoopMeter
+}
+
+func (m sdkMeter) ValueRecorder(name string, tags map[string]string) (gocb.ValueRecorder, error) {
+	if name != sdkMetricOperations {
+		return m.NoopMeter.ValueRecorder(name, tags)
+	}
+	return sdkOperationRecorder{
+		observer: sdkOperationDuration.WithLabelValues(tags[sdkAttribService], tags[sdkMetricAttribOperation]),
+	}, nil
+}
+
+type sdkOperationRecorder struct {
+	observer prometheus.Observer
+}
+
+// RecordValue records a duration, which the SDK gives in microseconds.
+func (r sdkOperationRecorder) RecordValue(val uint64) {
+	r.observer.Observe(float64(val) / 1000)
+}
+
+// SDKLogger returns a logger that passes on the warnings and reports the SDK logs, such as those
+// of operations over the threshold logging thresholds and of responses orphaned by timeouts, to
+// the log of spectroperf.
+func SDKLogger() gocb.Logger {
+	return sdkLogger{}
+}
+
+type sdkLogger struct{}
+
+func (l sdkLogger) Log(level gocb.LogLevel, offset int, format string, v ...interface{}) error {
+	logger := zap.L().Sugar().Named("gocb")
+	switch level {
+	case gocb.LogError:
+		logger.Errorf(format, v...)
+	case gocb.LogWarn:
+		logger.Warnf(format, v...)
+	case gocb.LogInfo:
+		logger.Infof(format, v...)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package workload
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// saturatedCPU is the fraction of the CPUs of the machine spectroperf may use before it is
+	// considered saturated, when operations queue for the CPU rather than the cluster.
+	saturatedCPU = 0.9
+	// saturatedGC is the fraction of time that may be spent paused for garbage collection
+	saturatedGC = 0.05
+	// saturatedFDs is the fraction of the open file limit that may be in use
+	saturatedFDs = 0.9
+	// saturatedSchedule is the fraction of operations that may start more than lagWarning behind
+	// their schedule, when load is generated more slowly than it was asked for.
+	saturatedSchedule = 0.01
+)
+
+// Remediation hints logged with warnings that spectroperf is saturated.
+const (
+	hintCPU      = "run fewer users on each client machine, or spread the run across more machines"
+	hintGC       = "use smaller documents, or run fewer users on each client machine"
+	hintFDs      = "raise the open file limit, or use fewer connections"
+	hintSchedule = "raise --workers, or lengthen the think time and add users to keep the same throughput"
+	hintSDKQueue = "lengthen the think time or run fewer users on each client machine, as the SDK cannot dispatch operations as fast as they start"
+)
+
+// clientSaturated is set while spectroperf appears to be limiting the run itself.
+var clientSaturated = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "client_saturated",
+		Help: "Whether spectroperf itself appears saturated, partitioned by resource.",
+	},
+	[]string{"resource"},
+)
+
+// ClientStatsInterval is how often the resource usage of spectroperf is logged, to follow its
+// memory and goroutines over a long run, or 0 to never log it.
+var ClientStatsInterval time.Duration
+
+// generatorBacklog counts the operations started since the client was last checked, those that
+// started late, and those the SDK refused because its queues were full.
+var generatorBacklog struct {
+	started  atomic.Int64
+	late     atomic.Int64
+	overload atomic.Int64
+}
+
+// observeBacklog records whether an operation started late, or was refused by the SDK, so that a
+// load generator falling behind is not mistaken for the cluster slowing down.
+func observeBacklog(lag time.Duration, err error) {
+	generatorBacklog.started.Add(1)
+	if lag > lagWarning {
+		generatorBacklog.late.Add(1)
+	}
+	if errors.Is(err, gocb.ErrOverload) {
+		generatorBacklog.overload.Add(1)
+	}
+}
+
+// clientUsage is the resource usage of spectroperf at a point in the run.
+type clientUsage struct {
+	at        time.Time
+	cpu       float64
+	gcPause   time.Duration
+	fds       float64
+	maxFDs    float64
+	heapBytes uint64
+	sysBytes  uint64
+}
+
+func currentUsage() clientUsage {
+	usage := clientUsage{at: time.Now()}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	usage.gcPause = time.Duration(mem.PauseTotalNs)
+	usage.heapBytes = mem.HeapAlloc
+	usage.sysBytes = mem.Sys
+
+	// The process collector only reports CPU and file descriptors on some platforms, where they
+	// are missing they are not checked.
+	families, err := registry.Gather()
+	if err != nil {
+		return usage
+	}
+	for _, family := range families {
+		if len(family.GetMetric()) == 0 {
+			continue
+		}
+		metric := family.GetMetric()[0]
+		switch family.GetName() {
+		case "process_cpu_seconds_total":
+			usage.cpu = metric.GetCounter().GetValue()
+		case "process_open_fds":
+			usage.fds = metric.GetGauge().GetValue()
+		case "process_max_fds":
+			usage.maxFDs = metric.GetGauge().GetValue()
+		}
+	}
+	return usage
+}
+
+// MonitorClient checks the resource usage of spectroperf once every interval until the context is
+// done, logging a warning whenever it appears saturated, so that the client is not mistaken for a
+// bottleneck in the cluster.  Operations starting behind their schedule, or refused by the SDK,
+// show it generating less load than asked for.  The usage itself is exported with the metrics of
+// the run.
+func MonitorClient(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := currentUsage()
+	logged := last.at
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		usage := currentUsage()
+		elapsed := usage.at.Sub(last.at)
+
+		cpu := (usage.cpu - last.cpu) / elapsed.Seconds() / float64(runtime.NumCPU())
+		gc := float64(usage.gcPause-last.gcPause) / float64(elapsed)
+		fds := 0.0
+		if usage.maxFDs > 0 {
+			fds = usage.fds / usage.maxFDs
+		}
+		last = usage
+
+		started := generatorBacklog.started.Swap(0)
+		late := generatorBacklog.late.Swap(0)
+		overload := generatorBacklog.overload.Swap(0)
+		schedule := 0.0
+		if started > 0 {
+			schedule = float64(late) / float64(started)
+		}
+
+		checkSaturation("cpu", cpu, saturatedCPU, hintCPU, zap.Int("goroutines", runtime.NumGoroutine()))
+		checkSaturation("gc", gc, saturatedGC, hintGC, zap.Uint64("heapBytes", usage.heapBytes))
+		checkSaturation("fds", fds, saturatedFDs, hintFDs, zap.Float64("openFDs", usage.fds))
+		checkSaturation("schedule", schedule, saturatedSchedule, hintSchedule, zap.Int64("lateOperations", late))
+		checkSaturation("sdk_queue", float64(overload), 0, hintSDKQueue, zap.Int64("refusedOperations", overload))
+
+		if ClientStatsInterval > 0 && usage.at.Sub(logged) >= ClientStatsInterval {
+			logged = usage.at
+			zap.L().Info("Client stats", zap.Int("goroutines", runtime.NumGoroutine()), zap.Uint64("heapBytes", usage.heapBytes),
+				zap.Uint64("sysBytes", usage.sysBytes), zap.Float64("cpu", cpu), zap.Float64("gc", gc), zap.Float64("openFDs", usage.fds))
+		}
+	}
+}
+
+func checkSaturation(resource string, used float64, limit float64, hint string, detail zap.Field) {
+	if used <= limit {
+		clientSaturated.WithLabelValues(resource).Set(0)
+		return
+	}
+	clientSaturated.WithLabelValues(resource).Set(1)
+	zap.L().Warn("spectroperf is saturated, latency may be limited by the client rather than the cluster",
+		zap.String("resource", resource), zap.Float64("used", used), zap.Float64("limit", limit), detail, zap.String("hint", hint))
+}
@@ -0,0 +1,105 @@
+package workload
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// statsdPacketSize is the size a batch of statsd metrics is sent at, small enough to fit in a
+	// single UDP packet on most networks.
+	statsdPacketSize = 1432
+	// statsdFlushInterval is the longest a metric waits in a batch before it is sent
+	statsdFlushInterval = 100 * time.Millisecond
+)
+
+// A StatsdSink sends the operation metrics of the run to a statsd server, in the DogStatsD format
+// with the labels of each metric as tags, for observability stacks such as Datadog.
+type StatsdSink struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// statsd is the sink operation metrics are sent to, or nil to only record them for Prometheus.
+var statsd *StatsdSink
+
+// UseStatsd sends the operation metrics of the run to the statsd server at addr, as well as
+// recording them for the summary of the run.  Every metric name is prefixed with prefix and tagged
+// with tags, given as name:value pairs.
+func UseStatsd(addr string, prefix string, tags []string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to statsd")
+	}
+
+	statsd = &StatsdSink{conn: conn, prefix: prefix, tags: strings.Join(tags, ",")}
+	registerMetrics()
+	// There are no metrics to serve, but the profiler is still served if it is enabled
+	if Pprof {
+		serveMetrics()
+	}
+	go func() {
+		for range time.Tick(statsdFlushInterval) {
+			statsd.flush()
+		}
+	}()
+	return nil
+}
+
+// observeOperation sends the outcome of an operation.
+func (s *StatsdSink) observeOperation(operation string, phase string, target string, duration time.Duration, err error) {
+	tags := "operation:" + operation + ",phase:" + phase
+	if target != "" {
+		tags += ",target:" + target
+	}
+
+	s.send("operations_total", "1", "c", tags)
+	if err != nil {
+		s.send("operations_failed_total", "1", "c", tags)
+	}
+	s.send("operation_duration_milliseconds", strconv.FormatFloat(float64(duration.Microseconds())/1000, 'f', -1, 64), "ms", tags)
+}
+
+func (s *StatsdSink) send(name string, value string, kind string, tags string) {
+	if s.tags != "" {
+		tags = s.tags + "," + tags
+	}
+	line := fmt.Sprintf("%s%s:%s|%s|#%s\n", s.prefix, name, value, kind, tags)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buf.Len()+len(line) > statsdPacketSize {
+		s.write()
+	}
+	s.buf.WriteString(line)
+}
+
+func (s *StatsdSink) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write()
+}
+
+// write sends the batched metrics, and must be called with the lock held.
+func (s *StatsdSink) write() {
+	if s.buf.Len() == 0 {
+		return
+	}
+	_, err := s.conn.Write(bytes.TrimSuffix(s.buf.Bytes(), []byte("\n")))
+	if err != nil {
+		zap.L().Debug("Failed to send metrics to statsd", zap.Error(err))
+	}
+	s.buf.Reset()
+}
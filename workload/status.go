@@ -0,0 +1,184 @@
+package workload
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// States of a run, as reported by the status endpoint.
+const (
+	RunStateStarting = "starting"
+	RunStateSetup    = "setup"
+	RunStateRunning  = "running"
+	RunStatePaused   = "paused"
+	RunStateCoolDown = "cooldown"
+	RunStateTeardown = "teardown"
+	RunStateComplete = "complete"
+	RunStateAborted  = "aborted"
+)
+
+// runStatusThroughputWindow is the shortest time throughput is measured over.
+const runStatusThroughputWindow = time.Second
+
+// RunStatus is the state of the run served as JSON at /status, so that orchestration such as
+// Kubernetes probes or test harnesses can follow and gate on the run.
+type RunStatus struct {
+	RunId      string `json:"runId"`
+	ConfigHash string `json:"configHash"`
+	State      string `json:"state"`
+	// Phase is the phase being run, and PhaseElapsed how long it has run for
+	Phase        string  `json:"phase,omitempty"`
+	PhaseElapsed float64 `json:"phaseElapsedSeconds"`
+	// Elapsed is how long the phases have run for, and Remaining how long until the last ends,
+	// neither counting the time spent Paused
+	Elapsed     float64 `json:"elapsedSeconds"`
+	Remaining   float64 `json:"remainingSeconds"`
+	Paused      float64 `json:"pausedSeconds"`
+	ActiveUsers int     `json:"activeUsers"`
+	// Throughput is the operations per second since the status was last read
+	Throughput float64 `json:"throughput"`
+	Attempts   uint64  `json:"attempts"`
+	Failures   uint64  `json:"failures"`
+}
+
+// runStatus tracks the state of the run between reads of the status.
+type runStatus struct {
+	mu         sync.Mutex
+	status     RunStatus
+	runStart   time.Time
+	runEnd     time.Time
+	phaseStart time.Time
+	// last are the operation totals when throughput was last measured
+	last   operationTotals
+	lastAt time.Time
+}
+
+var currentStatus = &runStatus{status: RunStatus{State: RunStateStarting}}
+
+// SetRunInfo sets the identifiers of the run reported by the status endpoint.
+func SetRunInfo(runId string, configHash string) {
+	currentStatus.mu.Lock()
+	defer currentStatus.mu.Unlock()
+	currentStatus.status.RunId = runId
+	currentStatus.status.ConfigHash = configHash
+}
+
+// SetRunState sets the state of the run reported by the status endpoint.
+func SetRunState(state string) {
+	currentStatus.mu.Lock()
+	defer currentStatus.mu.Unlock()
+	currentStatus.status.State = state
+	if state != RunStateRunning && state != RunStatePaused {
+		currentStatus.status.Phase = ""
+	}
+}
+
+// startRun records that the phases of the run, lasting duration in total, have started.
+func (s *runStatus) startRun(duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runStart = time.Now()
+	s.runEnd = s.runStart.Add(duration)
+	s.status.State = RunStateRunning
+}
+
+// setPaused records that the phases of the run have been paused or resumed.
+func (s *runStatus) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if paused && s.status.State == RunStateRunning {
+		s.status.State = RunStatePaused
+	} else if !paused && s.status.State == RunStatePaused {
+		s.status.State = RunStateRunning
+	}
+}
+
+// startPhase records that a phase of the run has started.
+func (s *runStatus) startPhase(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Phase = name
+	s.phaseStart = time.Now()
+}
+
+// snapshot returns the state and timing of the run now, without measuring its operations.
+func (s *runStatus) snapshot() RunStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timing(time.Now())
+}
+
+// timing returns the state of the run with how far through its phases it is.
+func (s *runStatus) timing(now time.Time) RunStatus {
+	status := s.status
+	if status.Phase != "" {
+		status.PhaseElapsed = (now.Sub(s.phaseStart) - PausedSince(s.phaseStart)).Seconds()
+	}
+	if !s.runStart.IsZero() {
+		paused := PausedSince(s.runStart)
+		status.Elapsed = (now.Sub(s.runStart) - paused).Seconds()
+		status.Remaining = max(s.runEnd.Add(paused).Sub(now), 0).Seconds()
+		status.Paused = paused.Seconds()
+	}
+	return status
+}
+
+// read returns the status of the run now.
+func (s *runStatus) read() RunStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	status := s.timing(now)
+
+	totals, err := clientTotals()
+	if err != nil {
+		zap.L().Warn("Failed to gather client metrics", zap.Error(err))
+	}
+	status.Attempts = totals.attempts
+	status.Failures = totals.failures
+	// Reads in quick succession, such as from several probes, share the last measurement
+	if now.Sub(s.lastAt) >= runStatusThroughputWindow {
+		if !s.lastAt.IsZero() {
+			s.status.Throughput = float64(totals.since(s.last).attempts) / now.Sub(s.lastAt).Seconds()
+		}
+		s.last, s.lastAt = totals, now
+	}
+	status.Throughput = s.status.Throughput
+
+	families, err := registry.Gather()
+	if err == nil {
+		for _, family := range families {
+			if family.GetName() == "active_users" && len(family.GetMetric()) > 0 {
+				status.ActiveUsers = int(family.GetMetric()[0].GetGauge().GetValue())
+			}
+		}
+	}
+	return status
+}
+
+// serveStatus serves the status of the run as JSON.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(currentStatus.read())
+	if err != nil {
+		zap.L().Warn("Failed to write run status", zap.Error(err))
+	}
+}
+
+// serveHealth reports that spectroperf is alive, failing once the run has been aborted.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	currentStatus.mu.Lock()
+	state := currentStatus.status.State
+	currentStatus.mu.Unlock()
+
+	if state == RunStateAborted {
+		http.Error(w, state, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte(state + "\n"))
+}
@@ -0,0 +1,270 @@
+package workload
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// registry holds the metrics of the run, both to expose them to Prometheus and to summarise
+// them at the end of the run without one.
+var registry = prometheus.NewRegistry()
+
+// An OperationSummary is the outcome of an operation during a phase of the run.
+type OperationSummary struct {
+	Phase     string
+	Target    string
+	Operation string
+	Attempts  uint64
+	Failures  uint64
+	// Timeouts are the failures caused by operations running past their deadline
+	Timeouts uint64
+	// Unexpected are the queries that returned a number of rows outside that expected of the
+	// operation, which are soft failures not counted in Failures
+	Unexpected uint64
+	// P50 and P99 are the median and 99th percentile durations in milliseconds, estimated from
+	// the buckets of the duration histogram as Prometheus does
+	P50 float64
+	P99 float64
+	// CorrectedP99 is the 99th percentile duration from when operations were scheduled to start,
+	// which is only recorded when correcting for coordinated omission
+	CorrectedP99 float64
+}
+
+// SummariseOperationMetrics summarises each operation that was attempted in each phase, from the
+// metrics recorded in this process, ordered by phase, target and operation.
+func SummariseOperationMetrics() ([]OperationSummary, error) {
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to gather metrics")
+	}
+
+	type key struct{ phase, target, operation string }
+	summaries := map[key]*OperationSummary{}
+	summaryFor := func(labels map[string]string) *OperationSummary {
+		k := key{labels["phase"], labels["target"], labels["operation"]}
+		if summaries[k] == nil {
+			summaries[k] = &OperationSummary{Phase: k.phase, Target: k.target, Operation: k.operation}
+		}
+		return summaries[k]
+	}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			switch family.GetName() {
+			case "operations_total":
+				summaryFor(labels).Attempts = uint64(metric.GetCounter().GetValue())
+			case "operations_failed_total":
+				summaryFor(labels).Failures = uint64(metric.GetCounter().GetValue())
+			case "operations_timed_out_total":
+				summaryFor(labels).Timeouts = uint64(metric.GetCounter().GetValue())
+			case "query_results_unexpected_total":
+				summaryFor(labels).Unexpected = uint64(metric.GetCounter().GetValue())
+			case "operation_duration_milliseconds", "operation_corrected_duration_milliseconds":
+				bounds, counts := histogramBuckets(metric.GetHistogram())
+				total := metric.GetHistogram().GetSampleCount()
+				summary := summaryFor(labels)
+				if family.GetName() == "operation_corrected_duration_milliseconds" {
+					summary.CorrectedP99 = bucketQuantile(0.99, bounds, counts, total)
+					continue
+				}
+				summary.P50 = bucketQuantile(0.5, bounds, counts, total)
+				summary.P99 = bucketQuantile(0.99, bounds, counts, total)
+			}
+		}
+	}
+
+	var result []OperationSummary
+	for _, summary := range summaries {
+		if summary.Attempts > 0 {
+			result = append(result, *summary)
+		}
+	}
+	sortSummaries(result)
+	return result, nil
+}
+
+// histogramBuckets returns the upper bounds and cumulative counts of the buckets of a histogram,
+// from its native buckets when it has them, which have the finer resolution.
+func histogramBuckets(h *dto.Histogram) ([]float64, []uint64) {
+	if len(h.GetPositiveSpan()) == 0 && h.GetZeroCount() == 0 {
+		return histogramBounds(h.GetBucket()), histogramCounts(h.GetBucket())
+	}
+
+	// Native bucket i holds the observations above base^(i-1) up to base^i, and the counts of the
+	// buckets are given as the difference from the bucket before.  Durations are never negative,
+	// so only the zero bucket and positive buckets are used.
+	base := math.Pow(2, math.Pow(2, -float64(h.GetSchema())))
+	bounds := []float64{h.GetZeroThreshold()}
+	counts := []uint64{h.GetZeroCount()}
+	cumulative := h.GetZeroCount()
+	var index int32
+	var count int64
+	deltas := h.GetPositiveDelta()
+	for _, span := range h.GetPositiveSpan() {
+		index += span.GetOffset()
+		for i := uint32(0); i < span.GetLength() && len(deltas) > 0; i++ {
+			count += deltas[0]
+			deltas = deltas[1:]
+			cumulative += uint64(count)
+			bounds = append(bounds, math.Pow(base, float64(index)))
+			counts = append(counts, cumulative)
+			index++
+		}
+	}
+	return bounds, counts
+}
+
+// bucketQuantile estimates a quantile from the cumulative counts of histogram buckets, by linear
+// interpolation within the bucket it falls in, as histogram_quantile does.  Observations above
+// the highest bucket are reported as its upper bound.
+func bucketQuantile(q float64, bounds []float64, counts []uint64, total uint64) float64 {
+	if total == 0 {
+		return math.NaN()
+	}
+
+	rank := q * float64(total)
+	lower, below := 0.0, uint64(0)
+	for i, bound := range bounds {
+		if float64(counts[i]) >= rank {
+			inBucket := counts[i] - below
+			if inBucket == 0 {
+				return bound
+			}
+			return lower + (bound-lower)*(rank-float64(below))/float64(inBucket)
+		}
+		lower, below = bound, counts[i]
+	}
+	return lower
+}
+
+// operationTotals are the cumulative counts of every operation of the run, across all phases.
+type operationTotals struct {
+	attempts     uint64
+	failures     uint64
+	observations uint64
+	bounds       []float64
+	counts       []uint64
+}
+
+// clientTotals gathers the operations of the run so far.
+func clientTotals() (operationTotals, error) {
+	byOperation, err := operationTotalsByName()
+	if err != nil {
+		return operationTotals{}, err
+	}
+
+	var totals operationTotals
+	for _, operation := range byOperation {
+		totals = totals.plus(operation)
+	}
+	return totals, nil
+}
+
+// operationTotalsByName gathers the operations of the run so far, for each operation across
+// every phase and target.
+func operationTotalsByName() (map[string]operationTotals, error) {
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to gather metrics")
+	}
+
+	totals := map[string]operationTotals{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var operation string
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "operation" {
+					operation = label.GetValue()
+				}
+			}
+
+			t := totals[operation]
+			switch family.GetName() {
+			case "operations_total":
+				t.attempts += uint64(metric.GetCounter().GetValue())
+			case "operations_failed_total":
+				t.failures += uint64(metric.GetCounter().GetValue())
+			case "operation_duration_milliseconds":
+				t = t.plus(operationTotals{
+					observations: metric.GetHistogram().GetSampleCount(),
+					bounds:       histogramBounds(metric.GetHistogram().GetBucket()),
+					counts:       histogramCounts(metric.GetHistogram().GetBucket()),
+				})
+			default:
+				continue
+			}
+			totals[operation] = t
+		}
+	}
+	return totals, nil
+}
+
+// plus returns the operations of both totals together.
+func (t operationTotals) plus(other operationTotals) operationTotals {
+	sum := operationTotals{
+		attempts:     t.attempts + other.attempts,
+		failures:     t.failures + other.failures,
+		observations: t.observations + other.observations,
+		bounds:       t.bounds,
+	}
+	if sum.bounds == nil {
+		sum.bounds = other.bounds
+	}
+	sum.counts = make([]uint64, max(len(t.counts), len(other.counts)))
+	for i := range sum.counts {
+		if i < len(t.counts) {
+			sum.counts[i] += t.counts[i]
+		}
+		if i < len(other.counts) {
+			sum.counts[i] += other.counts[i]
+		}
+	}
+	return sum
+}
+
+// p99 estimates the 99th percentile duration in milliseconds of the operations.
+func (t operationTotals) p99() float64 {
+	return bucketQuantile(0.99, t.bounds, t.counts, t.observations)
+}
+
+func histogramBounds(buckets []*dto.Bucket) []float64 {
+	bounds := make([]float64, len(buckets))
+	for i, bucket := range buckets {
+		bounds[i] = bucket.GetUpperBound()
+	}
+	return bounds
+}
+
+func histogramCounts(buckets []*dto.Bucket) []uint64 {
+	counts := make([]uint64, len(buckets))
+	for i, bucket := range buckets {
+		counts[i] = bucket.GetCumulativeCount()
+	}
+	return counts
+}
+
+// since returns the operations between earlier totals and these.
+func (t operationTotals) since(earlier operationTotals) operationTotals {
+	diff := operationTotals{
+		attempts:     t.attempts - earlier.attempts,
+		failures:     t.failures - earlier.failures,
+		observations: t.observations - earlier.observations,
+		bounds:       t.bounds,
+		counts:       make([]uint64, len(t.counts)),
+	}
+	for i := range t.counts {
+		diff.counts[i] = t.counts[i]
+		if i < len(earlier.counts) {
+			diff.counts[i] -= earlier.counts[i]
+		}
+	}
+	return diff
+}
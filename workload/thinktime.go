@@ -0,0 +1,91 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ThinkTime is the distribution of the pause a user takes before each operation.
+type ThinkTime struct {
+	// Distribution is one of fixed, uniform, exponential or none
+	Distribution string
+	// Min is the pause for a fixed think time, and the shortest pause for a uniform one
+	Min time.Duration
+	// Max is the longest pause for a uniform think time
+	Max time.Duration
+	// Mean is the average pause for an exponential think time
+	Mean time.Duration
+}
+
+// DefaultThinkTime is a pause of between 400ms and 5s, chosen uniformly.
+var DefaultThinkTime = ThinkTime{Distribution: "uniform", Min: 400 * time.Millisecond, Max: 5 * time.Second}
+
+// ParseThinkTime parses a think time given as one of:
+//
+//	none
+//	fixed:<duration>
+//	uniform:<min duration>-<max duration>
+//	exponential:<mean duration>
+func ParseThinkTime(spec string) (ThinkTime, error) {
+	distribution, params, _ := strings.Cut(spec, ":")
+
+	var err error
+	t := ThinkTime{Distribution: distribution}
+	switch distribution {
+	case "none":
+	case "fixed":
+		t.Min, err = time.ParseDuration(params)
+	case "uniform":
+		min, max, ok := strings.Cut(params, "-")
+		if !ok {
+			return ThinkTime{}, fmt.Errorf("uniform think time %s must be given as uniform:<min>-<max>", spec)
+		}
+		t.Min, err = time.ParseDuration(min)
+		if err == nil {
+			t.Max, err = time.ParseDuration(max)
+		}
+		if err == nil && t.Max < t.Min {
+			err = fmt.Errorf("maximum is less than the minimum")
+		}
+	case "exponential":
+		t.Mean, err = time.ParseDuration(params)
+	default:
+		return ThinkTime{}, fmt.Errorf("unknown think time distribution %s, expected none, fixed, uniform or exponential", distribution)
+	}
+	if err != nil {
+		return ThinkTime{}, errors.Wrapf(err, "invalid think time %s", spec)
+	}
+
+	return t, nil
+}
+
+func (t ThinkTime) sample(r *rand.Rand) time.Duration {
+	switch t.Distribution {
+	case "fixed":
+		return t.Min
+	case "uniform":
+		return t.Min + time.Duration(r.Int63n(int64(t.Max-t.Min)+1))
+	case "exponential":
+		return time.Duration(r.ExpFloat64() * float64(t.Mean))
+	default:
+		return 0
+	}
+}
+
+// ThinkTimes gives the think time before each operation of a workload, with Default used for any
+// operation not listed in Operations.
+type ThinkTimes struct {
+	Default    ThinkTime
+	Operations map[string]ThinkTime
+}
+
+func (t ThinkTimes) sample(r *rand.Rand, operation string) time.Duration {
+	if opThinkTime, ok := t.Operations[operation]; ok {
+		return opThinkTime.sample(r)
+	}
+	return t.Default.sample(r)
+}
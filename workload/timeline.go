@@ -0,0 +1,131 @@
+package workload
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TimelineInterval is how often the throughput and error rate of the run are sampled for the
+// timeline kept in the result of the run, so that degradations over the run can be seen without
+// dashboards.  With an interval of zero no timeline is kept.
+var TimelineInterval = 10 * time.Second
+
+// A TimelineSample is the throughput, error rate and latency of every operation over one interval
+// of the run.
+type TimelineSample struct {
+	// Time is the end of the interval
+	Time  time.Time `json:"time"`
+	Phase string    `json:"phase"`
+	// Throughput is in operations per second, and ErrorRate the fraction of them that failed
+	Throughput float64 `json:"throughput"`
+	ErrorRate  float64 `json:"errorRate"`
+	// P99 is the 99th percentile duration of the operations in milliseconds
+	P99 float64 `json:"p99Ms"`
+}
+
+// timeline holds the samples of the run so far.
+var timeline struct {
+	mu      sync.Mutex
+	samples []TimelineSample
+}
+
+// Timeline returns the samples of the run, oldest first.
+func Timeline() []TimelineSample {
+	timeline.mu.Lock()
+	defer timeline.mu.Unlock()
+	return append([]TimelineSample(nil), timeline.samples...)
+}
+
+// recordTimeline samples the run every interval, and once more for the part of an interval left
+// when it is stopped, returning a function that stops it.  The samples of an earlier run are
+// dropped.
+func recordTimeline() func() {
+	timeline.mu.Lock()
+	timeline.samples = nil
+	timeline.mu.Unlock()
+
+	if TimelineInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(TimelineInterval)
+		defer ticker.Stop()
+
+		last, err := clientTotals()
+		if err != nil {
+			zap.L().Warn("Failed to gather client metrics", zap.Error(err))
+		}
+		lastAt := time.Now()
+		for {
+			final := false
+			select {
+			case <-done:
+				final = true
+			case <-ticker.C:
+			}
+
+			// A last interval of under a second is too short for a rate that means anything
+			totals, err := clientTotals()
+			if err != nil {
+				zap.L().Warn("Failed to gather client metrics", zap.Error(err))
+			} else if now := time.Now(); !final || now.Sub(lastAt) >= time.Second {
+				recordSample(now, now.Sub(lastAt), totals.since(last))
+				last, lastAt = totals, now
+			}
+			if final {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// recordSample adds the operations of an interval ending at now to the timeline.
+func recordSample(now time.Time, interval time.Duration, diff operationTotals) {
+	sample := TimelineSample{
+		Time:       now,
+		Phase:      currentStatus.snapshot().Phase,
+		Throughput: float64(diff.attempts) / interval.Seconds(),
+	}
+	if diff.attempts > 0 {
+		sample.ErrorRate = float64(diff.failures) / float64(diff.attempts)
+		sample.P99 = diff.p99()
+	}
+	timeline.mu.Lock()
+	timeline.samples = append(timeline.samples, sample)
+	timeline.mu.Unlock()
+}
+
+// sparkBars are the bars of a sparkline, from lowest to highest.
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders values as a line of bars scaled between the smallest and largest of them,
+// such as ▁▃▅▇█▇▅▂, so their trend can be read at a glance in a log.
+func Sparkline(values []float64) string {
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lowest, highest = min(lowest, v), max(highest, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		bar := 0
+		if highest > lowest {
+			bar = int(math.Round((v - lowest) / (highest - lowest) * float64(len(sparkBars)-1)))
+		}
+		b.WriteRune(sparkBars[bar])
+	}
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package workload
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A ClusterTopology is what the cluster under test was made of at one point in the run, so that
+// its results can be interpreted later knowing the server versions and bucket settings.
+type ClusterTopology struct {
+	Time   time.Time      `json:"time"`
+	Nodes  []TopologyNode `json:"nodes"`
+	Bucket TopologyBucket `json:"bucket"`
+}
+
+// A TopologyNode is a node of the cluster, with the services it runs.
+type TopologyNode struct {
+	Hostname   string   `json:"hostname"`
+	Version    string   `json:"version"`
+	Status     string   `json:"status"`
+	Membership string   `json:"clusterMembership"`
+	Services   []string `json:"services"`
+}
+
+// A TopologyBucket is the settings of the bucket under test that affect its performance.
+type TopologyBucket struct {
+	Name               string `json:"name"`
+	Type               string `json:"bucketType"`
+	Replicas           int    `json:"replicaNumber"`
+	EvictionPolicy     string `json:"evictionPolicy"`
+	StorageBackend     string `json:"storageBackend"`
+	CompressionMode    string `json:"compressionMode"`
+	DurabilityMinLevel string `json:"durabilityMinLevel"`
+	// QuotaMB is the memory quota of the bucket across the cluster in MiB
+	QuotaMB int `json:"quotaMB"`
+}
+
+// Topology is the topology of the cluster under test at the start and end of the run, either of
+// which is nil if it could not be captured.
+type Topology struct {
+	Start *ClusterTopology `json:"start,omitempty"`
+	End   *ClusterTopology `json:"end,omitempty"`
+}
+
+// Changed returns whether the nodes or bucket settings differ between the start and end of the
+// run, such as after a failover or rebalance during it.
+func (t Topology) Changed() bool {
+	if t.Start == nil || t.End == nil {
+		return false
+	}
+	return !reflect.DeepEqual(t.Start.Nodes, t.End.Nodes) || t.Start.Bucket != t.End.Bucket
+}
+
+// Versions returns the distinct server versions of the nodes, which differ during an upgrade.
+func (t ClusterTopology) Versions() []string {
+	var versions []string
+	for _, node := range t.Nodes {
+		if !slices.Contains(versions, node.Version) {
+			versions = append(versions, node.Version)
+		}
+	}
+	return versions
+}
+
+// CaptureTopology reads the nodes of the cluster and the settings of the bucket from the
+// management REST API, authenticating as username unless it is empty.
+func CaptureTopology(ctx context.Context, baseURL string, bucket string, username string, password string, tlsConfig *tls.Config) (ClusterTopology, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 30 * time.Second}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	topology := ClusterTopology{Time: time.Now()}
+
+	var pool struct {
+		Nodes []TopologyNode `json:"nodes"`
+	}
+	err := getManagementJSON(ctx, client, baseURL+"/pools/default", username, password, &pool)
+	if err != nil {
+		return ClusterTopology{}, errors.Wrap(err, "failed to read cluster nodes")
+	}
+	topology.Nodes = pool.Nodes
+
+	var details struct {
+		TopologyBucket
+		Quota struct {
+			RAM int64 `json:"ram"`
+		} `json:"quota"`
+	}
+	err = getManagementJSON(ctx, client, baseURL+"/pools/default/buckets/"+url.PathEscape(bucket), username, password, &details)
+	if err != nil {
+		return ClusterTopology{}, errors.Wrapf(err, "failed to read settings of bucket %s", bucket)
+	}
+	topology.Bucket = details.TopologyBucket
+	topology.Bucket.QuotaMB = int(details.Quota.RAM >> 20)
+	return topology, nil
+}
+
+func getManagementJSON(ctx context.Context, client *http.Client, url string, username string, password string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned unexpected status code %d", req.URL.Path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
@@ -0,0 +1,122 @@
+package workload
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// TraceRecord is a single operation executed during a run.
+type TraceRecord struct {
+	Runner    int    `json:"runner"`
+	Phase     string `json:"phase"`
+	Operation string `json:"operation"`
+	// Keys are the keys, or other randomly chosen parameters, used by the operation in order.
+	Keys []string `json:"keys,omitempty"`
+	// Offset is when the operation started, relative to the start of the run.
+	Offset time.Duration `json:"offset"`
+}
+
+// TraceRecorder writes every operation of a run to a file of newline delimited JSON TraceRecords,
+// which can be replayed later to run the exact same operations against another cluster.
+type TraceRecorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	buf   *bufio.Writer
+	enc   *json.Encoder
+	start time.Time
+}
+
+func NewTraceRecorder(path string) (*TraceRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create trace file")
+	}
+
+	buf := bufio.NewWriter(file)
+	return &TraceRecorder{
+		file:  file,
+		buf:   buf,
+		enc:   json.NewEncoder(buf),
+		start: time.Now(),
+	}, nil
+}
+
+func (t *TraceRecorder) record(rec TraceRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.enc.Encode(rec)
+	if err != nil {
+		zap.L().Error("Failed to record operation trace", zap.Error(err))
+	}
+}
+
+// Close flushes any buffered records and closes the trace file.
+func (t *TraceRecorder) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.buf.Flush()
+	if err != nil {
+		t.file.Close()
+		return errors.Wrap(err, "failed to write trace file")
+	}
+	return t.file.Close()
+}
+
+// opKeys holds the keys chosen by a single operation, for recording or replaying a trace.  It is
+// shared by every copy of the Runctx, which the operation may use from several goroutines.
+type opKeys struct {
+	mu     sync.Mutex
+	chosen []string
+	replay []string
+}
+
+// next returns the key the operation uses given the one it generated, which is the next key to
+// replay if there is one, and records it otherwise.
+func (k *opKeys) next(generated string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.replay) > 0 {
+		key := k.replay[0]
+		k.replay = k.replay[1:]
+		return key
+	}
+	k.chosen = append(k.chosen, generated)
+	return generated
+}
+
+// recorded returns the keys the operation chose.
+func (k *opKeys) recorded() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.chosen
+}
+
+// readTrace reads the records of a trace file, grouped by runner in the order they were recorded.
+func readTrace(path string) (map[int][]TraceRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open trace file")
+	}
+	defer file.Close()
+
+	runners := map[int][]TraceRecord{}
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		var rec TraceRecord
+		err := dec.Decode(&rec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read trace file %s", path)
+		}
+		runners[rec.Runner] = append(runners[rec.Runner], rec)
+	}
+
+	return runners, nil
+}
@@ -0,0 +1,126 @@
+package workload
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// chains are the markov chains of every phase and target run, kept to compare the transitions
+// taken with the probabilities of the chain at the end of the run.
+var chains struct {
+	mu   sync.Mutex
+	runs []*phaseRun
+}
+
+// trackChain keeps the chain of a phase and target for SummariseTransitions.
+func trackChain(run *phaseRun) {
+	chains.mu.Lock()
+	defer chains.mu.Unlock()
+	chains.runs = append(chains.runs, run)
+}
+
+// resetChains forgets the chains of an earlier run.
+func resetChains() {
+	chains.mu.Lock()
+	defer chains.mu.Unlock()
+	chains.runs = nil
+}
+
+// A TransitionRow is the transitions taken from one operation during a phase of the run.
+type TransitionRow struct {
+	Phase  string `json:"phase"`
+	Target string `json:"target"`
+	From   string `json:"from"`
+	// Count is the number of transitions taken from the operation
+	Count       uint64       `json:"count"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// A Transition is how often the chain moved from one operation to another, compared with how
+// often it should have.
+type Transition struct {
+	To    string `json:"to"`
+	Count uint64 `json:"count"`
+	// Observed is the fraction of the transitions from the operation that went to To, and Expected
+	// its probability in the chain at the end of the phase
+	Observed float64 `json:"observed"`
+	Expected float64 `json:"expected"`
+}
+
+// MaxDeviation returns the largest difference between the observed and expected fraction of any
+// transition from the operation.
+func (r TransitionRow) MaxDeviation() float64 {
+	var deviation float64
+	for _, t := range r.Transitions {
+		deviation = max(deviation, math.Abs(t.Observed-t.Expected))
+	}
+	return deviation
+}
+
+// SummariseTransitions summarises the transitions taken from each operation in each phase and
+// target, from the metrics recorded in this process, ordered by phase, target and operation.
+// Users start at the first operation of the chain, so the transitions from it include the choice
+// of their first operation.
+func SummariseTransitions() ([]TransitionRow, error) {
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to gather metrics")
+	}
+
+	type key struct{ phase, target, from, to string }
+	counts := map[key]uint64{}
+	for _, family := range families {
+		if family.GetName() != "operation_transitions_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			counts[key{labels["phase"], labels["target"], labels["from"], labels["to"]}] += uint64(metric.GetCounter().GetValue())
+		}
+	}
+
+	chains.mu.Lock()
+	runs := append([]*phaseRun(nil), chains.runs...)
+	chains.mu.Unlock()
+
+	var rows []TransitionRow
+	for _, run := range runs {
+		probabilities := *run.probabilities.Load()
+		for i, from := range run.operations {
+			row := TransitionRow{Phase: run.name, Target: run.target, From: from}
+			for _, to := range run.operations {
+				row.Count += counts[key{run.name, run.target, from, to}]
+			}
+			if row.Count == 0 {
+				continue
+			}
+			for j, to := range run.operations {
+				t := Transition{To: to, Count: counts[key{run.name, run.target, from, to}]}
+				if i < len(probabilities) && j < len(probabilities[i]) {
+					t.Expected = probabilities[i][j]
+				}
+				if t.Count == 0 && t.Expected == 0 {
+					continue
+				}
+				t.Observed = float64(t.Count) / float64(row.Count)
+				row.Transitions = append(row.Transitions, t)
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Phase != b.Phase {
+			return a.Phase < b.Phase
+		}
+		return a.Target < b.Target
+	})
+	return rows, nil
+}
@@ -1,26 +1,262 @@
 package workload
 
 import (
+	"context"
+
+	"github.com/couchbase/gocb/v2"
 	"go.uber.org/zap"
 	"math/rand"
+	"sync"
 )
 
 var RandSeed = 11211
 
+// KeyNamespace is prefixed to the key of every document, so that concurrent runs against the same
+// collection each work on their own documents.
+var KeyNamespace string
+
+// NamespacedKey returns the document key for id within the key namespace of the run.
+func NamespacedKey(id string) string {
+	if KeyNamespace == "" {
+		return id
+	}
+	return KeyNamespace + "::" + id
+}
+
+// IndexPrefix is prefixed to the name of every index a workload creates, so that workloads
+// sharing a cluster can keep their indexes apart.
+var IndexPrefix string
+
+// IndexName returns the name of an index a workload creates, with the index prefix of the run.
+func IndexName(name string) string {
+	return IndexPrefix + name
+}
+
 type DocType struct {
 	Name string
 	Data interface{}
 }
 
+// Transcoder returns the transcoder the document is stored with, which is the raw binary one for
+// documents that are not JSON, and nil for the default JSON one otherwise.
+func (d DocType) Transcoder() gocb.Transcoder {
+	if _, ok := d.Data.([]byte); ok {
+		return gocb.NewRawBinaryTranscoder()
+	}
+	return nil
+}
+
+// A Runctx is the context of the simulated user running an operation.  It is passed by value, so
+// everything that lasts across operations is held by pointer and shared by every copy.
 type Runctx struct {
-	r rand.Rand
-	l zap.Logger
+	r         *rand.Rand
+	l         *zap.Logger
+	loggers   *opLoggers
+	id        int
+	phase     string
+	target    string
+	operation string
+	keys      *opKeys
+	state     *runState
+	chain     *opChain
+	identity  *Identity
+	// step runs another operation of the workload as a step of the one being run, measuring it
+	// as an operation of its own
+	step func(ctx context.Context, operation string) error
+}
+
+// newRunctx returns the context of runner id, whose random numbers are seeded from the seed of the
+// run so that every run makes the same choices.
+func newRunctx(id int, phase string, identity *Identity) Runctx {
+	return Runctx{
+		r:        rand.New(&lockedSource{src: rand.NewSource(int64(RandSeed + id)).(rand.Source64)}),
+		l:        zap.L().With(zap.Int("runner", id)),
+		loggers:  &opLoggers{loggers: map[string]*zap.Logger{}},
+		id:       id,
+		phase:    phase,
+		state:    &runState{values: map[string]any{}},
+		chain:    &opChain{},
+		identity: identity,
+	}
 }
 
+// lockedSource is a source of random numbers that is safe to share between goroutines, so that an
+// operation may fan out work that draws on the random numbers of its runner.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// runState holds the values a runner keeps across its operations.  It is shared by every copy of
+// the Runctx, which operations may use from several goroutines.
+type runState struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// opChain passes the result of each operation of a runner on to the operation after it.  Like the
+// state of the runner it is shared by every copy of the Runctx, so goroutines an operation fans out
+// may set its result.
+type opChain struct {
+	mu         sync.Mutex
+	previousOp string
+	previous   any
+	result     any
+}
+
+// advance makes the result of the operation just run available to the next one.  A failed
+// operation passes nothing on.
+func (c *opChain) advance(operation string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.previousOp = operation
+	c.previous = c.result
+	if err != nil {
+		c.previous = nil
+	}
+	c.result = nil
+}
+
+// Rand returns the random numbers of the runner, which carry on from one operation to the next.
+// They may be drawn from several goroutines, apart from with Read.
 func (r Runctx) Rand() *rand.Rand {
-	return &r.r
+	return r.r
 }
 
+// Logger returns a logger whose entries are tagged with the runner and the operation it is running.
 func (r Runctx) Logger() *zap.Logger {
-	return &r.l
+	if r.operation == "" {
+		return r.l
+	}
+	return r.loggers.get(r.l, r.operation)
+}
+
+// opLoggers holds the logger of each operation a runner has run, built the first time it is
+// needed.  It is shared by every copy of the Runctx, which operations may use from several
+// goroutines.
+type opLoggers struct {
+	mu      sync.Mutex
+	loggers map[string]*zap.Logger
+}
+
+// get returns the logger of the operation, a child of l.
+func (o *opLoggers) get(l *zap.Logger, operation string) *zap.Logger {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ol, ok := o.loggers[operation]
+	if !ok {
+		ol = l.With(zap.String("operation", operation))
+		o.loggers[operation] = ol
+	}
+	return ol
+}
+
+// With returns a child of the logger of the runner with fields added to every entry, such as the
+// key an operation is working on.
+func (r Runctx) With(fields ...zap.Field) *zap.Logger {
+	return r.Logger().With(fields...)
+}
+
+// RunnerId returns the number of the simulated user running the operation.
+func (r Runctx) RunnerId() int {
+	return r.id
+}
+
+// Target returns the name of the target the runner runs against in an A/B run, which is empty
+// unless targets are compared.  Runner numbers start from zero for each target.
+func (r Runctx) Target() string {
+	return r.target
+}
+
+// Key returns the key, or other randomly chosen parameter, that the operation should use given
+// the one it generated.  Routing random choices through Key lets a run be recorded and replayed
+// with the same keys.
+func (r Runctx) Key(generated string) string {
+	if r.keys == nil {
+		return generated
+	}
+	return r.keys.next(generated)
+}
+
+// Set stores a value in the state of the runner, which lasts across all of its operations.  This
+// lets an operation act on something an earlier operation of the same simulated user chose, such
+// as updating the profile it last fetched.
+func (r Runctx) Set(key string, value any) {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	r.state.values[key] = value
+}
+
+// Get returns a value from the state of the runner, and whether it was set.
+func (r Runctx) Get(key string) (any, bool) {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	value, ok := r.state.values[key]
+	return value, ok
+}
+
+// Delete removes a value from the state of the runner.
+func (r Runctx) Delete(key string) {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	delete(r.state.values, key)
+}
+
+// State returns a value of type T from the state of the runner, and false if it was not set or
+// is of another type.
+func State[T any](r Runctx, key string) (T, bool) {
+	value, _ := r.Get(key)
+	typed, ok := value.(T)
+	return typed, ok
+}
+
+// SetResult passes a value on to the next operation of the runner, whichever operation the markov
+// chain chooses, such as the profile found by a search for the next operation to update.
+func (r Runctx) SetResult(value any) {
+	r.chain.mu.Lock()
+	defer r.chain.mu.Unlock()
+	r.chain.result = value
+}
+
+// Previous returns the name of the operation the runner ran before this one, and the result it
+// passed on, which is nil if it did not set one or failed.
+func (r Runctx) Previous() (string, any) {
+	r.chain.mu.Lock()
+	defer r.chain.mu.Unlock()
+	return r.chain.previousOp, r.chain.previous
+}
+
+// PreviousResult returns the result passed on by the previous operation of the runner, and false
+// if it did not set one of type T.
+func PreviousResult[T any](r Runctx) (T, bool) {
+	_, previous := r.Previous()
+	value, ok := previous.(T)
+	return value, ok
+}
+
+// Identity returns the user the runner authenticates as, and false if runners use the credentials
+// of the workload.
+func (r Runctx) Identity() (Identity, bool) {
+	if r.identity == nil {
+		return Identity{}, false
+	}
+	return *r.identity, true
 }
@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"github.com/couchbase/gocb/v2"
 	"github.com/pkg/errors"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"go.uber.org/zap"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -31,152 +36,751 @@ type Workload interface {
 	Probabilities() [][]float64
 	// Returns a map of operations to workload functions
 	Functions() map[string]func(ctx context.Context, rctx Runctx) error
-	// Setup performs any workload specific setup, e.g creating indexes
-	Setup() error
+	// Setup performs any workload specific setup, e.g creating indexes.  It runs while the
+	// documents are loaded, so must not depend on them.
+	Setup(ctx context.Context) error
+	// Describe returns a description of each operation, in the same order as Operations
+	Describe() []OperationInfo
 }
 
-// InitMetrics initialises the metrics labelled with the operations performed by the given workload
+// OperationInfo documents what a workload operation does and which Couchbase services it uses.
+type OperationInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Services    []string `json:"services"`
+	// Writes is set for operations that change documents, rather than only read them
+	Writes bool `json:"writes"`
+}
+
+// Pprof exposes the Go profiler of spectroperf itself under /debug/pprof/ on the metrics server
+// when set, to diagnose spectroperf when it is the bottleneck of a run.
+var Pprof bool
+
+// CorrectCoordinatedOmission records the duration of each operation from when it was scheduled
+// to start as well as from when it started, so that operations held up by a saturated client are
+// not reported as faster than users would have seen them.
+var CorrectCoordinatedOmission bool
+
+// InitMetrics registers the metrics and exposes them over HTTP
 func InitMetrics(w Workload) {
-	// Create a non-global registry.
-	reg := prometheus.NewRegistry()
-	reg.MustRegister(opsAttempted)
-	reg.MustRegister(opsFailed)
-	reg.MustRegister(opDuration)
+	registerMetrics()
+	serveMetrics()
+}
+
+// metricsMux routes the requests of the metrics server.  It is separate from the default mux, on
+// which importing the profiler registers it whether it is enabled or not.
+var metricsMux = http.NewServeMux()
+
+var serveOnce sync.Once
+
+// serveMetrics starts the metrics server, the first time it is called in the process, which later
+// runs carry on serving from.  The profiler is only served while it is enabled.
+func serveMetrics() {
+	serveOnce.Do(func() {
+		// Expose metrics and custom registry, and the status of the run, via an HTTP server
+		metricsMux.Handle("/metrics", promhttp.HandlerFor(runLabelGatherer{}, promhttp.HandlerOpts{Registry: registry}))
+		metricsMux.HandleFunc("/status", serveStatus)
+		metricsMux.HandleFunc("/healthz", serveHealth)
+		metricsMux.HandleFunc("/control", serveControl)
+		metricsMux.Handle("/debug/pprof/", pprofOnly(pprof.Index))
+		metricsMux.Handle("/debug/pprof/cmdline", pprofOnly(pprof.Cmdline))
+		metricsMux.Handle("/debug/pprof/profile", pprofOnly(pprof.Profile))
+		metricsMux.Handle("/debug/pprof/symbol", pprofOnly(pprof.Symbol))
+		metricsMux.Handle("/debug/pprof/trace", pprofOnly(pprof.Trace))
+		go func() {
+			log.Fatal(http.ListenAndServe(":2112", metricsMux))
+		}()
+	})
+}
+
+// runLabel labels every metric served with the ID of the run, so that the summary of a run queried
+// from Prometheus leaves out the metrics of other runs it has scraped.
+const runLabel = "run_id"
+
+// runLabelGatherer gathers the metrics of the registry, labelled with the ID of the current run.
+type runLabelGatherer struct{}
+
+func (runLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := registry.Gather()
+	runId := currentStatus.snapshot().RunId
+	if runId == "" {
+		return families, err
+	}
+	name := runLabel
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &runId})
+			sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+		}
+	}
+	return families, err
+}
+
+// pprofOnly serves a profiler endpoint while the profiler is enabled.
+func pprofOnly(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Pprof {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// UseLocalMetrics only keeps the metrics of the run in this process, to be summarised at the end
+// of the run, rather than serving them or sending them anywhere.
+func UseLocalMetrics() {
+	registerMetrics()
+}
+
+var (
+	registerOnce sync.Once
+	// nativeRegistered is whether the duration histograms registered have native buckets
+	nativeRegistered bool
+)
 
-	// Setup metrics
-	for _, operation := range w.Operations() {
-		attemptMetrics[operation] = opsAttempted.WithLabelValues(operation)
-		failedMetrics[operation] = opsFailed.WithLabelValues(operation)
-		durationMetrics[operation] = opDuration.WithLabelValues(operation)
+// registerMetrics registers the metrics with the registry the first time it is called in the
+// process, and clears what earlier runs recorded in them and whether they were left paused, so that
+// each run reports only its own.
+func registerMetrics() {
+	registerOnce.Do(func() {
+		registry.MustRegister(opsAttempted)
+		registry.MustRegister(opsFailed)
+		registry.MustRegister(opsTimedOut)
+		registry.MustRegister(opDuration)
+		registry.MustRegister(opCorrectedDuration)
+		registry.MustRegister(schedulerLag)
+		registry.MustRegister(opTransitions)
+		registry.MustRegister(indexBuildDuration)
+		registry.MustRegister(setupStageDuration)
+		registry.MustRegister(scanItems)
+		registry.MustRegister(scanFirstItem)
+		registry.MustRegister(payloadBytes)
+		registry.MustRegister(bandwidthThrottled)
+		registry.MustRegister(lockContention)
+		registry.MustRegister(replicaReads)
+		registry.MustRegister(batchDuration)
+		registry.MustRegister(batchItemsFailed)
+		registry.MustRegister(mutationVisible)
+		registry.MustRegister(mutations)
+		registry.MustRegister(mutationDuration)
+		registry.MustRegister(queryRows)
+		registry.MustRegister(queryUnexpected)
+		registry.MustRegister(httpResponses)
+		registry.MustRegister(httpRetries)
+		registry.MustRegister(httpConnections)
+		registry.MustRegister(httpStepDuration)
+		registry.MustRegister(sdkOperationDuration)
+		registry.MustRegister(sdkDispatchDuration)
+		registry.MustRegister(sdkServerDuration)
+		registry.MustRegister(activeUsers)
+		registry.MustRegister(idleUsers)
+		registry.MustRegister(runPaused)
+		registry.MustRegister(clientSaturated)
+		// The resource usage of spectroperf itself, to tell when the client is the bottleneck
+		registry.MustRegister(collectors.NewGoCollector())
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	})
+
+	// The histograms of operation durations are recreated when a run asks for other buckets than
+	// those registered
+	if NativeHistograms != nativeRegistered {
+		registry.Unregister(opDuration)
+		registry.Unregister(opCorrectedDuration)
+		useDurationHistograms(NativeHistograms)
+		registry.MustRegister(opDuration)
+		registry.MustRegister(opCorrectedDuration)
+		nativeRegistered = NativeHistograms
 	}
+	resetMetrics()
+	resetPause()
+	// Nor is the control API of an earlier run served, unless this run enables it again
+	EnableControl(nil)
+}
 
-	// Expose metrics and custom registry via an HTTP server
+// SetupStage records when a stage of the setup started, how long it ran for and how it ended.
+type SetupStage struct {
+	Name string
+	// Target is the target the stage set up, which the runner sets when comparing targets
+	Target   string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+	// TimedOut is set for a stage that had not finished when the setup deadline passed
+	TimedOut bool
+}
+
+// setupTimeline is the record of the setup stages that have been started, in the order they
+// finished.
+type setupTimeline struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []SetupStage
+}
+
+// run executes a single setup stage, returning early if the setup deadline passes even when the
+// stage itself does not honour the context.
+func (t *setupTimeline) run(ctx context.Context, name string, stage func(ctx context.Context) error) error {
+	zap.L().Info("Starting setup stage", zap.String("stage", name))
+	start := time.Now()
+
+	errCh := make(chan error, 1)
 	go func() {
-		http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
-		log.Fatal(http.ListenAndServe(":2112", nil))
+		errCh <- stage(ctx)
 	}()
+
+	var err error
+	stalled := false
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		// A stage that returned just as the context ended still finished
+		select {
+		case err = <-errCh:
+		default:
+			err = ctx.Err()
+			stalled = true
+		}
+	}
+
+	// A stage that honours the context gives up with the deadline error, having not finished either
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded) && (stalled || errors.Is(err, context.DeadlineExceeded))
+	if timedOut {
+		err = fmt.Errorf("setup deadline exceeded during stage %s", name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stages = append(t.stages, SetupStage{Name: name, Start: start, Duration: time.Since(start), Err: err, TimedOut: timedOut})
+	setupStageDuration.WithLabelValues(name).Set(time.Since(start).Seconds())
+	return err
+}
+
+// deadlineError names the stages that had not finished when the setup deadline passed.
+func (t *setupTimeline) deadlineError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stalled []string
+	for _, stage := range t.stages {
+		if stage.TimedOut {
+			stalled = append(stalled, stage.Name)
+		}
+	}
+	if len(stalled) == 0 {
+		return fmt.Errorf("setup deadline exceeded between stages: %w", context.DeadlineExceeded)
+	}
+	return fmt.Errorf("setup deadline exceeded during stage %s: %w", strings.Join(stalled, ", "), context.DeadlineExceeded)
+}
+
+// recorded returns a copy of the stages recorded so far.
+func (t *setupTimeline) recorded() []SetupStage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SetupStage(nil), t.stages...)
+}
+
+// setupStep is a stage of the setup, which starts once the stages it runs after have succeeded.
+type setupStep struct {
+	name  string
+	after []string
+	run   func(ctx context.Context) error
+}
+
+// runGraph executes the steps of the setup, each concurrently with every other step it does not
+// depend on, and returns the first error.  Steps after one that failed are not started, and the
+// rest are cancelled.  If the deadline of the context passes first, the error names every step
+// that was still running.
+func (t *setupTimeline) runGraph(ctx context.Context, steps []setupStep) error {
+	t.start = time.Now()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	done := map[string]chan struct{}{}
+	for _, step := range steps {
+		done[step.name] = make(chan struct{})
+	}
+	for _, step := range steps {
+		for _, dep := range step.after {
+			if _, ok := done[dep]; !ok {
+				return fmt.Errorf("setup stage %s runs after unknown stage %s", step.name, dep)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(steps))
+	for _, step := range steps {
+		go func() {
+			defer wg.Done()
+			defer close(done[step.name])
+			for _, dep := range step.after {
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := t.run(ctx, step.name, step.run)
+			if err != nil {
+				cancel(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Once the deadline has passed, cancelling with the error of a stage no longer records it
+	err := context.Cause(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return t.deadlineError()
+	}
+	return err
+}
+
+func (t *setupTimeline) log() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, stage := range t.stages {
+		fields := []zap.Field{zap.String("stage", stage.Name), zap.Time("start", stage.Start), zap.Duration("duration", stage.Duration)}
+		if stage.Err != nil {
+			fields = append(fields, zap.Error(stage.Err))
+		}
+		if stage.TimedOut {
+			fields = append(fields, zap.Bool("timedOut", true))
+		}
+		zap.L().Info("Setup timeline", fields...)
+	}
+	zap.L().Info("Setup elapsed", zap.Duration("duration", time.Since(t.start)))
+}
+
+// SkipLoad skips loading the documents of the workload, which must already be loaded, such as by
+// an earlier run with the same key namespace and number of items.
+var SkipLoad bool
+
+// Setup uploads the documents generated by the workload, calls the workloads Setup function while
+// they load, and builds any deferred indexes once both are done.  The whole setup must complete
+// within the given timeout, a timeout of zero means no deadline.  The stages that ran are returned
+// whether or not the setup succeeded.
+func Setup(w Workload, numItemsArg int, scp *gocb.Scope, loader Loader, timeout time.Duration) ([]SetupStage, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var timeline setupTimeline
+	defer timeline.log()
+
+	err := timeline.runGraph(ctx, []setupStep{
+		{
+			name: "data load",
+			run: func(ctx context.Context) error {
+				if Preloaded(w) {
+					zap.L().Info("Skipping data load, the workload runs over existing documents")
+					return nil
+				}
+				if SkipLoad {
+					zap.L().Info("Skipping data load, reusing the documents already loaded")
+					return nil
+				}
+				return loadData(ctx, w, numItemsArg, loader)
+			},
+		},
+		{
+			// Call the worloads own Setup function to perform any workload specific setup
+			name: "workload setup",
+			run: func(ctx context.Context) error {
+				return errors.Wrap(w.Setup(ctx), "failed to setup workload")
+			},
+		},
+		{
+			// Deferred indexes are built once, over all of the data
+			name:  "index build",
+			after: []string{"data load", "workload setup"},
+			run: func(ctx context.Context) error {
+				return errors.Wrap(BuildDeferredIndexes(ctx), "failed to build indexes")
+			},
+		},
+	})
+	return timeline.recorded(), err
 }
 
-// Setup uploads the documents generated by the workload, and calls the workloads Setup function
-func Setup(w Workload, numItemsArg int, scp *gocb.Scope, coll *gocb.Collection) {
+// A Loader stores the documents Setup loads, overwriting any already stored under the same key.
+type Loader interface {
+	Load(ctx context.Context, rctx Runctx, doc DocType) error
+}
+
+// collectionLoader loads documents through the SDK.
+type collectionLoader struct {
+	coll *gocb.Collection
+}
+
+// CollectionLoader returns a loader that upserts documents into the collection with the SDK.
+func CollectionLoader(coll *gocb.Collection) Loader {
+	return collectionLoader{coll: coll}
+}
+
+func (l collectionLoader) Load(ctx context.Context, rctx Runctx, doc DocType) error {
+	_, err := l.coll.Upsert(doc.Name, doc.Data, &gocb.UpsertOptions{Context: ctx, Transcoder: doc.Transcoder()})
+	return err
+}
+
+// generateBatch is the number of documents generated at once by a workload that can generate them
+// together.
+const generateBatch = 256
+
+// loadData stores numItems documents generated by the workload with the loader, stopping at the
+// first failure.
+func loadData(ctx context.Context, w Workload, numItems int, loader Loader) error {
 	numConc := 2000
 	workChan := make(chan DocType, numConc)
-	shutdownChan := make(chan struct{}, numConc)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 	var wg sync.WaitGroup
 
 	wg.Add(numConc)
 	for i := 0; i < numConc; i++ {
 		go func() {
-			for {
-				select {
-				case doc := <-workChan:
-					_, err := coll.Upsert(doc.Name, doc.Data, nil)
-					if err != nil {
-						panic(errors.Wrap(err, "Data load upsert failed."))
-					}
-				case <-shutdownChan:
-					wg.Done()
+			defer wg.Done()
+			rctx := newRunctx(i, "setup", nil)
+			for doc := range workChan {
+				err := loader.Load(ctx, rctx, doc)
+				if err != nil {
+					cancel(errors.Wrap(err, "Data load upsert failed."))
 					return
 				}
 			}
 		}()
 	}
 
-	// Create a random document using the given workload definition
-	for i := 0; i < numItemsArg; i++ {
-		workChan <- w.GenerateDocument(fmt.Sprintf("u%d", i))
+	// Create random documents using the given workload definition, a batch at a time if it can
+	// generate them together.
+	batch := 1
+	generate := func(ids []string) []DocType {
+		return []DocType{w.GenerateDocument(ids[0])}
+	}
+	if g, ok := hook[BatchGenerator](w); ok {
+		batch = generateBatch
+		generate = g.GenerateDocuments
 	}
+	ids := make([]string, 0, batch)
+	for i := 0; i < numItems && ctx.Err() == nil; i += batch {
+		ids = ids[:0]
+		for j := i; j < min(i+batch, numItems); j++ {
+			ids = append(ids, NamespacedKey(fmt.Sprintf("u%d", j)))
+		}
+		for _, doc := range generate(ids) {
+			select {
+			case workChan <- doc:
+			case <-ctx.Done():
+			}
+		}
+	}
+	close(workChan)
+	wg.Wait()
 
-	// Call the worloads own Setup function to perform any workload specific setup
-	err := w.Setup()
-	if err != nil {
-		panic(errors.Wrap(err, "failed to setup workload"))
+	// Once the deadline has passed, cancelling with the error of a stage no longer records it
+	err := context.Cause(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return t.deadlineError()
 	}
+	return err
 }
 
-func Run(w Workload, numUsers int, runTime time.Duration) {
-	sigCh := make(chan os.Signal, 10)
-	ctx, cancelFn := context.WithCancel(context.Background())
+// Ramp describes how the number of active users changes at the start and end of a run.  Users
+// are started at a steady rate over the Up period, beginning with StartUsers at once, and are
+// stopped in reverse order over the Down period at the end of the run.
+type Ramp struct {
+	StartUsers int
+	Up         time.Duration
+	Down       time.Duration
+}
 
-	go func() {
-		<-sigCh
-		cancelFn()
-	}()
+// schedule returns when the given runner should start and stop, relative to the start of the run.
+func (r Ramp) schedule(runnerId int, numUsers int, runTime time.Duration) (time.Duration, time.Duration) {
+	if runnerId < r.StartUsers || numUsers <= r.StartUsers {
+		return 0, runTime
+	}
 
-	// Signal handler for SIGTERM
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	// Position of this runner among those being ramped, from 1 to the number of ramped users.
+	position := time.Duration(runnerId - r.StartUsers + 1)
+	ramped := time.Duration(numUsers - r.StartUsers)
 
-	// Create a work group of goroutine runners sharing the same probabilities.
-	var wg sync.WaitGroup
+	return r.Up * position / ramped, runTime - r.Down*position/ramped
+}
 
-	wg.Add(numUsers)
-	for i := 0; i < numUsers; i++ {
-		go runLoop(ctx, w.Probabilities(), w.Functions(), w.Operations(), runTime, i, &wg)
-	}
+// IdleUsers describes a cohort of mostly idle users, run alongside the regular users, that stay
+// connected but only perform an operation about once per Interval.
+type IdleUsers struct {
+	Users    int
+	Interval time.Duration
+}
 
-	wg.Wait()
+// thinkTime returns a random think time of between half and one and a half idle intervals.
+func (i IdleUsers) thinkTime(r *rand.Rand, _ string) time.Duration {
+	return i.Interval/2 + time.Duration(r.Int63n(int64(i.Interval)+1))
 }
 
-func runLoop(
-	ctx context.Context,
-	probabilities [][]float64,
-	functions map[string]func(context.Context, Runctx) error,
-	operations []string,
-	runTime time.Duration,
-	runnerId int,
-	wg *sync.WaitGroup) {
+// RunOptions controls how the simulated users behave across every phase of a run.
+type RunOptions struct {
+	// ThinkTimes are the pauses regular users take before each operation
+	ThinkTimes ThinkTimes
+	// Idle is the cohort of mostly idle users run alongside the regular users
+	Idle IdleUsers
+	// Recorder records every operation when set, so that the run can be replayed
+	Recorder *TraceRecorder
+	// Identities are the users runners authenticate as, or none to use the workload's credentials
+	Identities []Identity
+	// ErrorBudget aborts the run, or stops operations, that fail too often
+	ErrorBudget ErrorBudget
+	// Workers is how many operations may run at once, or zero for DefaultWorkers
+	Workers int
+	// Main is set for the main run, rather than those alongside it, whose phases are reported on
+	// the status endpoint and adjusted by the control API
+	Main bool
+	// Capacity searches for the highest throughput the cluster sustains when set, running steps
+	// like the first phase of the run plan instead of its phases
+	Capacity *CapacitySearch
+}
 
-	// Current operation index
-	currOpIndex := 0
+// A Target is one of the workloads compared side by side in a run, such as the same workload
+// against two clusters, or over the SDK and the Data API.  The metrics of each target are
+// labelled with its name.
+type Target struct {
+	Name     string
+	Workload Workload
+}
 
-	rng := rand.NewSource(int64(RandSeed + runnerId))
-	r := rand.New(rng)
+// Run executes each phase of the run plan in turn against the workload.  It returns an
+// ErrorBudgetExceeded error if the run was aborted for exceeding its error budget.
+func Run(w Workload, phases []Phase, opts RunOptions) error {
+	return RunTargets([]Target{{Workload: w}}, phases, opts)
+}
+
+// RunTargets executes each phase of the run plan in turn, with the users of each phase split
+// evenly between the targets.  The runners of every target have the same random seeds, so given
+// the same workload each target runs the same schedule of operations.
+func RunTargets(targets []Target, phases []Phase, opts RunOptions) error {
+	return RunTargetsContext(context.Background(), targets, phases, opts)
+}
 
-	timeout := time.After(runTime)
+// RunTargetsContext is RunTargets, stopping early once the context is done.
+func RunTargetsContext(parent context.Context, targets []Target, phases []Phase, opts RunOptions) error {
+	sigCtx, cancelFn := signalContext(parent)
+	defer cancelFn()
+	ctx, abort := context.WithCancelCause(sigCtx)
+	defer abort(nil)
+	defer summariseErrors()()
 
-	slog := zap.L().Sugar()
+	if opts.Recorder != nil {
+		opts.Recorder.start = time.Now()
+	}
+	if opts.Main {
+		var duration time.Duration
+		for _, phase := range phases {
+			duration += phase.Duration
+		}
+		// A capacity search ends early once it is precise enough, so this is its longest
+		if opts.Capacity != nil {
+			duration = time.Duration(opts.Capacity.MaxSteps) * opts.Capacity.StepDuration
+		}
+		currentStatus.startRun(duration)
+		defer handlePauseSignals()()
+		defer logProgress()()
+		defer recordTimeline()()
+		startBandwidthLimits()
+	}
 
-	slog.Debugf("Starting runner %d…", runnerId)
+	runStep := func(phase Phase) {
+		zap.L().Info("Starting phase", zap.String("phase", phase.Name), zap.Duration("duration", phase.Duration), zap.Int("users", phase.Users))
+		if opts.Main {
+			currentStatus.startPhase(phase.Name)
+		}
+		runPhase(ctx, targets, phase, opts, abort)
+	}
+	if opts.Capacity != nil && len(phases) > 0 {
+		opts.Capacity.run(ctx, phases[0], runStep)
+	} else {
+		for _, phase := range phases {
+			if ctx.Err() != nil {
+				break
+			}
+			runStep(phase)
+		}
+	}
 
-	var runCtx Runctx
-	runCtx.r = *r
-	runCtx.l = *zap.L() // TODO: create a log for results
-	// todo: move this into context.value, a KV store for junk
+	var exceeded ErrorBudgetExceeded
+	if cause := context.Cause(ctx); errors.As(cause, &exceeded) {
+		return exceeded
+	}
+	return nil
+}
 
-	for {
+// signalContext returns a context that is cancelled on an interrupt or SIGTERM, or when the
+// parent is done.  On Windows, Ctrl-C and Ctrl-Break arrive as an interrupt, and closing the
+// console, logging off or shutting down as SIGTERM, so the run stops cleanly there too.
+func signalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	sigCh := make(chan os.Signal, 10)
+	ctx, cancelFn := context.WithCancel(parent)
+
+	go func() {
 		select {
+		case <-sigCh:
+			cancelFn()
 		case <-ctx.Done():
-			slog.Debugf("Received cancel, stopping runner %d…", runnerId) // TODO: fix bug where only one runner stops
-			wg.Done()
-		case <-timeout:
-			slog.Debugf("Run time reached, stopping runner %d…", runnerId)
-			wg.Done()
-		default:
-			// Get the next operation index based on probabilities
-			nextOpIndex := getNextOperation(currOpIndex, probabilities, r)
-			// call the next function
-			nextFunction := operations[nextOpIndex]
-			slog.Debug(nextFunction)
-			attemptMetrics[nextFunction].Inc()
-
-			// sleep a random amount of time
-			t := r.Int31n(5000-400) + 400
-			time.Sleep(time.Duration(t) * time.Millisecond)
-
-			start := time.Now()
-			err := functions[operations[nextOpIndex]](ctx, runCtx)
-			duration := time.Now().Sub(start)
-			durationMetrics[nextFunction].Observe(float64(duration.Microseconds()) / 1000)
+		}
+		signal.Stop(sigCh)
+	}()
 
-			if err != nil {
-				slog.Error("operation failed", zap.String("operation", nextFunction), zap.Error(err))
-				failedMetrics[nextFunction].Inc()
-			}
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	return ctx, cancelFn
+}
+
+func runPhase(ctx context.Context, targets []Target, phase Phase, opts RunOptions, abort context.CancelCauseFunc) {
+	budget := opts.ErrorBudget
+	if phase.MaxErrorRate > 0 {
+		budget.MaxErrorRate = phase.MaxErrorRate
+	}
+
+	users := phase.Users / len(targets)
+	idle := opts.Idle
+	idle.Users /= len(targets)
+	if idle.Interval <= 0 {
+		idle.Users = 0
+	}
+	if users*len(targets) != phase.Users {
+		zap.L().Warn("Users cannot be split evenly between targets", zap.String("phase", phase.Name), zap.Int("users", phase.Users), zap.Int("usersPerTarget", users))
+	}
+
+	// Create the users of each target, sharing the same probabilities.
+	start := time.Now()
+	var runners []*virtualUser
+	var runs []*phaseRun
+	newRegularUser := func(shared *phaseRun, id int, start time.Time, startAfter time.Duration, stopAfter time.Duration) *virtualUser {
+		u := newVirtualUser(shared, id, start, startAfter, stopAfter, opts.ThinkTimes.sample, activeUsers)
+		u.regular = true
+		return u
+	}
+	for _, target := range targets {
+		w := target.Workload
+		shared := &phaseRun{
+			name:       phase.Name,
+			target:     target.Name,
+			functions:  w.Functions(),
+			operations: w.Operations(),
+			metrics:    newOperationMetrics(w.Operations(), phase.Name, target.Name),
+			users:      newUserLimit(users, users+idle.Users),
+			recorder:   opts.Recorder,
+			identities: opts.Identities,
+		}
+		probabilities := w.Probabilities()
+		if phase.Probabilities != nil {
+			probabilities = phase.Probabilities
+		}
+		shared.probabilities.Store(&probabilities)
+		trackChain(shared)
+		if phase.Throughput > 0 {
+			shared.limiter.Store(newRateLimiter(phase.Throughput / float64(len(targets))))
+		}
+		if budget.enabled() {
+			shared.breaker = newCircuitBreaker(budget, phase.Name, shared.operations, abort)
+		}
+		runs = append(runs, shared)
 
-			// update for next time
-			currOpIndex = nextOpIndex
+		for i := 0; i < users; i++ {
+			startAfter, stopAfter := phase.Ramp.schedule(i, users, phase.Duration)
+			runners = append(runners, newRegularUser(shared, i, start, startAfter, stopAfter))
 		}
+
+		// Idle users are numbered after the regular users so that they get their own random seeds.
+		for i := users; i < users+idle.Users; i++ {
+			runners = append(runners, newVirtualUser(shared, i, start, 0, phase.Duration, idle.thinkTime, idleUsers))
+		}
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	var add chan []*virtualUser
+	if opts.Main {
+		p := &phaseControl{
+			name:  phase.Name,
+			runs:  runs,
+			start: start,
+			end:   start.Add(phase.Duration),
+			newUser: func(shared *phaseRun, id int, start time.Time, stopAfter time.Duration) *virtualUser {
+				return newRegularUser(shared, id, start, 0, stopAfter)
+			},
+			add:      make(chan []*virtualUser),
+			finished: make(chan struct{}),
+		}
+		add = p.add
+		startControl(p)
+		defer finishControl(p)
+	}
+	// Without the control API no more users join, so there need be no more workers than users
+	if add == nil {
+		workers = min(workers, len(runners))
+	}
+	runUsers(ctx, phase.Name, runners, workers, add)
+}
+
+// executeOperation runs a single operation, recording its metrics, and returns its error.  The
+// operation was scheduled to start at intended, which it may have started after if the client fell
+// behind.
+func executeOperation(ctx context.Context, metrics operationMetrics, functions map[string]func(context.Context, Runctx) error, operation string, intended time.Time, runCtx Runctx) error {
+	runCtx.operation = operation
+	runCtx.step = func(ctx context.Context, step string) error {
+		return executeOperation(ctx, metrics, functions, step, time.Now(), runCtx)
+	}
+	metrics.attempts[operation].Inc()
+	start := time.Now()
+	lag := max(start.Sub(intended), 0)
+	metrics.lag.Observe(float64(lag.Microseconds()) / 1000)
+	opCtx, cancel := OperationDeadlines.withDeadline(ctx, operation)
+	err := callOperation(opCtx, functions[operation], runCtx)
+	cancel()
+	duration := time.Now().Sub(start)
+	runCtx.chain.advance(operation, err)
+	metrics.durations[operation].Observe(float64(duration.Microseconds()) / 1000)
+	if metrics.corrected != nil {
+		metrics.corrected[operation].Observe(float64((duration + lag).Microseconds()) / 1000)
 	}
+	if statsd != nil {
+		statsd.observeOperation(operation, metrics.phase, metrics.target, duration, err)
+	}
+
+	observeBacklog(lag, err)
+
+	if err != nil {
+		operationErrors.record(operation, err)
+		metrics.failures[operation].Inc()
+		// The operation failed because it ran past its deadline, rather than the run ending
+		if errors.Is(opCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			metrics.timeouts[operation].Inc()
+		}
+	}
+	return err
+}
+
+// callOperation runs an operation, turning a panic into a failure of the operation so that one
+// bad operation cannot kill the whole run.  The stack of the panic is logged.
+func callOperation(ctx context.Context, fn func(context.Context, Runctx) error, runCtx Runctx) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			runCtx.Logger().Error("Operation panicked", zap.Any("panic", r), zap.ByteString("stack", debug.Stack()))
+			err = fmt.Errorf("operation panicked: %v", r)
+		}
+	}()
+	return fn(ctx, runCtx)
 }
 
 func getNextOperation(currOpIndex int, probabilities [][]float64, r *rand.Rand) int {
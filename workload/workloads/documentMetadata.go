@@ -0,0 +1,234 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/brianvoe/gofakeit"
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+)
+
+// documentMetadata keeps metadata about each document in its extended attributes rather than its
+// body, as frameworks such as Sync Gateway do: a user xattr audits who last changed it, and a
+// system xattr holds the revision and channels of a sync framework, written atomically with the
+// body.
+type documentMetadata struct {
+	numItems   int
+	collection *gocb.Collection
+}
+
+// MetadataDocument is the body of a document of the document-metadata workload.
+type MetadataDocument struct {
+	Title     string
+	Body      string
+	Tags      []string
+	Updated   time.Time
+	Namespace string
+}
+
+// AuditMetadata is the user xattr recording who changed a document and when.
+type AuditMetadata struct {
+	ModifiedBy string
+	ModifiedAt time.Time
+	Revisions  int
+	// CAS is expanded by the server to the CAS of the mutation that wrote it
+	CAS string
+}
+
+// SyncMetadata is the system xattr of a sync framework, such as the _sync xattr of Sync Gateway.
+type SyncMetadata struct {
+	Rev      string
+	Sequence int
+	Channels []string
+	CAS      string
+}
+
+// auditXattr and syncXattr are the names of the extended attributes of the workload.  Names
+// starting with an underscore are system xattrs, which need the permission to read and write
+// system xattrs on top of the data roles.
+const (
+	auditXattr = "audit"
+	syncXattr  = "_sync"
+)
+
+func NewDocumentMetadata(numItems int, collection *gocb.Collection) documentMetadata {
+	return documentMetadata{
+		numItems:   numItems,
+		collection: collection,
+	}
+}
+
+func newMetadataDocument() MetadataDocument {
+	doc := MetadataDocument{
+		Title:     gofakeit.Sentence(gofakeit.Number(3, 8)),
+		Body:      gofakeit.Paragraph(1, gofakeit.Number(1, 4), gofakeit.Number(5, 15), "\n"),
+		Updated:   time.Now(),
+		Namespace: workload.KeyNamespace,
+	}
+	for i := 0; i < gofakeit.Number(0, 4); i++ {
+		doc.Tags = append(doc.Tags, gofakeit.Word())
+	}
+	return doc
+}
+
+// Create a document with no metadata, which is added as the run changes it.
+func (w documentMetadata) GenerateDocument(id string) workload.DocType {
+	return workload.DocType{Name: id, Data: newMetadataDocument()}
+}
+
+func (w documentMetadata) Operations() []string {
+	return []string{"fetchDocument", "readMetadata", "writeAudit", "syncUpdate", "syncFetch"}
+}
+
+func (w documentMetadata) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "fetchDocument", Description: "Get the body of a random document, without its xattrs", Services: []string{"kv"}},
+		{Name: "readMetadata", Description: "Look up the audit user xattr and the $document virtual xattr of a random document with sub-document reads", Services: []string{"kv"}},
+		{Name: "writeAudit", Description: "Record who changed a random document in its audit user xattr with a sub-document mutation, counting its revisions and expanding its CAS", Services: []string{"kv"}, Writes: true},
+		{Name: "syncUpdate", Description: "Replace the body of a random document and update the revision, sequence and channels in its _sync system xattr with one sub-document mutation", Services: []string{"kv"}, Writes: true},
+		{Name: "syncFetch", Description: "Get the body of a random document along with its _sync system xattr with one sub-document lookup", Services: []string{"kv"}},
+	}
+}
+
+// Documents are mostly read, as a sync framework reads its metadata along with them, and changes
+// are audited as often as they are synced.
+func (w documentMetadata) Probabilities() [][]float64 {
+	return [][]float64{
+		{0.2, 0.2, 0.1, 0.1, 0.4},
+		{0.2, 0.2, 0.1, 0.1, 0.4},
+		{0.2, 0.2, 0.1, 0.1, 0.4},
+		{0.2, 0.2, 0.1, 0.1, 0.4},
+		{0.2, 0.2, 0.1, 0.1, 0.4},
+	}
+}
+
+func (w documentMetadata) Setup(ctx context.Context) error {
+	return nil
+}
+
+func (w documentMetadata) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
+		"fetchDocument": w.fetchDocument, // read a document
+		"readMetadata":  w.readMetadata,  // check who changed a document
+		"writeAudit":    w.writeAudit,    // record a change
+		"syncUpdate":    w.syncUpdate,    // write a document through the sync framework
+		"syncFetch":     w.syncFetch,     // read a document through the sync framework
+	}
+}
+
+func (w documentMetadata) randomKey(rctx workload.Runctx) string {
+	return rctx.Key(workload.NamespacedKey(fmt.Sprintf("u%d", rctx.Item(w.numItems))))
+}
+
+// modifiedBy returns who the runner changes documents as.
+func modifiedBy(rctx workload.Runctx) string {
+	if identity, ok := rctx.Identity(); ok {
+		return identity.Username
+	}
+	return fmt.Sprintf("runner-%d", rctx.RunnerId())
+}
+
+// Get the body of a random document
+func (w documentMetadata) fetchDocument(ctx context.Context, rctx workload.Runctx) error {
+	result, err := w.collection.Get(w.randomKey(rctx), &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
+	if err != nil {
+		return fmt.Errorf("document fetch failed: %s", err.Error())
+	}
+
+	var doc MetadataDocument
+	err = result.Content(&doc)
+	if err != nil {
+		return fmt.Errorf("unable to load document into struct: %s", err.Error())
+	}
+	return nil
+}
+
+// Look up the audit metadata of a random document along with the metadata the server keeps
+func (w documentMetadata) readMetadata(ctx context.Context, rctx workload.Runctx) error {
+	ops := []gocb.LookupInSpec{
+		gocb.GetSpec(auditXattr, &gocb.GetSpecOptions{IsXattr: true}),
+		gocb.GetSpec("$document", &gocb.GetSpecOptions{IsXattr: true}),
+	}
+	result, err := w.collection.LookupIn(w.randomKey(rctx), ops, &gocb.LookupInOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("metadata lookup failed: %s", err.Error())
+	}
+
+	// A document that has not been audited yet has no audit xattr
+	if result.Exists(0) {
+		var audit AuditMetadata
+		err = result.ContentAt(0, &audit)
+		if err != nil {
+			return fmt.Errorf("unable to read audit metadata: %s", err.Error())
+		}
+	}
+	var document map[string]interface{}
+	err = result.ContentAt(1, &document)
+	if err != nil {
+		return fmt.Errorf("unable to read document metadata: %s", err.Error())
+	}
+	return nil
+}
+
+// Record who changed a random document, without changing its body
+func (w documentMetadata) writeAudit(ctx context.Context, rctx workload.Runctx) error {
+	xattr := &gocb.UpsertSpecOptions{IsXattr: true, CreatePath: true}
+	ops := []gocb.MutateInSpec{
+		gocb.UpsertSpec(auditXattr+".ModifiedBy", modifiedBy(rctx), xattr),
+		gocb.UpsertSpec(auditXattr+".ModifiedAt", time.Now(), xattr),
+		gocb.IncrementSpec(auditXattr+".Revisions", 1, &gocb.CounterSpecOptions{IsXattr: true, CreatePath: true}),
+		gocb.UpsertSpec(auditXattr+".CAS", gocb.MutationMacroCAS, xattr),
+	}
+	_, err := w.collection.MutateIn(w.randomKey(rctx), ops, &gocb.MutateInOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("audit mutation failed: %s", err.Error())
+	}
+	return nil
+}
+
+// Replace the body of a random document along with its sync metadata, atomically as a sync
+// framework must.  Xattrs must be mutated before the body.
+func (w documentMetadata) syncUpdate(ctx context.Context, rctx workload.Runctx) error {
+	xattr := &gocb.UpsertSpecOptions{IsXattr: true, CreatePath: true}
+	ops := []gocb.MutateInSpec{
+		gocb.IncrementSpec(syncXattr+".Sequence", 1, &gocb.CounterSpecOptions{IsXattr: true, CreatePath: true}),
+		gocb.UpsertSpec(syncXattr+".Rev", gofakeit.UUID(), xattr),
+		gocb.UpsertSpec(syncXattr+".Channels", []string{gofakeit.Word(), gofakeit.Word()}, xattr),
+		gocb.UpsertSpec(syncXattr+".CAS", gocb.MutationMacroCAS, xattr),
+		gocb.ReplaceSpec("", newMetadataDocument(), nil),
+	}
+	_, err := w.collection.MutateIn(w.randomKey(rctx), ops, &gocb.MutateInOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("sync mutation failed: %s", err.Error())
+	}
+	return nil
+}
+
+// Get the body of a random document along with its sync metadata
+func (w documentMetadata) syncFetch(ctx context.Context, rctx workload.Runctx) error {
+	ops := []gocb.LookupInSpec{
+		gocb.GetSpec(syncXattr, &gocb.GetSpecOptions{IsXattr: true}),
+		gocb.GetSpec("", nil),
+	}
+	result, err := w.collection.LookupIn(w.randomKey(rctx), ops, &gocb.LookupInOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("sync lookup failed: %s", err.Error())
+	}
+
+	// A document that has not been synced yet has no sync xattr
+	if result.Exists(0) {
+		var sync SyncMetadata
+		err = result.ContentAt(0, &sync)
+		if err != nil {
+			return fmt.Errorf("unable to read sync metadata: %s", err.Error())
+		}
+	}
+	var doc MetadataDocument
+	err = result.ContentAt(1, &doc)
+	if err != nil {
+		return fmt.Errorf("unable to load document into struct: %s", err.Error())
+	}
+	return nil
+}
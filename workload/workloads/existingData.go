@@ -0,0 +1,199 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+	"go.uber.org/zap"
+)
+
+// Ways the existing-data workload discovers the keys it runs over.
+const (
+	ExistingKeysSample = "sample"
+	ExistingKeysQuery  = "query"
+)
+
+// existingQueryPrefix marks a discovery as a query statement returning the keys to run over.
+const existingQueryPrefix = "query:"
+
+// existingData runs reads and updates over documents that are already in the collection, such as
+// a copy of production data, rather than documents it loaded.  The keys are discovered by the
+// setup, either by randomly sampling the collection or with a query.
+type existingData struct {
+	numItems   int
+	scope      *gocb.Scope
+	collection *gocb.Collection
+	discovery  string
+	// mutations are the semantics update writes documents back with
+	mutations workload.MutationSemantics
+	// keys are those discovered by the setup, shared by every runner
+	keys *[]string
+}
+
+func NewExistingData(numItems int, scope *gocb.Scope, collection *gocb.Collection) existingData {
+	return existingData{
+		numItems:   numItems,
+		scope:      scope,
+		collection: collection,
+		discovery:  ExistingKeysSample,
+		keys:       &[]string{},
+	}
+}
+
+// WithDiscovery sets how the keys are discovered: sample for a random sample of the collection,
+// query for the first keys of the collection, or query:<statement> for the keys a query returns.
+func (w existingData) WithDiscovery(discovery string) (existingData, error) {
+	if discovery != ExistingKeysSample && discovery != ExistingKeysQuery && !strings.HasPrefix(discovery, existingQueryPrefix) {
+		return w, fmt.Errorf("unknown key discovery %s, expected %s, %s or %s<statement>", discovery, ExistingKeysSample, ExistingKeysQuery, existingQueryPrefix)
+	}
+	w.discovery = discovery
+	return w, nil
+}
+
+// WithMutationSemantics sets the semantics update writes documents back with, by default replace.
+func (w existingData) WithMutationSemantics(semantics workload.MutationSemantics) existingData {
+	w.mutations = semantics
+	return w
+}
+
+// The documents already exist, so none are generated.
+func (w existingData) GenerateDocument(id string) workload.DocType {
+	return workload.DocType{Name: id, Data: map[string]interface{}{}}
+}
+
+// Preloaded stops the setup loading documents over those already in the collection.
+func (w existingData) Preloaded() bool {
+	return true
+}
+
+func (w existingData) Operations() []string {
+	return []string{"read", "update"}
+}
+
+func (w existingData) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "read", Description: "Get a random discovered document", Services: []string{"kv"}},
+		{Name: "update", Description: "Get a random discovered document and write it back unchanged, preserving its expiry, by default with a replace", Services: []string{"kv"}, Writes: true},
+	}
+}
+
+// Reads outnumber updates four to one, whichever operation came before.
+func (w existingData) Probabilities() [][]float64 {
+	return [][]float64{
+		{0.8, 0.2},
+		{0.8, 0.2},
+	}
+}
+
+// Setup discovers up to numItems keys to run over.
+func (w existingData) Setup(ctx context.Context) error {
+	var keys []string
+	var err error
+	if w.discovery == ExistingKeysSample {
+		keys, err = w.sampleKeys(ctx)
+	} else {
+		keys, err = w.queryKeys(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no documents found in collection %s", w.collection.Name())
+	}
+	*w.keys = keys
+	zap.L().Info("Discovered existing keys", zap.Int("keys", len(keys)), zap.String("discovery", w.discovery))
+	return nil
+}
+
+// sampleKeys returns a random sample of the keys of the collection, found with a sampling scan.
+func (w existingData) sampleKeys(ctx context.Context) ([]string, error) {
+	scan, err := w.collection.Scan(gocb.SamplingScan{Limit: uint64(w.numItems)}, &gocb.ScanOptions{Context: ctx, IDsOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("sampling scan failed: %s", err.Error())
+	}
+	defer scan.Close()
+
+	var keys []string
+	for item := scan.Next(); item != nil; item = scan.Next() {
+		keys = append(keys, item.ID())
+	}
+	err = scan.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating the sampling scan: %s", err.Error())
+	}
+	return keys, nil
+}
+
+// queryKeys returns the keys a query returns, by default the first keys of the collection, which
+// needs a primary index.
+func (w existingData) queryKeys(ctx context.Context) ([]string, error) {
+	statement := fmt.Sprintf("SELECT RAW META().id FROM `%s` LIMIT %d", w.collection.Name(), w.numItems)
+	if custom, ok := strings.CutPrefix(w.discovery, existingQueryPrefix); ok {
+		statement = custom
+	}
+
+	rows, err := w.scope.Query(statement, &gocb.QueryOptions{Context: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("key query failed: %s", err.Error())
+	}
+	var keys []string
+	for rows.Next() && len(keys) < w.numItems {
+		var key string
+		err := rows.Row(&key)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("key query returned a row that is not a key: %s", err.Error())
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("key query failed: %s", err.Error())
+	}
+	return keys, nil
+}
+
+func (w existingData) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
+		"read":   w.read,
+		"update": w.update,
+	}
+}
+
+// key returns a random discovered key, from the shard of the runner if keys are partitioned.
+func (w existingData) key(rctx workload.Runctx) string {
+	keys := *w.keys
+	return rctx.Key(keys[rctx.Item(len(keys))])
+}
+
+// Read a random document, without decoding it as it may be in any format
+func (w existingData) read(ctx context.Context, rctx workload.Runctx) error {
+	result, err := w.collection.Get(w.key(rctx), &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
+	if err != nil {
+		return fmt.Errorf("document fetch failed: %s", err.Error())
+	}
+	workload.ObserveContent(result)
+	return nil
+}
+
+// Write a random JSON document back unchanged, by default failing if it changed since it was read
+func (w existingData) update(ctx context.Context, rctx workload.Runctx) error {
+	key := w.key(rctx)
+	transcoder := rctx.PayloadTranscoder(gocb.NewRawJSONTranscoder())
+	result, err := w.collection.Get(key, &gocb.GetOptions{Context: ctx, Transcoder: transcoder})
+	if err != nil {
+		return fmt.Errorf("document fetch failed: %s", err.Error())
+	}
+	var content []byte
+	err = result.Content(&content)
+	if err != nil {
+		return fmt.Errorf("document %s is not JSON: %s", key, err.Error())
+	}
+
+	semantics := w.mutations.For("update", workload.MutationReplace)
+	_, err = rctx.Mutate(ctx, w.collection, semantics, workload.Mutation{Key: key, Value: content, Cas: result.Cas(), Transcoder: transcoder, PreserveExpiry: true})
+	return err
+}
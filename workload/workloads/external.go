@@ -0,0 +1,220 @@
+package workloads
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// externalInitTimeout is how long an external workload has to start and describe itself.
+const externalInitTimeout = 30 * time.Second
+
+// ExternalConfig is what an external workload is told about the run, so that it can connect to the
+// cluster itself.
+type ExternalConfig struct {
+	Connstr       string `json:"connstr"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Cert          string `json:"cert,omitempty"`
+	ClientCert    string `json:"clientCert,omitempty"`
+	ClientKey     string `json:"clientKey,omitempty"`
+	TLSSkipVerify bool   `json:"tlsSkipVerify"`
+	Bucket        string `json:"bucket"`
+	Scope         string `json:"scope"`
+	Collection    string `json:"collection"`
+	NumItems      int    `json:"numItems"`
+	Seed          int    `json:"seed"`
+	KeyNamespace  string `json:"keyNamespace"`
+	IndexPrefix   string `json:"indexPrefix"`
+	// DapiConnstr is the Data API to make requests through, such as that of the mock backend
+	DapiConnstr string `json:"dapiConnstr,omitempty"`
+}
+
+// externalDescription is the reply of an external workload to init, describing the workload.
+type externalDescription struct {
+	Operations    []string                 `json:"operations"`
+	Probabilities [][]float64              `json:"probabilities"`
+	Descriptions  []workload.OperationInfo `json:"descriptions"`
+	// Documents is set if the workload generates the documents loaded by the setup, rather than
+	// loading none
+	Documents bool `json:"documents"`
+	// Hooks lists the optional methods the workload implements, cleanup and validate
+	Hooks []string `json:"hooks"`
+}
+
+func (d externalDescription) validate() error {
+	if len(d.Operations) == 0 {
+		return fmt.Errorf("no operations")
+	}
+	if len(d.Probabilities) != len(d.Operations) {
+		return fmt.Errorf("%d rows of probabilities for %d operations", len(d.Probabilities), len(d.Operations))
+	}
+	for i, row := range d.Probabilities {
+		if len(row) != len(d.Operations) {
+			return fmt.Errorf("row %d of probabilities has %d columns for %d operations", i, len(row), len(d.Operations))
+		}
+	}
+	return nil
+}
+
+// externalInvocation is the params of a call to run an operation of an external workload.
+type externalInvocation struct {
+	Operation string `json:"operation"`
+	Runner    int    `json:"runner"`
+	// Seed is drawn from the random numbers of the runner, so that the choices the workload makes
+	// with it are the same from one run to the next
+	Seed int64 `json:"seed"`
+}
+
+// external runs a workload implemented by another process, which it calls over the process's
+// stdin and stdout, so that workloads can be written without forking spectroperf.
+type external struct {
+	path string
+	rpc  *rpcClient
+	desc externalDescription
+}
+
+// validatingExternal is an external workload that validates its data at the end of the run.
+type validatingExternal struct {
+	external
+}
+
+// NewExternal starts the external workload at path with args and describes the run to it.  A
+// path ending in .so is loaded as a Go plugin instead of run as a process.
+func NewExternal(path string, args []string, cfg ExternalConfig) (workload.Workload, error) {
+	if strings.HasSuffix(path, ".so") {
+		return openPlugin(path, cfg)
+	}
+
+	rpc, err := startRPC(path, args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), externalInitTimeout)
+	defer cancel()
+	var desc externalDescription
+	err = rpc.call(ctx, "init", cfg, &desc)
+	if err == nil {
+		err = desc.validate()
+	}
+	if err != nil {
+		rpc.Close()
+		return nil, errors.Wrapf(err, "failed to initialise external workload %s", path)
+	}
+
+	w := external{path: path, rpc: rpc, desc: desc}
+	if slices.Contains(desc.Hooks, "validate") {
+		return validatingExternal{w}, nil
+	}
+	return w, nil
+}
+
+// GenerateDocument asks the workload for a document, or returns an empty one if it does not
+// generate them.
+func (w external) GenerateDocument(id string) workload.DocType {
+	if !w.desc.Documents {
+		return workload.DocType{Name: id}
+	}
+
+	var data json.RawMessage
+	err := w.rpc.call(context.Background(), "generate", map[string]string{"id": id}, &data)
+	if err != nil {
+		zap.L().Error("External workload failed to generate document", zap.String("workload", w.path), zap.String("id", id), zap.Error(err))
+		return workload.DocType{Name: id}
+	}
+	return workload.DocType{Name: id, Data: data}
+}
+
+// GenerateDocuments asks the workload for each document in a single batch, rather than waiting
+// for the reply to each before asking for the next.
+func (w external) GenerateDocuments(ids []string) []workload.DocType {
+	if !w.desc.Documents {
+		docs := make([]workload.DocType, len(ids))
+		for i, id := range ids {
+			docs[i] = workload.DocType{Name: id}
+		}
+		return docs
+	}
+
+	params := make([]any, len(ids))
+	results := make([]any, len(ids))
+	data := make([]json.RawMessage, len(ids))
+	for i, id := range ids {
+		params[i] = map[string]string{"id": id}
+		results[i] = &data[i]
+	}
+	errs := w.rpc.callEach(context.Background(), "generate", params, results)
+	docs := make([]workload.DocType, len(ids))
+	for i, id := range ids {
+		docs[i] = workload.DocType{Name: id, Data: data[i]}
+		if errs[i] != nil {
+			zap.L().Error("External workload failed to generate document", zap.String("workload", w.path), zap.String("id", id), zap.Error(errs[i]))
+			docs[i].Data = nil
+		}
+	}
+	return docs
+}
+
+// Preloaded returns true if the workload does not generate documents, so that the setup loads
+// none rather than empty ones.
+func (w external) Preloaded() bool {
+	return !w.desc.Documents
+}
+
+func (w external) Operations() []string {
+	return w.desc.Operations
+}
+
+func (w external) Probabilities() [][]float64 {
+	return w.desc.Probabilities
+}
+
+func (w external) Describe() []workload.OperationInfo {
+	if len(w.desc.Descriptions) == len(w.desc.Operations) {
+		return w.desc.Descriptions
+	}
+	info := make([]workload.OperationInfo, len(w.desc.Operations))
+	for i, op := range w.desc.Operations {
+		info[i] = workload.OperationInfo{Name: op, Description: "operation of external workload " + w.path}
+	}
+	return info
+}
+
+func (w external) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	functions := map[string]func(ctx context.Context, rctx workload.Runctx) error{}
+	for _, op := range w.desc.Operations {
+		functions[op] = func(ctx context.Context, rctx workload.Runctx) error {
+			return w.rpc.call(ctx, "invoke", externalInvocation{Operation: op, Runner: rctx.RunnerId(), Seed: rctx.Rand().Int63()}, nil)
+		}
+	}
+	return functions
+}
+
+func (w external) Setup(ctx context.Context) error {
+	return w.rpc.call(ctx, "setup", nil, nil)
+}
+
+// Cleanup asks the workload to remove what its operations created, if it can.
+func (w external) Cleanup(ctx context.Context) error {
+	if !slices.Contains(w.desc.Hooks, "cleanup") {
+		return nil
+	}
+	return w.rpc.call(ctx, "cleanup", nil, nil)
+}
+
+// Close stops the process of the workload.
+func (w external) Close() error {
+	return w.rpc.Close()
+}
+
+func (w validatingExternal) Validate(ctx context.Context) error {
+	return w.rpc.call(ctx, "validate", nil, nil)
+}
@@ -0,0 +1,193 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/brianvoe/gofakeit"
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+)
+
+// inbox keeps the messages of each user in an array in their inbox document, which grows through
+// the run as messages are appended with sub-document operations, and is read with projections.
+type inbox struct {
+	numItems   int
+	collection *gocb.Collection
+	// messages is the most messages an inbox starts with
+	messages int
+}
+
+// Inbox is the document holding the messages sent to a user.
+type Inbox struct {
+	Owner     string
+	Unread    int
+	Messages  []Message
+	Namespace string
+}
+
+// Message is a single message in an inbox.
+type Message struct {
+	From    string
+	Sent    time.Time
+	Subject string
+	Body    string
+}
+
+func NewInbox(numItems int, collection *gocb.Collection) inbox {
+	return inbox{
+		numItems:   numItems,
+		collection: collection,
+		messages:   10,
+	}
+}
+
+// WithMessages sets the most messages an inbox starts with, to start the run with large documents.
+func (w inbox) WithMessages(messages int) (inbox, error) {
+	if messages < 0 {
+		return w, fmt.Errorf("inbox messages %d must not be negative", messages)
+	}
+	w.messages = messages
+	return w, nil
+}
+
+func newMessage() Message {
+	return Message{
+		From:    gofakeit.Email(),
+		Sent:    time.Now(),
+		Subject: gofakeit.Sentence(gofakeit.Number(3, 8)),
+		Body:    gofakeit.Paragraph(1, gofakeit.Number(1, 5), gofakeit.Number(5, 15), "\n"),
+	}
+}
+
+// Create an inbox holding up to the most messages an inbox starts with.
+func (w inbox) GenerateDocument(id string) workload.DocType {
+	doc := Inbox{
+		Owner:     gofakeit.Email(),
+		Messages:  []Message{},
+		Namespace: workload.KeyNamespace,
+	}
+	for i := 0; i < gofakeit.Number(0, w.messages); i++ {
+		doc.Messages = append(doc.Messages, newMessage())
+	}
+	doc.Unread = gofakeit.Number(0, len(doc.Messages))
+	return workload.DocType{Name: id, Data: doc}
+}
+
+func (w inbox) Operations() []string {
+	return []string{"sendMessage", "readLatest", "readInbox", "markRead"}
+}
+
+func (w inbox) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "sendMessage", Description: "Append a message to the inbox of a random user and count it as unread with one sub-document mutation, growing the document", Services: []string{"kv"}, Writes: true},
+		{Name: "readLatest", Description: "Look up the unread count, number of messages and latest message of the user's own inbox with sub-document reads", Services: []string{"kv"}},
+		{Name: "readInbox", Description: "Get the owner and every message of the user's own inbox with a projection", Services: []string{"kv"}},
+		{Name: "markRead", Description: "Set the unread count of the user's own inbox to zero with a sub-document mutation", Services: []string{"kv"}, Writes: true},
+	}
+}
+
+// Messages are sent as often as they are checked for, and the whole inbox is read occasionally.
+func (w inbox) Probabilities() [][]float64 {
+	return [][]float64{
+		{0.5, 0.3, 0.1, 0.1},
+		{0.5, 0.3, 0.1, 0.1},
+		{0.5, 0.3, 0.1, 0.1},
+		{0.5, 0.3, 0.1, 0.1},
+	}
+}
+
+func (w inbox) Setup(ctx context.Context) error {
+	return nil
+}
+
+func (w inbox) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
+		"sendMessage": w.sendMessage, // message someone
+		"readLatest":  w.readLatest,  // check for new messages
+		"readInbox":   w.readInbox,   // open the inbox
+		"markRead":    w.markRead,    // dismiss the notification
+	}
+}
+
+func inboxKey(i int32) string {
+	return workload.NamespacedKey(fmt.Sprintf("u%d", i))
+}
+
+// ownInbox returns the key of the inbox of the user.
+func (w inbox) ownInbox(rctx workload.Runctx) string {
+	return rctx.Key(inboxKey(int32(rctx.RunnerId() % w.numItems)))
+}
+
+// Send a message to a random user
+func (w inbox) sendMessage(ctx context.Context, rctx workload.Runctx) error {
+	key := rctx.Key(inboxKey(rctx.Item(w.numItems)))
+	ops := []gocb.MutateInSpec{
+		gocb.ArrayAppendSpec("Messages", newMessage(), nil),
+		gocb.IncrementSpec("Unread", 1, nil),
+	}
+	_, err := w.collection.MutateIn(key, ops, &gocb.MutateInOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("message append failed: %s", err.Error())
+	}
+	return nil
+}
+
+// Check the inbox of the user for new messages
+func (w inbox) readLatest(ctx context.Context, rctx workload.Runctx) error {
+	ops := []gocb.LookupInSpec{
+		gocb.GetSpec("Unread", nil),
+		gocb.CountSpec("Messages", nil),
+		gocb.GetSpec("Messages[-1]", nil),
+	}
+	result, err := w.collection.LookupIn(w.ownInbox(rctx), ops, &gocb.LookupInOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("inbox lookup failed: %s", err.Error())
+	}
+
+	var unread, count int
+	err = result.ContentAt(0, &unread)
+	if err == nil {
+		err = result.ContentAt(1, &count)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read inbox counts: %s", err.Error())
+	}
+	// An empty inbox has no latest message
+	if count > 0 {
+		var latest Message
+		err = result.ContentAt(2, &latest)
+		if err != nil {
+			return fmt.Errorf("unable to read latest message: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Read every message in the inbox of the user
+func (w inbox) readInbox(ctx context.Context, rctx workload.Runctx) error {
+	result, err := w.collection.Get(w.ownInbox(rctx), &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil), Project: []string{"Owner", "Messages"}})
+	if err != nil {
+		return fmt.Errorf("inbox fetch failed: %s", err.Error())
+	}
+
+	var doc Inbox
+	err = result.Content(&doc)
+	if err != nil {
+		return fmt.Errorf("unable to load inbox into struct: %s", err.Error())
+	}
+	return nil
+}
+
+// Mark the messages in the inbox of the user as read
+func (w inbox) markRead(ctx context.Context, rctx workload.Runctx) error {
+	ops := []gocb.MutateInSpec{
+		gocb.ReplaceSpec("Unread", 0, nil),
+	}
+	_, err := w.collection.MutateIn(w.ownInbox(rctx), ops, &gocb.MutateInOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("mark read failed: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package workloads
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+)
+
+// mgmt polls lightweight endpoints of the management REST API, as monitoring agents do.  It is run
+// at a low rate alongside a data workload, to reproduce the impact of monitoring on data latency.
+type mgmt struct {
+	baseURL  string
+	bucket   string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewMgmt returns a workload polling the management API at baseURL, which authenticates as
+// username unless it is empty, such as when a client certificate in the TLS config authenticates.
+func NewMgmt(baseURL string, bucket string, username string, password string, tlsConfig *tls.Config) mgmt {
+	return mgmt{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		bucket:   bucket,
+		username: username,
+		password: password,
+		client:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}
+}
+
+// MgmtURL returns the management REST API address of a cluster from the hostname in its
+// connection string, on the TLS port for a couchbases:// connection string.
+func MgmtURL(connstr string) (string, error) {
+	u, err := url.Parse(connstr)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid connection string")
+	}
+	// A connection string may list several comma separated hosts, any of which will do
+	host, _, _ := strings.Cut(u.Host, ",")
+	host, _, _ = strings.Cut(host, ":")
+	if host == "" {
+		return "", fmt.Errorf("connection string %s has no host", connstr)
+	}
+
+	if u.Scheme == "couchbases" {
+		return "https://" + host + ":18091", nil
+	}
+	return "http://" + host + ":8091", nil
+}
+
+// The mgmt workload has no documents of its own.
+func (w mgmt) GenerateDocument(id string) workload.DocType {
+	return workload.DocType{Name: id}
+}
+
+func (w mgmt) Operations() []string {
+	return []string{"poolsDefault", "bucketStats", "listCollections"}
+}
+
+func (w mgmt) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "poolsDefault", Description: "GET /pools/default, the cluster overview polled by most monitoring agents", Services: []string{"mgmt"}},
+		{Name: "bucketStats", Description: "GET the stats of the bucket under test", Services: []string{"mgmt"}},
+		{Name: "listCollections", Description: "GET the scopes and collections of the bucket under test", Services: []string{"mgmt"}},
+	}
+}
+
+func (w mgmt) Probabilities() [][]float64 {
+	return [][]float64{
+		{0.4, 0.4, 0.2},
+		{0.4, 0.4, 0.2},
+		{0.4, 0.4, 0.2},
+	}
+}
+
+func (w mgmt) Setup(ctx context.Context) error {
+	return nil
+}
+
+func (w mgmt) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
+		"poolsDefault":    w.poolsDefault,    // check the health of the cluster
+		"bucketStats":     w.bucketStats,     // graph the bucket
+		"listCollections": w.listCollections, // discover what to monitor
+	}
+}
+
+func (w mgmt) get(ctx context.Context, rctx workload.Runctx, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", w.baseURL+path, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to build management request")
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("management request failed: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	rctx.ObserveHTTPResponse(req.Method, resp.StatusCode)
+
+	_, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response body: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned unexpected status code %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// Fetch the cluster overview
+func (w mgmt) poolsDefault(ctx context.Context, rctx workload.Runctx) error {
+	return w.get(ctx, rctx, "/pools/default")
+}
+
+// Fetch the stats of the bucket
+func (w mgmt) bucketStats(ctx context.Context, rctx workload.Runctx) error {
+	return w.get(ctx, rctx, "/pools/default/buckets/"+url.PathEscape(w.bucket)+"/stats")
+}
+
+// List the scopes and collections of the bucket
+func (w mgmt) listCollections(ctx context.Context, rctx workload.Runctx) error {
+	return w.get(ctx, rctx, "/pools/default/buckets/"+url.PathEscape(w.bucket)+"/scopes")
+}
@@ -0,0 +1,32 @@
+package workloads
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/couchbaselabs/spectroperf/workload"
+	"github.com/pkg/errors"
+)
+
+// openPlugin loads a workload compiled as a Go plugin, which must export
+//
+//	func NewWorkload(cfg workloads.ExternalConfig) (workload.Workload, error)
+//
+// and be built with the same version of Go, and of spectroperf, as the binary loading it.  Go
+// plugins are only supported on Linux, macOS and FreeBSD, and run in process, so their operations
+// cost no more to call than those of a built in workload.
+func openPlugin(path string, cfg ExternalConfig) (workload.Workload, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open plugin %s", path)
+	}
+	sym, err := p.Lookup("NewWorkload")
+	if err != nil {
+		return nil, errors.Wrapf(err, "plugin %s has no NewWorkload", path)
+	}
+	newWorkload, ok := sym.(func(ExternalConfig) (workload.Workload, error))
+	if !ok {
+		return nil, fmt.Errorf("NewWorkload of plugin %s is a %T, expected func(workloads.ExternalConfig) (workload.Workload, error)", path, sym)
+	}
+	return newWorkload(cfg)
+}
@@ -0,0 +1,338 @@
+package workloads
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxRPCBatch is the most calls written to an external workload at once.
+	maxRPCBatch = 256
+	// maxRPCLine is the longest line an external workload may reply with, which holds a batch of
+	// replies or a generated document.
+	maxRPCLine = 64 * 1024 * 1024
+	// rpcExitTimeout is how long an external workload has to exit once its stdin is closed before
+	// it is killed.
+	rpcExitTimeout = 10 * time.Second
+)
+
+// rpcRequest is a JSON-RPC 2.0 request.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response.
+type rpcResponse struct {
+	Id     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// rpcError is the error of a call that failed, such as an operation of the workload.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcCall is a call waiting for its response.
+type rpcCall struct {
+	request rpcRequest
+	done    chan rpcResponse
+}
+
+// rpcClient calls the methods of an external workload with JSON-RPC over the stdin and stdout of
+// its process.  Calls made while others are being written are written together as a single
+// batch, so that many users calling at once cost one write rather than one each.  Responses may
+// come back one per line or as batches, in any order, so that a slow operation does not hold up
+// the rest.
+type rpcClient struct {
+	path      string
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	queue     chan []*rpcCall
+	nextId    atomic.Uint64
+	closing   chan struct{}
+	closeOnce sync.Once
+	// readDone is closed once the process has closed its stdout
+	readDone chan struct{}
+
+	mu      sync.Mutex
+	pending map[uint64]*rpcCall
+	// err is why the workload can no longer be called, once it is set
+	err  error
+	dead chan struct{}
+}
+
+// startRPC starts the process of an external workload.  What it writes to stderr is logged.
+func startRPC(path string, args []string) (*rpcClient, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = &stderrLogger{l: zap.L().With(zap.String("workload", path))}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open stdin of external workload")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open stdout of external workload")
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start external workload %s", path)
+	}
+
+	c := &rpcClient{
+		path:     path,
+		cmd:      cmd,
+		stdin:    stdin,
+		queue:    make(chan []*rpcCall, maxRPCBatch),
+		closing:  make(chan struct{}),
+		readDone: make(chan struct{}),
+		pending:  map[uint64]*rpcCall{},
+		dead:     make(chan struct{}),
+	}
+	go c.writeLoop()
+	go c.readLoop(stdout)
+	return c, nil
+}
+
+// call calls a method of the workload, decoding its result into result unless it is nil.
+func (c *rpcClient) call(ctx context.Context, method string, params any, result any) error {
+	return c.callEach(ctx, method, []any{params}, []any{result})[0]
+}
+
+// callEach calls a method of the workload once for each of params, decoding each result into the
+// matching one of results unless it is nil, and returns the error of each call.  The calls are
+// written together as a single batch.
+func (c *rpcClient) callEach(ctx context.Context, method string, params []any, results []any) []error {
+	errs := make([]error, len(params))
+	calls := make([]*rpcCall, len(params))
+	for i, p := range params {
+		calls[i] = &rpcCall{
+			request: rpcRequest{JSONRPC: "2.0", Id: c.nextId.Add(1), Method: method},
+			done:    make(chan rpcResponse, 1),
+		}
+		if p != nil {
+			var err error
+			calls[i].request.Params, err = json.Marshal(p)
+			if err != nil {
+				return fill(errs, errors.Wrapf(err, "failed to encode params of %s", method))
+			}
+		}
+	}
+
+	// The calls are pending before they are written, so that their responses cannot arrive first.
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return fill(errs, c.err)
+	}
+	for _, call := range calls {
+		c.pending[call.request.Id] = call
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		for _, call := range calls {
+			delete(c.pending, call.request.Id)
+		}
+		c.mu.Unlock()
+	}()
+
+	select {
+	case c.queue <- calls:
+	case <-ctx.Done():
+		return fill(errs, ctx.Err())
+	case <-c.dead:
+		return fill(errs, c.deadErr())
+	}
+
+	for i, call := range calls {
+		select {
+		case resp := <-call.done:
+			if resp.Error != nil {
+				errs[i] = resp.Error
+			} else if results[i] != nil {
+				errs[i] = errors.Wrapf(json.Unmarshal(resp.Result, results[i]), "invalid result of %s", method)
+			}
+		case <-ctx.Done():
+			fill(errs[i:], ctx.Err())
+			return errs
+		case <-c.dead:
+			fill(errs[i:], c.deadErr())
+			return errs
+		}
+	}
+	return errs
+}
+
+// fill sets each of errs to err, returning errs.
+func fill(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// writeLoop writes the queued calls to the workload, each batch of those queued at once as a JSON
+// array on a line of its own.
+func (c *rpcClient) writeLoop() {
+	defer c.stdin.Close()
+	w := bufio.NewWriter(c.stdin)
+	enc := json.NewEncoder(w)
+	batch := make([]rpcRequest, 0, maxRPCBatch)
+	for {
+		select {
+		case calls := <-c.queue:
+			batch = batch[:0]
+			for _, call := range calls {
+				batch = append(batch, call.request)
+			}
+		case <-c.closing:
+			return
+		case <-c.dead:
+			return
+		}
+
+	drain:
+		for len(batch) < maxRPCBatch {
+			select {
+			case calls := <-c.queue:
+				for _, call := range calls {
+					batch = append(batch, call.request)
+				}
+			default:
+				break drain
+			}
+		}
+
+		err := enc.Encode(batch)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			c.fail(errors.Wrapf(err, "failed to write to external workload %s", c.path))
+			return
+		}
+	}
+}
+
+// readLoop hands the responses of the workload to the calls waiting for them, until the workload
+// closes its stdout.
+func (c *rpcClient) readLoop(stdout io.Reader) {
+	defer close(c.readDone)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxRPCLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var responses []rpcResponse
+		var err error
+		if line[0] == '[' {
+			err = json.Unmarshal(line, &responses)
+		} else {
+			responses = make([]rpcResponse, 1)
+			err = json.Unmarshal(line, &responses[0])
+		}
+		if err != nil {
+			c.fail(errors.Wrapf(err, "invalid response from external workload %s", c.path))
+			io.Copy(io.Discard, stdout)
+			return
+		}
+
+		c.mu.Lock()
+		for _, resp := range responses {
+			if call, ok := c.pending[resp.Id]; ok {
+				select {
+				case call.done <- resp:
+				default:
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = fmt.Errorf("external workload %s exited", c.path)
+	}
+	c.fail(err)
+}
+
+// fail stops the workload from being called again, failing the calls waiting on it.
+func (c *rpcClient) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.dead)
+}
+
+func (c *rpcClient) deadErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the stdin of the workload, killing it if it does not exit in time.
+func (c *rpcClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+	select {
+	case <-c.readDone:
+	case <-time.After(rpcExitTimeout):
+		zap.L().Warn("External workload did not exit, killing it", zap.String("workload", c.path))
+		c.cmd.Process.Kill()
+		<-c.readDone
+	}
+	c.fail(fmt.Errorf("external workload %s closed", c.path))
+	if c.cmd.ProcessState != nil {
+		return nil
+	}
+	return errors.Wrapf(c.cmd.Wait(), "external workload %s failed", c.path)
+}
+
+// stderrLogger logs each line an external workload writes to stderr.
+type stderrLogger struct {
+	l       *zap.Logger
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (s *stderrLogger) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = append(s.partial, p...)
+	for {
+		line, rest, found := bytes.Cut(s.partial, []byte("\n"))
+		if !found {
+			break
+		}
+		s.l.Info("External workload", zap.ByteString("stderr", line))
+		s.partial = append(s.partial[:0], rest...)
+	}
+	return len(p), nil
+}
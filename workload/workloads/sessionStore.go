@@ -0,0 +1,167 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/brianvoe/gofakeit"
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+)
+
+// sessionStore uses the collection as a web session store: sessions are created with an expiry,
+// read with GetAndTouch on every request to keep them alive, and deleted when the user logs out.
+type sessionStore struct {
+	numItems   int
+	collection *gocb.Collection
+	ttl        time.Duration
+	// churn is the chance that a request is followed by the user logging out and a new session
+	// being created
+	churn float64
+	// sequence numbers the sessions created, shared by every runner.  It starts from the time the
+	// workload was created so that the keys of one run do not collide with those of the last.
+	sequence *atomic.Int64
+}
+
+// StoredSession is the state a web application keeps for a logged in user.
+type StoredSession struct {
+	User      string
+	Created   time.Time
+	Cart      []string
+	Settings  map[string]string
+	Namespace string
+}
+
+func NewSessionStore(numItems int, collection *gocb.Collection) sessionStore {
+	sequence := &atomic.Int64{}
+	sequence.Store(time.Now().UnixMicro())
+	return sessionStore{
+		numItems:   numItems,
+		collection: collection,
+		ttl:        30 * time.Minute,
+		churn:      0.1,
+		sequence:   sequence,
+	}
+}
+
+// WithSessions sets how long a session lasts since it was last touched, and the chance that a
+// request is followed by the user logging out.
+func (w sessionStore) WithSessions(ttl time.Duration, churn float64) (sessionStore, error) {
+	if ttl < time.Second {
+		return w, fmt.Errorf("session ttl %s must be at least 1s", ttl)
+	}
+	if churn <= 0 || churn > 1 {
+		return w, fmt.Errorf("session churn %g must be greater than 0 and at most 1", churn)
+	}
+	w.ttl = ttl
+	w.churn = churn
+	return w, nil
+}
+
+func (w sessionStore) newSession() StoredSession {
+	session := StoredSession{
+		User:      gofakeit.Username(),
+		Created:   time.Now(),
+		Settings:  map[string]string{"country": gofakeit.CountryAbr(), "timezone": gofakeit.TimeZone(), "userAgent": gofakeit.UserAgent()},
+		Namespace: workload.KeyNamespace,
+	}
+	for i := 0; i < gofakeit.Number(0, 5); i++ {
+		session.Cart = append(session.Cart, gofakeit.UUID())
+	}
+	return session
+}
+
+// Create a session, which does not expire, as sessions left behind by other clients.
+func (w sessionStore) GenerateDocument(id string) workload.DocType {
+	return workload.DocType{Name: id, Data: w.newSession()}
+}
+
+func (w sessionStore) Operations() []string {
+	return []string{"deleteSession", "createSession", "touchSession"}
+}
+
+func (w sessionStore) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "deleteSession", Description: "Remove the session of the user as they log out", Services: []string{"kv"}, Writes: true},
+		{Name: "createSession", Description: "Insert a new session for the user that expires after --session-ttl", Services: []string{"kv"}, Writes: true},
+		{Name: "touchSession", Description: "Read the session of the user with GetAndTouch, extending its expiry by --session-ttl", Services: []string{"kv"}},
+	}
+}
+
+// Every user starts out logged out, as users start at the first operation of the chain, so its
+// first operation is to log in.  After each request it logs out with the chance set by the churn,
+// logging straight back in.
+func (w sessionStore) Probabilities() [][]float64 {
+	return [][]float64{
+		{0, 1, 0},
+		{0, 0, 1},
+		{w.churn, 0, 1 - w.churn},
+	}
+}
+
+func (w sessionStore) Setup(ctx context.Context) error {
+	return nil
+}
+
+func (w sessionStore) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
+		"deleteSession": w.deleteSession, // log out
+		"createSession": w.createSession, // log in
+		"touchSession":  w.touchSession,  // handle a request
+	}
+}
+
+// sessionState is the runner state holding the key of the session of the user.
+const sessionState = "session"
+
+// currentSession returns the key of the session of the user, or a random session if they have
+// not created one.
+func (w sessionStore) currentSession(rctx workload.Runctx) string {
+	key, ok := workload.State[string](rctx, sessionState)
+	if !ok {
+		key = workload.NamespacedKey(fmt.Sprintf("u%d", rctx.Item(w.numItems)))
+	}
+	return rctx.Key(key)
+}
+
+// Create a session for the user
+func (w sessionStore) createSession(ctx context.Context, rctx workload.Runctx) error {
+	key := rctx.Key(workload.NamespacedKey(fmt.Sprintf("sess%d", w.sequence.Add(1))))
+	_, err := w.collection.Insert(key, w.newSession(), &gocb.InsertOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil), Expiry: w.ttl})
+	if err != nil {
+		return fmt.Errorf("session insert failed: %s", err.Error())
+	}
+	rctx.Set(sessionState, key)
+	return nil
+}
+
+// Read the session of the user, keeping it alive
+func (w sessionStore) touchSession(ctx context.Context, rctx workload.Runctx) error {
+	result, err := w.collection.GetAndTouch(w.currentSession(rctx), w.ttl, &gocb.GetAndTouchOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
+	if err != nil {
+		return fmt.Errorf("session get and touch failed: %s", err.Error())
+	}
+
+	var session StoredSession
+	err = result.Content(&session)
+	if err != nil {
+		return fmt.Errorf("unable to load session into struct: %s", err.Error())
+	}
+	return nil
+}
+
+// Remove the session of the user, never one of the sessions loaded for every user to share
+func (w sessionStore) deleteSession(ctx context.Context, rctx workload.Runctx) error {
+	key, ok := workload.State[string](rctx, sessionState)
+	if !ok {
+		return fmt.Errorf("no session to remove, the user has not logged in")
+	}
+	_, err := w.collection.Remove(rctx.Key(key), &gocb.RemoveOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("session remove failed: %s", err.Error())
+	}
+	rctx.Delete(sessionState)
+	return nil
+}
@@ -0,0 +1,184 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/couchbaselabs/spectroperf/workload"
+)
+
+// timeSeriesDevices is the number of devices events are recorded for.  Each runner is one of the
+// devices, appending its own events.
+const timeSeriesDevices = 1000
+
+// timeSeries appends event documents under monotonically increasing keys, as IoT and event
+// ingestion pipelines do, expiring them after a retention period, and queries windows of the
+// most recent events of a device.
+type timeSeries struct {
+	numItems   int
+	scope      *gocb.Scope
+	collection *gocb.Collection
+	retention  time.Duration
+	window     time.Duration
+	// sequence numbers the events appended, shared by every runner.  It starts from the time the
+	// workload was created so that the keys of one run follow those of the last.
+	sequence *atomic.Int64
+}
+
+// Event is a reading from a device at a point in time.
+type Event struct {
+	Device    int
+	Timestamp int64
+	Value     float64
+	Namespace string
+}
+
+func NewTimeSeries(numItems int, scope *gocb.Scope, collection *gocb.Collection) timeSeries {
+	sequence := &atomic.Int64{}
+	sequence.Store(time.Now().UnixMicro())
+	return timeSeries{
+		numItems:   numItems,
+		scope:      scope,
+		collection: collection,
+		retention:  time.Hour,
+		window:     5 * time.Minute,
+		sequence:   sequence,
+	}
+}
+
+// WithRetention sets how long appended events last before they expire, and the window of recent
+// events queried.
+func (w timeSeries) WithRetention(retention time.Duration, window time.Duration) (timeSeries, error) {
+	if retention < time.Second {
+		return w, fmt.Errorf("event retention %s must be at least 1s", retention)
+	}
+	if window <= 0 || window > retention {
+		return w, fmt.Errorf("event window %s must be positive and no longer than the retention %s", window, retention)
+	}
+	w.retention = retention
+	w.window = window
+	return w, nil
+}
+
+// Create an event from a random device at a random time within the retention period, as the
+// history the run starts with.
+func (w timeSeries) GenerateDocument(id string) workload.DocType {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	r := rand.New(rand.NewSource(int64(h.Sum64()) + int64(workload.RandSeed)))
+
+	return workload.DocType{
+		Name: id,
+		Data: Event{
+			Device:    r.Intn(timeSeriesDevices),
+			Timestamp: time.Now().Add(-time.Duration(r.Int63n(int64(w.retention)))).UnixMilli(),
+			Value:     r.NormFloat64()*10 + 20,
+			Namespace: workload.KeyNamespace,
+		},
+	}
+}
+
+func (w timeSeries) Operations() []string {
+	return []string{"appendEvent", "fetchLatestEvent", "queryRecentEvents"}
+}
+
+func (w timeSeries) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "appendEvent", Description: "Insert a new event for the device under the next key in sequence, expiring after --event-retention", Services: []string{"kv"}, Writes: true},
+		{Name: "fetchLatestEvent", Description: "Get the event the device last appended, or a random event if it has not appended one", Services: []string{"kv"}},
+		{Name: "queryRecentEvents", Description: "Count and average the events of the device within --event-window with a query using a secondary index", Services: []string{"query", "index"}},
+	}
+}
+
+// Ingestion dominates, with occasional reads of what was just written and of recent windows.
+func (w timeSeries) Probabilities() [][]float64 {
+	return [][]float64{
+		{0.8, 0.1, 0.1},
+		{0.8, 0.1, 0.1},
+		{0.8, 0.1, 0.1},
+	}
+}
+
+func (w timeSeries) Setup(ctx context.Context) error {
+	return workload.CreateQueryIndex(ctx, w.collection, "namespaceDeviceTimestampIndex", []string{"Namespace", "Device", "Timestamp"})
+}
+
+func (w timeSeries) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
+		"appendEvent":       w.appendEvent,       // record a reading
+		"fetchLatestEvent":  w.fetchLatestEvent,  // check the last reading
+		"queryRecentEvents": w.queryRecentEvents, // chart recent readings
+	}
+}
+
+// lastEventState is the runner state holding the key of the event the device last appended.
+const lastEventState = "lastEvent"
+
+func device(rctx workload.Runctx) int {
+	return rctx.RunnerId() % timeSeriesDevices
+}
+
+// Append a new event for the device
+func (w timeSeries) appendEvent(ctx context.Context, rctx workload.Runctx) error {
+	key := rctx.Key(workload.NamespacedKey(fmt.Sprintf("ev%020d", w.sequence.Add(1))))
+	event := Event{
+		Device:    device(rctx),
+		Timestamp: time.Now().UnixMilli(),
+		Value:     rctx.Rand().NormFloat64()*10 + 20,
+		Namespace: workload.KeyNamespace,
+	}
+
+	_, err := w.collection.Insert(key, event, &gocb.InsertOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil), Expiry: w.retention})
+	if err != nil {
+		return fmt.Errorf("event insert failed: %s", err.Error())
+	}
+	rctx.Set(lastEventState, key)
+	return nil
+}
+
+// Fetch the event the device last appended
+func (w timeSeries) fetchLatestEvent(ctx context.Context, rctx workload.Runctx) error {
+	key, ok := workload.State[string](rctx, lastEventState)
+	if !ok {
+		key = workload.NamespacedKey(fmt.Sprintf("u%d", rctx.Item(w.numItems)))
+	}
+	key = rctx.Key(key)
+
+	result, err := w.collection.Get(key, &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
+	if err != nil {
+		return fmt.Errorf("event fetch failed: %s", err.Error())
+	}
+	workload.ObserveContent(result)
+	return nil
+}
+
+// Summarise the recent events of the device
+func (w timeSeries) queryRecentEvents(ctx context.Context, rctx workload.Runctx) error {
+	query := fmt.Sprintf("SELECT COUNT(*) AS events, AVG(`Value`) AS mean FROM `%s` WHERE Namespace = $namespace AND Device = $device AND Timestamp >= $from", w.collection.Name())
+	params := map[string]interface{}{
+		"namespace": workload.KeyNamespace,
+		"device":    device(rctx),
+		"from":      time.Now().Add(-w.window).UnixMilli(),
+	}
+
+	rows, err := w.scope.Query(query, workload.Queries.SDKOptions(ctx, params))
+	if err != nil {
+		return fmt.Errorf("query failed: %s", err.Error())
+	}
+	var returned int
+	for rows.Next() {
+		returned++
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error iterating the rows: %s", err.Error())
+	}
+	rctx.ObserveQueryResult(rows)
+	rctx.ObserveRows(returned)
+	return nil
+}
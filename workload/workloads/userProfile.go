@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"github.com/brianvoe/gofakeit"
+	"github.com/brianvoe/gofakeit/data"
 	"github.com/couchbase/gocb/v2"
 	"github.com/couchbaselabs/spectroperf/workload"
 	"github.com/pkg/errors"
 	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,28 +20,169 @@ type userProfile struct {
 	numItems   int
 	scope      *gocb.Scope
 	collection *gocb.Collection
+	// identityScopes are the scope connected as each identity, by username, when runners
+	// authenticate as their own users
+	identityScopes map[string]*gocb.Scope
+	scanSize       workload.ScanSize
+	lockMode       string
+	lockDuration   time.Duration
+	lockHold       time.Duration
+	// replicaReads is the fraction of fetchProfile reads made from any replica
+	replicaReads float64
+	batchSize    int
+	churn        string
+	// mutations are the semantics updateProfile and lockProfile write profiles back with
+	mutations workload.MutationSemantics
+	// inserted counts the profiles inserted beyond numItems when the keyspace grows, shared by
+	// every runner
+	inserted *atomic.Int32
+	// locked holds the CAS each profile locked during the run was locked with, by key, shared by
+	// every runner.  A profile replaced with a new one or deleted is no longer locked, so its key
+	// is removed, which keeps the keys held to at most one for each profile however long the run.
+	locked *sync.Map
 }
 
+const (
+	// LockModeFlag locks a profile by disabling it with an upsert
+	LockModeFlag = "flag"
+	// LockModePessimistic locks a profile with GetAndLock while disabling it, so that concurrent
+	// locks of the same profile contend
+	LockModePessimistic = "pessimistic"
+
+	// ChurnPolicyGrow inserts profiles under new keys, growing the keyspace
+	ChurnPolicyGrow = "grow"
+	// ChurnPolicyRecycle inserts profiles under the keys of the loaded profiles, removing the
+	// profile first if it was not already deleted, so the keyspace stays the same size
+	ChurnPolicyRecycle = "recycle"
+)
+
 func NewUserProfile(numItems int, scope *gocb.Scope, collection *gocb.Collection) userProfile {
 	return userProfile{
 		numItems:   numItems,
 		scope:      scope,
 		collection: collection,
+		scanSize:   workload.DefaultScanSize,
+		lockMode:   LockModeFlag,
+		batchSize:  10,
+		churn:      ChurnPolicyRecycle,
+		inserted:   &atomic.Int32{},
+		locked:     &sync.Map{},
+	}
+}
+
+// WithLocking sets how lockProfile locks a profile.  In pessimistic mode the profile is locked
+// for up to duration, and held for hold before it is written and unlocked.
+func (w userProfile) WithLocking(mode string, duration time.Duration, hold time.Duration) (userProfile, error) {
+	switch mode {
+	case LockModeFlag:
+	case LockModePessimistic:
+		if duration < time.Second {
+			return w, fmt.Errorf("lock duration %s must be at least 1s", duration)
+		}
+		if hold >= duration {
+			return w, fmt.Errorf("lock hold %s must be shorter than the lock duration %s", hold, duration)
+		}
+	default:
+		return w, fmt.Errorf("unknown lock mode %s, expected %s or %s", mode, LockModeFlag, LockModePessimistic)
+	}
+	w.lockMode = mode
+	w.lockDuration = duration
+	w.lockHold = hold
+	return w, nil
+}
+
+// WithReplicaReads sets the fraction of fetchProfile reads that are made from any replica rather
+// than the active copy.
+func (w userProfile) WithReplicaReads(fraction float64) (userProfile, error) {
+	if fraction < 0 || fraction > 1 {
+		return w, fmt.Errorf("replica read fraction %g must be between 0 and 1", fraction)
+	}
+	w.replicaReads = fraction
+	return w, nil
+}
+
+// WithBatchSize sets the number of profiles read or written by each bulk operation.
+func (w userProfile) WithBatchSize(size int) (userProfile, error) {
+	if size < 1 {
+		return w, fmt.Errorf("batch size %d must be at least 1", size)
+	}
+	w.batchSize = size
+	return w, nil
+}
+
+// WithChurnPolicy sets which keys insertProfile inserts profiles under.
+func (w userProfile) WithChurnPolicy(policy string) (userProfile, error) {
+	if policy != ChurnPolicyGrow && policy != ChurnPolicyRecycle {
+		return w, fmt.Errorf("unknown churn policy %s, expected %s or %s", policy, ChurnPolicyGrow, ChurnPolicyRecycle)
+	}
+	w.churn = policy
+	return w, nil
+}
+
+// WithMutationSemantics sets the semantics updateProfile and the flag mode of lockProfile write
+// profiles back with, by default upsert.
+func (w userProfile) WithMutationSemantics(semantics workload.MutationSemantics) userProfile {
+	w.mutations = semantics
+	return w
+}
+
+// WithScanSize sets the distribution of the number of profiles read by each range scan.
+func (w userProfile) WithScanSize(size workload.ScanSize) userProfile {
+	w.scanSize = size
+	return w
+}
+
+// WithIdentities has each runner use the scope connected as its identity, rather than the scope
+// the workload was created with.
+func (w userProfile) WithIdentities(scopes map[string]*gocb.Scope) userProfile {
+	w.identityScopes = scopes
+	return w
+}
+
+// scopeFor returns the scope to run an operation in, as the identity of the runner if it has one.
+func (w userProfile) scopeFor(rctx workload.Runctx) *gocb.Scope {
+	if identity, ok := rctx.Identity(); ok {
+		if scope, ok := w.identityScopes[identity.Username]; ok {
+			return scope
+		}
 	}
+	return w.scope
+}
+
+func (w userProfile) collectionFor(rctx workload.Runctx) *gocb.Collection {
+	return w.scopeFor(rctx).Collection(w.collection.Name())
 }
 
 type User struct {
-	Name    string
-	Email   string
-	Created time.Time
-	Status  string
-	Enabled bool
+	Name      string
+	Email     string
+	Created   time.Time
+	Status    string
+	Enabled   bool
+	Namespace string
+}
+
+// Session is created when a user logs in and removed when they log out.  Sessions that are never
+// logged out of expire after sessionExpiry.
+type Session struct {
+	Profile   string
+	Created   time.Time
+	Namespace string
 }
 
+const sessionExpiry = 30 * time.Minute
+
 type UserQueryResponse struct {
+	Id       string `json:"id"`
 	Profiles User
 }
 
+// foundProfile is the result findProfile passes on to the next operation.
+type foundProfile struct {
+	Key   string
+	Email string
+}
+
 // Create a random document with a realistic size from name, email, status text and whether
 // or not the account is enabled.
 func (w userProfile) GenerateDocument(id string) workload.DocType {
@@ -44,11 +190,12 @@ func (w userProfile) GenerateDocument(id string) workload.DocType {
 	r := rand.New(rng)
 
 	iu := User{
-		Name:    gofakeit.Name(),
-		Email:   gofakeit.Email(), // TODO: make the email actually based on the name (pedantic)
-		Created: gofakeit.DateRange(time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)),
-		Status:  gofakeit.Paragraph(1, r.Intn(8)+1, r.Intn(12)+1, "\n"),
-		Enabled: true,
+		Name:      gofakeit.Name(),
+		Email:     gofakeit.Email(), // TODO: make the email actually based on the name (pedantic)
+		Created:   gofakeit.DateRange(time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)),
+		Status:    gofakeit.Paragraph(1, r.Intn(8)+1, r.Intn(12)+1, "\n"),
+		Enabled:   true,
+		Namespace: workload.KeyNamespace,
 	}
 
 	return workload.DocType{
@@ -58,23 +205,56 @@ func (w userProfile) GenerateDocument(id string) workload.DocType {
 }
 
 func (w userProfile) Operations() []string {
-	return []string{"fetchProfile", "updateProfile", "lockProfile", "findProfile", "findRelatedProfiles"}
+	return []string{"logout", "login", "fetchProfile", "updateProfile", "lockProfile", "findProfile", "findRelatedProfiles", "scanProfiles", "prefixScanProfiles", "fetchProfileAnyReplica", "fetchProfileAllReplicas", "bulkFetchProfiles", "bulkUpsertProfiles", "changeEmail", "deleteProfile", "insertProfile"}
 }
 
+func (w userProfile) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "logout", Description: "Remove the session of the user", Services: []string{"kv"}, Writes: true},
+		{Name: "login", Description: "Insert a session for a random profile that expires if the user never logs out", Services: []string{"kv"}, Writes: true},
+		{Name: "fetchProfile", Description: "Get a random profile, similar to logging in or looking at someone, from any replica for a fraction of reads set by --replica-reads", Services: []string{"kv"}},
+		{Name: "updateProfile", Description: "Get the profile the user just found or last fetched, or a random one, and upsert it with a new status", Services: []string{"kv"}, Writes: true},
+		{Name: "lockProfile", Description: "Get a random profile and upsert it disabled, as in an account lockout, or with --lock-mode pessimistic, disable it while holding a lock on it", Services: []string{"kv"}, Writes: true},
+		{Name: "findProfile", Description: "Find a profile by email address prefix with a query using a secondary index", Services: []string{"query", "index"}},
+		{Name: "findRelatedProfiles", Description: "Look for people with similar interests (not yet implemented)", Services: []string{}},
+		{Name: "scanProfiles", Description: "Read a number of profiles with a KV range scan, from a random profile onwards (off by default)", Services: []string{"kv"}},
+		{Name: "prefixScanProfiles", Description: "Read the profiles whose keys start with the key of a random profile with a KV prefix scan (off by default)", Services: []string{"kv"}},
+		{Name: "fetchProfileAnyReplica", Description: "Get a random profile from whichever of the active copy and replicas answers first (off by default)", Services: []string{"kv"}},
+		{Name: "fetchProfileAllReplicas", Description: "Get a random profile from the active copy and every replica (off by default)", Services: []string{"kv"}},
+		{Name: "bulkFetchProfiles", Description: "Get a batch of random profiles with a bulk operation (off by default)", Services: []string{"kv"}},
+		{Name: "bulkUpsertProfiles", Description: "Upsert a batch of newly generated random profiles with a bulk operation, as an ETL client would (off by default)", Services: []string{"kv"}, Writes: true},
+		{Name: "changeEmail", Description: "Give a random profile a new email address, then find it by that address with a request_plus query, measuring how long until the change is visible (off by default)", Services: []string{"kv", "query", "index"}, Writes: true},
+		{Name: "deleteProfile", Description: "Remove a random profile, leaving a tombstone (off by default)", Services: []string{"kv"}, Writes: true},
+		{Name: "insertProfile", Description: "Insert a newly generated profile, under a new key or, with --churn-policy recycle, a recycled one (off by default)", Services: []string{"kv"}, Writes: true},
+	}
+}
+
+// Every user starts out logged out, so the first operation is always a login.
 func (w userProfile) Probabilities() [][]float64 {
 	return [][]float64{
-		{0, 0.7, 0.1, 0.15, 0.05},
-		{0.8, 0, 0.1, 0.05, 0.05},
-		{0.7, 0.2, 0, 0.05, 0.05},
-		{0.6, 0.2, 0.15, 0, 0.05},
-		{0.6, 0.2, 0.15, 0.05, 0},
+		{0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0.7, 0.1, 0.05, 0.1, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0, 0.65, 0.1, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.75, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.65, 0.2, 0, 0.05, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.55, 0.2, 0.15, 0, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.55, 0.2, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.55, 0.2, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.55, 0.2, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0, 0.65, 0.1, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0, 0.65, 0.1, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0, 0.65, 0.1, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0, 0.65, 0.1, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0, 0.65, 0.1, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.55, 0.2, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0.05, 0, 0.55, 0.2, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 	}
 }
 
-func (w userProfile) Setup() error {
+func (w userProfile) Setup(ctx context.Context) error {
 	gofakeit.Seed(int64(workload.RandSeed))
 
-	err := createQueryIndex(w.collection)
+	err := createQueryIndex(ctx, w.collection)
 	if err != nil {
 		return err
 	}
@@ -82,44 +262,190 @@ func (w userProfile) Setup() error {
 	return nil
 }
 
-func createQueryIndex(collection *gocb.Collection) error {
-	mgr := collection.QueryIndexes()
-	err := mgr.CreateIndex("eMailIndex", []string{"Email"}, &gocb.CreateQueryIndexOptions{
-		IgnoreIfExists: true,
-	})
+func createQueryIndex(ctx context.Context, collection *gocb.Collection) error {
+	return workload.CreateQueryIndex(ctx, collection, "namespaceEmailIndex", []string{"Namespace", "Email"})
+}
 
-	if err != nil {
-		return errors.Wrap(err, "failed to create eMailIndex")
+func (w userProfile) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
+	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
+		"logout":                  w.logout,                  // end the session of the user
+		"login":                   w.login,                   // start a session for a random profile
+		"fetchProfile":            w.fetchProfile,            // similar to login or looking at someone
+		"updateProfile":           w.updateProfile,           // updating a status on the profile
+		"lockProfile":             w.lockProfile,             // disable or enable a random profile (account lockout)
+		"findProfile":             w.findProfile,             // find a profile by a secondary index (email address)
+		"findRelatedProfiles":     w.findRelatedProfiles,     // look for people with similar interests
+		"scanProfiles":            w.scanProfiles,            // browse through profiles in key order
+		"prefixScanProfiles":      w.prefixScanProfiles,      // browse through profiles sharing a key prefix
+		"fetchProfileAnyReplica":  w.fetchProfileAnyReplica,  // looking at someone, from the fastest copy
+		"fetchProfileAllReplicas": w.fetchProfileAllReplicas, // looking at someone, from every copy
+		"bulkFetchProfiles":       w.bulkFetchProfiles,       // export a batch of profiles
+		"bulkUpsertProfiles":      w.bulkUpsertProfiles,      // import a batch of profiles
+		"changeEmail":             w.changeEmail,             // change an email address and look it up straight away
+		"deleteProfile":           w.deleteProfile,           // close an account
+		"insertProfile":           w.insertProfile,           // sign up a new account
 	}
+}
+
+// profileKey returns the key of the i'th profile loaded during setup.
+func profileKey(i int32) string {
+	return workload.NamespacedKey(fmt.Sprintf("u%d", i))
+}
+
+// lastProfileState is the runner state holding the key of the profile the user last fetched.
+const lastProfileState = "lastProfile"
+
+// lastProfileKey returns the key of the profile the user is looking at, so that updates act on
+// it: the profile found by the operation just before, else the profile the user last fetched, or
+// a random profile if they have not fetched one yet.
+func lastProfileKey(rctx workload.Runctx, numItems int) string {
+	if found, ok := workload.PreviousResult[foundProfile](rctx); ok {
+		return rctx.Key(found.Key)
+	}
+	key, ok := workload.State[string](rctx, lastProfileState)
+	if !ok {
+		key = profileKey(rctx.Item(numItems))
+	}
+	return rctx.Key(key)
+}
+
+// sessionKey returns the key of the session of the user, which includes the target in an A/B run
+// as each target numbers its runners from zero.
+func sessionKey(rctx workload.Runctx) string {
+	if target := rctx.Target(); target != "" {
+		return workload.NamespacedKey(fmt.Sprintf("s%s-%d", target, rctx.RunnerId()))
+	}
+	return workload.NamespacedKey(fmt.Sprintf("s%d", rctx.RunnerId()))
+}
 
+// randomStatus writes a status of up to eight sentences of lorem ipsum.  The words are drawn from
+// the random numbers of the runner rather than the global ones of gofakeit, so that a run with the
+// same seed writes the same statuses.
+func randomStatus(r *rand.Rand) string {
+	sentences, words := r.Intn(8)+1, r.Intn(12)+1
+	lorem := data.Lorem["word"]
+
+	var status strings.Builder
+	for i := 0; i < sentences; i++ {
+		if i > 0 {
+			status.WriteByte(' ')
+		}
+		for j := 0; j < words; j++ {
+			word := lorem[r.Intn(len(lorem))]
+			if j == 0 {
+				word = strings.ToUpper(word[:1]) + word[1:]
+			} else {
+				status.WriteByte(' ')
+			}
+			status.WriteString(word)
+		}
+		status.WriteByte('.')
+	}
+	return status.String()
+}
+
+// randomLetter returns a lower case letter drawn from the random numbers of the runner.
+func randomLetter(r *rand.Rand) string {
+	return string(rune('a' + r.Intn(26)))
+}
+
+// randomEmail returns an address made up as gofakeit makes them, from the random numbers of the
+// runner.
+func randomEmail(r *rand.Rand) string {
+	pick := func(values []string) string {
+		return values[r.Intn(len(values))]
+	}
+	email := pick(data.Person["first"]) + pick(data.Person["last"]) + "@" + pick(data.Person["last"]) + "." + pick(data.Internet["domain_suffix"])
+	return strings.ToLower(email)
+}
+
+// Start a session for a random profile
+func (w userProfile) login(ctx context.Context, rctx workload.Runctx) error {
+	session := Session{
+		Profile:   rctx.Key(profileKey(rctx.Item(w.numItems))),
+		Created:   time.Now(),
+		Namespace: workload.KeyNamespace,
+	}
+
+	_, err := w.collectionFor(rctx).Insert(sessionKey(rctx), session, &gocb.InsertOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil), Expiry: sessionExpiry})
+	if errors.Is(err, gocb.ErrDocumentExists) {
+		// A session left behind by an earlier run that stopped before logging out.
+		_, err = w.collectionFor(rctx).Upsert(sessionKey(rctx), session, &gocb.UpsertOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil), Expiry: sessionExpiry})
+	}
+	if err != nil {
+		return fmt.Errorf("session insert failed: %s", err.Error())
+	}
 	return nil
 }
 
-func (w userProfile) Functions() map[string]func(ctx context.Context, rctx workload.Runctx) error {
-	return map[string]func(ctx context.Context, rctx workload.Runctx) error{
-		"fetchProfile":        w.fetchProfile,        // similar to login or looking at someone
-		"updateProfile":       w.updateProfile,       // updating a status on the profile
-		"lockProfile":         w.lockProfile,         // disable or enable a random profile (account lockout)
-		"findProfile":         w.findProfile,         // find a profile by a secondary index (email address)
-		"findRelatedProfiles": w.findRelatedProfiles, // look for people with similar interests
+// End the session of the user
+func (w userProfile) logout(ctx context.Context, rctx workload.Runctx) error {
+	_, err := w.collectionFor(rctx).Remove(sessionKey(rctx), &gocb.RemoveOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("session remove failed: %s", err.Error())
 	}
+	return nil
 }
 
 // Fetch a random profile in the range of profiles
 func (w userProfile) fetchProfile(ctx context.Context, rctx workload.Runctx) error {
-	p := fmt.Sprintf("u%d", rctx.Rand().Int31n(int32(w.numItems)))
-	_, err := w.collection.Get(p, &gocb.GetOptions{Context: ctx})
+	if w.replicaReads > 0 && rctx.Rand().Float64() < w.replicaReads {
+		return w.fetchAnyReplica(ctx, rctx, "fetchProfile")
+	}
+
+	p := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	result, err := w.collectionFor(rctx).Get(p, &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
 	if err != nil {
 		return fmt.Errorf("profile fetch failed: %s", err.Error())
 	}
+	workload.ObserveContent(result)
 	rctx.Logger().Sugar().Debugf("fetching profile %s", p)
+	rctx.Set(lastProfileState, p)
 	return nil
 }
 
-// Update the status of a random profile
+// Fetch a random profile from whichever copy answers first
+func (w userProfile) fetchProfileAnyReplica(ctx context.Context, rctx workload.Runctx) error {
+	return w.fetchAnyReplica(ctx, rctx, "fetchProfileAnyReplica")
+}
+
+func (w userProfile) fetchAnyReplica(ctx context.Context, rctx workload.Runctx, operation string) error {
+	p := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	result, err := w.collectionFor(rctx).GetAnyReplica(p, &gocb.GetAnyReplicaOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
+	if err != nil {
+		return fmt.Errorf("profile fetch from any replica failed: %s", err.Error())
+	}
+	workload.ObserveContent(result)
+	rctx.ObserveReplicaRead(operation, result.IsReplica())
+	rctx.Set(lastProfileState, p)
+	return nil
+}
+
+// Fetch a random profile from the active copy and every replica
+func (w userProfile) fetchProfileAllReplicas(ctx context.Context, rctx workload.Runctx) error {
+	p := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	results, err := w.collectionFor(rctx).GetAllReplicas(p, &gocb.GetAllReplicaOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("profile fetch from all replicas failed: %s", err.Error())
+	}
+	defer results.Close()
+
+	var copies int
+	for result := results.Next(); result != nil; result = results.Next() {
+		rctx.ObserveReplicaRead("fetchProfileAllReplicas", result.IsReplica())
+		copies++
+	}
+	if copies == 0 {
+		return fmt.Errorf("profile fetch from all replicas returned no copies")
+	}
+	rctx.Set(lastProfileState, p)
+	return nil
+}
+
+// Update the status of the profile the user just found or last fetched
 func (w userProfile) updateProfile(ctx context.Context, rctx workload.Runctx) error {
-	p := fmt.Sprintf("u%d", rctx.Rand().Int31n(int32(w.numItems))) // Question to self, should I instead just grab this from context?  probably.
-	result, err := w.collection.Get(p, nil)
+	p := lastProfileKey(rctx, w.numItems)
+	result, err := w.collectionFor(rctx).Get(p, &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
 	if err != nil {
 		return fmt.Errorf("profile fetch during update failed: %s", err.Error())
 	}
@@ -130,19 +456,21 @@ func (w userProfile) updateProfile(ctx context.Context, rctx workload.Runctx) er
 		return fmt.Errorf("unable to load user into struct: %s", cerr.Error())
 	}
 
-	toUd.Status = gofakeit.Paragraph(1, rctx.Rand().Intn(8)+1, rctx.Rand().Intn(12)+1, "\n")
+	toUd.Status = randomStatus(rctx.Rand())
 
-	_, uerr := w.collection.Upsert(p, toUd, nil)
-	if uerr != nil {
-		return fmt.Errorf("data load upsert failed: %s", uerr.Error())
-	}
-	return nil
+	semantics := w.mutations.For("updateProfile", workload.MutationUpsert)
+	_, err = rctx.Mutate(ctx, w.collectionFor(rctx), semantics, workload.Mutation{Key: p, Value: toUd, Cas: result.Cas(), Transcoder: rctx.PayloadTranscoder(nil)})
+	return err
 }
 
 // Lock a random user profile by setting 'Enabled' to false
 func (w userProfile) lockProfile(ctx context.Context, rctx workload.Runctx) error {
-	p := fmt.Sprintf("u%d", rctx.Rand().Int31n(int32(w.numItems))) // Question to self, should I instead just grab this from context?  probably.
-	result, err := w.collection.Get(p, nil)
+	p := rctx.Key(profileKey(rctx.Item(w.numItems))) // Question to self, should I instead just grab this from context?  probably.
+	if w.lockMode == LockModePessimistic {
+		return w.lockProfilePessimistic(ctx, rctx, p)
+	}
+
+	result, err := w.collectionFor(rctx).Get(p, &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
 	if err != nil {
 		return fmt.Errorf("profile fetch during lock failed: %s", err.Error())
 	}
@@ -152,27 +480,71 @@ func (w userProfile) lockProfile(ctx context.Context, rctx workload.Runctx) erro
 
 	toUd.Enabled = false
 
-	_, uerr := w.collection.Upsert(p, toUd, nil) // replace with replace or subdoc
-	if uerr != nil {
-		return fmt.Errorf("data load upsert failed: %s", uerr.Error())
+	semantics := w.mutations.For("lockProfile", workload.MutationUpsert)
+	cas, err := rctx.Mutate(ctx, w.collectionFor(rctx), semantics, workload.Mutation{Key: p, Value: toUd, Cas: result.Cas(), Transcoder: rctx.PayloadTranscoder(nil)})
+	if err != nil {
+		return err
+	}
+	if cas != 0 {
+		w.locked.Store(p, cas)
 	}
 	return nil
 }
 
+// lockProfilePessimistic holds a lock on the profile while disabling it, as an application
+// serializing changes to an account would.  Replacing the profile with the CAS of the lock
+// releases it.
+func (w userProfile) lockProfilePessimistic(ctx context.Context, rctx workload.Runctx, p string) error {
+	collection := w.collectionFor(rctx)
+	result, err := collection.GetAndLock(p, w.lockDuration, &gocb.GetAndLockOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
+	if errors.Is(err, gocb.ErrDocumentLocked) {
+		rctx.ObserveLockContention("lockProfile")
+		return fmt.Errorf("profile %s is already locked", p)
+	}
+	if err != nil {
+		return fmt.Errorf("profile get and lock failed: %s", err.Error())
+	}
+
+	var toUd User
+	err = result.Content(&toUd)
+	if err != nil {
+		collection.Unlock(p, result.Cas(), nil)
+		return fmt.Errorf("unable to load user into struct: %s", err.Error())
+	}
+	toUd.Enabled = false
+
+	select {
+	case <-ctx.Done():
+		collection.Unlock(p, result.Cas(), nil)
+		return ctx.Err()
+	case <-time.After(w.lockHold):
+	}
+
+	replaced, err := collection.Replace(p, toUd, &gocb.ReplaceOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil), Cas: result.Cas()})
+	if err != nil {
+		collection.Unlock(p, result.Cas(), nil)
+		return fmt.Errorf("locked profile replace failed: %s", err.Error())
+	}
+	w.locked.Store(p, replaced.Cas())
+	return nil
+}
+
 // Find a profile using a n1ql query on the email field
 func (w userProfile) findProfile(ctx context.Context, rctx workload.Runctx) error {
-	toFind := fmt.Sprintf("%s%%", gofakeit.Letter())
+	toFind := rctx.Key(fmt.Sprintf("%s%%", randomLetter(rctx.Rand())))
 
-	query := "SELECT * FROM profiles WHERE Email LIKE $email LIMIT 1"
+	query := fmt.Sprintf("SELECT META().id AS id, * FROM `%s` AS profiles WHERE Namespace = $namespace AND Email LIKE $email LIMIT 1", w.collection.Name())
 	rctx.Logger().Sugar().Debugf("Querying with %s using param %s", query, toFind)
-	params := make(map[string]interface{}, 1)
+	params := make(map[string]interface{}, 2)
+	params["namespace"] = workload.KeyNamespace
 	params["email"] = toFind
 
-	rows, err := w.scope.Query(query, &gocb.QueryOptions{NamedParameters: params, Adhoc: true})
+	rows, err := w.scopeFor(rctx).Query(query, workload.Queries.SDKOptions(ctx, params))
 	if err != nil {
 		return fmt.Errorf("query failed: %s", err.Error())
 	}
 
+	var found int
 	for rows.Next() {
 		var resp UserQueryResponse
 		err := rows.Row(&resp)
@@ -180,12 +552,16 @@ func (w userProfile) findProfile(ctx context.Context, rctx workload.Runctx) erro
 			return fmt.Errorf("could not read next row: %s", err.Error())
 		}
 		rctx.Logger().Sugar().Debugf("Found a User: %+v", resp.Profiles)
+		rctx.SetResult(foundProfile{Key: resp.Id, Email: resp.Profiles.Email})
+		found++
 	}
 
 	err = rows.Err()
 	if err != nil {
 		return fmt.Errorf("error iterating the rows: %s", err.Error())
 	}
+	rctx.ObserveQueryResult(rows)
+	rctx.ObserveRows(found)
 	return nil
 }
 
@@ -207,7 +583,7 @@ func (w userProfile) findRelatedProfiles(ctx context.Context, rctx workload.Runc
 	// 	},
 	// )
 	// if err != nil {
-	// 	panic(err)
+	// 	return fmt.Errorf("search failed: %s", err.Error())
 	// }
 
 	// for matchResult.Next() {
@@ -218,7 +594,7 @@ func (w userProfile) findRelatedProfiles(ctx context.Context, rctx workload.Runc
 	// 	var fields interface{}
 	// 	err := row.Fields(&fields)
 	// 	if err != nil {
-	// 		panic(err)
+	// 		return fmt.Errorf("unable to read search fields: %s", err.Error())
 	// 	}
 
 	// 	fmt.Printf("Document ID: %s, search score: %f, fields included in result: %v\n", docID, score, fields)
@@ -227,6 +603,269 @@ func (w userProfile) findRelatedProfiles(ctx context.Context, rctx workload.Runc
 	// // always check for errors after iterating
 	// err = matchResult.Err()
 	// if err != nil {
-	// 	panic(err)
+	// 	return fmt.Errorf("error iterating the search results: %s", err.Error())
 	// }
 }
+
+// Read profiles in key order from a random profile, up to the end of the profiles of the run
+func (w userProfile) scanProfiles(ctx context.Context, rctx workload.Runctx) error {
+	from := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	to := workload.NamespacedKey("u") + gocb.ScanTermMaximum().Term
+	return w.scan(ctx, rctx, "scanProfiles", gocb.RangeScan{
+		From: &gocb.ScanTerm{Term: from},
+		To:   &gocb.ScanTerm{Term: to},
+	})
+}
+
+// Read the profiles whose keys start with the key of a random profile, e.g. u12, u120 to u129,
+// u1200 to u1299 and so on
+func (w userProfile) prefixScanProfiles(ctx context.Context, rctx workload.Runctx) error {
+	prefix := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	return w.scan(ctx, rctx, "prefixScanProfiles", gocb.NewRangeScanForPrefix(prefix))
+}
+
+// scan reads up to a sampled scan size of profiles from a range scan, recording the scan metrics
+// of the operation.
+func (w userProfile) scan(ctx context.Context, rctx workload.Runctx, operation string, scanType gocb.ScanType) error {
+	size := w.scanSize.Sample(rctx.Rand())
+
+	start := time.Now()
+	result, err := w.collectionFor(rctx).Scan(scanType, &gocb.ScanOptions{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("range scan failed: %s", err.Error())
+	}
+	defer result.Close()
+
+	var items int
+	var firstItem time.Duration
+	for items < size {
+		item := result.Next()
+		if item == nil {
+			break
+		}
+		if items == 0 {
+			firstItem = time.Since(start)
+		}
+
+		var profile User
+		err := item.Content(&profile)
+		if err != nil {
+			return fmt.Errorf("unable to load scanned user into struct: %s", err.Error())
+		}
+		items++
+	}
+	rctx.ObserveScan(operation, items, firstItem)
+
+	err = result.Err()
+	if err != nil {
+		return fmt.Errorf("error iterating the range scan: %s", err.Error())
+	}
+	return nil
+}
+
+// Fetch a batch of random profiles in one bulk operation
+func (w userProfile) bulkFetchProfiles(ctx context.Context, rctx workload.Runctx) error {
+	ops := make([]gocb.BulkOp, w.batchSize)
+	for i := range ops {
+		ops[i] = &gocb.GetOp{ID: rctx.Key(profileKey(rctx.Item(w.numItems)))}
+	}
+	return w.bulk(ctx, rctx, "bulkFetchProfiles", ops)
+}
+
+// Overwrite a batch of random profiles with newly generated ones in one bulk operation
+func (w userProfile) bulkUpsertProfiles(ctx context.Context, rctx workload.Runctx) error {
+	ops := make([]gocb.BulkOp, w.batchSize)
+	for i := range ops {
+		doc := w.GenerateDocument(rctx.Key(profileKey(rctx.Item(w.numItems))))
+		ops[i] = &gocb.UpsertOp{ID: doc.Name, Value: doc.Data}
+		w.locked.Delete(doc.Name)
+	}
+	return w.bulk(ctx, rctx, "bulkUpsertProfiles", ops)
+}
+
+// bulk runs a batch of operations, recording the batch metrics of the operation.  It fails if
+// any item of the batch failed.
+func (w userProfile) bulk(ctx context.Context, rctx workload.Runctx, operation string, ops []gocb.BulkOp) error {
+	start := time.Now()
+	err := w.collectionFor(rctx).Do(ops, &gocb.BulkOpOptions{Context: ctx})
+	duration := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("bulk operation failed: %s", err.Error())
+	}
+
+	var failed int
+	var firstErr error
+	for _, op := range ops {
+		var opErr error
+		switch op := op.(type) {
+		case *gocb.GetOp:
+			opErr = op.Err
+		case *gocb.UpsertOp:
+			opErr = op.Err
+		}
+		if opErr != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = opErr
+			}
+		}
+	}
+	rctx.ObserveBatch(operation, len(ops), duration, failed)
+
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d bulk items failed, first with: %s", failed, len(ops), firstErr.Error())
+	}
+	return nil
+}
+
+// Change the email address of a random profile, then find the profile by its new address with a
+// request_plus query, as a user checking their change would
+func (w userProfile) changeEmail(ctx context.Context, rctx workload.Runctx) error {
+	p := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	result, err := w.collectionFor(rctx).Get(p, &gocb.GetOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil)})
+	if err != nil {
+		return fmt.Errorf("profile fetch during email change failed: %s", err.Error())
+	}
+
+	var toUd User
+	err = result.Content(&toUd)
+	if err != nil {
+		return fmt.Errorf("unable to load user into struct: %s", err.Error())
+	}
+	// A unique address, so that only the changed profile can match it
+	toUd.Email = fmt.Sprintf("%s.%s", randomEmail(rctx.Rand()), strconv.FormatInt(rctx.Rand().Int63(), 36))
+
+	start := time.Now()
+	_, err = w.collectionFor(rctx).Replace(p, toUd, &gocb.ReplaceOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(nil), Cas: result.Cas()})
+	if err != nil {
+		return fmt.Errorf("email change replace failed: %s", err.Error())
+	}
+
+	query := fmt.Sprintf("SELECT META().id AS id FROM `%s` WHERE Namespace = $namespace AND Email = $email", w.collection.Name())
+	params := map[string]interface{}{
+		"namespace": workload.KeyNamespace,
+		"email":     toUd.Email,
+	}
+	opts := workload.Queries.SDKOptions(ctx, params)
+	opts.ScanConsistency = gocb.QueryScanConsistencyRequestPlus
+
+	rows, err := w.scopeFor(rctx).Query(query, opts)
+	if err != nil {
+		return fmt.Errorf("query for changed email failed: %s", err.Error())
+	}
+	var found int
+	for rows.Next() {
+		found++
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error iterating the rows: %s", err.Error())
+	}
+	rctx.ObserveQueryResult(rows)
+	rctx.ObserveRows(found)
+	if found == 0 {
+		return fmt.Errorf("request_plus query did not find profile %s by its changed email", p)
+	}
+
+	rctx.ObserveMutationVisible("changeEmail", time.Since(start))
+	return nil
+}
+
+// keyspaceSize returns how many profiles the workload has keys for, including those inserted
+// beyond the loaded profiles.
+func (w userProfile) keyspaceSize() int32 {
+	return int32(w.numItems) + w.inserted.Load()
+}
+
+// Remove a random profile, as an account being closed.  A profile that was already removed has
+// nothing to delete, so it is not counted as a failure.
+func (w userProfile) deleteProfile(ctx context.Context, rctx workload.Runctx) error {
+	p := rctx.Key(profileKey(rctx.Item(int(w.keyspaceSize()))))
+	w.locked.Delete(p)
+	_, err := w.collectionFor(rctx).Remove(p, &gocb.RemoveOptions{Context: ctx})
+	if err != nil && !errors.Is(err, gocb.ErrDocumentNotFound) {
+		return fmt.Errorf("profile delete failed: %s", err.Error())
+	}
+	return nil
+}
+
+// Insert a newly generated profile, as an account being created.  When keys are recycled a
+// profile that still exists is removed first, so every insert follows a delete.
+func (w userProfile) insertProfile(ctx context.Context, rctx workload.Runctx) error {
+	var p string
+	if w.churn == ChurnPolicyGrow {
+		p = rctx.Key(profileKey(int32(w.numItems) + w.inserted.Add(1) - 1))
+	} else {
+		p = rctx.Key(profileKey(rctx.Item(w.numItems)))
+	}
+
+	doc := w.GenerateDocument(p)
+	w.locked.Delete(p)
+	_, err := w.collectionFor(rctx).Insert(p, doc.Data, &gocb.InsertOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(doc.Transcoder())})
+	if errors.Is(err, gocb.ErrDocumentExists) && w.churn == ChurnPolicyRecycle {
+		_, err = w.collectionFor(rctx).Remove(p, &gocb.RemoveOptions{Context: ctx})
+		if err != nil && !errors.Is(err, gocb.ErrDocumentNotFound) {
+			return fmt.Errorf("profile delete before insert failed: %s", err.Error())
+		}
+		_, err = w.collectionFor(rctx).Insert(p, doc.Data, &gocb.InsertOptions{Context: ctx, Transcoder: rctx.PayloadTranscoder(doc.Transcoder())})
+	}
+	if err != nil {
+		return fmt.Errorf("profile insert failed: %s", err.Error())
+	}
+	return nil
+}
+
+// maxValidatedLocks is the most locked profiles Validate checks, to bound how long it takes.
+const maxValidatedLocks = 10000
+
+// Cleanup removes the profiles inserted beyond those loaded during setup, which teardown of the
+// loaded documents leaves behind when the keyspace grows.
+func (w userProfile) Cleanup(ctx context.Context) error {
+	for i := int32(w.numItems); i < w.keyspaceSize(); i++ {
+		_, err := w.collection.Remove(profileKey(i), &gocb.RemoveOptions{Context: ctx})
+		if err != nil && !errors.Is(err, gocb.ErrDocumentNotFound) {
+			return fmt.Errorf("inserted profile delete failed: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Validate checks that the profiles locked during the run are still disabled, which an update
+// that read a profile before it was locked and wrote it back after would undo.  Profiles
+// replaced with new ones or deleted since they were locked are not checked.  Nor, when
+// updateProfile upserts, are profiles written since they were locked, as an upsert overwrites
+// the lock without checking it is unchanged.
+func (w userProfile) Validate(ctx context.Context) error {
+	upserted := w.mutations.For("updateProfile", workload.MutationUpsert) == workload.MutationUpsert
+	checked := 0
+	var err error
+	w.locked.Range(func(key, lockCas any) bool {
+		p := key.(string)
+		if checked == maxValidatedLocks {
+			return false
+		}
+		checked++
+
+		var result *gocb.GetResult
+		result, err = w.collection.Get(p, &gocb.GetOptions{Context: ctx})
+		if err != nil {
+			err = fmt.Errorf("locked profile fetch failed: %s", err.Error())
+			return false
+		}
+		if upserted && result.Cas() != lockCas.(gocb.Cas) {
+			return true
+		}
+		var profile User
+		err = result.Content(&profile)
+		if err != nil {
+			err = fmt.Errorf("unable to load user into struct: %s", err.Error())
+			return false
+		}
+		if profile.Enabled {
+			err = fmt.Errorf("profile %s was locked but is enabled", p)
+			return false
+		}
+		return true
+	})
+	return err
+}
@@ -1,41 +1,29 @@
 package workloads
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"github.com/brianvoe/gofakeit"
 	"github.com/couchbaselabs/spectroperf/workload"
-	"io"
-	"io/ioutil"
+	"github.com/couchbaselabs/spectroperf/workload/dapi"
+	"github.com/pkg/errors"
 	"math/rand"
-	"net/http"
 	"time"
 )
 
 type userProfileDapi struct {
-	connstr    string
-	username   string
-	password   string
-	client     *http.Client
-	numItems   int
-	bucket     string
-	scope      string
+	client   *dapi.Client
+	numItems int
+	bucket   string
+	scope    string
+	// collection is the name of the collection, as it appears in queries
 	collection string
 }
 
-func NewUserProfileDapi(connstr string, bucket string, scope string, collection string, numItems int, usr string, pwd string) userProfileDapi {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		MaxConnsPerHost: 500,
-	}
+func NewUserProfileDapi(connstr string, bucket string, scope string, collection string, numItems int, usr string, pwd string, tlsConfig *tls.Config, transport dapi.Transport) userProfileDapi {
 	return userProfileDapi{
-		connstr:    connstr,
-		username:   usr,
-		password:   pwd,
-		client:     &http.Client{Transport: tr},
+		client:     dapi.NewClient(connstr, bucket, scope, collection, usr, pwd, tlsConfig, transport),
 		numItems:   numItems,
 		bucket:     bucket,
 		scope:      scope,
@@ -50,11 +38,12 @@ func (w userProfileDapi) GenerateDocument(id string) workload.DocType {
 	r := rand.New(rng)
 
 	iu := User{
-		Name:    gofakeit.Name(),
-		Email:   gofakeit.Email(), // TODO: make the email actually based on the name (pedantic)
-		Created: gofakeit.DateRange(time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)),
-		Status:  gofakeit.Paragraph(1, r.Intn(8)+1, r.Intn(12)+1, "\n"),
-		Enabled: true,
+		Name:      gofakeit.Name(),
+		Email:     gofakeit.Email(), // TODO: make the email actually based on the name (pedantic)
+		Created:   gofakeit.DateRange(time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)),
+		Status:    gofakeit.Paragraph(1, r.Intn(8)+1, r.Intn(12)+1, "\n"),
+		Enabled:   true,
+		Namespace: workload.KeyNamespace,
 	}
 
 	return workload.DocType{
@@ -64,20 +53,42 @@ func (w userProfileDapi) GenerateDocument(id string) workload.DocType {
 }
 
 func (w userProfileDapi) Operations() []string {
-	return []string{"fetchProfile", "updateProfile", "lockProfile", "findProfile", "findRelatedProfiles"}
+	return []string{"fetchProfile", "updateProfile", "lockProfile", "findProfile", "findRelatedProfiles", "updateProfileCas", "casConflict", "login", "logout", "fetchStatus", "updateStatus"}
+}
+
+func (w userProfileDapi) Describe() []workload.OperationInfo {
+	return []workload.OperationInfo{
+		{Name: "fetchProfile", Description: "GET a random profile document, similar to logging in or looking at someone", Services: []string{"data-api", "kv"}},
+		{Name: "updateProfile", Description: "GET the profile document the user just found or last fetched, or a random one, and PUT it back with a new status", Services: []string{"data-api", "kv"}, Writes: true},
+		{Name: "lockProfile", Description: "GET a random profile document and PUT it back disabled, as in an account lockout", Services: []string{"data-api", "kv"}, Writes: true},
+		{Name: "findProfile", Description: "Find a profile by email address prefix with a query through the query service proxy", Services: []string{"data-api", "query"}},
+		{Name: "findRelatedProfiles", Description: "Look for people with similar interests (not yet implemented)", Services: []string{}},
+		{Name: "updateProfileCas", Description: "GET a random profile document and PUT it back with a new status, only if it is unchanged according to If-Match (off by default)", Services: []string{"data-api", "kv"}, Writes: true},
+		{Name: "casConflict", Description: "Update a random profile document with If-Match, then again with the stale ETag, verifying the conflict is rejected (off by default)", Services: []string{"data-api", "kv"}, Writes: true},
+		{Name: "login", Description: "PUT a session document for a random profile with an expiry (off by default)", Services: []string{"data-api", "kv"}, Writes: true},
+		{Name: "logout", Description: "DELETE the session document of the user (off by default)", Services: []string{"data-api", "kv"}, Writes: true},
+		{Name: "fetchStatus", Description: "Look up the status of a random profile with a sub-document request (off by default)", Services: []string{"data-api", "kv"}},
+		{Name: "updateStatus", Description: "Replace the status of a random profile with a sub-document request (off by default)", Services: []string{"data-api", "kv"}, Writes: true},
+	}
 }
 
 func (w userProfileDapi) Probabilities() [][]float64 {
 	return [][]float64{
-		{0, 0.7, 0.1, 0.15, 0.05},
-		{0.8, 0, 0.1, 0.05, 0.05},
-		{0.7, 0.2, 0, 0.05, 0.05},
-		{0.6, 0.2, 0.15, 0, 0.05},
-		{0.6, 0.2, 0.15, 0.05, 0},
+		{0, 0.7, 0.1, 0.15, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.8, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.7, 0.2, 0, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.6, 0.2, 0.15, 0, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.6, 0.2, 0.15, 0.05, 0, 0, 0, 0, 0, 0, 0},
+		{0.8, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.8, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.8, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.8, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.8, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
+		{0.8, 0, 0.1, 0.05, 0.05, 0, 0, 0, 0, 0, 0},
 	}
 }
 
-func (w userProfileDapi) Setup() error {
+func (w userProfileDapi) Setup(ctx context.Context) error {
 	// TODO setup FTS index here for findRelatedProfile
 	gofakeit.Seed(int64(workload.RandSeed))
 	return nil
@@ -90,178 +101,178 @@ func (w userProfileDapi) Functions() map[string]func(ctx context.Context, rctx w
 		"lockProfile":         w.lockProfile,         // disable or enable a random profile (account lockout)
 		"findProfile":         w.findProfile,         // find a profile by a secondary index (email address)
 		"findRelatedProfiles": w.findRelatedProfiles, // look for people with similar interests
+		"updateProfileCas":    w.updateProfileCas,    // updating a status without overwriting a concurrent change
+		"casConflict":         w.casConflict,         // a concurrent change being rejected
+		"login":               w.login,               // start a session that expires
+		"logout":              w.logout,              // end the session of the user
+		"fetchStatus":         w.fetchStatus,         // read just the status of a profile
+		"updateStatus":        w.updateStatus,        // write just the status of a profile
 	}
 }
 
-func (w userProfileDapi) executeRequest(req *http.Request) (*http.Response, error) {
-	req.SetBasicAuth(w.username, w.password)
-	resp, err := w.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute get request: %s", err.Error())
-	}
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("profile fetch returned unexpected status code %d", resp.StatusCode)
-	}
-	return resp, nil
-}
-
 // Fetch a random profile in the range of profiles
 func (w userProfileDapi) fetchProfile(ctx context.Context, rctx workload.Runctx) error {
-	id := fmt.Sprintf("u%d", rctx.Rand().Int31n(int32(w.numItems)))
-	requestURL := fmt.Sprintf("%s/v1/buckets/%s/scopes/%s/collections/%s/documents/%s", w.connstr, w.bucket, w.scope, w.collection, id)
-	req, err := http.NewRequest("GET", requestURL, nil)
+	id := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	var profile User
+	_, err := w.client.GetDocument(ctx, rctx, id, &profile)
 	if err != nil {
-		panic(fmt.Errorf("failed to build profile fetch request: %s", err.Error()))
+		return fmt.Errorf("could not fetch profile: %s", err.Error())
 	}
+	rctx.Set(lastProfileState, id)
+	return nil
+}
 
-	resp, err := w.executeRequest(req)
+// Update the status of the profile the user just found or last fetched
+func (w userProfileDapi) updateProfile(ctx context.Context, rctx workload.Runctx) error {
+	id := lastProfileKey(rctx, w.numItems)
+	var toUd User
+	_, err := w.client.GetDocument(ctx, rctx, id, &toUd)
 	if err != nil {
 		return fmt.Errorf("could not fetch profile to update: %s", err.Error())
 	}
 
-	bodyText, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("could not read response body: %s", err.Error())
-	}
+	toUd.Status = randomStatus(rctx.Rand())
 
-	var toUd User
-	err = json.Unmarshal(bodyText, &toUd)
+	_, err = w.client.UpsertDocument(ctx, rctx, id, toUd, nil)
 	if err != nil {
-		return fmt.Errorf("could not unmarshal response body - %s : %s", string(bodyText), err.Error())
+		return fmt.Errorf("error executing upsert request: %s", err.Error())
 	}
 	return nil
 }
 
-// Update the status of a random profile
-func (w userProfileDapi) updateProfile(ctx context.Context, rctx workload.Runctx) error {
-	id := fmt.Sprintf("u%d", rctx.Rand().Int31n(int32(w.numItems)))
-	requestURL := fmt.Sprintf("%s/v1/buckets/%s/scopes/%s/collections/%s/documents/%s", w.connstr, w.bucket, w.scope, w.collection, id)
-	req, err := http.NewRequest("GET", requestURL, nil)
-	if err != nil {
-		panic(fmt.Errorf("failed to build profile fetch request: %s", err.Error()))
-	}
-
-	resp, err := w.executeRequest(req)
+// Lock a random user profile by setting 'Enabled' to false
+func (w userProfileDapi) lockProfile(ctx context.Context, rctx workload.Runctx) error {
+	id := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	var toUd User
+	_, err := w.client.GetDocument(ctx, rctx, id, &toUd)
 	if err != nil {
 		return fmt.Errorf("could not fetch profile to update: %s", err.Error())
 	}
 
-	bodyText, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("could not read response body: %s", err.Error())
-	}
+	toUd.Enabled = false
 
-	var toUd User
-	err = json.Unmarshal(bodyText, &toUd)
+	_, err = w.client.UpsertDocument(ctx, rctx, id, toUd, nil)
 	if err != nil {
-		return fmt.Errorf("could not unmarshal response body - %s : %s", string(bodyText), err.Error())
+		return fmt.Errorf("error executing upsert request: %s", err.Error())
 	}
+	return nil
+}
 
-	toUd.Status = gofakeit.Paragraph(1, rctx.Rand().Intn(8)+1, rctx.Rand().Intn(12)+1, "\n")
+type DapiUserQueryResponse struct {
+	Results []UserQueryResponse `json:"results"`
+}
 
-	jsonBytes, err := json.Marshal(toUd)
-	if err != nil {
-		return fmt.Errorf("could not marshal User to json: &s", err.Error())
+func (w userProfileDapi) findProfile(ctx context.Context, rctx workload.Runctx) error {
+	toFind := rctx.Key(fmt.Sprintf("%s%%", randomLetter(rctx.Rand())))
+	query := fmt.Sprintf("SELECT META().id AS id, * FROM `%s`.`%s`.`%s` AS profiles WHERE Namespace = $namespace AND Email LIKE $email LIMIT 1", w.bucket, w.scope, w.collection)
+	params := map[string]interface{}{
+		"namespace": workload.KeyNamespace,
+		"email":     toFind,
 	}
 
-	req, err = http.NewRequest("PUT", requestURL, bytes.NewBuffer(jsonBytes))
+	var results DapiUserQueryResponse
+	err := w.client.Query(ctx, rctx, query, params, &results)
 	if err != nil {
-		panic(fmt.Errorf("failed to build profile update request: %s", err.Error()))
+		return fmt.Errorf("could not execute query request: %s", err.Error())
 	}
-
-	_, err = w.executeRequest(req)
-	if err != nil {
-		return fmt.Errorf("error executing upsert request: %s", err.Error())
+	for _, result := range results.Results {
+		rctx.SetResult(foundProfile{Key: result.Id, Email: result.Profiles.Email})
 	}
+	rctx.ObserveRows(len(results.Results))
 	return nil
 }
 
-// Lock a random user profile by setting 'Enabled' to false
-func (w userProfileDapi) lockProfile(ctx context.Context, rctx workload.Runctx) error {
-	id := fmt.Sprintf("u%d", rctx.Rand().Int31n(int32(w.numItems)))
-	requestURL := fmt.Sprintf("%s/v1/buckets/%s/scopes/%s/collections/%s/documents/%s", w.connstr, w.bucket, w.scope, w.collection, id)
-	req, err := http.NewRequest("GET", requestURL, nil)
-	if err != nil {
-		panic(fmt.Errorf("failed to build profile fetch request: %s", err.Error()))
-	}
+func (w userProfileDapi) findRelatedProfiles(ctx context.Context, rctx workload.Runctx) error {
+	return nil
+}
 
-	resp, err := w.executeRequest(req)
+// Update the status of a random profile, unless it changed since it was fetched
+func (w userProfileDapi) updateProfileCas(ctx context.Context, rctx workload.Runctx) error {
+	id := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	var profile User
+	etag, err := w.client.GetDocument(ctx, rctx, id, &profile)
 	if err != nil {
 		return fmt.Errorf("could not fetch profile to update: %s", err.Error())
 	}
 
-	bodyText, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("could not read response body: %s", err.Error())
+	profile.Status = randomStatus(rctx.Rand())
+	_, err = w.client.UpsertDocument(ctx, rctx, id, profile, &dapi.WriteOptions{IfMatch: etag})
+	if errors.Is(err, dapi.ErrCasMismatch) {
+		return fmt.Errorf("profile %s changed since it was fetched", id)
 	}
-
-	var toUd User
-	err = json.Unmarshal(bodyText, &toUd)
 	if err != nil {
-		return fmt.Errorf("could not unmarshal response body - %s : %s", string(bodyText), err.Error())
+		return fmt.Errorf("conditional profile update failed: %s", err.Error())
 	}
+	return nil
+}
 
-	toUd.Enabled = false
-
-	jsonBytes, err := json.Marshal(toUd)
+// Update a random profile, then update it again with the ETag it had before the first update,
+// verifying that the Data API rejects the stale write
+func (w userProfileDapi) casConflict(ctx context.Context, rctx workload.Runctx) error {
+	id := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	var profile User
+	staleETag, err := w.client.GetDocument(ctx, rctx, id, &profile)
 	if err != nil {
-		return fmt.Errorf("could not marshal User to json: &s", err.Error())
+		return fmt.Errorf("could not fetch profile to update: %s", err.Error())
 	}
 
-	req, err = http.NewRequest("PUT", requestURL, bytes.NewBuffer(jsonBytes))
+	profile.Status = randomStatus(rctx.Rand())
+	_, err = w.client.UpsertDocument(ctx, rctx, id, profile, &dapi.WriteOptions{IfMatch: staleETag})
 	if err != nil {
-		panic(fmt.Errorf("failed to build profile update request: %s", err.Error()))
+		return fmt.Errorf("conditional profile update failed: %s", err.Error())
 	}
 
-	_, err = w.executeRequest(req)
-	if err != nil {
-		return fmt.Errorf("error executing upsert request: %s", err.Error())
+	_, err = w.client.UpsertDocument(ctx, rctx, id, profile, &dapi.WriteOptions{IfMatch: staleETag})
+	if err == nil {
+		return fmt.Errorf("update with a stale ETag was not rejected")
+	}
+	if !errors.Is(err, dapi.ErrCasMismatch) {
+		return fmt.Errorf("update with a stale ETag failed unexpectedly: %s", err.Error())
 	}
 	return nil
 }
 
-type DapiQueryPayload struct {
-	Statement string `json:"statement"`
-}
-
-type DapiUserQueryResponse struct {
-	Results []UserQueryResponse `json:"results"`
-}
-
-func (w userProfileDapi) findProfile(ctx context.Context, rctx workload.Runctx) error {
-	toFind := fmt.Sprintf("%s%%", gofakeit.Letter())
-	query := fmt.Sprintf("SELECT * FROM %s.%s.%s WHERE Email LIKE '%s' LIMIT 1", w.bucket, w.scope, w.collection, toFind)
-	payload := DapiQueryPayload{
-		Statement: query,
+// Start a session for a random profile, which expires if the user never logs out
+func (w userProfileDapi) login(ctx context.Context, rctx workload.Runctx) error {
+	session := Session{
+		Profile:   rctx.Key(profileKey(rctx.Item(w.numItems))),
+		Created:   time.Now(),
+		Namespace: workload.KeyNamespace,
 	}
-	requestURL := fmt.Sprintf("%s/_p/query/query/service", w.connstr)
-	body, _ := json.Marshal(payload)
 
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(body))
+	_, err := w.client.UpsertDocument(ctx, rctx, sessionKey(rctx), session, &dapi.WriteOptions{Expiry: session.Created.Add(sessionExpiry)})
 	if err != nil {
-		panic(fmt.Errorf("failed to build profile fetch request: %s", err.Error()))
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := w.executeRequest(req)
-	if err != nil {
-		return fmt.Errorf("could not execute query request: %s", err.Error())
+		return fmt.Errorf("session upsert failed: %s", err.Error())
 	}
+	return nil
+}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+// End the session of the user
+func (w userProfileDapi) logout(ctx context.Context, rctx workload.Runctx) error {
+	err := w.client.DeleteDocument(ctx, rctx, sessionKey(rctx))
 	if err != nil {
-		return fmt.Errorf("could not read response body: %s", err.Error())
+		return fmt.Errorf("session remove failed: %s", err.Error())
 	}
+	return nil
+}
 
-	var results DapiUserQueryResponse
-	err = json.Unmarshal(bodyBytes, &results)
+// Look up just the status of a random profile
+func (w userProfileDapi) fetchStatus(ctx context.Context, rctx workload.Runctx) error {
+	id := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	err := w.client.LookupIn(ctx, rctx, id, []dapi.SubdocOp{{Operation: "get", Path: "Status"}}, nil)
 	if err != nil {
-		return fmt.Errorf("could not unmarshal response body - %s : %s", string(bodyBytes), err.Error())
+		return fmt.Errorf("status lookup failed: %s", err.Error())
 	}
 	return nil
 }
 
-func (w userProfileDapi) findRelatedProfiles(ctx context.Context, rctx workload.Runctx) error {
+// Replace just the status of a random profile
+func (w userProfileDapi) updateStatus(ctx context.Context, rctx workload.Runctx) error {
+	id := rctx.Key(profileKey(rctx.Item(w.numItems)))
+	status := randomStatus(rctx.Rand())
+	err := w.client.MutateIn(ctx, rctx, id, []dapi.SubdocOp{{Operation: "replace", Path: "Status", Value: status}})
+	if err != nil {
+		return fmt.Errorf("status update failed: %s", err.Error())
+	}
 	return nil
 }